@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/future-architect/apidoor/dblogger"
+)
+
+var defaultShipInterval = 5 * time.Second
+
+// shipper entry point, which ships the log file of the gateway until it is stopped
+func main() {
+	db, err := dblogger.OpenDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	parser, err := dblogger.NewParser()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	interval := defaultShipInterval
+	if v := os.Getenv("SHIP_INTERVAL"); v != "" {
+		if interval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("invalid SHIP_INTERVAL: %v", err)
+		}
+	}
+	batchSize := 0
+	if v := os.Getenv("SHIP_BATCH_SIZE"); v != "" {
+		if batchSize, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("invalid SHIP_BATCH_SIZE: %v", err)
+		}
+	}
+
+	shipper := dblogger.Shipper{
+		DB:        db,
+		Path:      os.Getenv("LOG_PATH"),
+		Parser:    parser,
+		BatchSize: batchSize,
+	}
+	if shipper.Path == "" {
+		log.Fatal("missing LOG_PATH env")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shipper.Run(ctx, interval)
+}
@@ -6,4 +6,4 @@ export DATABASE_USER="root"
 export DATABASE_PASSWORD="password"
 export DATABASE_NAME="root"
 export DATABASE_SSLMODE="disable"
-export LOGPATH="./log.csv"
\ No newline at end of file
+export LOG_PATH="./log.csv"
\ No newline at end of file
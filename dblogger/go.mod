@@ -2,4 +2,9 @@ module github.com/future-architect/apidoor/dblogger
 
 go 1.16
 
-require github.com/lib/pq v1.10.2
+require (
+	github.com/future-architect/apidoor/logtail v0.0.0
+	github.com/lib/pq v1.10.2
+)
+
+replace github.com/future-architect/apidoor/logtail => ../logtail
@@ -0,0 +1,148 @@
+package dblogger
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLogPattern is the LOG_PATTERN of the gateway when it is not set
+const DefaultLogPattern = "time,key,path,response_status,billing_status"
+
+// Row is a row of log_list
+type Row struct {
+	RunDate time.Time
+	APIKey  string
+	APIPath string
+	// CustomLog is the json object of the whole log line
+	CustomLog []byte
+}
+
+// LineParser converts a line of the log file to a row
+type LineParser interface {
+	Parse(line []byte) (Row, error)
+}
+
+// csvColumnFields maps the columns of LOG_PATTERN to the fields of the json log of the gateway,
+// so that custom_log has the same fields regardless of the log format. Other columns are header values.
+var csvColumnFields = map[string]string{
+	"time":            "time",
+	"key":             "api_key",
+	"path":            "path",
+	"method":          "method",
+	"response_status": "status_code",
+	"billing_status":  "billing_status",
+	"cache_status":    "cache_status",
+	"latency_ms":      "latency_ms",
+	"upstream_host":   "upstream_host",
+	"request_bytes":   "request_bytes",
+	"response_bytes":  "response_bytes",
+	"client_ip":       "client_ip",
+	"request_id":      "request_id",
+	"contract_id":     "contract_id",
+	"product_id":      "product_id",
+	"error_class":     "error_class",
+}
+
+// numericFields are written to custom_log as numbers
+var numericFields = map[string]bool{
+	"status_code":    true,
+	"latency_ms":     true,
+	"request_bytes":  true,
+	"response_bytes": true,
+	"contract_id":    true,
+	"product_id":     true,
+}
+
+// CSVParser parses a line written by the csv appender of the gateway with the columns of LOG_PATTERN
+type CSVParser struct {
+	fields []string
+}
+
+// NewCSVParser returns the parser of the pattern, which must have the time, key and path columns
+func NewCSVParser(pattern string) (CSVParser, error) {
+	if pattern == "" {
+		pattern = DefaultLogPattern
+	}
+	var fields []string
+	seen := make(map[string]bool)
+	for _, column := range strings.Split(pattern, ",") {
+		field, ok := csvColumnFields[column]
+		if !ok {
+			field = column
+		}
+		fields = append(fields, field)
+		seen[field] = true
+	}
+	for _, required := range []string{"time", "api_key", "path"} {
+		if !seen[required] {
+			return CSVParser{}, fmt.Errorf("log pattern %s has no column of %s", pattern, required)
+		}
+	}
+	return CSVParser{fields: fields}, nil
+}
+
+func (p CSVParser) Parse(line []byte) (Row, error) {
+	reader := csv.NewReader(strings.NewReader(string(line)))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	record, err := reader.Read()
+	if err != nil {
+		return Row{}, fmt.Errorf("parse csv line: %w", err)
+	}
+	if len(record) != len(p.fields) {
+		return Row{}, fmt.Errorf("the line has %d columns, while the pattern has %d", len(record), len(p.fields))
+	}
+
+	custom := make(map[string]interface{}, len(p.fields))
+	for i, field := range p.fields {
+		var value interface{} = record[i]
+		if numericFields[field] {
+			if record[i] == "" {
+				value = nil
+			} else if n, err := strconv.ParseInt(record[i], 10, 64); err == nil {
+				value = n
+			}
+		}
+		custom[field] = value
+	}
+	return newRow(custom)
+}
+
+// JSONParser parses a line written by the json appender of the gateway
+type JSONParser struct{}
+
+func (JSONParser) Parse(line []byte) (Row, error) {
+	var custom map[string]interface{}
+	if err := json.Unmarshal(line, &custom); err != nil {
+		return Row{}, fmt.Errorf("parse json line: %w", err)
+	}
+	return newRow(custom)
+}
+
+func newRow(custom map[string]interface{}) (Row, error) {
+	timestamp, _ := custom["time"].(string)
+	apikey, _ := custom["api_key"].(string)
+	path, _ := custom["path"].(string)
+	if timestamp == "" || apikey == "" {
+		return Row{}, errors.New("the line has no time or api key")
+	}
+	runDate, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return Row{}, fmt.Errorf("parse time: %w", err)
+	}
+	customLog, err := json.Marshal(custom)
+	if err != nil {
+		return Row{}, err
+	}
+	return Row{
+		RunDate:   runDate,
+		APIKey:    apikey,
+		APIPath:   path,
+		CustomLog: customLog,
+	}, nil
+}
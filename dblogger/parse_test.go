@@ -0,0 +1,94 @@
+package dblogger_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/future-architect/apidoor/dblogger"
+)
+
+func TestParser(t *testing.T) {
+	tests := []struct {
+		name       string
+		parser     func(t *testing.T) dblogger.LineParser
+		line       string
+		wantPath   string
+		wantCustom string
+		wantErr    bool
+	}{
+		{
+			name:       "csv of the default pattern",
+			parser:     csvParser(""),
+			line:       "2021-07-01T14:01:46+09:00,key,/users,200,billing",
+			wantPath:   "/users",
+			wantCustom: `{"api_key":"key","billing_status":"billing","path":"/users","status_code":200,"time":"2021-07-01T14:01:46+09:00"}`,
+		},
+		{
+			name:       "csv with header and empty numeric columns",
+			parser:     csvParser("key,time,path,contract_id,X-Client,latency_ms"),
+			line:       `key,2021-07-01T14:01:46+09:00,/users,,"a,b",12`,
+			wantPath:   "/users",
+			wantCustom: `{"X-Client":"a,b","api_key":"key","contract_id":null,"latency_ms":12,"path":"/users","time":"2021-07-01T14:01:46+09:00"}`,
+		},
+		{
+			name:    "csv of a different number of columns",
+			parser:  csvParser(""),
+			line:    "2021-07-01T14:01:46+09:00,key,/users",
+			wantErr: true,
+		},
+		{
+			name:    "csv of a broken time",
+			parser:  csvParser(""),
+			line:    "yesterday,key,/users,200,billing",
+			wantErr: true,
+		},
+		{
+			name: "json",
+			parser: func(t *testing.T) dblogger.LineParser {
+				return dblogger.JSONParser{}
+			},
+			line:       `{"time":"2021-07-01T14:01:46+09:00","api_key":"key","path":"/users","status_code":200,"contract_id":null}`,
+			wantPath:   "/users",
+			wantCustom: `{"api_key":"key","contract_id":null,"path":"/users","status_code":200,"time":"2021-07-01T14:01:46+09:00"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row, err := tt.parser(t).Parse([]byte(tt.line))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", row)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := time.Date(2021, time.July, 1, 5, 1, 46, 0, time.UTC); !row.RunDate.Equal(want) {
+				t.Errorf("unexpected date %s, expected %s", row.RunDate, want)
+			}
+			if row.APIKey != "key" || row.APIPath != tt.wantPath {
+				t.Errorf("unexpected key %s and path %s", row.APIKey, row.APIPath)
+			}
+			if string(row.CustomLog) != tt.wantCustom {
+				t.Errorf("unexpected custom log %s, expected %s", row.CustomLog, tt.wantCustom)
+			}
+		})
+	}
+}
+
+func TestNewCSVParser(t *testing.T) {
+	if _, err := dblogger.NewCSVParser("time,path,response_status"); err == nil {
+		t.Error("a pattern without the key column should be rejected")
+	}
+}
+
+func csvParser(pattern string) func(t *testing.T) dblogger.LineParser {
+	return func(t *testing.T) dblogger.LineParser {
+		parser, err := dblogger.NewCSVParser(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parser
+	}
+}
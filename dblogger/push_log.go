@@ -1,57 +1,54 @@
 package dblogger
 
 import (
+	"context"
 	"database/sql"
-	"encoding/csv"
-	"log"
+	"fmt"
 	"os"
 
 	_ "github.com/lib/pq"
 )
 
-func PushLog() {
-	// set up database
-	db, err := sql.Open(os.Getenv("DATABASE_DRIVER"),
+// OpenDB opens the database set by the DATABASE_* envs
+func OpenDB() (*sql.DB, error) {
+	return sql.Open(os.Getenv("DATABASE_DRIVER"),
 		"host="+os.Getenv("DATABASE_HOST")+" "+
 			"port="+os.Getenv("DATABASE_PORT")+" "+
 			"user="+os.Getenv("DATABASE_USER")+" "+
 			"password="+os.Getenv("DATABASE_PASSWORD")+" "+
 			"dbname="+os.Getenv("DATABASE_NAME")+" "+
 			"sslmode="+os.Getenv("DATABASE_SSLMODE"))
-	if err != nil {
-		log.Fatal(err)
+}
+
+// NewParser returns the parser of the log file set by the LOG_FORMAT and LOG_PATTERN envs of the gateway
+func NewParser() (LineParser, error) {
+	switch os.Getenv("LOG_FORMAT") {
+	case "", "CSV":
+		return NewCSVParser(os.Getenv("LOG_PATTERN"))
+	case "JSON":
+		return JSONParser{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported LOG_FORMAT: %s", os.Getenv("LOG_FORMAT"))
 	}
-	defer db.Close()
+}
 
-	// open log file
-	file, err := os.OpenFile(os.Getenv("LOG_PATH"), os.O_CREATE|os.O_RDWR, 0600)
+// PushLog ships the lines of the log file at LOG_PATH which are not shipped yet
+func PushLog() error {
+	db, err := OpenDB()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer file.Close()
-
-	// write log to database
-	reader := csv.NewReader(file)
-	for {
-		line, err := reader.Read()
-		if err != nil {
-			if err.Error() == "EOF" {
-				break
-			}
-			log.Fatal(err)
-		}
+	defer db.Close()
 
-		date := line[0]
-		key := line[1]
-		path := line[2]
-		custom := line[3]
-		if _, err := db.Exec("INSERT INTO log_list(run_date, api_key, api_path, custom_log) VALUES($1, $2, $3, $4)", date, key, path, custom); err != nil {
-			log.Fatal(err)
-		}
+	parser, err := NewParser()
+	if err != nil {
+		return err
 	}
-
-	// initialize log file
-	if err := file.Truncate(0); err != nil {
-		log.Fatal(err)
+	shipper := Shipper{
+		DB:     db,
+		Path:   os.Getenv("LOG_PATH"),
+		Parser: parser,
 	}
+	_, err = shipper.ShipOnce(context.Background())
+	return err
 }
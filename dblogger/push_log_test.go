@@ -23,30 +23,37 @@ var testLogData = testLog{
 	date:   "2021-07-01T14:01:46+09:00",
 	key:    "key",
 	path:   "path",
-	custom: `{"key": "value"}`,
+	custom: `{"api_key": "key", "billing_status": "billing", "path": "path", "status_code": 200, "time": "2021-07-01T14:01:46+09:00"}`,
 }
 
-func TestPushLog(t *testing.T) {
-	// set up database
-	db, err := sql.Open(os.Getenv("DATABASE_DRIVER"),
-		"host="+os.Getenv("DATABASE_HOST")+" "+
-			"port="+os.Getenv("DATABASE_PORT")+" "+
-			"user="+os.Getenv("DATABASE_USER")+" "+
-			"password="+os.Getenv("DATABASE_PASSWORD")+" "+
-			"dbname="+os.Getenv("DATABASE_NAME")+" "+
-			"sslmode="+os.Getenv("DATABASE_SSLMODE"))
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := dblogger.OpenDB()
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer db.Close()
+	t.Cleanup(func() { db.Close() })
+	return db
+}
 
-	// clean up database for test
-	if _, err := db.Exec("DELETE FROM log_list WHERE api_key='key' AND api_path='path'"); err != nil {
-		t.Fatal(err)
+// cleanupTestLog deletes the logs of the api key and the offset of the log file before and after the test
+func cleanupTestLog(t *testing.T, db *sql.DB, key, logPath string) {
+	t.Helper()
+	cleanup := func() {
+		if _, err := db.Exec("DELETE FROM log_list WHERE api_key = $1", key); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("DELETE FROM log_shipper_offset WHERE log_path = $1", logPath); err != nil {
+			t.Fatal(err)
+		}
 	}
-	t.Cleanup(func() {
-		db.Exec("DELETE FROM log_list WHERE api_key='key' AND api_path='path'")
-	})
+	cleanup()
+	t.Cleanup(cleanup)
+}
+
+func TestPushLog(t *testing.T) {
+	db := openTestDB(t)
+	cleanupTestLog(t, db, testLogData.key, os.Getenv("LOG_PATH"))
 
 	// open log file
 	file, err := os.OpenFile(os.Getenv("LOG_PATH"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
@@ -59,20 +66,37 @@ func TestPushLog(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// execute PushLog()
+	// execute PushLog() with the default LOG_PATTERN
 	writer := csv.NewWriter(file)
 	writer.Write([]string{
 		testLogData.date,
 		testLogData.key,
 		testLogData.path,
-		testLogData.custom,
+		"200",
+		"billing",
 	})
 	writer.Flush()
-	dblogger.PushLog()
+	if err := dblogger.PushLog(); err != nil {
+		t.Fatal(err)
+	}
+	// the lines are not shipped twice
+	if err := dblogger.PushLog(); err != nil {
+		t.Fatal(err)
+	}
 
 	// check if log is written to database correctly
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM log_list WHERE api_key='key'").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("unexpected count of logs %d, expected 1", count)
+	}
 	row := testLog{}
-	if err := db.QueryRow("SELECT * FROM log_list WHERE api_key='key'").Scan(&row.date, &row.key, &row.path, &row.custom); err != nil {
+	var runDate time.Time
+	var customMatched bool
+	if err := db.QueryRow("SELECT run_date, api_key, api_path, custom_log::text, custom_log = $1::jsonb FROM log_list WHERE api_key='key'", testLogData.custom).
+		Scan(&runDate, &row.key, &row.path, &row.custom, &customMatched); err != nil {
 		t.Fatal(err)
 	}
 
@@ -80,18 +104,14 @@ func TestPushLog(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	t2, err := time.Parse(time.RFC3339, row.date)
-	if err != nil {
-		t.Fatal(err)
-	}
 
-	if !t1.Equal(t2) {
-		t.Fatalf("unexpected date %s, expected %s", t1.String(), t2.String())
+	if !t1.Equal(runDate) {
+		t.Fatalf("unexpected date %s, expected %s", runDate.String(), t1.String())
 	} else if row.key != testLogData.key {
 		t.Fatalf("unexpected key %s, expected %s", row.key, testLogData.key)
 	} else if row.path != testLogData.path {
 		t.Fatalf("unexpected path %s, expected %s", row.path, testLogData.path)
-	} else if row.custom != testLogData.custom {
+	} else if !customMatched {
 		t.Fatalf("unexpected custom data %s, expected %s", row.custom, testLogData.custom)
 	}
 }
@@ -0,0 +1,141 @@
+package dblogger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/future-architect/apidoor/logtail"
+)
+
+// maxInsertRows keeps an insert statement within the limit of 65535 parameters
+const maxInsertRows = 1000
+
+// ErrOffsetConflict is returned if the offset is updated by another shipper of the same log file
+var ErrOffsetConflict = errors.New("offset of the log file is updated by another shipper")
+
+// Shipper inserts the lines appended to the log file into log_list.
+// The offset of the shipped lines is stored in log_shipper_offset in the same transaction as the insert,
+// so each line is inserted exactly once even if the shipper stops in the middle.
+type Shipper struct {
+	DB     *sql.DB
+	Path   string
+	Parser LineParser
+	// BatchSize is the number of lines inserted by a transaction, 1000 if it is 0
+	BatchSize int
+}
+
+// Run ships the log file every interval until the context is done
+func (s Shipper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := s.ShipOnce(ctx)
+		if err != nil {
+			log.Printf("shipping %s failed: %v", s.Path, err)
+		} else if n > 0 {
+			log.Printf("shipped %d lines of %s", n, s.Path)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ShipOnce inserts the complete lines after the stored offset, and returns the number of the shipped lines.
+// If the log file is rotated, the rest of the previous file and the files rotated after it are shipped in rotation
+// order before the new file.
+func (s Shipper) ShipOnce(ctx context.Context) (int, error) {
+	tailer := logtail.Tailer{
+		Path:      s.Path,
+		Store:     offsetStore{s},
+		BatchSize: s.BatchSize,
+	}
+	return tailer.ReadOnce(ctx)
+}
+
+// offsetStore stores the offset of the log file in log_shipper_offset
+type offsetStore struct {
+	Shipper
+}
+
+func (o offsetStore) Load(ctx context.Context) (logtail.Position, error) {
+	if _, err := o.DB.ExecContext(ctx,
+		"INSERT INTO log_shipper_offset(log_path, fingerprint, file_offset, updated_at) VALUES($1, '', 0, now()) ON CONFLICT DO NOTHING",
+		o.Path); err != nil {
+		return logtail.Position{}, fmt.Errorf("create offset: %w", err)
+	}
+	var pos logtail.Position
+	if err := o.DB.QueryRowContext(ctx, "SELECT fingerprint, file_offset FROM log_shipper_offset WHERE log_path = $1", o.Path).
+		Scan(&pos.Fingerprint, &pos.Offset); err != nil {
+		return logtail.Position{}, fmt.Errorf("select offset: %w", err)
+	}
+	return pos, nil
+}
+
+func (o offsetStore) Commit(ctx context.Context, prev, next logtail.Position, lines []logtail.Line) (int, error) {
+	rows := make([]Row, 0, len(lines))
+	for _, line := range lines {
+		row, err := o.Parser.Parse(line.Content)
+		if err != nil {
+			log.Printf("[WARN] skip a line at offset %d of %s: %v", line.Offset, line.Path, err)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := o.commit(ctx, prev, next, rows); err != nil {
+		return 0, err
+	}
+	return len(rows), nil
+}
+
+// commit inserts the rows and moves the offset from prev to next in a transaction,
+// which fails with ErrOffsetConflict if the offset is not prev
+func (s Shipper) commit(ctx context.Context, prev, next logtail.Position, rows []Row) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current logtail.Position
+	if err := tx.QueryRowContext(ctx, "SELECT fingerprint, file_offset FROM log_shipper_offset WHERE log_path = $1 FOR UPDATE", s.Path).
+		Scan(&current.Fingerprint, &current.Offset); err != nil {
+		return fmt.Errorf("lock offset: %w", err)
+	}
+	if current != prev {
+		return ErrOffsetConflict
+	}
+
+	for start := 0; start < len(rows); start += maxInsertRows {
+		end := start + maxInsertRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*4)
+		for _, row := range rows[start:end] {
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4))
+			args = append(args, row.RunDate, row.APIKey, row.APIPath, string(row.CustomLog))
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO log_list(run_date, api_key, api_path, custom_log) VALUES "+strings.Join(values, ", "),
+			args...); err != nil {
+			return fmt.Errorf("insert log_list: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE log_shipper_offset SET fingerprint = $2, file_offset = $3, updated_at = now() WHERE log_path = $1",
+		s.Path, next.Fingerprint, next.Offset); err != nil {
+		return fmt.Errorf("update offset: %w", err)
+	}
+	return tx.Commit()
+}
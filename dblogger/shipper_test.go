@@ -0,0 +1,94 @@
+package dblogger_test
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/future-architect/apidoor/dblogger"
+)
+
+func TestShipper_Rotation(t *testing.T) {
+	db := openTestDB(t)
+	logPath := filepath.Join(t.TempDir(), "log.csv")
+	cleanupTestLog(t, db, "rotation-key", logPath)
+
+	parser, err := dblogger.NewCSVParser("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipper := dblogger.Shipper{DB: db, Path: logPath, Parser: parser, BatchSize: 2}
+	ctx := context.Background()
+
+	line := func(i int) string {
+		return fmt.Sprintf("2021-07-01T14:01:%02d+09:00,rotation-key,/%d,200,billing\n", i, i)
+	}
+	appendLog := func(path, content string) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ship := func(want int) {
+		t.Helper()
+		n, err := shipper.ShipOnce(ctx)
+		if err != nil {
+			t.Fatalf("ship failed: %v", err)
+		}
+		if n != want {
+			t.Errorf("unexpected shipped lines %d, expected %d", n, want)
+		}
+	}
+
+	// lines are shipped in batches, and an incomplete line waits for its end
+	appendLog(logPath, line(0)+line(1)+line(2)+"2021-07-01T14:01:03+09:00,rotation")
+	ship(3)
+	appendLog(logPath, "-key,/3,200,billing\n"+line(4))
+	ship(2)
+
+	// the rest of the rotated file is shipped before the new file, even if it is compressed
+	appendLog(logPath, line(5))
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := os.Create(logPath + ".1.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(rotated)
+	gz.Write(content)
+	gz.Close()
+	rotated.Close()
+	if err := os.Remove(logPath); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(logPath, line(6)+line(7))
+	ship(3)
+	ship(0)
+
+	rows, err := db.Query("SELECT api_path FROM log_list WHERE api_key = 'rotation-key' ORDER BY run_date")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	if got, want := fmt.Sprint(paths), "[/0 /1 /2 /3 /4 /5 /6 /7]"; got != want {
+		t.Errorf("unexpected shipped paths %s, expected %s", got, want)
+	}
+}
@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+// cache statuses, which the gateway returns in the header StatusHeader and records in the access log
+const (
+	StatusHeader = "X-Apidoor-Cache"
+
+	// StatusHit means the response is served from the cache without calling the api
+	StatusHit = "HIT"
+	// StatusRevalidated means the api answered 304 to a conditional request, and the cached response is served
+	StatusRevalidated = "REVALIDATED"
+	// StatusMiss means the response is fetched from the api
+	StatusMiss = "MISS"
+)
+
+var (
+	// MaxEntryBytes is the maximum size of a response body stored in the cache
+	MaxEntryBytes int64 = 1 << 20
+	// StaleRetention is how long a stale entry with validators is kept to revalidate it with a conditional request
+	StaleRetention = 24 * time.Hour
+)
+
+// cacheableStatusCodes are status codes cacheable by default, RFC 7231 section 6.1
+var cacheableStatusCodes = map[int]struct{}{
+	200: {}, 203: {}, 204: {}, 300: {}, 301: {}, 404: {}, 405: {}, 410: {}, 414: {}, 501: {},
+}
+
+// Store keeps cached responses, whose implementations must be safe for concurrent use
+type Store interface {
+	// Get returns nil if the entry does not exist
+	Get(ctx context.Context, key string) (*Entry, error)
+	// Set stores the entry, which may be evicted after ttl
+	Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error
+}
+
+// Entry is a cached response
+type Entry struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	// Vary holds values of the request headers listed in the Vary header of the response
+	Vary      map[string]string `json:"vary,omitempty"`
+	StoredAt  time.Time         `json:"stored_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Key returns the key of the cache for the request, which is separated by api keys
+// because the response may depend on tokens or headers added for the key
+func Key(apikey string, r *http.Request) string {
+	return "cache:" + apikey + ":" + r.URL.RequestURI()
+}
+
+// IsCacheableRequest reports whether the response of the request can be served from or stored in the cache
+func IsCacheableRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	_, noStore := parseCacheControl(r.Header)["no-store"]
+	return !noStore
+}
+
+// RequiresRevalidation reports whether the client asks not to be served a cached response without revalidation
+func RequiresRevalidation(r *http.Request) bool {
+	cc := parseCacheControl(r.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return true
+	}
+	if v, ok := cc["max-age"]; ok && v == "0" {
+		return true
+	}
+	return r.Header.Get("Pragma") == "no-cache"
+}
+
+// NewEntry returns an entry of the response and how long it is kept in the store,
+// or nil if the response must not be stored. a response without freshness information is fresh for defaultTTL
+func NewEntry(r *http.Request, res *http.Response, body []byte, defaultTTL time.Duration) (*Entry, time.Duration) {
+	if _, ok := cacheableStatusCodes[res.StatusCode]; !ok {
+		return nil, 0
+	}
+	cc := parseCacheControl(res.Header)
+	if _, ok := cc["no-store"]; ok {
+		return nil, 0
+	}
+	// the gateway is a shared cache
+	if _, ok := cc["private"]; ok {
+		return nil, 0
+	}
+	if res.Header.Get("Set-Cookie") != "" {
+		return nil, 0
+	}
+
+	vary := make(map[string]string)
+	for _, name := range varyHeaders(res.Header) {
+		if name == "*" {
+			return nil, 0
+		}
+		vary[name] = r.Header.Get(name)
+	}
+
+	now := flextime.Now()
+	entry := &Entry{
+		StatusCode: res.StatusCode,
+		Header:     res.Header.Clone(),
+		Body:       body,
+		Vary:       vary,
+		StoredAt:   now,
+		ExpiresAt:  now.Add(freshness(res.Header, cc, now, defaultTTL)),
+	}
+	entry.Header.Del(StatusHeader)
+
+	ttl := entry.ExpiresAt.Sub(now)
+	if entry.hasValidators() {
+		ttl += StaleRetention
+	}
+	if ttl <= 0 {
+		return nil, 0
+	}
+	return entry, ttl
+}
+
+// freshness follows RFC 7234 section 4.2.1, s-maxage precedes max-age, which precedes Expires
+func freshness(header http.Header, cc map[string]string, now time.Time, defaultTTL time.Duration) time.Duration {
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds < 0 {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	if v := header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil || expires.Before(now) {
+			return 0
+		}
+		return expires.Sub(now)
+	}
+	return defaultTTL
+}
+
+// Fresh reports whether the entry can be served without revalidation
+func (e *Entry) Fresh() bool {
+	return flextime.Now().Before(e.ExpiresAt)
+}
+
+// MatchesVary reports whether the request has the same values of the headers listed in the Vary header
+func (e *Entry) MatchesVary(header http.Header) bool {
+	for name, value := range e.Vary {
+		if header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Entry) hasValidators() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// AddConditionalHeaders sets validators of the entry to the request to revalidate it.
+// it returns false if the entry cannot be revalidated
+func (e *Entry) AddConditionalHeaders(header http.Header) bool {
+	if !e.hasValidators() {
+		return false
+	}
+	header.Del("If-None-Match")
+	header.Del("If-Modified-Since")
+	if etag := e.Header.Get("ETag"); etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified := e.Header.Get("Last-Modified"); lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+	return true
+}
+
+// Revalidate updates the entry with the 304 response of the api, and returns how long it is kept in the store
+func (e *Entry) Revalidate(res *http.Response, defaultTTL time.Duration) time.Duration {
+	// RFC 7234 section 4.3.4, headers of the 304 response replace the stored ones
+	for key, values := range res.Header {
+		if key == "Content-Length" {
+			continue
+		}
+		e.Header[key] = values
+	}
+	now := flextime.Now()
+	e.StoredAt = now
+	e.ExpiresAt = now.Add(freshness(e.Header, parseCacheControl(e.Header), now, defaultTTL))
+	return e.ExpiresAt.Sub(now) + StaleRetention
+}
+
+// Response returns the response served from the entry
+func (e *Entry) Response(status string) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(flextime.Now().Sub(e.StoredAt).Seconds())))
+	header.Set(StatusHeader, status)
+	header.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	return &http.Response{
+		StatusCode:    e.StatusCode,
+		Header:        header,
+		ContentLength: int64(len(e.Body)),
+	}
+}
+
+func parseCacheControl(header http.Header) map[string]string {
+	ret := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(line, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, value = directive[:i], strings.Trim(directive[i+1:], `"`)
+			}
+			ret[strings.ToLower(name)] = value
+		}
+	}
+	return ret
+}
+
+func varyHeaders(header http.Header) []string {
+	ret := make([]string, 0)
+	for _, line := range header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				ret = append(ret, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+func TestNewEntry(t *testing.T) {
+	restore := flextime.Fix(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC))
+	defer restore()
+
+	tests := []struct {
+		name       string
+		status     int
+		header     http.Header
+		defaultTTL time.Duration
+		wantStored bool
+		wantFresh  time.Duration
+		wantTTL    time.Duration
+	}{
+		{
+			name:       "max-age gives freshness",
+			status:     http.StatusOK,
+			header:     http.Header{"Cache-Control": {"public, max-age=60"}},
+			wantStored: true,
+			wantFresh:  time.Minute,
+			wantTTL:    time.Minute,
+		},
+		{
+			name:       "s-maxage precedes max-age",
+			status:     http.StatusOK,
+			header:     http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}},
+			wantStored: true,
+			wantFresh:  10 * time.Second,
+			wantTTL:    10 * time.Second,
+		},
+		{
+			name:       "expires gives freshness",
+			status:     http.StatusOK,
+			header:     http.Header{"Expires": {"Sat, 01 Jan 2022 00:00:30 GMT"}},
+			wantStored: true,
+			wantFresh:  30 * time.Second,
+			wantTTL:    30 * time.Second,
+		},
+		{
+			name:       "default ttl is used without freshness information",
+			status:     http.StatusOK,
+			header:     http.Header{},
+			defaultTTL: 5 * time.Second,
+			wantStored: true,
+			wantFresh:  5 * time.Second,
+			wantTTL:    5 * time.Second,
+		},
+		{
+			name:       "no-cache response with an etag is kept to be revalidated",
+			status:     http.StatusOK,
+			header:     http.Header{"Cache-Control": {"no-cache"}, "Etag": {`"v1"`}},
+			defaultTTL: 5 * time.Second,
+			wantStored: true,
+			wantTTL:    StaleRetention,
+		},
+		{
+			name:   "no-cache response without validators",
+			status: http.StatusOK,
+			header: http.Header{"Cache-Control": {"no-cache"}},
+		},
+		{
+			name:   "no-store",
+			status: http.StatusOK,
+			header: http.Header{"Cache-Control": {"no-store, max-age=60"}},
+		},
+		{
+			name:   "private",
+			status: http.StatusOK,
+			header: http.Header{"Cache-Control": {"private, max-age=60"}},
+		},
+		{
+			name:   "vary by all headers",
+			status: http.StatusOK,
+			header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}},
+		},
+		{
+			name:   "status code is not cacheable",
+			status: http.StatusInternalServerError,
+			header: http.Header{"Cache-Control": {"max-age=60"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "http://localhost/test", nil)
+			res := &http.Response{StatusCode: tt.status, Header: tt.header}
+			entry, ttl := NewEntry(r, res, []byte("body"), tt.defaultTTL)
+			if !tt.wantStored {
+				if entry != nil {
+					t.Errorf("response must not be stored, ttl %v", ttl)
+				}
+				return
+			}
+			if entry == nil {
+				t.Fatal("response is not stored")
+			}
+			if got := entry.ExpiresAt.Sub(flextime.Now()); got != tt.wantFresh {
+				t.Errorf("wrong freshness: got %v, want %v", got, tt.wantFresh)
+			}
+			if ttl != tt.wantTTL {
+				t.Errorf("wrong ttl: got %v, want %v", ttl, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestEntry_MatchesVary(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "http://localhost/test", nil)
+	r.Header.Set("Accept-Language", "ja")
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"accept-language"}},
+	}
+	entry, _ := NewEntry(r, res, nil, 0)
+	if entry == nil {
+		t.Fatal("response is not stored")
+	}
+	if !entry.MatchesVary(http.Header{"Accept-Language": {"ja"}}) {
+		t.Error("entry must match the request with the same header")
+	}
+	if entry.MatchesVary(http.Header{"Accept-Language": {"en"}}) {
+		t.Error("entry must not match the request with a different header")
+	}
+}
+
+func TestEntry_Revalidate(t *testing.T) {
+	now := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	restore := flextime.Fix(now)
+	defer restore()
+
+	entry := &Entry{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Etag": {`"v1"`}, "Cache-Control": {"max-age=10"}, "Content-Length": {"4"}},
+		Body:       []byte("body"),
+		StoredAt:   now.Add(-time.Minute),
+		ExpiresAt:  now.Add(-50 * time.Second),
+	}
+	header := http.Header{"If-None-Match": {`"client"`}}
+	if !entry.AddConditionalHeaders(header) {
+		t.Fatal("entry with an etag must be revalidated")
+	}
+	if got := header.Get("If-None-Match"); got != `"v1"` {
+		t.Errorf("wrong If-None-Match: got %s", got)
+	}
+
+	ttl := entry.Revalidate(&http.Response{
+		StatusCode: http.StatusNotModified,
+		Header:     http.Header{"Cache-Control": {"max-age=30"}, "Content-Length": {"0"}},
+	}, 0)
+	if !entry.Fresh() || entry.ExpiresAt != now.Add(30*time.Second) {
+		t.Errorf("entry is not refreshed, expires at %v", entry.ExpiresAt)
+	}
+	if ttl != 30*time.Second+StaleRetention {
+		t.Errorf("wrong ttl: got %v", ttl)
+	}
+	if got := entry.Header.Get("Content-Length"); got != "4" {
+		t.Errorf("content length of the stored body must be kept: got %s", got)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	restore := flextime.Fix(now)
+	defer restore()
+
+	ctx := context.Background()
+	store := NewMemoryStore(2)
+	for _, key := range []string{"a", "b"} {
+		if err := store.Set(ctx, key, &Entry{Body: []byte(key)}, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a is used recently, so b is evicted
+	if entry, _ := store.Get(ctx, "a"); entry == nil {
+		t.Fatal("entry a is not found")
+	}
+	if err := store.Set(ctx, "c", &Entry{Body: []byte("c")}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := store.Get(ctx, "b"); entry != nil {
+		t.Error("least recently used entry b must be evicted")
+	}
+
+	flextime.Fix(now.Add(2 * time.Second))
+	if entry, _ := store.Get(ctx, "c"); entry != nil {
+		t.Error("entry c must be evicted after its ttl")
+	}
+	if entry, _ := store.Get(ctx, "a"); entry == nil || string(entry.Body) != "a" {
+		t.Errorf("entry a must be kept, got %v", entry)
+	}
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+// MemoryStore keeps entries in memory, and evicts the least recently used one when it is full
+type MemoryStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	items      map[string]*list.Element
+	order      *list.List
+}
+
+type memoryItem struct {
+	key     string
+	entry   *Entry
+	evictAt time.Time
+}
+
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (ms *MemoryStore) Get(_ context.Context, key string) (*Entry, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	elem, ok := ms.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := elem.Value.(*memoryItem)
+	if !flextime.Now().Before(item.evictAt) {
+		ms.order.Remove(elem)
+		delete(ms.items, key)
+		return nil, nil
+	}
+	ms.order.MoveToFront(elem)
+	// a copy is returned so that the caller can update it without a lock
+	entry := *item.entry
+	entry.Header = item.entry.Header.Clone()
+	return &entry, nil
+}
+
+func (ms *MemoryStore) Set(_ context.Context, key string, entry *Entry, ttl time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	item := &memoryItem{
+		key:     key,
+		entry:   entry,
+		evictAt: flextime.Now().Add(ttl),
+	}
+	if elem, ok := ms.items[key]; ok {
+		elem.Value = item
+		ms.order.MoveToFront(elem)
+		return nil
+	}
+	ms.items[key] = ms.order.PushFront(item)
+	for ms.maxEntries > 0 && ms.order.Len() > ms.maxEntries {
+		oldest := ms.order.Back()
+		ms.order.Remove(oldest)
+		delete(ms.items, oldest.Value.(*memoryItem).key)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisStore keeps entries as json in redis, so that they are shared by gateway instances
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+func (rs *RedisStore) Get(ctx context.Context, key string) (*Entry, error) {
+	data, err := rs.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get cache entry error: %w", err)
+	}
+	var entry Entry
+	if err = json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("parse cache entry error: %w", err)
+	}
+	return &entry, nil
+}
+
+func (rs *RedisStore) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshal cache entry error: %w", err)
+	}
+	if err = rs.client.Set(ctx, key, data, ttl).Err(); err != nil {
+		return fmt.Errorf("set cache entry error: %w", err)
+	}
+	return nil
+}
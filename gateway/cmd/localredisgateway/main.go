@@ -2,23 +2,37 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/csv"
+	"fmt"
 	"github.com/future-architect/apidoor/gateway"
+	"github.com/future-architect/apidoor/gateway/cache"
 	"github.com/future-architect/apidoor/gateway/datasource"
 	"github.com/future-architect/apidoor/gateway/datasource/dynamo"
 	"github.com/future-architect/apidoor/gateway/datasource/redis"
 	"github.com/future-architect/apidoor/gateway/logger"
+	"github.com/future-architect/apidoor/gateway/metrics"
+	"github.com/future-architect/apidoor/gateway/oauth2"
 	"github.com/go-chi/chi/v5"
+	goredis "github.com/go-redis/redis/v8"
+	_ "github.com/lib/pq"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
 
 var (
-	updateDBInterval = 10 * time.Second
+	updateDBInterval        = 10 * time.Second
+	defaultCacheMaxEntries  = 1000
+	tokenRequestTimeout     = 10 * time.Second
+	logSinkRequestTimeout   = 10 * time.Second
+	defaultLogFlushInterval = time.Second
+	defaultAdminAddr        = ":3002"
 )
 
 // gateway entry point @localhost
@@ -29,16 +43,54 @@ func main() {
 		logPath = "./log.csv"
 	}
 
-	// open log file
-	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	// open log file, which is rotated by size or age
+	file, err := logger.OpenRotatingFile(logPath)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 	defer file.Close()
+	file.MaxBytes = envInt64("LOG_ROTATE_BYTES")
+	file.MaxAge = envDuration("LOG_ROTATE_INTERVAL", 0)
+	file.MaxBackups = int(envInt64("LOG_MAX_BACKUPS"))
+	file.MaxBackupAge = envDuration("LOG_MAX_BACKUP_AGE", 0)
+	file.Compress = os.Getenv("LOG_COMPRESS") == "true"
+
+	// keep access logs on local disk until they are put to the db
+	var spool *logger.Spool
+	if dir := os.Getenv("LOG_SPOOL_DIR"); dir != "" {
+		spool, err = logger.OpenSpool(dir)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer spool.Close()
+	}
+
+	sink, closeSink := newLogSink()
+	defer closeSink()
 
 	// write to log file
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	var appender logger.Appender
+	// flush writes the buffered logs to the log file
+	flush := func() error { return nil }
+	switch os.Getenv("LOG_FORMAT") {
+	case "", "CSV":
+		csvAppender := &logger.CSVAppender{
+			Writer: csv.NewWriter(file),
+			Spool:  spool,
+			Sink:   sink,
+		}
+		defer csvAppender.Flush()
+		flush = csvAppender.Flush
+		appender = csvAppender
+	case "JSON":
+		jsonAppender := logger.NewJSONAppender(file)
+		jsonAppender.Spool = spool
+		jsonAppender.Sink = sink
+		appender = jsonAppender
+	default:
+		log.Fatalf("unsupported LOG_FORMAT: %s", os.Getenv("LOG_FORMAT"))
+	}
+	logger.TrustForwardedFor = os.Getenv("TRUST_X_FORWARDED_FOR") == "true"
 
 	//set up api db
 	var dataSource datasource.DataSource
@@ -50,10 +102,13 @@ func main() {
 	}
 
 	h := gateway.DefaultHandler{
-		Appender: &logger.CSVAppender{
-			Writer: writer,
-		},
-		DataSource: dataSource,
+		Appender:             appender,
+		DataSource:           dataSource,
+		Cache:                newCacheStore(),
+		MaxRequestBodyBytes:  envInt64("MAX_REQUEST_BODY_BYTES"),
+		MaxResponseBodyBytes: envInt64("MAX_RESPONSE_BODY_BYTES"),
+		ValidateRequests:     os.Getenv("VALIDATE_REQUESTS") == "true",
+		TokenSource:          oauth2.NewClientCredentials(&http.Client{Timeout: tokenRequestTimeout}),
 	}
 
 	ctx := context.Background()
@@ -64,13 +119,45 @@ func main() {
 	go logger.UpdateDBRoutine(ctx, h.Appender, updateDBInterval, routineKill, routineFinish)
 	defer logger.CleanupUpdateDBTask(routineKill, routineFinish)
 
+	// flush the log file periodically not to lose logs by a crash
+	go func() {
+		ticker := time.NewTicker(envDuration("LOG_FLUSH_INTERVAL", defaultLogFlushInterval))
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := flush(); err != nil {
+				log.Printf("flush log file failed: %v", err)
+			}
+		}
+	}()
+
+	// reopen the log file moved by logrotate
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := flush(); err != nil {
+				log.Printf("flush log file failed: %v", err)
+			}
+			if err := file.Reopen(); err != nil {
+				log.Printf("reopen log file failed: %v", err)
+			}
+		}
+	}()
+
 	// capturing keyboard interrupt
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
 		log.Println("keyboard interrupt occurs")
 		logger.CleanupUpdateDBTask(routineKill, routineFinish)
+		if spool != nil {
+			spool.Close()
+		}
+		if err := flush(); err != nil {
+			log.Printf("flush log file failed: %v", err)
+		}
+		file.Close()
 		os.Exit(2)
 	}()
 
@@ -82,6 +169,26 @@ func main() {
 		r.Post("/*", h.Handle)
 	})
 
+	// serve the metrics on the admin port, which is not exposed to clients of apis
+	if buffered, ok := appender.(interface{ BufferedItems() int64 }); ok {
+		metrics.BufferedItems(buffered.BufferedItems)
+	}
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = defaultAdminAddr
+	}
+	adminRouter := chi.NewRouter()
+	adminRouter.Handle("/metrics", metrics.Handler())
+	go func() {
+		admin := &http.Server{
+			Addr:    adminAddr,
+			Handler: adminRouter,
+		}
+		if err := admin.ListenAndServe(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
 	s := &http.Server{
 		Addr:    ":3000",
 		Handler: r,
@@ -92,3 +199,119 @@ func main() {
 	}
 
 }
+
+// newLogSink returns the sinks of access logs selected by LOG_SINKS, and the function closing them
+func newLogSink() (logger.LogSink, func()) {
+	names := os.Getenv("LOG_SINKS")
+	if names == "" {
+		names = "DYNAMO"
+	}
+	var sinks logger.MultiSink
+	closeSink := func() {}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "DYNAMO":
+			sinks = append(sinks, logger.DynamoSink())
+		case "POSTGRES":
+			db, err := sql.Open("postgres",
+				"host="+os.Getenv("DATABASE_HOST")+" "+
+					"port="+os.Getenv("DATABASE_PORT")+" "+
+					"user="+os.Getenv("DATABASE_USER")+" "+
+					"password="+os.Getenv("DATABASE_PASSWORD")+" "+
+					"dbname="+os.Getenv("DATABASE_NAME")+" "+
+					"sslmode="+os.Getenv("DATABASE_SSLMODE"))
+			if err != nil {
+				log.Fatal(err)
+			}
+			closeSink = func() { db.Close() }
+			sinks = append(sinks, logger.PostgresSink{DB: db})
+		case "WEBHOOK":
+			sink := logger.WebhookSink{
+				URL:    os.Getenv("LOG_WEBHOOK_URL"),
+				Client: &http.Client{Timeout: logSinkRequestTimeout},
+			}
+			if sink.URL == "" {
+				log.Fatal("missing LOG_WEBHOOK_URL env")
+			}
+			if auth := os.Getenv("LOG_WEBHOOK_AUTHORIZATION"); auth != "" {
+				sink.Header = http.Header{"Authorization": []string{auth}}
+			}
+			sinks = append(sinks, sink)
+		case "STREAM":
+			producer := logger.KafkaRESTProducer{
+				URL:    os.Getenv("LOG_STREAM_URL"),
+				Topic:  os.Getenv("LOG_STREAM_TOPIC"),
+				Client: &http.Client{Timeout: logSinkRequestTimeout},
+			}
+			if producer.URL == "" || producer.Topic == "" {
+				log.Fatal("missing LOG_STREAM_URL or LOG_STREAM_TOPIC env")
+			}
+			sinks = append(sinks, logger.StreamSink{Producer: producer})
+		default:
+			log.Fatalf("unsupported LOG_SINKS: %s", name)
+		}
+	}
+	if len(sinks) == 1 {
+		return sinks[0], closeSink
+	}
+	return sinks, closeSink
+}
+
+// newCacheStore returns the response cache store selected by CACHE_TYPE, nil disables the cache
+func newCacheStore() cache.Store {
+	switch os.Getenv("CACHE_TYPE") {
+	case "":
+		return nil
+	case "MEMORY":
+		maxEntries := defaultCacheMaxEntries
+		if v := os.Getenv("CACHE_MAX_ENTRIES"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Fatalf("invalid CACHE_MAX_ENTRIES: %v", err)
+			}
+			maxEntries = n
+		}
+		return cache.NewMemoryStore(maxEntries)
+	case "REDIS":
+		host := os.Getenv("REDIS_HOST")
+		if host == "" {
+			host = "localhost"
+		}
+		port := os.Getenv("REDIS_PORT")
+		if port == "" {
+			port = "6379"
+		}
+		return cache.NewRedisStore(goredis.NewClient(&goredis.Options{
+			Addr: fmt.Sprintf("%s:%s", host, port),
+		}))
+	default:
+		log.Fatalf("unsupported CACHE_TYPE: %s", os.Getenv("CACHE_TYPE"))
+		return nil
+	}
+}
+
+// envInt64 returns the env as an integer, 0 if it is not set
+func envInt64(key string) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return n
+}
+
+// envDuration returns the env as a duration such as "24h", def if it is not set
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return d
+}
@@ -0,0 +1,30 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"github.com/future-architect/apidoor/gateway/model"
+	"github.com/future-architect/apidoor/secret"
+)
+
+// DecryptAccessTokens decrypts secrets of the tokens encrypted by the management api in place.
+// secrets stored in plaintext are left as they are, and an encrypted secret is an error if envelope is nil
+func DecryptAccessTokens(ctx context.Context, envelope *secret.Envelope, tokens *model.AccessTokens) error {
+	for i := range tokens.Tokens {
+		token := &tokens.Tokens[i]
+		for _, field := range []*string{&token.Value, &token.ClientSecret} {
+			if !secret.IsEncrypted(*field) {
+				continue
+			}
+			if envelope == nil {
+				return fmt.Errorf("access token of %s is encrypted, but %s is not set", token.ParamType, secret.KeyFileEnv)
+			}
+			plaintext, err := envelope.Decrypt(ctx, *field)
+			if err != nil {
+				return fmt.Errorf("decrypt access token of %s failed: %w", token.ParamType, err)
+			}
+			*field = plaintext
+		}
+	}
+	return nil
+}
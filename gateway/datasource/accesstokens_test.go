@@ -0,0 +1,54 @@
+package datasource
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/future-architect/apidoor/gateway/model"
+	"github.com/future-architect/apidoor/secret"
+	"github.com/google/go-cmp/cmp"
+	"strings"
+	"testing"
+)
+
+func TestDecryptAccessTokens(t *testing.T) {
+	ctx := context.Background()
+	keyFile, err := secret.ParseKeyFile([]byte("k1:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := secret.NewEnvelope(keyFile)
+	value, err := envelope.Encrypt(ctx, "token-value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSecret, err := envelope.Encrypt(ctx, "client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTokens := func() model.AccessTokens {
+		return model.AccessTokens{Tokens: []model.AccessToken{
+			{ParamType: model.Header, Key: "X-Token", Value: value},
+			{ParamType: model.Query, Key: "token", Value: "plain-value"},
+			{ParamType: model.OAuth2ClientCredentials, ClientID: "id", ClientSecret: clientSecret},
+		}}
+	}
+
+	tokens := newTokens()
+	if err = DecryptAccessTokens(ctx, envelope, &tokens); err != nil {
+		t.Fatalf("decrypt access tokens failed: %v", err)
+	}
+	want := model.AccessTokens{Tokens: []model.AccessToken{
+		{ParamType: model.Header, Key: "X-Token", Value: "token-value"},
+		{ParamType: model.Query, Key: "token", Value: "plain-value"},
+		{ParamType: model.OAuth2ClientCredentials, ClientID: "id", ClientSecret: "client-secret"},
+	}}
+	if diff := cmp.Diff(want, tokens); diff != "" {
+		t.Errorf("decrypted tokens differ:\n%s", diff)
+	}
+
+	tokens = newTokens()
+	if err = DecryptAccessTokens(ctx, nil, &tokens); err == nil {
+		t.Error("encrypted tokens are decrypted without a key")
+	}
+}
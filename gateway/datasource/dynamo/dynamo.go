@@ -7,21 +7,31 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/future-architect/apidoor/gateway/datasource"
 	"github.com/future-architect/apidoor/gateway/model"
+	"github.com/future-architect/apidoor/secret"
 	"github.com/guregu/dynamo"
 	"log"
 	"os"
 )
 
 type APIRouting struct {
-	APIKey     string `dynamo:"api_key"`
-	Path       string `dynamo:"path"`
-	ForwardURL string `dynamo:"forward_url"`
+	APIKey         string             `dynamo:"api_key"`
+	Path           string             `dynamo:"path"`
+	ForwardURL     string             `dynamo:"forward_url"`
+	ContractID     int                `dynamo:"contract_id"`
+	ProductID      int                `dynamo:"product_id"`
+	APIKeyID       int                `dynamo:"apikey_id"`
+	ForwardHeaders map[string]string  `dynamo:"forward_headers"`
+	Transform      *model.Transform   `dynamo:"transform"`
+	Cache          *model.CacheConfig `dynamo:"cache"`
+	Operations     []model.Operation  `dynamo:"operations"`
 }
 
 type DataSource struct {
 	client          *dynamo.DB
 	apiRoutingTable string
 	accessKeyTable  string
+	// envelope decrypts access tokens encrypted by the management api
+	envelope *secret.Envelope
 }
 
 func New() *DataSource {
@@ -30,6 +40,12 @@ func New() *DataSource {
 		log.Fatal("missing DYNAMO_TABLE_API_ROUTING env")
 	}
 	//TODO: env
+	accessKeyTable := os.Getenv("DYNAMO_TABLE_ACCESS_TOKEN")
+
+	envelope, err := secret.NewEnvelopeFromEnv()
+	if err != nil {
+		log.Fatalf("load token encryption key failed: %v", err)
+	}
 
 	dbEndpoint := os.Getenv("DYNAMO_DATA_SOURCE_ENDPOINT")
 	if dbEndpoint != "" {
@@ -40,12 +56,16 @@ func New() *DataSource {
 				Config:            aws.Config{Endpoint: aws.String(dbEndpoint)},
 			}))),
 			apiRoutingTable: apiRoutingTable,
+			accessKeyTable:  accessKeyTable,
+			envelope:        envelope,
 		}
 	}
 
 	return &DataSource{
 		client:          dynamo.New(session.Must(session.NewSession())),
 		apiRoutingTable: apiRoutingTable,
+		accessKeyTable:  accessKeyTable,
+		envelope:        envelope,
 	}
 }
 
@@ -63,7 +83,18 @@ func (dd DataSource) GetFields(ctx context.Context, key string) (model.Fields, e
 
 	fields := make([]model.Field, 0, len(routingList))
 	for _, routing := range routingList {
-		field, err := datasource.CreateField(ctx, routing.APIKey, routing.Path, routing.ForwardURL)
+		field, err := datasource.CreateField(ctx, datasource.Routing{
+			APIKey:         routing.APIKey,
+			Path:           routing.Path,
+			ForwardURL:     routing.ForwardURL,
+			ContractID:     routing.ContractID,
+			ProductID:      routing.ProductID,
+			APIKeyID:       routing.APIKeyID,
+			ForwardHeaders: routing.ForwardHeaders,
+			Transform:      routing.Transform,
+			Cache:          routing.Cache,
+			Operations:     routing.Operations,
+		})
 		if err != nil {
 			return nil, fmt.Errorf("fetch field, key = %v, hk = %v, forwardURL = %v, error: %w",
 				routing.APIKey, routing.Path, routing.ForwardURL, err)
@@ -83,5 +114,8 @@ func (dd DataSource) GetAccessTokens(ctx context.Context, apikey, templatePath s
 	if err != nil && err != dynamo.ErrNotFound {
 		return nil, &model.MyError{Message: fmt.Sprintf("get access tokens db error: %v", err)}
 	}
+	if err = datasource.DecryptAccessTokens(ctx, dd.envelope, &tokens); err != nil {
+		return nil, err
+	}
 	return &tokens, nil
 }
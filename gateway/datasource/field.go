@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/future-architect/apidoor/gateway/logger"
 	"github.com/future-architect/apidoor/gateway/model"
+	"strconv"
 	"strings"
 )
 
@@ -12,12 +13,42 @@ var (
 	defaultAPICallMaxLimit = 100
 )
 
-func CreateField(ctx context.Context, key, hkey, forwardURL string) (model.Field, error) {
+// Routing is a routing registered by the management api
+type Routing struct {
+	APIKey string
+	// Path is a gateway path
+	Path string
+	// ForwardURL may contain placeholders in its path and query string
+	ForwardURL     string
+	ContractID     int
+	APIKeyID       int
+	ProductID      int
+	ForwardHeaders map[string]string
+	Transform      *model.Transform
+	Cache          *model.CacheConfig
+	Operations     []model.Operation
+}
+
+func (r Routing) builtins() map[string]string {
+	ret := make(map[string]string, 3)
+	if r.APIKeyID != 0 {
+		ret["apikey_id"] = strconv.Itoa(r.APIKeyID)
+	}
+	if r.ContractID != 0 {
+		ret["contract_id"] = strconv.Itoa(r.ContractID)
+	}
+	if r.ProductID != 0 {
+		ret["product_id"] = strconv.Itoa(r.ProductID)
+	}
+	return ret
+}
+
+func CreateField(ctx context.Context, routing Routing) (model.Field, error) {
+	key, hkey, forwardURL := routing.APIKey, routing.Path, routing.ForwardURL
 	var schema string
 	if strings.HasPrefix(forwardURL, "http://") {
 		schema = "http"
 		forwardURL = strings.Replace(forwardURL, "http://", "", 1)
-		fmt.Println("Redis After:", forwardURL)
 	} else if strings.HasPrefix(forwardURL, "https://") {
 		schema = "https"
 		forwardURL = strings.Replace(forwardURL, "https://", "", 1)
@@ -25,6 +56,10 @@ func CreateField(ctx context.Context, key, hkey, forwardURL string) (model.Field
 		// スキーマが存在しない(tcpなどのスキーマは非対応)
 		schema = "http"
 	}
+	var query string
+	if i := strings.Index(forwardURL, "?"); i >= 0 {
+		forwardURL, query = forwardURL[:i], forwardURL[i+1:]
+	}
 	path := model.NewURITemplate(forwardURL)
 	template := model.NewURITemplate(hkey)
 
@@ -37,6 +72,12 @@ func CreateField(ctx context.Context, key, hkey, forwardURL string) (model.Field
 		Template:      template,
 		ForwardSchema: schema,
 		Path:          path,
+		Query:         query,
+		Headers:       routing.ForwardHeaders,
+		Builtins:      routing.builtins(),
+		Transform:     routing.Transform,
+		Cache:         routing.Cache,
+		Operations:    routing.Operations,
 		Num:           count,
 		Max:           defaultAPICallMaxLimit,
 	}, nil
@@ -2,15 +2,20 @@ package redis
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/future-architect/apidoor/gateway/datasource"
 	"github.com/future-architect/apidoor/gateway/model"
+	"github.com/future-architect/apidoor/secret"
 	"github.com/go-redis/redis/v8"
+	"log"
 	"os"
 )
 
 type DataSource struct {
 	client *redis.Client
+	// envelope decrypts access tokens encrypted by the management api
+	envelope *secret.Envelope
 }
 
 func New() *DataSource {
@@ -25,23 +30,64 @@ func New() *DataSource {
 		port = "6379"
 	}
 
+	envelope, err := secret.NewEnvelopeFromEnv()
+	if err != nil {
+		log.Fatalf("load token encryption key failed: %v", err)
+	}
+
 	return &DataSource{
 		client: redis.NewClient(&redis.Options{
 			Addr:     fmt.Sprintf("%s:%s", host, port),
 			Password: "",
 			DB:       0,
 		}),
+		envelope: envelope,
 	}
 }
 
+// routingMeta is the attributes of a routing other than the forward url,
+// which the management api stores as json in the hash "routing_meta:<api key>"
+type routingMeta struct {
+	ContractID     int                `json:"contract_id"`
+	ProductID      int                `json:"product_id"`
+	APIKeyID       int                `json:"apikey_id"`
+	ForwardHeaders map[string]string  `json:"forward_headers"`
+	Transform      *model.Transform   `json:"transform"`
+	Cache          *model.CacheConfig `json:"cache"`
+	Operations     []model.Operation  `json:"operations"`
+}
+
 func (rd DataSource) GetFields(ctx context.Context, key string) (model.Fields, error) {
 	var fields []model.Field
 
+	metas, err := rd.client.HGetAll(ctx, "routing_meta:"+key).Result()
+	if err != nil {
+		return nil, &model.MyError{Message: fmt.Sprintf("internal server error: %v", err)}
+	}
+
 	for _, hk := range rd.client.HKeys(ctx, key).Val() {
 
 		pathValue := rd.client.HGet(ctx, key, hk).Val()
 
-		field, err := datasource.CreateField(ctx, key, hk, pathValue)
+		var meta routingMeta
+		if v, ok := metas[hk]; ok {
+			if err := json.Unmarshal([]byte(v), &meta); err != nil {
+				return nil, fmt.Errorf("parse routing attributes, key = %v, hk = %v, error: %w", key, hk, err)
+			}
+		}
+
+		field, err := datasource.CreateField(ctx, datasource.Routing{
+			APIKey:         key,
+			Path:           hk,
+			ForwardURL:     pathValue,
+			ContractID:     meta.ContractID,
+			ProductID:      meta.ProductID,
+			APIKeyID:       meta.APIKeyID,
+			ForwardHeaders: meta.ForwardHeaders,
+			Transform:      meta.Transform,
+			Cache:          meta.Cache,
+			Operations:     meta.Operations,
+		})
 		if err != nil {
 			return nil, fmt.Errorf("fetch field, key = %v, hk = %v, forwardURL = %v, error: %w",
 				key, hk, pathValue, err)
@@ -57,7 +103,38 @@ func (rd DataSource) GetFields(ctx context.Context, key string) (model.Fields, e
 	return fields, nil
 }
 
+// accessToken is an access token which the management api stores as json in the hash "access_token:<api key>",
+// whose fields are paths
+type accessToken struct {
+	ParamType    model.ParamType `json:"param_type"`
+	Key          string          `json:"key"`
+	Value        string          `json:"value"`
+	ClientID     string          `json:"client_id"`
+	ClientSecret string          `json:"client_secret"`
+	TokenURL     string          `json:"token_url"`
+	Scopes       []string        `json:"scopes"`
+}
+
 func (dd DataSource) GetAccessTokens(ctx context.Context, apikey, templatePath string) (*model.AccessTokens, error) {
-	//TODO: impl
-	return nil, &model.MyError{Message: "for redis get access tokens is not implemented"}
+	var tokens model.AccessTokens
+	v, err := dd.client.HGet(ctx, "access_token:"+apikey, templatePath).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return &tokens, nil
+		}
+		return nil, &model.MyError{Message: fmt.Sprintf("get access tokens db error: %v", err)}
+	}
+
+	var stored []accessToken
+	if err = json.Unmarshal([]byte(v), &stored); err != nil {
+		return nil, fmt.Errorf("parse access tokens, key = %v, hk = %v, error: %w", apikey, templatePath, err)
+	}
+	tokens.Tokens = make([]model.AccessToken, len(stored))
+	for i, t := range stored {
+		tokens.Tokens[i] = model.AccessToken(t)
+	}
+	if err = datasource.DecryptAccessTokens(ctx, dd.envelope, &tokens); err != nil {
+		return nil, err
+	}
+	return &tokens, nil
 }
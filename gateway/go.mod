@@ -6,10 +6,15 @@ require (
 	github.com/Songmu/flextime v0.1.0
 	github.com/aws/aws-sdk-go v1.40.37
 	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
+	github.com/future-architect/apidoor/secret v0.0.0
 	github.com/go-chi/chi/v5 v5.0.3
 	github.com/go-redis/redis/v8 v8.11.3
 	github.com/gofrs/uuid v4.0.0+incompatible // indirect
 	github.com/google/go-cmp v0.5.6
 	github.com/guregu/dynamo v1.11.0
+	github.com/lib/pq v1.10.2
+	github.com/prometheus/client_golang v1.12.2
 	golang.org/x/net v0.0.0-20210903162142-ad29c8ab022f // indirect
 )
+
+replace github.com/future-architect/apidoor/secret => ../secret
@@ -1,24 +1,46 @@
 package gateway
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"github.com/Songmu/flextime"
+	"github.com/future-architect/apidoor/gateway/cache"
 	"github.com/future-architect/apidoor/gateway/datasource"
 	"github.com/future-architect/apidoor/gateway/logger"
+	"github.com/future-architect/apidoor/gateway/metrics"
 	"github.com/future-architect/apidoor/gateway/model"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type DefaultHandler struct {
 	Appender   logger.Appender
 	DataSource datasource.DataSource
+	// Cache stores responses of routings whose cache is enabled, nil disables the cache
+	Cache cache.Store
+	// MaxRequestBodyBytes and MaxResponseBodyBytes limit sizes of bodies, 0 means unlimited
+	MaxRequestBodyBytes  int64
+	MaxResponseBodyBytes int64
+	// ValidateRequests enables the validation of requests against operations of the swagger file
+	ValidateRequests bool
+	// TokenSource fetches access tokens of the oauth2_client_credentials type, nil disables the type
+	TokenSource model.TokenSource
 }
 
+var errBodyTooLarge = errors.New("body too large")
+
 func (h DefaultHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	r, info := logger.StartRequest(r)
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	defer func() { observeRequest(info, rec.status) }()
+	w.Header().Set(logger.RequestIDHeader, info.RequestID)
 
 	apikey := r.Header.Get("X-Apidoor-Authorization")
 	if apikey == "" {
@@ -28,7 +50,9 @@ func (h DefaultHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// get all apis linked with the api key
+	lookupStart := flextime.Now()
 	fields, err := h.DataSource.GetFields(r.Context(), apikey)
+	metrics.DataSourceLookupDuration.WithLabelValues("get_fields").Observe(flextime.Since(lookupStart).Seconds())
 	if err != nil {
 		log.Print(err.Error())
 		if errors.Is(err, model.ErrUnauthorizedRequest) {
@@ -47,23 +71,70 @@ func (h DefaultHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	forwardURL := result.ForwardURL
+	info.Route = result.TemplatePath
+	info.ContractID, _ = strconv.Atoi(result.Field.Builtins["contract_id"])
+	info.ProductID, _ = strconv.Atoi(result.Field.Builtins["product_id"])
 
 	// check if number of request does not exceed limit
 	if err := fields.CheckAPILimit(result.Field.Path.JoinPath()); err != nil {
 		log.Print(err.Error())
+		metrics.RateLimitRejections.WithLabelValues(info.Route).Inc()
 		http.Error(w, "gateway error: API limit exceeded", http.StatusForbidden)
 		return
 	}
 
+	if err := limitRequestBody(r, h.MaxRequestBodyBytes); err != nil {
+		log.Printf("read request body failed: %v", err)
+		if errors.Is(err, errBodyTooLarge) {
+			http.Error(w, "gateway error: request body too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "gateway error: couldn't read request", http.StatusBadRequest)
+		}
+		return
+	}
+	// the request is transformed before the validation and the cache lookup, since the operations and the cached
+	// responses are the ones of the api, which receives the transformed request
+	if err := result.Field.Transform.ApplyToRequest(r); err != nil {
+		log.Printf("transform request failed: %v", err)
+		http.Error(w, "gateway error: couldn't transform request", http.StatusBadRequest)
+		return
+	}
+	// an invalid request is rejected before calling the api, so it is neither logged nor billed
+	if h.ValidateRequests {
+		if err := result.Field.ValidateRequest(r, result.PathParams); err != nil {
+			log.Printf("invalid request: %v", err)
+			http.Error(w, "gateway error: invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// look up the response cache
+	var cached *cache.Entry
+	cacheKey := ""
+	if h.Cache != nil && result.Field.Cache != nil && cache.IsCacheableRequest(r) {
+		cacheKey = cache.Key(apikey, r)
+		if cached, err = h.Cache.Get(r.Context(), cacheKey); err != nil {
+			log.Printf("get cache entry failed: %v", err)
+		}
+		if cached != nil && !cached.MatchesVary(r.Header) {
+			cached = nil
+		}
+		if cached != nil && cached.Fresh() && !cache.RequiresRevalidation(r) {
+			h.serveCached(w, r, apikey, result, cached, cache.StatusHit)
+			return
+		}
+	}
+
 	var req *http.Request
 	method := r.Method
-	if err := h.addStoredTokens(r.Context(), r, result.TemplatePath); err != nil {
-		log.Printf("set stored tokens failed: %v", err)
-	}
 
 	if method == http.MethodGet || method == http.MethodHead || method == http.MethodDelete || method == http.MethodOptions {
 		if r.URL.RawQuery != "" {
-			forwardURL = forwardURL + "?" + r.URL.RawQuery
+			if strings.Contains(forwardURL, "?") {
+				forwardURL = forwardURL + "&" + r.URL.RawQuery
+			} else {
+				forwardURL = forwardURL + "?" + r.URL.RawQuery
+			}
 		}
 		req, err = http.NewRequest(method, forwardURL, nil)
 	} else {
@@ -76,6 +147,26 @@ func (h DefaultHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	setRequestHeader(r, req)
+	req.Header.Set(logger.RequestIDHeader, info.RequestID)
+	info.UpstreamHost = req.URL.Host
+	for key, values := range result.ForwardHeaders {
+		req.Header[key] = values
+	}
+	if err := h.addStoredTokens(r.Context(), apikey, req, result.TemplatePath); err != nil {
+		log.Printf("add stored tokens failed: %v", err)
+		switch {
+		case errors.Is(err, model.ErrTokenNotApplicable):
+			http.Error(w, "gateway error: couldn't add access token to request", http.StatusBadRequest)
+		case errors.Is(err, model.ErrTokenUnavailable):
+			http.Error(w, "gateway error: couldn't get access token", http.StatusBadGateway)
+		default:
+			http.Error(w, "gateway error: server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if cached != nil && !cached.AddConditionalHeaders(req.Header) {
+		cached = nil
+	}
 
 	// call a target api
 	res, err := http.DefaultClient.Do(req)
@@ -83,9 +174,44 @@ func (h DefaultHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		//TODO: notify detailed errors to the client when certain errors, such as timeout, occurred
 		log.Printf("error in http %s: %s", method, err.Error())
 		http.Error(w, "gateway error: server error", http.StatusInternalServerError)
+		h.logFailedCall(apikey, result, r, http.StatusInternalServerError, logger.ErrorClassUpstreamUnreachable)
 		return
 	}
 	defer res.Body.Close()
+	if err := limitResponseBody(res, h.MaxResponseBodyBytes); err != nil {
+		log.Printf("read response body failed: %v", err)
+		if errors.Is(err, errBodyTooLarge) {
+			http.Error(w, "gateway error: response body too large", http.StatusBadGateway)
+			h.logFailedCall(apikey, result, r, http.StatusBadGateway, logger.ErrorClassResponseTooLarge)
+		} else {
+			http.Error(w, "gateway error: server error", http.StatusBadGateway)
+			h.logFailedCall(apikey, result, r, http.StatusBadGateway, logger.ErrorClassUpstreamUnreachable)
+		}
+		return
+	}
+
+	defaultTTL := time.Duration(0)
+	if result.Field.Cache != nil {
+		defaultTTL = time.Duration(result.Field.Cache.DefaultTTL) * time.Second
+	}
+	if cached != nil && res.StatusCode == http.StatusNotModified {
+		ttl := cached.Revalidate(res, defaultTTL)
+		if err := h.Cache.Set(r.Context(), cacheKey, cached, ttl); err != nil {
+			log.Printf("set cache entry failed: %v", err)
+		}
+		h.serveCached(w, r, apikey, result, cached, cache.StatusRevalidated)
+		return
+	}
+
+	if err := result.Field.Transform.ApplyToResponse(res); err != nil {
+		log.Printf("transform response failed: %v", err)
+		http.Error(w, "gateway error: couldn't transform response", http.StatusBadGateway)
+		h.logFailedCall(apikey, result, r, http.StatusBadGateway, logger.ErrorClassResponseTransform)
+		return
+	}
+	if cacheKey != "" {
+		h.storeResponse(r, res, cacheKey, defaultTTL)
+	}
 
 	for key, values := range res.Header {
 		valueSerialized := strings.Join(values, ",")
@@ -94,7 +220,7 @@ func (h DefaultHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(res.StatusCode)
 
 	// return response and write log
-	if err := copyResponse(w, res); err != nil {
+	if info.ResponseBytes, err = copyResponse(w, res); err != nil {
 		return
 	}
 
@@ -103,21 +229,166 @@ func (h DefaultHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h DefaultHandler) addStoredTokens(ctx context.Context, src *http.Request, templatePath string) error {
-	apikey := src.Header.Get("X-Apidoor-Authorization")
+// logFailedCall records a call to the api whose response is not returned to the client, which is not billed
+func (h DefaultHandler) logFailedCall(apikey string, result *model.FieldResult, r *http.Request, status int, errorClass string) {
+	if info := logger.RequestInfoFrom(r.Context()); info != nil {
+		info.ErrorClass = errorClass
+	}
+	res := &http.Response{StatusCode: status, Header: http.Header{}}
+	if err := h.Appender.Do(apikey, result.Field.Path.JoinPath(), r, res, calcBillingStatus); err != nil {
+		log.Printf("[ERROR] appender write err: %v\n", err)
+	}
+}
+
+// serveCached writes the cached response, which is billed only if the routing bills cache hits
+func (h DefaultHandler) serveCached(w http.ResponseWriter, r *http.Request, apikey string, result *model.FieldResult,
+	entry *cache.Entry, status string) {
+	res := entry.Response(status)
+	for key, values := range res.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(res.StatusCode)
+	if _, err := w.Write(entry.Body); err != nil {
+		log.Printf("error occur while writing response: %s", err.Error())
+		return
+	}
+	if info := logger.RequestInfoFrom(r.Context()); info != nil {
+		info.ResponseBytes = int64(len(entry.Body))
+	}
+
+	billing := calcBillingStatus
+	if status == cache.StatusHit && !result.Field.Cache.BillHits {
+		billing = func(*http.Response) logger.BillingStatus {
+			return logger.NotBilling
+		}
+	}
+	if err := h.Appender.Do(apikey, result.Field.Path.JoinPath(), r, res, billing); err != nil {
+		log.Printf("[ERROR] appender write err: %v\n", err)
+	}
+}
+
+// storeResponse stores the response if it is cacheable, and replaces its body with the one read into memory
+func (h DefaultHandler) storeResponse(r *http.Request, res *http.Response, key string, defaultTTL time.Duration) {
+	res.Header.Set(cache.StatusHeader, cache.StatusMiss)
+	if res.ContentLength > cache.MaxEntryBytes {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, cache.MaxEntryBytes+1))
+	if err != nil {
+		log.Printf("read response body for the cache failed: %v", err)
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		return
+	}
+	if int64(len(body)) > cache.MaxEntryBytes {
+		res.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), res.Body))
+		return
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	entry, ttl := cache.NewEntry(r, res, body, defaultTTL)
+	if entry == nil {
+		return
+	}
+	if err := h.Cache.Set(r.Context(), key, entry, ttl); err != nil {
+		log.Printf("set cache entry failed: %v", err)
+	}
+}
+
+// limitRequestBody reads the request body into memory, and fails if it exceeds max bytes
+func limitRequestBody(r *http.Request, max int64) error {
+	if max <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	if r.ContentLength > max {
+		return errBodyTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, max+1))
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if int64(len(body)) > max {
+		return errBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// limitResponseBody reads the response body into memory, and fails if it exceeds max bytes
+func limitResponseBody(res *http.Response, max int64) error {
+	if max <= 0 {
+		return nil
+	}
+	if res.ContentLength > max {
+		return errBodyTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, max+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > max {
+		return errBodyTooLarge
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// addStoredTokens adds the access tokens registered for the api key and the path to the outbound request
+func (h DefaultHandler) addStoredTokens(ctx context.Context, apikey string, req *http.Request, templatePath string) error {
+	lookupStart := flextime.Now()
 	accessTokens, err := h.DataSource.GetAccessTokens(ctx, apikey, templatePath)
+	metrics.DataSourceLookupDuration.WithLabelValues("get_access_tokens").Observe(flextime.Since(lookupStart).Seconds())
 	if err != nil {
 		return fmt.Errorf("get access tokens failed: %w", err)
 	}
 	if accessTokens == nil {
 		return nil
 	}
-	if err = accessTokens.AddTokensToRequest(src); err != nil {
-		return fmt.Errorf("adding tokens to request failed: %v", err)
+	if err = accessTokens.AddTokensToRequest(req, h.TokenSource); err != nil {
+		return fmt.Errorf("adding tokens to request failed: %w", err)
 	}
 	return nil
 }
 
+// statusRecorder keeps the status code written to the client for the metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if !sr.wroteHeader {
+		sr.status = code
+		sr.wroteHeader = true
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	sr.wroteHeader = true
+	return sr.ResponseWriter.Write(p)
+}
+
+// observeRequest records the metrics of the request, whose billing status is "none" if it is not logged
+func observeRequest(info *logger.RequestInfo, status int) {
+	route := info.Route
+	if route == "" {
+		route = metrics.RouteUnmatched
+	}
+	billing := "none"
+	if item, ok := info.LogItem(); ok {
+		billing = item.BillingStatus.String()
+		// client errors are caused by the request, not by the api
+		if item.ErrorClass != "" && item.ErrorClass != logger.ErrorClassUpstreamClient {
+			metrics.UpstreamErrors.WithLabelValues(route, item.ErrorClass).Inc()
+		}
+	}
+	statusClass := metrics.StatusClass(status)
+	metrics.Requests.WithLabelValues(route, statusClass, billing).Inc()
+	metrics.RequestDuration.WithLabelValues(route, statusClass, billing).Observe(flextime.Since(info.StartedAt).Seconds())
+}
+
 func setRequestHeader(src, dist *http.Request) {
 	dist.Header = src.Header
 	dist.Header.Del("X-Apidoor-Authorization")
@@ -125,13 +396,15 @@ func setRequestHeader(src, dist *http.Request) {
 	dist.Header.Del("Cookie")
 }
 
-func copyResponse(w http.ResponseWriter, res *http.Response) error {
-	if _, err := io.Copy(w, res.Body); err != nil {
+// copyResponse returns the number of bytes of the body written to the client
+func copyResponse(w http.ResponseWriter, res *http.Response) (int64, error) {
+	n, err := io.Copy(w, res.Body)
+	if err != nil {
 		log.Printf("error occur while writing response: %s", err.Error())
 		http.Error(w, "gateway error: error occur while writing response", http.StatusInternalServerError)
-		return errors.New("error occur while writing response")
+		return n, errors.New("error occur while writing response")
 	}
-	return nil
+	return n, nil
 }
 
 func calcBillingStatus(resp *http.Response) logger.BillingStatus {
@@ -2,12 +2,18 @@ package gateway
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/Songmu/flextime"
+	"github.com/future-architect/apidoor/gateway/cache"
+	"github.com/future-architect/apidoor/gateway/datasource"
 	"github.com/future-architect/apidoor/gateway/logger"
 	"github.com/future-architect/apidoor/gateway/model"
+	"github.com/future-architect/apidoor/gateway/oauth2"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/prometheus/client_golang/prometheus"
 	"io"
 	"log"
 	"net/http"
@@ -16,6 +22,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 var dbHost, templatePath string
@@ -338,21 +345,35 @@ func TestSetStoredTokens(t *testing.T) {
 			wantURL:       "http://example.com/test/notoken",
 		},
 		{
-			name:          "append header parameter properly",
+			name:          "unsupported param type",
 			templatePath:  "test/unsupport",
 			requestMethod: "GET",
 			requestURL:    "http://example.com/test/unsupport",
 			wantURL:       "http://example.com/test/unsupport",
-			wantErr:       errors.New("unsupported param type: unsupported"),
+			wantErr:       model.NotSupportedParamType("unsupported param type: unsupported"),
 		},
 		{
-			name:          "append header parameter properly",
+			name:          "form token to a json request",
 			templatePath:  "test/wrongtype",
 			requestMethod: "GET",
 			requestHeader: http.Header{"Content-Type": []string{"application/json"}},
 			requestURL:    "http://example.com/test/wrongtype",
 			wantURL:       "http://example.com/test/wrongtype",
-			wantErr:       errors.New("content-Type header is not application/x-www-form-urlencoded, got application/json"),
+			wantErr:       model.ErrTokenNotApplicable,
+		},
+		{
+			name:          "append form value properly",
+			templatePath:  "testform",
+			requestMethod: "POST",
+			requestHeader: http.Header{"Content-Type": []string{"application/x-www-form-urlencoded; charset=utf-8"}},
+			requestBody:   createFormURLEncodedBody(map[string]string{"name": "apidoor"}),
+			requestURL:    "http://example.com/testform",
+			wantURL:       "http://example.com/testform",
+			wantHeader:    http.Header{"Content-Type": []string{"application/x-www-form-urlencoded; charset=utf-8"}},
+			wantBody: url.Values{
+				"name":  {"apidoor"},
+				"token": {"token_value"},
+			},
 		},
 	}
 	h := DefaultHandler{
@@ -372,11 +393,10 @@ func TestSetStoredTokens(t *testing.T) {
 			if tt.requestHeader != nil {
 				req.Header = tt.requestHeader
 			}
-			req.Header.Add("X-Apidoor-Authorization", apikey)
 
-			err = h.addStoredTokens(context.Background(), req, tt.templatePath)
+			err = h.addStoredTokens(context.Background(), apikey, req, tt.templatePath)
 			if err != nil {
-				if errors.Is(err, tt.wantErr) {
+				if !errors.Is(err, tt.wantErr) {
 					t.Errorf("returned error differs: want %v, got %v", tt.wantErr, err)
 				}
 				return
@@ -403,7 +423,7 @@ func TestSetStoredTokens(t *testing.T) {
 			}
 
 			switch req.Header.Get("Content-Type") {
-			case "application/x-www-form-urlencoded":
+			case "application/x-www-form-urlencoded", "application/x-www-form-urlencoded; charset=utf-8":
 				err = req.ParseForm()
 				if err != nil {
 					t.Errorf("cannot parse body as form: %v", err)
@@ -428,3 +448,766 @@ func createFormURLEncodedBody(data map[string]string) io.Reader {
 	}
 	return strings.NewReader(form.Encode())
 }
+
+// templateDBMock returns a field whose forward url has a query template and header templates
+type templateDBMock struct {
+	dbMock
+	host string
+}
+
+func (dm templateDBMock) GetFields(_ context.Context, _ string) (model.Fields, error) {
+	return model.Fields{
+		{
+			ForwardSchema: "http",
+			Template:      model.NewURITemplate("/users/{user_id}"),
+			Path:          model.NewURITemplate(dm.host + "/users"),
+			Query:         "id={user_id}",
+			Headers: map[string]string{
+				"X-Consumer-Id": "{apikey_id}",
+			},
+			Builtins: map[string]string{
+				"apikey_id": "10",
+			},
+			Num: 5,
+			Max: 10,
+		},
+	}, nil
+}
+
+func TestHandle_ForwardTemplate(t *testing.T) {
+	var gotQuery, gotHeader string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		gotHeader = r.Header.Get("X-Consumer-Id")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	h := DefaultHandler{
+		Appender: &logger.DefaultAppender{
+			Writer: io.Discard,
+		},
+		DataSource: templateDBMock{host: ts.URL[len("http://"):]},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/users/foo?page=2", nil)
+	r.Header.Set("X-Apidoor-Authorization", "apikey1")
+	w := httptest.NewRecorder()
+	h.Handle(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d, body: %s", w.Code, w.Body.String())
+	}
+	if gotQuery != "id=foo&page=2" {
+		t.Errorf("wrong query of the forward request: got %s, want %s", gotQuery, "id=foo&page=2")
+	}
+	if gotHeader != "10" {
+		t.Errorf("wrong header of the forward request: got %s, want %s", gotHeader, "10")
+	}
+}
+
+// transformDBMock returns a field which converts a form request into json and strips internal response headers
+type transformDBMock struct {
+	dbMock
+	host string
+}
+
+func (dm transformDBMock) GetFields(_ context.Context, _ string) (model.Fields, error) {
+	return model.Fields{
+		{
+			ForwardSchema: "http",
+			Template:      model.NewURITemplate("/legacy"),
+			Path:          model.NewURITemplate(dm.host + "/legacy"),
+			Transform: &model.Transform{
+				Request: []model.TransformStep{
+					{Type: model.TransformFormToJSON},
+					{Type: model.TransformRenameHeader, Name: "X-Legacy-Id", To: "X-Id"},
+				},
+				Response: []model.TransformStep{
+					{Type: model.TransformRemoveHeader, Name: "X-Internal-*"},
+					{Type: model.TransformRemoveJSONField, Name: "internal_id"},
+				},
+			},
+			Num: 5,
+			Max: 10,
+		},
+	}, nil
+}
+
+func TestHandle_Transform(t *testing.T) {
+	var gotBody, gotContentType, gotID string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		gotContentType = r.Header.Get("Content-Type")
+		gotID = r.Header.Get("X-Id")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Internal-Trace", "trace")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"internal_id":1,"name":"foo"}`))
+	}))
+	defer ts.Close()
+
+	h := DefaultHandler{
+		Appender: &logger.DefaultAppender{
+			Writer: io.Discard,
+		},
+		DataSource: transformDBMock{host: ts.URL[len("http://"):]},
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/legacy", strings.NewReader("name=foo"))
+	r.Header.Set("X-Apidoor-Authorization", "apikey1")
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Legacy-Id", "1")
+	w := httptest.NewRecorder()
+	h.Handle(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d, body: %s", w.Code, w.Body.String())
+	}
+	if gotBody != `{"name":"foo"}` || gotContentType != "application/json" {
+		t.Errorf("wrong forward request: got body %s, content type %s", gotBody, gotContentType)
+	}
+	if gotID != "1" {
+		t.Errorf("header of the forward request is not renamed: got %s", gotID)
+	}
+	if got := w.Header().Get("X-Internal-Trace"); got != "" {
+		t.Errorf("internal header is not removed: got %s", got)
+	}
+	if got := w.Body.String(); got != `{"name":"foo"}` {
+		t.Errorf("wrong response body: got %s", got)
+	}
+	if got := w.Header().Get("Content-Length"); got != "14" {
+		t.Errorf("wrong content length: got %s, want 14", got)
+	}
+}
+
+// cacheDBMock returns a field whose response cache is enabled
+type cacheDBMock struct {
+	dbMock
+	host     string
+	billHits bool
+}
+
+func (dm cacheDBMock) GetFields(_ context.Context, _ string) (model.Fields, error) {
+	return model.Fields{
+		{
+			ForwardSchema: "http",
+			Template:      model.NewURITemplate("/reference"),
+			Path:          model.NewURITemplate(dm.host + "/reference"),
+			Cache:         &model.CacheConfig{BillHits: dm.billHits},
+			Num:           5,
+			Max:           10,
+		},
+	}, nil
+}
+
+func TestHandle_Cache(t *testing.T) {
+	now := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	restore := flextime.Fix(now)
+	defer restore()
+
+	var calls int
+	var gotIfNoneMatch string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		gotIfNoneMatch = r.Header.Get("If-None-Match")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		if gotIfNoneMatch == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("reference data"))
+	}))
+	defer ts.Close()
+
+	var logBuf strings.Builder
+	h := DefaultHandler{
+		Appender: &logger.DefaultAppender{
+			Writer: &logBuf,
+		},
+		DataSource: cacheDBMock{host: ts.URL[len("http://"):]},
+		Cache:      cache.NewMemoryStore(10),
+	}
+
+	tests := []struct {
+		name             string
+		now              time.Time
+		wantCalls        int
+		wantStatus       string
+		wantIfNoneMatch  string
+		wantBillingState string
+	}{
+		{
+			name:             "first request is fetched from the api",
+			now:              now,
+			wantCalls:        1,
+			wantStatus:       cache.StatusMiss,
+			wantBillingState: logger.Billing.String(),
+		},
+		{
+			name:             "fresh response is served from the cache without billing",
+			now:              now.Add(30 * time.Second),
+			wantCalls:        1,
+			wantStatus:       cache.StatusHit,
+			wantBillingState: logger.NotBilling.String(),
+		},
+		{
+			name:             "stale response is revalidated with the etag",
+			now:              now.Add(2 * time.Minute),
+			wantCalls:        2,
+			wantStatus:       cache.StatusRevalidated,
+			wantIfNoneMatch:  `"v1"`,
+			wantBillingState: logger.Billing.String(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flextime.Fix(tt.now)
+			logBuf.Reset()
+
+			r := httptest.NewRequest(http.MethodGet, "/reference", nil)
+			r.Header.Set("X-Apidoor-Authorization", "apikey1")
+			w := httptest.NewRecorder()
+			h.Handle(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status code %d, body: %s", w.Code, w.Body.String())
+			}
+			if got := w.Body.String(); got != "reference data" {
+				t.Errorf("wrong body: got %s", got)
+			}
+			if got := w.Header().Get(cache.StatusHeader); got != tt.wantStatus {
+				t.Errorf("wrong cache status: got %s, want %s", got, tt.wantStatus)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("wrong number of api calls: got %d, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantIfNoneMatch != "" && gotIfNoneMatch != tt.wantIfNoneMatch {
+				t.Errorf("wrong If-None-Match: got %s, want %s", gotIfNoneMatch, tt.wantIfNoneMatch)
+			}
+			if !strings.HasSuffix(logBuf.String(), ","+tt.wantBillingState+"\n") {
+				t.Errorf("wrong billing status in the log: %s", logBuf.String())
+			}
+		})
+	}
+}
+
+// validationDBMock returns a field with an operation to be validated
+type validationDBMock struct {
+	dbMock
+	host string
+}
+
+func (dm validationDBMock) GetFields(_ context.Context, _ string) (model.Fields, error) {
+	return model.Fields{
+		{
+			ForwardSchema: "http",
+			Template:      model.NewURITemplate("/users/{user_id}"),
+			Path:          model.NewURITemplate(dm.host + "/users/{user_id}"),
+			Operations: []model.Operation{
+				{
+					Method: http.MethodPost,
+					Parameters: []model.OperationParameter{
+						{Name: "user_id", In: "path", Required: true, Schema: `{"type":"integer"}`},
+						{Name: "dry_run", In: "query", Schema: `{"type":"boolean"}`},
+					},
+					RequestBody:         `{"type":"object","required":["name"],"properties":{"name":{"type":"string","maxLength":10}}}`,
+					RequestBodyRequired: true,
+				},
+			},
+			Num: 5,
+			Max: 10,
+		},
+	}, nil
+}
+
+func TestHandle_Validation(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/users/2" {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(strings.Repeat("a", 20)))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name          string
+		url           string
+		body          string
+		validate      bool
+		maxRequest    int64
+		maxResponse   int64
+		wantStatus    int
+		wantCalls     int
+		wantBodyMatch string
+		wantLogged    bool
+	}{
+		{
+			name:       "valid request is forwarded",
+			url:        "/users/1?dry_run=true",
+			body:       `{"name":"foo"}`,
+			validate:   true,
+			wantStatus: http.StatusCreated,
+			wantCalls:  1,
+			wantLogged: true,
+		},
+		{
+			name:          "invalid path parameter is rejected",
+			url:           "/users/foo",
+			body:          `{"name":"foo"}`,
+			validate:      true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyMatch: "path.user_id must be integer",
+		},
+		{
+			name:          "invalid query parameter is rejected",
+			url:           "/users/1?dry_run=maybe",
+			body:          `{"name":"foo"}`,
+			validate:      true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyMatch: "query.dry_run must be boolean",
+		},
+		{
+			name:          "invalid body is rejected",
+			url:           "/users/1",
+			body:          `{"name":"too long name"}`,
+			validate:      true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyMatch: "body.name must be at most 10 characters",
+		},
+		{
+			name:          "missing body is rejected",
+			url:           "/users/1",
+			validate:      true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyMatch: "body is required",
+		},
+		{
+			name:       "invalid request is forwarded if validation is disabled",
+			url:        "/users/foo",
+			body:       `{"name":"too long name"}`,
+			wantStatus: http.StatusCreated,
+			wantCalls:  1,
+			wantLogged: true,
+		},
+		{
+			name:          "request body larger than the limit is rejected",
+			url:           "/users/1",
+			body:          `{"name":"foo"}`,
+			maxRequest:    10,
+			wantStatus:    http.StatusRequestEntityTooLarge,
+			wantBodyMatch: "request body too large",
+		},
+		{
+			name:          "response body larger than the limit is rejected",
+			url:           "/users/2",
+			body:          `{"name":"foo"}`,
+			maxResponse:   10,
+			wantStatus:    http.StatusBadGateway,
+			wantCalls:     1,
+			wantBodyMatch: "response body too large",
+			// the failed call is logged as not billing
+			wantLogged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			var logBuf strings.Builder
+			h := DefaultHandler{
+				Appender: &logger.DefaultAppender{
+					Writer: &logBuf,
+				},
+				DataSource:           validationDBMock{host: ts.URL[len("http://"):]},
+				MaxRequestBodyBytes:  tt.maxRequest,
+				MaxResponseBodyBytes: tt.maxResponse,
+				ValidateRequests:     tt.validate,
+			}
+
+			r := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			r.Header.Set("X-Apidoor-Authorization", "apikey1")
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			h.Handle(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("wrong status code: got %d, want %d, body: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBodyMatch) {
+				t.Errorf("wrong body: got %s, want containing %s", w.Body.String(), tt.wantBodyMatch)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("wrong number of api calls: got %d, want %d", calls, tt.wantCalls)
+			}
+			if logged := logBuf.Len() > 0; logged != tt.wantLogged {
+				t.Errorf("wrong logging: got %v, want %v", logged, tt.wantLogged)
+			}
+		})
+	}
+}
+
+// transformValidationDBMock returns a field which converts a form request into json, and validates the json request
+type transformValidationDBMock struct {
+	dbMock
+	host string
+}
+
+func (dm transformValidationDBMock) GetFields(_ context.Context, _ string) (model.Fields, error) {
+	return model.Fields{
+		{
+			ForwardSchema: "http",
+			Template:      model.NewURITemplate("/users"),
+			Path:          model.NewURITemplate(dm.host + "/users"),
+			Transform: &model.Transform{
+				Request: []model.TransformStep{
+					{Type: model.TransformFormToJSON},
+					{Type: model.TransformRenameHeader, Name: "X-Legacy-Id", To: "X-Id"},
+				},
+			},
+			Operations: []model.Operation{
+				{
+					Method: http.MethodPost,
+					Parameters: []model.OperationParameter{
+						{Name: "X-Id", In: "header", Required: true, Schema: `{"type":"string"}`},
+					},
+					RequestBody:         `{"type":"object","required":["name"],"properties":{"name":{"type":"string","maxLength":10}}}`,
+					RequestBodyRequired: true,
+				},
+			},
+			Num: 5,
+			Max: 10,
+		},
+	}, nil
+}
+
+func TestHandle_TransformValidation(t *testing.T) {
+	var calls int
+	var gotBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name          string
+		body          string
+		legacyID      string
+		wantStatus    int
+		wantCalls     int
+		wantBodyMatch string
+	}{
+		{
+			name:       "transformed request is validated against the operation of the api",
+			body:       "name=foo",
+			legacyID:   "1",
+			wantStatus: http.StatusCreated,
+			wantCalls:  1,
+		},
+		{
+			name:          "invalid body after the transform is rejected",
+			body:          "name=too+long+name",
+			legacyID:      "1",
+			wantStatus:    http.StatusBadRequest,
+			wantBodyMatch: "body.name must be at most 10 characters",
+		},
+		{
+			name:          "missing header after the transform is rejected",
+			body:          "name=foo",
+			wantStatus:    http.StatusBadRequest,
+			wantBodyMatch: "header.X-Id is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls, gotBody = 0, ""
+			h := DefaultHandler{
+				Appender: &logger.DefaultAppender{
+					Writer: io.Discard,
+				},
+				DataSource:       transformValidationDBMock{host: ts.URL[len("http://"):]},
+				ValidateRequests: true,
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(tt.body))
+			r.Header.Set("X-Apidoor-Authorization", "apikey1")
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.legacyID != "" {
+				r.Header.Set("X-Legacy-Id", tt.legacyID)
+			}
+			w := httptest.NewRecorder()
+			h.Handle(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("wrong status code: got %d, want %d, body: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBodyMatch) {
+				t.Errorf("wrong body: got %s, want containing %s", w.Body.String(), tt.wantBodyMatch)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("wrong number of api calls: got %d, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantCalls > 0 && gotBody != `{"name":"foo"}` {
+				t.Errorf("wrong forward request body: got %s", gotBody)
+			}
+		})
+	}
+}
+
+// oauth2DBMock returns a field with a token of the oauth2_client_credentials type
+type oauth2DBMock struct {
+	dbMock
+	host     string
+	tokenURL string
+}
+
+func (dm oauth2DBMock) GetFields(_ context.Context, _ string) (model.Fields, error) {
+	return model.Fields{
+		{
+			ForwardSchema: "http",
+			Template:      model.NewURITemplate("/secure"),
+			Path:          model.NewURITemplate(dm.host + "/secure"),
+			Num:           5,
+			Max:           10,
+		},
+	}, nil
+}
+
+func (dm oauth2DBMock) GetAccessTokens(_ context.Context, _, _ string) (*model.AccessTokens, error) {
+	return &model.AccessTokens{
+		Tokens: []model.AccessToken{
+			{
+				ParamType:    model.OAuth2ClientCredentials,
+				ClientID:     "client",
+				ClientSecret: "secret",
+				TokenURL:     dm.tokenURL,
+			},
+		},
+	}, nil
+}
+
+func TestHandle_OAuth2(t *testing.T) {
+	var tokenCalls int
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenCalls++
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"issued","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	var gotAuthorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuthorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	h := DefaultHandler{
+		Appender: &logger.DefaultAppender{
+			Writer: io.Discard,
+		},
+		DataSource:  oauth2DBMock{host: ts.URL[len("http://"):], tokenURL: tokenServer.URL},
+		TokenSource: oauth2.NewClientCredentials(tokenServer.Client()),
+	}
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/secure", nil)
+		r.Header.Set("X-Apidoor-Authorization", "apikey1")
+		w := httptest.NewRecorder()
+		h.Handle(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status code %d, body: %s", w.Code, w.Body.String())
+		}
+		if gotAuthorization != "Bearer issued" {
+			t.Errorf("wrong Authorization header of the forward request: got %s", gotAuthorization)
+		}
+	}
+	if tokenCalls != 1 {
+		t.Errorf("token is not cached, token requests: %d", tokenCalls)
+	}
+}
+
+// accessLogDBMock returns a field linked to a contract
+type accessLogDBMock struct {
+	dbMock
+	host string
+}
+
+func (dm accessLogDBMock) GetFields(_ context.Context, _ string) (model.Fields, error) {
+	return model.Fields{
+		{
+			ForwardSchema: "http",
+			Template:      model.NewURITemplate("/users"),
+			Path:          model.NewURITemplate(dm.host + "/users"),
+			Builtins: map[string]string{
+				"contract_id": "3",
+				"product_id":  "7",
+			},
+			Num: 5,
+			Max: 10,
+		},
+	}, nil
+}
+
+func TestHandle_AccessLog(t *testing.T) {
+	var gotRequestID string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRequestID = r.Header.Get(logger.RequestIDHeader)
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+	}))
+	defer ts.Close()
+
+	var logBuf strings.Builder
+	h := DefaultHandler{
+		Appender:   logger.NewJSONAppender(&logBuf),
+		DataSource: accessLogDBMock{host: ts.URL[len("http://"):]},
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"foo"}`))
+	r.RemoteAddr = "192.0.2.1:54321"
+	r.Header.Set("X-Apidoor-Authorization", "apikey1")
+	r.Header.Set(logger.RequestIDHeader, "request-1")
+	w := httptest.NewRecorder()
+	h.Handle(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code %d, body: %s", w.Code, w.Body.String())
+	}
+	if gotRequestID != "request-1" {
+		t.Errorf("wrong request id of the forward request: got %s", gotRequestID)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(logBuf.String()), &got); err != nil {
+		t.Fatalf("log is not a json line: %v, %s", err, logBuf.String())
+	}
+	delete(got, "time")
+	delete(got, "latency_ms")
+	want := map[string]interface{}{
+		"api_key":        "apikey1",
+		"path":           ts.URL[len("http://"):] + "/users",
+		"method":         http.MethodPost,
+		"status_code":    float64(http.StatusNotFound),
+		"billing_status": "billing",
+		"cache_status":   "",
+		"upstream_host":  ts.URL[len("http://"):],
+		"request_bytes":  float64(len(`{"name":"foo"}`)),
+		"response_bytes": float64(len("not found")),
+		"client_ip":      "192.0.2.1",
+		"request_id":     "request-1",
+		"contract_id":    float64(3),
+		"product_id":     float64(7),
+		"error_class":    logger.ErrorClassUpstreamClient,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("access log differs:\n%s", diff)
+	}
+}
+
+// metricValue returns the value of the series in the default registry, 0 if it is not recorded yet.
+// the series of a histogram is its count, whose name ends with _count, and labels of a series are sorted by name
+func metricValue(t *testing.T, series string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics failed: %v", err)
+	}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			pairs := make([]string, 0, len(m.GetLabel()))
+			for _, label := range m.GetLabel() {
+				pairs = append(pairs, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+			}
+			labels := "{" + strings.Join(pairs, ",") + "}"
+			switch {
+			case m.Counter != nil && series == family.GetName()+labels:
+				return m.Counter.GetValue()
+			case m.Histogram != nil && series == family.GetName()+"_count"+labels:
+				return float64(m.Histogram.GetSampleCount())
+			}
+		}
+	}
+	return 0
+}
+
+func TestHandle_Metrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		dataSource datasource.DataSource
+		apikey     string
+		// deltas of the series by the request
+		want map[string]float64
+	}{
+		{
+			name:       "APIが5xxを返したリクエストは課金されずにエラーとして数えられる",
+			dataSource: accessLogDBMock{host: ts.URL[len("http://"):]},
+			apikey:     "apikey1",
+			want: map[string]float64{
+				`apidoor_gateway_requests_total{billing_status="not billing",route="users",status_class="5xx"}`:                 1,
+				`apidoor_gateway_request_duration_seconds_count{billing_status="not billing",route="users",status_class="5xx"}`: 1,
+				`apidoor_gateway_upstream_errors_total{error_class="upstream_server_error",route="users"}`:                      1,
+				`apidoor_gateway_datasource_lookup_duration_seconds_count{operation="get_fields"}`:                              1,
+				`apidoor_gateway_datasource_lookup_duration_seconds_count{operation="get_access_tokens"}`:                       1,
+			},
+		},
+		{
+			name:       "ルーティングが見つからないリクエストはログに記録されない",
+			dataSource: dbMock{},
+			apikey:     "apikeyNotExist",
+			want: map[string]float64{
+				`apidoor_gateway_requests_total{billing_status="none",route="unmatched",status_class="4xx"}`: 1,
+				`apidoor_gateway_datasource_lookup_duration_seconds_count{operation="get_fields"}`:           1,
+				`apidoor_gateway_datasource_lookup_duration_seconds_count{operation="get_access_tokens"}`:    0,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := make(map[string]float64)
+			for series := range tt.want {
+				before[series] = metricValue(t, series)
+			}
+
+			h := DefaultHandler{
+				Appender:   logger.NewJSONAppender(io.Discard),
+				DataSource: tt.dataSource,
+			}
+			r := httptest.NewRequest(http.MethodGet, "/users", nil)
+			r.Header.Set("X-Apidoor-Authorization", tt.apikey)
+			h.Handle(httptest.NewRecorder(), r)
+
+			got := make(map[string]float64)
+			for series := range tt.want {
+				got[series] = metricValue(t, series) - before[series]
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("metrics differ (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/Songmu/flextime"
+	"github.com/future-architect/apidoor/gateway/metrics"
 	"github.com/future-architect/apidoor/gateway/model"
 	"sync"
 	"time"
@@ -33,9 +34,11 @@ func (ac *APICallCounter) getCount(ctx context.Context, key counterKey) (int, er
 	if ok {
 		stat := stat.(counterStat)
 		if flextime.Now().Before(stat.expireAt) {
+			metrics.CounterLookups.WithLabelValues("hit").Inc()
 			return stat.calls, nil
 		}
 	}
+	metrics.CounterLookups.WithLabelValues("miss").Inc()
 	return ac.updateCount(ctx, key)
 }
 
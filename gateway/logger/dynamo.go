@@ -2,14 +2,24 @@ package logger
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/guregu/dynamo"
 	"log"
 	"os"
+	"sync"
 	"time"
 )
 
+// maxBatchWriteItems is the limit of items in a BatchWriteItem request
+const maxBatchWriteItems = 25
+
+// BatchWriteConcurrency is the number of BatchWriteItem requests run at the same time while shipping access logs
+var BatchWriteConcurrency = 4
+
 type accessLogDB struct {
 	client         *dynamo.DB
 	accessLogTable string
@@ -43,9 +53,106 @@ func init() {
 	}
 }
 
-func (ad accessLogDB) postAccessLogDB(ctx context.Context, item LogItem) error {
-	return ad.client.Table(ad.accessLogTable).
-		Put(item).RunWithContext(ctx)
+// ErrDuplicateLogKey is returned for an item whose key in the access log table is the same as another item in the batch,
+// which would overwrite it
+var ErrDuplicateLogKey = errors.New("duplicate key of the access log item")
+
+// Put puts the items to the access log table by BatchWriteItem requests run concurrently, and returns the error of each item,
+// which is nil if the item is put. Unprocessed items of a request are retried with backoff until the context is done.
+func (ad accessLogDB) Put(ctx context.Context, items []LogItem) []error {
+	errs := make([]error, len(items))
+	chunks, duplicates := chunkLogItems(items)
+	for _, i := range duplicates {
+		errs[i] = fmt.Errorf("%w: api key %s, sort key %s", ErrDuplicateLogKey, items[i].Key, items[i].sortKey())
+	}
+
+	sem := make(chan struct{}, BatchWriteConcurrency)
+	var wg sync.WaitGroup
+	for _, chunk := range chunks {
+		wg.Add(1)
+		go func(chunk []int) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				for _, i := range chunk {
+					errs[i] = ctx.Err()
+				}
+				return
+			}
+
+			puts := make([]interface{}, 0, len(chunk))
+			for _, i := range chunk {
+				put, err := items[i].dynamoItem()
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				puts = append(puts, put)
+			}
+			if len(puts) == 0 {
+				return
+			}
+			// the items of a failed request are all retried, since Put of the same item is idempotent
+			if _, err := ad.client.Table(ad.accessLogTable).Batch().Write().Put(puts...).RunWithContext(ctx); err != nil {
+				for _, i := range chunk {
+					if errs[i] == nil {
+						errs[i] = err
+					}
+				}
+			}
+		}(chunk)
+	}
+	wg.Wait()
+	return errs
+}
+
+// sortKey is the range key of the item in the access log table. the timestamp is kept as its prefix,
+// so that the items are still queried by the range of the timestamp
+func (item LogItem) sortKey() string {
+	if item.LogID == "" {
+		// items spooled before LogID is introduced
+		return item.TimeStamp
+	}
+	return item.TimeStamp + "#" + item.LogID
+}
+
+func (item LogItem) dynamoItem() (map[string]*dynamodb.AttributeValue, error) {
+	av, err := dynamo.MarshalItem(item)
+	if err != nil {
+		return nil, fmt.Errorf("marshal access log item failed: %w", err)
+	}
+	av["timestamp"] = &dynamodb.AttributeValue{S: aws.String(item.sortKey())}
+	return av, nil
+}
+
+// chunkLogItems splits the indexes of the items into chunks of a BatchWriteItem request.
+// An item with the same key as an earlier item would overwrite it, so it is returned as a duplicate instead of being put.
+func chunkLogItems(items []LogItem) (chunks [][]int, duplicates []int) {
+	type itemKey struct {
+		apikey, sortKey string
+	}
+	seen := make(map[itemKey]struct{}, len(items))
+
+	var chunk []int
+	for i, item := range items {
+		key := itemKey{item.Key, item.sortKey()}
+		if _, ok := seen[key]; ok {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[key] = struct{}{}
+		chunk = append(chunk, i)
+		if len(chunk) == maxBatchWriteItems {
+			chunks = append(chunks, chunk)
+			chunk = nil
+		}
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks, duplicates
 }
 
 func (ad accessLogDB) countBillingAccessLogDB(ctx context.Context, apikey, path string, startAt time.Time) (int64, error) {
@@ -0,0 +1,39 @@
+package logger
+
+import (
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestChunkLogItems(t *testing.T) {
+	items := make([]LogItem, 0, 60)
+	for i := 0; i < 55; i++ {
+		items = append(items, LogItem{Key: "key", TimeStamp: fmt.Sprintf("2022-01-04T10:00:%02dZ", i)})
+	}
+	// calls of the same key in the same second have different log ids
+	items = append(items, LogItem{Key: "key", TimeStamp: "2022-01-04T10:00:00Z", LogID: "a"})
+	items = append(items, LogItem{Key: "key", TimeStamp: "2022-01-04T10:00:00Z", LogID: "b"})
+	// items of the same key as the first and the 56th ones
+	items = append(items, LogItem{Key: "key", TimeStamp: "2022-01-04T10:00:00Z"})
+	items = append(items, LogItem{Key: "key", TimeStamp: "2022-01-04T10:00:00Z", LogID: "a"})
+
+	var want [][]int
+	var chunk []int
+	for i := 0; i < 57; i++ {
+		chunk = append(chunk, i)
+		if len(chunk) == maxBatchWriteItems {
+			want = append(want, chunk)
+			chunk = nil
+		}
+	}
+	want = append(want, chunk)
+
+	chunks, duplicates := chunkLogItems(items)
+	if diff := cmp.Diff(want, chunks); diff != "" {
+		t.Errorf("chunks differ:\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{57, 58}, duplicates); diff != "" {
+		t.Errorf("duplicates differ:\n%s", diff)
+	}
+}
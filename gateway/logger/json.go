@@ -0,0 +1,105 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// JSONAppender writes an access log as a json object per line, whose fields are fixed regardless of LOG_PATTERN
+type JSONAppender struct {
+	mu     sync.Mutex
+	Writer io.Writer
+
+	LogItems LogItems
+	// Spool keeps the log items on local disk instead of LogItems if it is set
+	Spool *Spool
+	// Sink is the destination of the log items, which is DynamoSink if it is nil
+	Sink LogSink
+}
+
+func NewJSONAppender(writer io.Writer) *JSONAppender {
+	return &JSONAppender{
+		Writer:   writer,
+		LogItems: NewLogItems(),
+	}
+}
+
+// jsonLogRecord is the schema of a line of JSONAppender, whose fields are always written even if they are empty
+type jsonLogRecord struct {
+	Time          string `json:"time"`
+	APIKey        string `json:"api_key"`
+	Path          string `json:"path"`
+	Method        string `json:"method"`
+	StatusCode    int    `json:"status_code"`
+	BillingStatus string `json:"billing_status"`
+	CacheStatus   string `json:"cache_status"`
+	LatencyMillis int64  `json:"latency_ms"`
+	UpstreamHost  string `json:"upstream_host"`
+	RequestBytes  int64  `json:"request_bytes"`
+	ResponseBytes int64  `json:"response_bytes"`
+	ClientIP      string `json:"client_ip"`
+	RequestID     string `json:"request_id"`
+	// ContractID and ProductID are null if the routing is not linked to a contract or not generated from a product
+	ContractID *int   `json:"contract_id"`
+	ProductID  *int   `json:"product_id"`
+	ErrorClass string `json:"error_class"`
+}
+
+func newJSONLogRecord(item LogItem) jsonLogRecord {
+	record := jsonLogRecord{
+		Time:          item.TimeStamp,
+		APIKey:        item.Key,
+		Path:          item.Path,
+		Method:        item.Method,
+		StatusCode:    item.StatusCode,
+		BillingStatus: item.BillingStatus.String(),
+		CacheStatus:   item.CacheStatus,
+		LatencyMillis: item.LatencyMillis,
+		UpstreamHost:  item.UpstreamHost,
+		RequestBytes:  item.RequestBytes,
+		ResponseBytes: item.ResponseBytes,
+		ClientIP:      item.ClientIP,
+		RequestID:     item.RequestID,
+		ErrorClass:    item.ErrorClass,
+	}
+	if item.ContractID != 0 {
+		contractID := item.ContractID
+		record.ContractID = &contractID
+	}
+	if item.ProductID != 0 {
+		productID := item.ProductID
+		record.ProductID = &productID
+	}
+	return record
+}
+
+func (a *JSONAppender) Do(key, path string, r *http.Request,
+	apiResp *http.Response, calcBillingStatus func(resp *http.Response) BillingStatus) error {
+	logItem, err := NewLogItem(key, path, r, apiResp, calcBillingStatus)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(newJSONLogRecord(logItem))
+	if err != nil {
+		return err
+	}
+	if err := bufferLogItem(&a.LogItems, a.Spool, logItem); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.Writer.Write(append(line, '\n'))
+	return err
+}
+
+func (a *JSONAppender) UpdateDB(ctx context.Context) {
+	shipLogItems(ctx, &a.LogItems, a.Spool, a.Sink)
+}
+
+func (a *JSONAppender) BufferedItems() int64 {
+	return bufferedItems(&a.LogItems, a.Spool)
+}
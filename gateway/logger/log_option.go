@@ -39,6 +39,82 @@ func WithBillingStatus() LogOption {
 	}
 }
 
+func WithCacheStatus() LogOption {
+	return func(record *[]string, logItem *LogItem, r *http.Request) {
+		*record = append(*record, logItem.CacheStatus)
+	}
+}
+
+func WithMethod() LogOption {
+	return func(record *[]string, logItem *LogItem, r *http.Request) {
+		*record = append(*record, logItem.Method)
+	}
+}
+
+func WithLatency() LogOption {
+	return func(record *[]string, logItem *LogItem, r *http.Request) {
+		*record = append(*record, strconv.FormatInt(logItem.LatencyMillis, 10))
+	}
+}
+
+func WithUpstreamHost() LogOption {
+	return func(record *[]string, logItem *LogItem, r *http.Request) {
+		*record = append(*record, logItem.UpstreamHost)
+	}
+}
+
+func WithRequestBytes() LogOption {
+	return func(record *[]string, logItem *LogItem, r *http.Request) {
+		*record = append(*record, strconv.FormatInt(logItem.RequestBytes, 10))
+	}
+}
+
+func WithResponseBytes() LogOption {
+	return func(record *[]string, logItem *LogItem, r *http.Request) {
+		*record = append(*record, strconv.FormatInt(logItem.ResponseBytes, 10))
+	}
+}
+
+func WithClientIP() LogOption {
+	return func(record *[]string, logItem *LogItem, r *http.Request) {
+		*record = append(*record, logItem.ClientIP)
+	}
+}
+
+func WithRequestID() LogOption {
+	return func(record *[]string, logItem *LogItem, r *http.Request) {
+		*record = append(*record, logItem.RequestID)
+	}
+}
+
+// WithContractID writes an empty column if the routing is not linked to a contract
+func WithContractID() LogOption {
+	return func(record *[]string, logItem *LogItem, r *http.Request) {
+		if logItem.ContractID == 0 {
+			*record = append(*record, "")
+			return
+		}
+		*record = append(*record, strconv.Itoa(logItem.ContractID))
+	}
+}
+
+// WithProductID writes an empty column if the routing is not generated from a product
+func WithProductID() LogOption {
+	return func(record *[]string, logItem *LogItem, r *http.Request) {
+		if logItem.ProductID == 0 {
+			*record = append(*record, "")
+			return
+		}
+		*record = append(*record, strconv.Itoa(logItem.ProductID))
+	}
+}
+
+func WithErrorClass() LogOption {
+	return func(record *[]string, logItem *LogItem, r *http.Request) {
+		*record = append(*record, logItem.ErrorClass)
+	}
+}
+
 func HeaderElement(name string) LogOption {
 	return func(record *[]string, logItem *LogItem, r *http.Request) {
 		*record = append(*record, r.Header.Get(name))
@@ -68,6 +144,28 @@ func DefaultLogPattern() []LogOption {
 			pattern = append(pattern, WithResponseStatus())
 		case "billing_status":
 			pattern = append(pattern, WithBillingStatus())
+		case "cache_status":
+			pattern = append(pattern, WithCacheStatus())
+		case "method":
+			pattern = append(pattern, WithMethod())
+		case "latency_ms":
+			pattern = append(pattern, WithLatency())
+		case "upstream_host":
+			pattern = append(pattern, WithUpstreamHost())
+		case "request_bytes":
+			pattern = append(pattern, WithRequestBytes())
+		case "response_bytes":
+			pattern = append(pattern, WithResponseBytes())
+		case "client_ip":
+			pattern = append(pattern, WithClientIP())
+		case "request_id":
+			pattern = append(pattern, WithRequestID())
+		case "contract_id":
+			pattern = append(pattern, WithContractID())
+		case "product_id":
+			pattern = append(pattern, WithProductID())
+		case "error_class":
+			pattern = append(pattern, WithErrorClass())
 		default:
 			pattern = append(pattern, HeaderElement(value))
 		}
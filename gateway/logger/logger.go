@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/csv"
 	"github.com/Songmu/flextime"
+	"github.com/future-architect/apidoor/gateway/cache"
 	"io"
 	"log"
 	"net/http"
@@ -31,15 +32,22 @@ type Appender interface {
 		apiResp *http.Response, calcBillingStatus func(resp *http.Response) BillingStatus) error
 }
 
+// UpdateDBRoutine puts the access logs to the db every interval, each of which is limited to the interval
+// so that the next one starts on time
 func UpdateDBRoutine(ctx context.Context, appender Appender, interval time.Duration, kill, finish chan bool) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	updateDB := func() {
+		ctx, cancel := context.WithTimeout(ctx, interval)
+		defer cancel()
+		appender.UpdateDB(ctx)
+	}
 	for {
 		select {
 		case <-ticker.C:
-			appender.UpdateDB(ctx)
+			updateDB()
 		case <-kill:
-			appender.UpdateDB(ctx)
+			updateDB()
 			finish <- true
 			return
 		}
@@ -54,11 +62,15 @@ func CleanupUpdateDBTask(kill, finish chan bool) {
 type DefaultAppender struct {
 	Writer   io.Writer
 	LogItems LogItems
+	// Spool keeps the log items on local disk instead of LogItems if it is set
+	Spool *Spool
+	// Sink is the destination of the log items, which is DynamoSink if it is nil
+	Sink LogSink
 }
 
 func (a *DefaultAppender) Do(key, path string, r *http.Request,
 	apiResp *http.Response, calcBillingStatus func(resp *http.Response) BillingStatus) error {
-	logItem, err := NewLogItem(key, path, apiResp, calcBillingStatus)
+	logItem, err := NewLogItem(key, path, r, apiResp, calcBillingStatus)
 	record := make([]string, 0, len(LogOptionPattern))
 	for _, logOption := range LogOptionPattern {
 		logOption(&record, &logItem, r)
@@ -66,25 +78,33 @@ func (a *DefaultAppender) Do(key, path string, r *http.Request,
 	if err != nil {
 		return err
 	}
-	a.LogItems.Append(logItem)
+	if err := bufferLogItem(&a.LogItems, a.Spool, logItem); err != nil {
+		return err
+	}
 
 	_, err = a.Writer.Write([]byte(strings.Join(record, ",") + "\n"))
 	return err
 }
 
 func (a *DefaultAppender) UpdateDB(ctx context.Context) {
-	logItems := a.LogItems.ReadAndDeleteAll()
-	for _, item := range logItems {
-		if err := db.postAccessLogDB(ctx, item); err != nil {
-			log.Printf("putting log info, %v, failed: %v", item, err)
-		}
-	}
+	shipLogItems(ctx, &a.LogItems, a.Spool, a.Sink)
+}
+
+// BufferedItems returns the number of the log items which are not put to the db yet
+func (a *DefaultAppender) BufferedItems() int64 {
+	return bufferedItems(&a.LogItems, a.Spool)
 }
 
 type CSVAppender struct {
+	// mu guards Writer, which is written by concurrent requests and flushed periodically
+	mu     sync.Mutex
 	Writer *csv.Writer
 
 	LogItems LogItems
+	// Spool keeps the log items on local disk instead of LogItems if it is set
+	Spool *Spool
+	// Sink is the destination of the log items, which is DynamoSink if it is nil
+	Sink LogSink
 }
 
 func NewCSVAppender(writer *csv.Writer) CSVAppender {
@@ -96,7 +116,7 @@ func NewCSVAppender(writer *csv.Writer) CSVAppender {
 
 func (a *CSVAppender) Do(key, path string, r *http.Request,
 	apiResp *http.Response, calcBillingStatus func(resp *http.Response) BillingStatus) error {
-	logItem, err := NewLogItem(key, path, apiResp, calcBillingStatus)
+	logItem, err := NewLogItem(key, path, r, apiResp, calcBillingStatus)
 	record := make([]string, 0, len(LogOptionPattern))
 	for _, logOption := range LogOptionPattern {
 		logOption(&record, &logItem, r)
@@ -104,16 +124,76 @@ func (a *CSVAppender) Do(key, path string, r *http.Request,
 	if err != nil {
 		return err
 	}
-	a.LogItems.Append(logItem)
+	if err := bufferLogItem(&a.LogItems, a.Spool, logItem); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	return a.Writer.Write(record)
 }
 
+// Flush writes the buffered records to the underlying writer
+func (a *CSVAppender) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Writer.Flush()
+	return a.Writer.Error()
+}
+
 func (a *CSVAppender) UpdateDB(ctx context.Context) {
-	logItems := a.LogItems.ReadAndDeleteAll()
-	for _, item := range logItems {
-		if err := db.postAccessLogDB(ctx, item); err != nil {
-			log.Printf("putting log info, %v, failed: %v", item, err)
+	shipLogItems(ctx, &a.LogItems, a.Spool, a.Sink)
+}
+
+func (a *CSVAppender) BufferedItems() int64 {
+	return bufferedItems(&a.LogItems, a.Spool)
+}
+
+// bufferLogItem keeps the item until it is put to the db, in the spool if it is given
+func bufferLogItem(items *LogItems, spool *Spool, item LogItem) error {
+	if spool != nil {
+		return spool.Append(item)
+	}
+	items.Append(item)
+	return nil
+}
+
+// bufferedItems returns the number of the items waiting to be put to the db, in the spool if it is set
+func bufferedItems(items *LogItems, spool *Spool) int64 {
+	if spool != nil {
+		return spool.Stats().PendingItems
+	}
+	return int64(items.Len())
+}
+
+// shipLogItems puts the buffered items to the sink.
+// Items in memory are dropped if they fail, except for the items not put before the context is done,
+// while items in the spool are retried by a later call.
+func shipLogItems(ctx context.Context, items *LogItems, spool *Spool, sink LogSink) {
+	if sink == nil {
+		sink = DynamoSink()
+	}
+	if spool != nil {
+		if err := spool.Ship(ctx, sink.Put); err != nil {
+			log.Printf("shipping access log spool failed: %v", err)
+		}
+		if stats := spool.Stats(); stats.PendingItems > 0 {
+			log.Printf("access log spool backlog: %d items, %d retrying", stats.PendingItems, stats.RetryingItems)
+		}
+		return
+	}
+	logItems := items.ReadAndDeleteAll()
+	if len(logItems) == 0 {
+		return
+	}
+	for i, err := range sink.Put(ctx, logItems) {
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			items.Append(logItems[i])
+			continue
 		}
+		log.Printf("putting log info, %v, failed: %v", logItems[i], err)
 	}
 }
 
@@ -123,16 +203,67 @@ type LogItem struct {
 	Path          string        `dynamo:"path"`
 	StatusCode    int           `dynamo:"status_code"`
 	BillingStatus BillingStatus `dynamo:"billing_status"`
+	// CacheStatus is HIT, REVALIDATED or MISS if the response cache of the routing is enabled, otherwise empty
+	CacheStatus string `dynamo:"cache_status,omitempty"`
+	// the following fields except Method and ErrorClass are taken from the RequestInfo of the request,
+	// and are empty if it is not given
+	Method        string `dynamo:"method,omitempty"`
+	LatencyMillis int64  `dynamo:"latency_ms,omitempty"`
+	UpstreamHost  string `dynamo:"upstream_host,omitempty"`
+	RequestBytes  int64  `dynamo:"request_bytes,omitempty"`
+	ResponseBytes int64  `dynamo:"response_bytes,omitempty"`
+	ClientIP      string `dynamo:"client_ip,omitempty"`
+	RequestID     string `dynamo:"request_id,omitempty"`
+	ContractID    int    `dynamo:"contract_id,omitempty"`
+	ProductID     int    `dynamo:"product_id,omitempty"`
+	ErrorClass    string `dynamo:"error_class,omitempty"`
+	// LogID identifies the item, and makes its sort key in the access log table unique among calls of the key in a second.
+	// it is generated by the gateway, unlike RequestID which may be given by the client
+	LogID string `dynamo:"log_id,omitempty"`
 }
 
-func NewLogItem(key, path string, apiResp *http.Response, calcBillingStatus func(resp *http.Response) BillingStatus) (LogItem, error) {
-	return LogItem{
-		TimeStamp:     flextime.Now().Format(time.RFC3339),
+func NewLogItem(key, path string, r *http.Request, apiResp *http.Response,
+	calcBillingStatus func(resp *http.Response) BillingStatus) (LogItem, error) {
+	now := flextime.Now()
+	item := LogItem{
+		TimeStamp:     now.Format(time.RFC3339),
 		Key:           key,
 		Path:          path,
 		StatusCode:    apiResp.StatusCode,
 		BillingStatus: calcBillingStatus(apiResp),
-	}, nil
+		CacheStatus:   apiResp.Header.Get(cache.StatusHeader),
+		LogID:         newRequestID(),
+	}
+	if r != nil {
+		item.Method = r.Method
+		if info := RequestInfoFrom(r.Context()); info != nil {
+			item.Method = info.Method
+			item.LatencyMillis = now.Sub(info.StartedAt).Milliseconds()
+			item.UpstreamHost = info.UpstreamHost
+			item.RequestBytes = info.RequestBytes()
+			item.ResponseBytes = info.ResponseBytes
+			item.ClientIP = info.ClientIP
+			item.RequestID = info.RequestID
+			item.ContractID = info.ContractID
+			item.ProductID = info.ProductID
+			item.ErrorClass = info.ErrorClass
+		}
+	}
+	if item.ErrorClass == "" {
+		switch {
+		case apiResp.StatusCode >= 500:
+			item.ErrorClass = ErrorClassUpstreamServer
+		case apiResp.StatusCode >= 400:
+			item.ErrorClass = ErrorClassUpstreamClient
+		}
+	}
+	if r != nil {
+		if info := RequestInfoFrom(r.Context()); info != nil {
+			logged := item
+			info.logged = &logged
+		}
+	}
+	return item, nil
 }
 
 type LogItems struct {
@@ -152,6 +283,13 @@ func (li *LogItems) Append(item LogItem) {
 	li.Items = append(li.Items, item)
 }
 
+// Len returns the number of the items waiting to be put to the db
+func (li *LogItems) Len() int {
+	li.Lock()
+	defer li.Unlock()
+	return len(li.Items)
+}
+
 func (li *LogItems) ReadAndDeleteAll() []LogItem {
 	li.Lock()
 	defer li.Unlock()
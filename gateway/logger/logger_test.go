@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/csv"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/future-architect/apidoor/gateway"
@@ -94,6 +95,15 @@ func TestUpdateLog(t *testing.T) {
 			logPattern:     "time,key,path,TEST1,TEST2,TEST3",
 			wantLog:        "2021-12-27T17:01:41Z,key,path,header1,,header3\n",
 		},
+		{
+			name:           "write request metadata",
+			key:            "key",
+			path:           "path",
+			header:         map[string]string{logger.RequestIDHeader: "request-1"},
+			responseStatus: http.StatusBadGateway,
+			logPattern:     "method,latency_ms,upstream_host,request_bytes,response_bytes,client_ip,request_id,contract_id,product_id,error_class",
+			wantLog:        "GET,0,,0,0,192.0.2.1,request-1,,,upstream_server_error\n",
+		},
 	}
 
 	defer func() {
@@ -118,6 +128,7 @@ func TestUpdateLog(t *testing.T) {
 			for k, v := range tt.header {
 				r.Header.Set(k, v)
 			}
+			r, _ = logger.StartRequest(r)
 			resp := http.Response{
 				StatusCode: tt.responseStatus,
 			}
@@ -189,6 +200,7 @@ func TestUpdateDBRoutine(t *testing.T) {
 			Path:          inputAccesses[0].path,
 			StatusCode:    http.StatusOK,
 			BillingStatus: logger.Billing,
+			Method:        http.MethodGet,
 		},
 	})
 
@@ -204,6 +216,7 @@ func TestUpdateDBRoutine(t *testing.T) {
 				Path:          inputAccesses[0].path,
 				StatusCode:    http.StatusOK,
 				BillingStatus: logger.Billing,
+				Method:        http.MethodGet,
 			},
 		})
 
@@ -217,12 +230,15 @@ func TestUpdateDBRoutine(t *testing.T) {
 				Path:          inputAccesses[1].path,
 				StatusCode:    http.StatusInternalServerError,
 				BillingStatus: logger.NotBilling,
+				Method:        http.MethodGet,
+				ErrorClass:    logger.ErrorClassUpstreamServer,
 			},
 			{
 				Key:           inputAccesses[0].key,
 				Path:          inputAccesses[0].path,
 				StatusCode:    http.StatusOK,
 				BillingStatus: logger.Billing,
+				Method:        http.MethodGet,
 			},
 		})
 }
@@ -236,7 +252,7 @@ func testAccessLogDBResult(t *testing.T, name string, wantResult []logger.LogIte
 		}
 		return
 	}
-	if diff := cmp.Diff(result, wantResult, cmpopts.IgnoreFields(logger.LogItem{}, "TimeStamp")); diff != "" {
+	if diff := cmp.Diff(result, wantResult, cmpopts.IgnoreFields(logger.LogItem{}, "TimeStamp", "LogID")); diff != "" {
 		t.Errorf("check %v: access log scan result differs:\n%v", name, diff)
 	}
 }
@@ -248,3 +264,49 @@ func scanAccessLog(t *testing.T) []logger.LogItem {
 	}
 	return result
 }
+
+func TestJSONAppender(t *testing.T) {
+	restore := flextime.Set(time.Date(2021, time.December, 27, 17, 1, 41, 0, time.UTC))
+	defer restore()
+
+	buffer := &bytes.Buffer{}
+	appender := logger.NewJSONAppender(buffer)
+
+	r := httptest.NewRequest(http.MethodPost, "http://example.com", strings.NewReader("body"))
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 192.0.2.10")
+	r, info := logger.StartRequest(r)
+	if _, err := io.ReadAll(r.Body); err != nil {
+		t.Fatal(err)
+	}
+	info.UpstreamHost = "api.example.com"
+	info.ContractID = 3
+	info.ProductID = 5
+	info.ResponseBytes = 10
+	info.ErrorClass = logger.ErrorClassResponseTransform
+	resp := http.Response{StatusCode: http.StatusBadGateway}
+	if err := appender.Do("key", "path", r, &resp, calcBillingStatus); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	// a request not started by StartRequest has no metadata
+	if err := appender.Do("key", "path", httptest.NewRequest(http.MethodGet, "http://example.com", nil),
+		&http.Response{StatusCode: http.StatusOK}, calcBillingStatus); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	want := fmt.Sprintf(`{"time":"2021-12-27T17:01:41Z","api_key":"key","path":"path","method":"POST","status_code":502,`+
+		`"billing_status":"not billing","cache_status":"","latency_ms":0,"upstream_host":"api.example.com",`+
+		`"request_bytes":4,"response_bytes":10,"client_ip":"192.0.2.1","request_id":"%s","contract_id":3,`+
+		`"product_id":5,"error_class":"response_transform_failed"}`+"\n", info.RequestID) +
+		`{"time":"2021-12-27T17:01:41Z","api_key":"key","path":"path","method":"GET","status_code":200,` +
+		`"billing_status":"billing","cache_status":"","latency_ms":0,"upstream_host":"",` +
+		`"request_bytes":0,"response_bytes":0,"client_ip":"","request_id":"","contract_id":null,"product_id":null,"error_class":""}` + "\n"
+	if diff := cmp.Diff(want, buffer.String()); diff != "" {
+		t.Errorf("json log differs:\n%s", diff)
+	}
+	if len(info.RequestID) != 32 {
+		t.Errorf("request id is not generated: %s", info.RequestID)
+	}
+	if len(appender.LogItems.Items) != 2 {
+		t.Errorf("log items are not stored: %d", len(appender.LogItems.Items))
+	}
+}
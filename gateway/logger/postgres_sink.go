@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// defaultPostgresBatchSize keeps an insert statement within the limit of 65535 parameters
+const defaultPostgresBatchSize = 500
+
+// PostgresSink inserts the access logs to the log_list table, whose custom_log has the json record of JSONAppender.
+// An item put again is skipped by the unique log_id of sql/009_log_list_log_id.sql.
+type PostgresSink struct {
+	DB *sql.DB
+	// BatchSize is the number of rows inserted by a statement, 500 if it is 0
+	BatchSize int
+}
+
+func (ps PostgresSink) Put(ctx context.Context, items []LogItem) []error {
+	size := ps.BatchSize
+	if size <= 0 {
+		size = defaultPostgresBatchSize
+	}
+	return putInBatches(ctx, items, size, ps.insert)
+}
+
+func (ps PostgresSink) insert(ctx context.Context, items []LogItem) error {
+	values := make([]string, 0, len(items))
+	args := make([]interface{}, 0, len(items)*5)
+	for _, item := range items {
+		custom, err := json.Marshal(newJSONLogRecord(item))
+		if err != nil {
+			return err
+		}
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5))
+		// items spooled before LogID is introduced have no id, and are inserted as they are
+		logID := sql.NullString{String: item.LogID, Valid: item.LogID != ""}
+		args = append(args, item.TimeStamp, item.Key, item.Path, string(custom), logID)
+	}
+	query := "INSERT INTO log_list(run_date, api_key, api_path, custom_log, log_id) VALUES " + strings.Join(values, ", ") +
+		" ON CONFLICT (log_id) DO NOTHING"
+	if _, err := ps.DB.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("insert log_list: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/Songmu/flextime"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RequestIDHeader carries the request id, which is taken from the client request if given
+const RequestIDHeader = "X-Request-Id"
+
+// error classes of access logs, which are empty for successful calls
+const (
+	ErrorClassUpstreamClient      = "upstream_client_error"
+	ErrorClassUpstreamServer      = "upstream_server_error"
+	ErrorClassUpstreamUnreachable = "upstream_unreachable"
+	ErrorClassResponseTooLarge    = "response_too_large"
+	ErrorClassResponseTransform   = "response_transform_failed"
+)
+
+// TrustForwardedFor makes the client ip the first address of X-Forwarded-For instead of the remote address,
+// which must be enabled only behind a proxy which sets the header
+var TrustForwardedFor = false
+
+// RequestInfo is metadata of a call recorded in access logs, which the handler fills while processing the request
+type RequestInfo struct {
+	RequestID string
+	Method    string
+	ClientIP  string
+	StartedAt time.Time
+	// UpstreamHost is the host of the forwarded api, empty if the api is not called
+	UpstreamHost string
+	ContractID   int
+	// ProductID is the product the routing is generated from, 0 if it is unknown
+	ProductID int
+	// ResponseBytes is the size of the response body written to the client
+	ResponseBytes int64
+	// ErrorClass is one of the error classes set by the handler, or derived from the response status if empty
+	ErrorClass string
+	// Route is the template path of the matched routing, empty until the routing is looked up
+	Route string
+
+	requestBody *countingReader
+	// logged is the log item made for the request, nil if it is not logged
+	logged *LogItem
+}
+
+// LogItem returns the log item made for the request, which is false if the request is not logged
+func (ri *RequestInfo) LogItem() (LogItem, bool) {
+	if ri.logged == nil {
+		return LogItem{}, false
+	}
+	return *ri.logged, true
+}
+
+// RequestBytes returns the size of the request body read from the client
+func (ri *RequestInfo) RequestBytes() int64 {
+	if ri.requestBody == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&ri.requestBody.n)
+}
+
+type requestInfoKey struct{}
+
+// StartRequest returns the request carrying a new RequestInfo, whose body counts bytes read from the client
+func StartRequest(r *http.Request) (*http.Request, *RequestInfo) {
+	info := &RequestInfo{
+		RequestID: r.Header.Get(RequestIDHeader),
+		Method:    r.Method,
+		ClientIP:  clientIP(r),
+		StartedAt: flextime.Now(),
+	}
+	if info.RequestID == "" {
+		info.RequestID = newRequestID()
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		info.requestBody = &countingReader{ReadCloser: r.Body}
+		r.Body = info.requestBody
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+// RequestInfoFrom returns the RequestInfo of the request, or nil if the request is not started by StartRequest
+func RequestInfoFrom(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
+func clientIP(r *http.Request) string {
+	if TrustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.SplitN(forwarded, ",", 2)[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	atomic.AddInt64(&cr.n, int64(n))
+	return n, err
+}
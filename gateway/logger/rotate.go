@@ -0,0 +1,303 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/Songmu/flextime"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rotatedTimeLayout = "20060102T150405"
+
+// RotatingFile is a log file which is rotated by size or age. Rotated files are renamed to
+// <name>-<time>.<ext> in the same directory, compressed by gzip if Compress is set, and deleted by the retention limits.
+// Rotation and Reopen take effect at a line boundary, so a line is never split into two files.
+// MaxBytes and MaxAge are checked on Write, so an idle file is rotated by the next write.
+type RotatingFile struct {
+	Path string
+	// MaxBytes rotates the file before it exceeds the size, 0 disables it
+	MaxBytes int64
+	// MaxAge rotates the file when it has been written for the duration, 0 disables it
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept, 0 keeps all of them
+	MaxBackups int
+	// MaxBackupAge deletes rotated files older than the duration, 0 keeps all of them
+	MaxBackupAge time.Duration
+	Compress     bool
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	// midLine is true if the last write does not end with a newline
+	midLine bool
+	reopen  bool
+	// background waits for the compression and the retention of rotated files, which are run one by one
+	background   sync.WaitGroup
+	backgroundMu sync.Mutex
+}
+
+// OpenRotatingFile opens the file in append mode, whose settings must be set before Write
+func OpenRotatingFile(path string) (*RotatingFile, error) {
+	rf := &RotatingFile{Path: path}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	rf.openedAt = flextime.Now()
+	rf.reopen = false
+	return nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if rf.reopen || rf.rotationDue(int64(len(p))) {
+		if !rf.midLine {
+			if err := rf.switchFile(); err != nil {
+				return 0, err
+			}
+		} else if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			// the rest of the current line is written before the next file starts
+			n, err := rf.write(p[:i+1])
+			if err != nil {
+				return n, err
+			}
+			if err := rf.switchFile(); err != nil {
+				return n, err
+			}
+			m, err := rf.write(p[i+1:])
+			return n + m, err
+		}
+	}
+	return rf.write(p)
+}
+
+func (rf *RotatingFile) write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	if n > 0 {
+		rf.midLine = p[n-1] != '\n'
+	}
+	return n, err
+}
+
+func (rf *RotatingFile) rotationDue(n int64) bool {
+	if rf.MaxBytes > 0 && rf.size > 0 && rf.size+n > rf.MaxBytes {
+		return true
+	}
+	return rf.MaxAge > 0 && flextime.Since(rf.openedAt) >= rf.MaxAge
+}
+
+// switchFile reopens the file if Reopen is requested, otherwise rotates it
+func (rf *RotatingFile) switchFile() error {
+	if rf.reopen {
+		if err := rf.file.Close(); err != nil {
+			log.Printf("close log file failed: %v", err)
+		}
+		return rf.open()
+	}
+	return rf.rotate()
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		log.Printf("close log file failed: %v", err)
+	}
+	rotated := rf.rotatedPath(flextime.Now())
+	if err := os.Rename(rf.Path, rotated); err != nil {
+		// the file is written again not to lose logs, and rotated at the next check
+		log.Printf("rotate log file failed: %v", err)
+		return rf.open()
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	rf.background.Add(1)
+	go func() {
+		defer rf.background.Done()
+		rf.backgroundMu.Lock()
+		defer rf.backgroundMu.Unlock()
+		if rf.Compress {
+			// the file may be deleted by the retention of a later rotation
+			if err := compressFile(rotated); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("compress rotated log file failed: %v", err)
+			}
+		}
+		rf.removeExpired()
+	}()
+	return nil
+}
+
+// rotatedPath returns the path of the file rotated at t, which does not exist yet
+func (rf *RotatingFile) rotatedPath(t time.Time) string {
+	ext := filepath.Ext(rf.Path)
+	base := strings.TrimSuffix(rf.Path, ext) + "-" + t.Format(rotatedTimeLayout)
+	path := base + ext
+	for i := 1; exists(path) || exists(path+".gz"); i++ {
+		path = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+	return path
+}
+
+// Reopen closes the file and opens the path again at the next line boundary, which is used after the file is
+// moved by an external tool such as logrotate
+func (rf *RotatingFile) Reopen() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return os.ErrClosed
+	}
+	rf.reopen = true
+	if rf.midLine {
+		return nil
+	}
+	return rf.switchFile()
+}
+
+// Close closes the file after the compression of rotated files finishes
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.background.Wait()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// removeExpired deletes the rotated files over MaxBackups or older than MaxBackupAge
+func (rf *RotatingFile) removeExpired() {
+	if rf.MaxBackups <= 0 && rf.MaxBackupAge <= 0 {
+		return
+	}
+	ext := filepath.Ext(rf.Path)
+	prefix := strings.TrimSuffix(filepath.Base(rf.Path), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(rf.Path))
+	if err != nil {
+		log.Printf("list rotated log files failed: %v", err)
+		return
+	}
+	type rotatedFile struct {
+		entry os.DirEntry
+		t     time.Time
+		seq   int
+	}
+	var rotated []rotatedFile
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".gz")
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		// <time> or <time>.<seq> between the prefix and the extension
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		seq := 0
+		if i := strings.IndexByte(stamp, '.'); i >= 0 {
+			n, err := strconv.Atoi(stamp[i+1:])
+			if err != nil {
+				continue
+			}
+			stamp, seq = stamp[:i], n
+		}
+		t, err := time.Parse(rotatedTimeLayout, stamp)
+		if err != nil {
+			continue
+		}
+		rotated = append(rotated, rotatedFile{entry: entry, t: t, seq: seq})
+	}
+	// the newest first
+	sort.Slice(rotated, func(i, j int) bool {
+		if !rotated[i].t.Equal(rotated[j].t) {
+			return rotated[i].t.After(rotated[j].t)
+		}
+		return rotated[i].seq > rotated[j].seq
+	})
+
+	for i, file := range rotated {
+		entry := file.entry
+		expired := rf.MaxBackups > 0 && i >= rf.MaxBackups
+		if !expired && rf.MaxBackupAge > 0 {
+			if info, err := entry.Info(); err == nil && flextime.Since(info.ModTime()) > rf.MaxBackupAge {
+				expired = true
+			}
+		}
+		if expired {
+			if err := os.Remove(filepath.Join(filepath.Dir(rf.Path), entry.Name())); err != nil {
+				log.Printf("remove rotated log file failed: %v", err)
+			}
+		}
+	}
+}
+
+// compressFile replaces the file with the gzip file, which appears only after it is completely written
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
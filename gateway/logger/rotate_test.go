@@ -0,0 +1,147 @@
+package logger_test
+
+import (
+	"compress/gzip"
+	"github.com/Songmu/flextime"
+	"github.com/future-architect/apidoor/gateway/logger"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readLogFiles returns the contents of the files in the dir by name, whose gzip files are decompressed
+func readLogFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir failed: %v", err)
+	}
+	contents := make(map[string]string)
+	for _, entry := range entries {
+		f, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatalf("open file failed: %v", err)
+		}
+		var r io.Reader = f
+		if strings.HasSuffix(entry.Name(), ".gz") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatalf("open gzip failed: %v", err)
+			}
+			r = gz
+		}
+		b, err := io.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatalf("read file failed: %v", err)
+		}
+		contents[entry.Name()] = string(b)
+	}
+	return contents
+}
+
+func TestRotatingFile_Size(t *testing.T) {
+	restore := flextime.Fix(time.Date(2022, time.January, 4, 10, 0, 0, 0, time.UTC))
+	defer restore()
+
+	dir := t.TempDir()
+	rf, err := logger.OpenRotatingFile(filepath.Join(dir, "log.csv"))
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	rf.MaxBytes = 10
+
+	// the line split into two writes is kept in the same file
+	for _, s := range []string{"aaaa\n", "bbbb\n", "cc", "cc\n", "dddd\neee", "e\n"} {
+		if _, err := rf.Write([]byte(s)); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	want := map[string]string{
+		"log-20220104T100000.csv":   "aaaa\nbbbb\n",
+		"log-20220104T100000.1.csv": "cccc\n",
+		"log.csv":                   "dddd\neeee\n",
+	}
+	if diff := cmp.Diff(want, readLogFiles(t, dir)); diff != "" {
+		t.Errorf("files differ (-want +got):\n%s", diff)
+	}
+}
+
+func TestRotatingFile_AgeAndRetention(t *testing.T) {
+	now := time.Date(2022, time.January, 4, 10, 0, 0, 0, time.UTC)
+	restore := flextime.Fix(now)
+	defer restore()
+
+	dir := t.TempDir()
+	rf, err := logger.OpenRotatingFile(filepath.Join(dir, "log.csv"))
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	rf.MaxAge = time.Hour
+	rf.MaxBackups = 2
+	rf.Compress = true
+
+	for i, s := range []string{"a\n", "b\n", "c\n", "d\n"} {
+		flextime.Fix(now.Add(time.Duration(i) * time.Hour))
+		if _, err := rf.Write([]byte(s)); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	// rotated files are named by the rotation time, and the oldest file of "a" is deleted
+	want := map[string]string{
+		"log-20220104T120000.csv.gz": "b\n",
+		"log-20220104T130000.csv.gz": "c\n",
+		"log.csv":                    "d\n",
+	}
+	if diff := cmp.Diff(want, readLogFiles(t, dir)); diff != "" {
+		t.Errorf("files differ (-want +got):\n%s", diff)
+	}
+}
+
+func TestRotatingFile_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log.csv")
+	rf, err := logger.OpenRotatingFile(path)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+
+	// the file is moved by logrotate in the middle of a line
+	if _, err := rf.Write([]byte("a\nb")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if err := os.Rename(path, filepath.Join(dir, "log.csv.1")); err != nil {
+		t.Fatalf("rename failed: %v", err)
+	}
+	if err := rf.Reopen(); err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	for _, s := range []string{"b\nc", "c\n"} {
+		if _, err := rf.Write([]byte(s)); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	want := map[string]string{
+		"log.csv.1": "a\nbb\n",
+		"log.csv":   "cc\n",
+	}
+	if diff := cmp.Diff(want, readLogFiles(t, dir)); diff != "" {
+		t.Errorf("files differ (-want +got):\n%s", diff)
+	}
+}
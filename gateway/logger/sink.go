@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// LogSink is a destination to which UpdateDB of appenders ships the access logs
+type LogSink interface {
+	// Put stores the items and returns the error of each item, which is nil if the item is stored.
+	// Items may be put again after a failure, so the sink should tolerate duplicates.
+	Put(ctx context.Context, items []LogItem) []error
+}
+
+// DynamoSink returns the sink of the access log table of DynamoDB, which is used by appenders without a sink
+func DynamoSink() LogSink {
+	return db
+}
+
+// MultiSink puts the items to all the sinks at the same time.
+// An item fails if any of the sinks fails, and it is put to all the sinks again by the retry.
+type MultiSink []LogSink
+
+func (ms MultiSink) Put(ctx context.Context, items []LogItem) []error {
+	results := make([][]error, len(ms))
+	var wg sync.WaitGroup
+	for i, sink := range ms {
+		wg.Add(1)
+		go func(i int, sink LogSink) {
+			defer wg.Done()
+			results[i] = sink.Put(ctx, items)
+		}(i, sink)
+	}
+	wg.Wait()
+
+	errs := make([]error, len(items))
+	for i := range items {
+		var msgs []string
+		for j, result := range results {
+			if result[i] != nil {
+				msgs = append(msgs, fmt.Sprintf("sink %d: %v", j, result[i]))
+			}
+		}
+		if len(msgs) > 0 {
+			errs[i] = fmt.Errorf("%s", strings.Join(msgs, ", "))
+		}
+	}
+	return errs
+}
+
+// putInBatches calls put for each batch of up to size items, and returns the error of each item
+func putInBatches(ctx context.Context, items []LogItem, size int, put func(ctx context.Context, batch []LogItem) error) []error {
+	errs := make([]error, len(items))
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+		err := ctx.Err()
+		if err == nil {
+			err = put(ctx, items[start:end])
+		}
+		if err != nil {
+			for i := start; i < end; i++ {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,193 @@
+package logger_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/future-architect/apidoor/gateway/logger"
+	"github.com/google/go-cmp/cmp"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+var sinkTestItems = []logger.LogItem{
+	{TimeStamp: "2022-01-04T10:00:00Z", Key: "key1", Path: "/a", Method: "GET", StatusCode: 200, BillingStatus: logger.Billing, ContractID: 3, ProductID: 5, LogID: "log1"},
+	{TimeStamp: "2022-01-04T10:00:01Z", Key: "key2", Path: "/b", Method: "POST", StatusCode: 201, BillingStatus: logger.Billing, LogID: "log2"},
+	{TimeStamp: "2022-01-04T10:00:02Z", Key: "key1", Path: "/c", Method: "GET", StatusCode: 500, BillingStatus: logger.NotBilling, ErrorClass: logger.ErrorClassUpstreamServer, LogID: "log3"},
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received [][]map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("wrong header: %v", r.Header)
+		}
+		var records []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+			t.Errorf("decode body failed: %v", err)
+		}
+		received = append(received, records)
+		// the second batch fails
+		if len(received) == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	sink := logger.WebhookSink{
+		URL:       server.URL,
+		Header:    http.Header{"Authorization": []string{"Bearer token"}},
+		BatchSize: 2,
+	}
+	errs := sink.Put(context.Background(), sinkTestItems)
+	if len(errs) != 3 || errs[0] != nil || errs[1] != nil || errs[2] == nil {
+		t.Errorf("only the last item should fail: %v", errs)
+	}
+	if len(received) != 2 || len(received[0]) != 2 || len(received[1]) != 1 {
+		t.Fatalf("wrong batches: %v", received)
+	}
+	want := map[string]interface{}{
+		"time": "2022-01-04T10:00:00Z", "api_key": "key1", "path": "/a", "method": "GET", "status_code": float64(200),
+		"billing_status": "billing", "cache_status": "", "latency_ms": float64(0), "upstream_host": "",
+		"request_bytes": float64(0), "response_bytes": float64(0), "client_ip": "", "request_id": "",
+		"contract_id": float64(3), "product_id": float64(5), "error_class": "",
+	}
+	if diff := cmp.Diff(want, received[0][0]); diff != "" {
+		t.Errorf("record differs:\n%s", diff)
+	}
+}
+
+func TestStreamSink_KafkaREST(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/access_log" {
+			t.Errorf("wrong path: %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/vnd.kafka.json.v2+json" {
+			t.Errorf("wrong content type: %s", got)
+		}
+		var body struct {
+			Records []struct {
+				Key   string                 `json:"key"`
+				Value map[string]interface{} `json:"value"`
+			} `json:"records"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body failed: %v", err)
+		}
+		offsets := make([]map[string]interface{}, 0, len(body.Records))
+		for _, record := range body.Records {
+			keys = append(keys, record.Key)
+			if record.Value["api_key"] != record.Key {
+				t.Errorf("value of %s has api key %v", record.Key, record.Value["api_key"])
+			}
+			offset := map[string]interface{}{"partition": 0, "offset": len(keys), "error_code": nil, "error": nil}
+			if record.Value["path"] == "/c" {
+				offset["error_code"] = 50002
+				offset["error"] = "broker is unavailable"
+			}
+			offsets = append(offsets, offset)
+		}
+		w.Header().Set("Content-Type", "application/vnd.kafka.v2+json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"offsets": offsets})
+	}))
+	defer server.Close()
+
+	sink := logger.StreamSink{
+		Producer:  logger.KafkaRESTProducer{URL: server.URL, Topic: "access_log"},
+		BatchSize: 2,
+	}
+	errs := sink.Put(context.Background(), sinkTestItems)
+	if len(errs) != 3 || errs[0] != nil || errs[1] != nil || errs[2] == nil {
+		t.Errorf("only the last item should fail: %v", errs)
+	}
+	if diff := cmp.Diff([]string{"key1", "key2", "key1"}, keys); diff != "" {
+		t.Errorf("message keys differ:\n%s", diff)
+	}
+}
+
+type sinkFunc func(ctx context.Context, items []logger.LogItem) []error
+
+func (f sinkFunc) Put(ctx context.Context, items []logger.LogItem) []error {
+	return f(ctx, items)
+}
+
+func TestMultiSink(t *testing.T) {
+	failAt := func(fail int) sinkFunc {
+		return func(_ context.Context, items []logger.LogItem) []error {
+			errs := make([]error, len(items))
+			if fail >= 0 {
+				errs[fail] = errors.New("unavailable")
+			}
+			return errs
+		}
+	}
+	errs := logger.MultiSink{failAt(-1), failAt(1), failAt(2)}.Put(context.Background(), sinkTestItems)
+	if errs[0] != nil {
+		t.Errorf("the first item should be put: %v", errs[0])
+	}
+	if errs[1] == nil || errs[1].Error() != "sink 1: unavailable" {
+		t.Errorf("wrong error of the second item: %v", errs[1])
+	}
+	if errs[2] == nil || errs[2].Error() != "sink 2: unavailable" {
+		t.Errorf("wrong error of the third item: %v", errs[2])
+	}
+}
+
+// TestPostgresSink needs the database of sql/001_init.sql and sql/009_log_list_log_id.sql, which is set by the DATABASE_* envs as dblogger
+func TestPostgresSink(t *testing.T) {
+	if os.Getenv("DATABASE_HOST") == "" {
+		t.Skip("DATABASE_HOST is not set")
+	}
+	db, err := sql.Open("postgres",
+		"host="+os.Getenv("DATABASE_HOST")+" "+
+			"port="+os.Getenv("DATABASE_PORT")+" "+
+			"user="+os.Getenv("DATABASE_USER")+" "+
+			"password="+os.Getenv("DATABASE_PASSWORD")+" "+
+			"dbname="+os.Getenv("DATABASE_NAME")+" "+
+			"sslmode="+os.Getenv("DATABASE_SSLMODE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cleanup := func() {
+		if _, err := db.Exec("DELETE FROM log_list WHERE api_key IN ('key1', 'key2')"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	// the items put again by the retry of MultiSink are not inserted twice
+	for n := 0; n < 2; n++ {
+		errs := logger.PostgresSink{DB: db, BatchSize: 2}.Put(context.Background(), sinkTestItems)
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("item %d failed: %v", i, err)
+			}
+		}
+	}
+
+	rows, err := db.Query("SELECT api_key, api_path, custom_log->>'status_code' FROM log_list WHERE api_key IN ('key1', 'key2') ORDER BY run_date")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got [][3]string
+	for rows.Next() {
+		var row [3]string
+		if err := rows.Scan(&row[0], &row[1], &row[2]); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, row)
+	}
+	want := [][3]string{{"key1", "/a", "200"}, {"key2", "/b", "201"}, {"key1", "/c", "500"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("rows differ:\n%s", diff)
+	}
+}
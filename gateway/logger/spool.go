@@ -0,0 +1,619 @@
+package logger
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Songmu/flextime"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	spoolSegmentPrefix = "segment-"
+	spoolSegmentSuffix = ".log"
+	spoolIndexSuffix   = ".idx"
+	// spoolMaxBatch is the max number of log items written by one fsync
+	spoolMaxBatch = 512
+)
+
+var (
+	defaultSpoolRetryBackoff    = time.Second * 10
+	defaultSpoolMaxRetryBackoff = time.Minute * 10
+	defaultSpoolShipBatchSize   = 1000
+
+	ErrSpoolClosed = errors.New("spool is closed")
+)
+
+// Spool is a write-ahead log of access log items on local disk, which keeps the items until they are put to the db.
+// Items are appended to the active segment file and fsynced in batches, and Ship sends the items of sealed segments
+// to the db. Segments are never rewritten: the results of shipping their items are appended to the index file of each
+// segment, and a segment is removed when all of its items are put. The segments which remain when the gateway stops
+// are replayed by the next Ship after OpenSpool.
+type Spool struct {
+	// RetryBackoff is the wait before the first retry of a failed item, which doubles every failure up to MaxRetryBackoff
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// ShipBatchSize is the max number of items put by a Ship, the rest of which are put by the following calls
+	ShipBatchSize int
+
+	dir string
+
+	mu      sync.Mutex
+	active  *os.File
+	written int
+	nextSeq int64
+	sealed  []*spoolSegment
+	closed  bool
+
+	requests chan spoolRequest
+	done     chan struct{}
+
+	shipMu sync.Mutex
+
+	pending  int64
+	retrying int64
+	shipped  int64
+	failures int64
+}
+
+// SpoolStats is the backlog of a spool
+type SpoolStats struct {
+	// PendingItems is the number of items which are not put to the db yet, including RetryingItems
+	PendingItems int64
+	// RetryingItems is the number of items which failed to be put and wait for the retry
+	RetryingItems int64
+	// ShippedItems and FailedAttempts are counted since the spool is opened
+	ShippedItems   int64
+	FailedAttempts int64
+}
+
+// spoolSegment is a sealed segment, whose state is kept in memory so that Ship reads only the segments with due items
+type spoolSegment struct {
+	name string
+	// remaining is the number of the items which are not put yet
+	remaining int
+	// nextDue is the earliest next attempt of the remaining items, which is zero if some of them are due now
+	nextDue time.Time
+}
+
+// spoolRecord is an item of a segment. Attempts and NextAttempt are set from the index of the segment
+type spoolRecord struct {
+	Item        LogItem    `json:"item"`
+	Attempts    int        `json:"attempts,omitempty"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+
+	shipped bool
+}
+
+// spoolIndexEntry is a result of putting the record of a segment, the last entry of which is effective
+type spoolIndexEntry struct {
+	Record      int        `json:"record"`
+	Shipped     bool       `json:"shipped,omitempty"`
+	Attempts    int        `json:"attempts,omitempty"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+}
+
+type spoolRequest struct {
+	line []byte
+	done chan error
+}
+
+// OpenSpool opens the spool in the directory, creating it if it does not exist.
+// The segments left in the directory are counted as pending items.
+func OpenSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create spool directory: %w", err)
+	}
+	s := &Spool{
+		RetryBackoff:    defaultSpoolRetryBackoff,
+		MaxRetryBackoff: defaultSpoolMaxRetryBackoff,
+		ShipBatchSize:   defaultSpoolShipBatchSize,
+		dir:             dir,
+		requests:        make(chan spoolRequest),
+		done:            make(chan struct{}),
+	}
+
+	segments, err := s.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range segments {
+		seq, _ := segmentSeq(name)
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+		records, err := s.loadSegment(name)
+		if err != nil {
+			return nil, err
+		}
+		segment := &spoolSegment{name: name}
+		segment.update(records)
+		if segment.remaining == 0 {
+			// all the items were put before the segment was removed
+			if err := s.removeSegment(name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		s.pending += int64(segment.remaining)
+		for _, record := range records {
+			if !record.shipped && record.Attempts > 0 {
+				s.retrying++
+			}
+		}
+		s.sealed = append(s.sealed, segment)
+	}
+	if s.pending > 0 {
+		log.Printf("access log spool has %d items to replay", s.pending)
+	}
+
+	go s.writeLoop()
+	return s, nil
+}
+
+// Append writes the item to the active segment, and returns after the segment is fsynced
+func (s *Spool) Append(item LogItem) error {
+	line, err := json.Marshal(spoolRecord{Item: item})
+	if err != nil {
+		return err
+	}
+	req := spoolRequest{
+		line: append(line, '\n'),
+		done: make(chan error, 1),
+	}
+	select {
+	case s.requests <- req:
+	case <-s.done:
+		return ErrSpoolClosed
+	}
+	return <-req.done
+}
+
+// writeLoop writes the appended items, whose requests waiting at the same time are fsynced together
+func (s *Spool) writeLoop() {
+	for {
+		var batch []spoolRequest
+		select {
+		case req := <-s.requests:
+			batch = append(batch, req)
+		case <-s.done:
+			return
+		}
+	drain:
+		for len(batch) < spoolMaxBatch {
+			select {
+			case req := <-s.requests:
+				batch = append(batch, req)
+			default:
+				break drain
+			}
+		}
+
+		err := s.writeBatch(batch)
+		for _, req := range batch {
+			req.done <- err
+		}
+	}
+}
+
+func (s *Spool) writeBatch(batch []spoolRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSpoolClosed
+	}
+	if s.active == nil {
+		name := segmentName(s.nextSeq)
+		f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("open spool segment: %w", err)
+		}
+		if err := syncDir(s.dir); err != nil {
+			f.Close()
+			return err
+		}
+		s.nextSeq++
+		s.active = f
+		s.written = 0
+	}
+
+	w := bufio.NewWriter(s.active)
+	for _, req := range batch {
+		if _, err := w.Write(req.line); err != nil {
+			return fmt.Errorf("write spool segment: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write spool segment: %w", err)
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("sync spool segment: %w", err)
+	}
+	s.written += len(batch)
+	atomic.AddInt64(&s.pending, int64(len(batch)))
+	return nil
+}
+
+// Ship seals the active segment and puts the items of the sealed segments to the db by post, which returns the error
+// of each item. At most ShipBatchSize items are put from the oldest segment, and the rest are put by the next calls.
+// Items whose post fails are retried with a backoff, and items not put before the context is done are kept as they are.
+func (s *Spool) Ship(ctx context.Context, post func(ctx context.Context, items []LogItem) []error) error {
+	s.shipMu.Lock()
+	defer s.shipMu.Unlock()
+
+	if err := s.seal(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	segments := make([]*spoolSegment, len(s.sealed))
+	copy(segments, s.sealed)
+	s.mu.Unlock()
+
+	// the due records are collected from the segments which have them
+	type target struct {
+		segment *spoolSegment
+		records []spoolRecord
+		due     []int
+	}
+	limit := s.ShipBatchSize
+	if limit <= 0 {
+		limit = defaultSpoolShipBatchSize
+	}
+	now := flextime.Now()
+	var targets []target
+	var items []LogItem
+	for _, segment := range segments {
+		if len(items) >= limit {
+			break
+		}
+		if segment.nextDue.After(now) {
+			continue
+		}
+		records, err := s.loadSegment(segment.name)
+		if err != nil {
+			return err
+		}
+		t := target{segment: segment, records: records}
+		for i, record := range records {
+			if len(items) >= limit {
+				break
+			}
+			if record.shipped || (record.NextAttempt != nil && record.NextAttempt.After(now)) {
+				continue
+			}
+			t.due = append(t.due, i)
+			items = append(items, record.Item)
+		}
+		targets = append(targets, t)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	errs := post(ctx, items)
+	n := 0
+	for _, t := range targets {
+		var entries []spoolIndexEntry
+		var shipped, retried, failures int64
+		for _, i := range t.due {
+			err := errs[n]
+			n++
+			record := &t.records[i]
+			switch {
+			case err == nil:
+				if record.Attempts > 0 {
+					retried--
+				}
+				shipped++
+				record.shipped = true
+				entries = append(entries, spoolIndexEntry{Record: i, Shipped: true})
+			case ctx.Err() != nil:
+				// the record is put by the next call without a backoff
+			default:
+				log.Printf("putting log info, %v, failed: %v", record.Item, err)
+				failures++
+				if record.Attempts == 0 {
+					retried++
+				}
+				record.Attempts++
+				next := now.Add(s.backoff(record.Attempts))
+				record.NextAttempt = &next
+				entries = append(entries, spoolIndexEntry{Record: i, Attempts: record.Attempts, NextAttempt: &next})
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		// the results are written before they are counted, so a crash between the post and the write only puts
+		// some items twice
+		t.segment.update(t.records)
+		if t.segment.remaining == 0 {
+			if err := s.removeSegment(t.segment.name); err != nil {
+				return err
+			}
+			s.mu.Lock()
+			for i, segment := range s.sealed {
+				if segment == t.segment {
+					s.sealed = append(s.sealed[:i], s.sealed[i+1:]...)
+					break
+				}
+			}
+			s.mu.Unlock()
+		} else if err := s.appendIndex(t.segment.name, entries); err != nil {
+			return err
+		}
+		atomic.AddInt64(&s.pending, -shipped)
+		atomic.AddInt64(&s.retrying, retried)
+		atomic.AddInt64(&s.shipped, shipped)
+		atomic.AddInt64(&s.failures, failures)
+	}
+	return nil
+}
+
+// Stats returns the current backlog of the spool
+func (s *Spool) Stats() SpoolStats {
+	return SpoolStats{
+		PendingItems:   atomic.LoadInt64(&s.pending),
+		RetryingItems:  atomic.LoadInt64(&s.retrying),
+		ShippedItems:   atomic.LoadInt64(&s.shipped),
+		FailedAttempts: atomic.LoadInt64(&s.failures),
+	}
+}
+
+// Close closes the active segment, after which Append fails. The items left in the spool are replayed on the next open.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Close()
+	s.active = nil
+	return err
+}
+
+// seal closes the active segment and adds it to the sealed segments
+func (s *Spool) seal() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == nil {
+		return nil
+	}
+	name := filepath.Base(s.active.Name())
+	if err := s.active.Close(); err != nil {
+		return fmt.Errorf("close spool segment: %w", err)
+	}
+	s.active = nil
+	s.sealed = append(s.sealed, &spoolSegment{name: name, remaining: s.written})
+	return nil
+}
+
+// update sets the number of the remaining records and their next due from the records of the segment
+func (seg *spoolSegment) update(records []spoolRecord) {
+	seg.remaining = 0
+	seg.nextDue = time.Time{}
+	for _, record := range records {
+		if record.shipped {
+			continue
+		}
+		due := time.Time{}
+		if record.NextAttempt != nil {
+			due = *record.NextAttempt
+		}
+		if seg.remaining == 0 || due.Before(seg.nextDue) {
+			seg.nextDue = due
+		}
+		seg.remaining++
+	}
+}
+
+// loadSegment returns the records of the segment with the results written in its index
+func (s *Spool) loadSegment(name string) ([]spoolRecord, error) {
+	records, err := readSegment(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readIndex(filepath.Join(s.dir, name+spoolIndexSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Record < 0 || entry.Record >= len(records) {
+			continue
+		}
+		record := &records[entry.Record]
+		record.shipped = entry.Shipped
+		record.Attempts = entry.Attempts
+		record.NextAttempt = entry.NextAttempt
+	}
+	return records, nil
+}
+
+// appendIndex appends the results of putting the records to the index of the segment
+func (s *Spool) appendIndex(name string, entries []spoolIndexEntry) error {
+	path := filepath.Join(s.dir, name+spoolIndexSuffix)
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open spool index: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			f.Close()
+			return fmt.Errorf("write spool index: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("write spool index: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync spool index: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close spool index: %w", err)
+	}
+	if errors.Is(statErr, os.ErrNotExist) {
+		return syncDir(s.dir)
+	}
+	return nil
+}
+
+// removeSegment removes the segment and then its index, so a crash between them leaves only the index,
+// which is removed by OpenSpool
+func (s *Spool) removeSegment(name string) error {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove spool segment: %w", err)
+	}
+	if err := os.Remove(filepath.Join(s.dir, name+spoolIndexSuffix)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove spool index: %w", err)
+	}
+	return syncDir(s.dir)
+}
+
+func (s *Spool) backoff(attempts int) time.Duration {
+	backoff := s.RetryBackoff
+	for i := 1; i < attempts && backoff < s.MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > s.MaxRetryBackoff {
+		backoff = s.MaxRetryBackoff
+	}
+	return backoff
+}
+
+// listSegments returns the segments in the directory in the order of their sequence numbers
+func (s *Spool) listSegments() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool directory: %w", err)
+	}
+	var segments []string
+	exists := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if _, ok := segmentSeq(entry.Name()); ok {
+			segments = append(segments, entry.Name())
+			exists[entry.Name()] = true
+		}
+	}
+	for _, entry := range entries {
+		// the index of a segment removed by a crash
+		name := entry.Name()
+		if strings.HasSuffix(name, spoolIndexSuffix) && !exists[strings.TrimSuffix(name, spoolIndexSuffix)] {
+			os.Remove(filepath.Join(s.dir, name))
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		a, _ := segmentSeq(segments[i])
+		b, _ := segmentSeq(segments[j])
+		return a < b
+	})
+	return segments, nil
+}
+
+// readSegment returns the records of the segment, skipping a line torn by a crash while writing
+func readSegment(path string) ([]spoolRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	var records []spoolRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record spoolRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Printf("skip a broken line of spool segment %s: %v", path, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read spool segment: %w", err)
+	}
+	return records, nil
+}
+
+// readIndex returns the entries of the index of a segment, which does not exist if no item of the segment is put
+func readIndex(path string) ([]spoolIndexEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("open spool index: %w", err)
+	}
+	defer f.Close()
+
+	var entries []spoolIndexEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry spoolIndexEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("skip a broken line of spool index %s: %v", path, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read spool index: %w", err)
+	}
+	return entries, nil
+}
+
+func segmentName(seq int64) string {
+	return fmt.Sprintf("%s%020d%s", spoolSegmentPrefix, seq, spoolSegmentSuffix)
+}
+
+func segmentSeq(name string) (int64, bool) {
+	if !strings.HasPrefix(name, spoolSegmentPrefix) || !strings.HasSuffix(name, spoolSegmentSuffix) {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, spoolSegmentPrefix), spoolSegmentSuffix), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+// syncDir fsyncs the directory so that created, renamed and removed files survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open spool directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync spool directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package logger_test
+
+import (
+	"context"
+	"errors"
+	"github.com/Songmu/flextime"
+	"github.com/future-architect/apidoor/gateway/logger"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpool(t *testing.T) {
+	now := time.Date(2022, time.January, 4, 10, 0, 0, 0, time.UTC)
+	restore := flextime.Fix(now)
+	defer restore()
+
+	dir := t.TempDir()
+	spool, err := logger.OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("open spool failed: %v", err)
+	}
+	spool.RetryBackoff = time.Minute
+	spool.MaxRetryBackoff = 3 * time.Minute
+
+	items := []logger.LogItem{
+		{TimeStamp: "2022-01-04T10:00:00Z", Key: "key", Path: "/a", StatusCode: 200, BillingStatus: logger.Billing},
+		{TimeStamp: "2022-01-04T10:00:01Z", Key: "key", Path: "/b", StatusCode: 200, BillingStatus: logger.Billing},
+		{TimeStamp: "2022-01-04T10:00:02Z", Key: "key", Path: "/c", StatusCode: 500, BillingStatus: logger.NotBilling},
+	}
+	for _, item := range items {
+		if err := spool.Append(item); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+	if got := spool.Stats().PendingItems; got != 3 {
+		t.Errorf("pending items: want 3, got %d", got)
+	}
+
+	// the item of /b fails and is kept
+	var posted []string
+	post := func(fail string) func(context.Context, []logger.LogItem) []error {
+		return func(_ context.Context, items []logger.LogItem) []error {
+			errs := make([]error, len(items))
+			for i, item := range items {
+				if item.Path == fail {
+					errs[i] = errors.New("db is unavailable")
+					continue
+				}
+				posted = append(posted, item.Path)
+			}
+			return errs
+		}
+	}
+	if err := spool.Ship(context.Background(), post("/b")); err != nil {
+		t.Fatalf("ship failed: %v", err)
+	}
+	if got, want := spool.Stats(), (logger.SpoolStats{PendingItems: 1, RetryingItems: 1, ShippedItems: 2, FailedAttempts: 1}); got != want {
+		t.Errorf("stats after a failure: want %+v, got %+v", want, got)
+	}
+
+	// the failed item waits for the backoff, and the spool is replayed after reopened
+	if err := spool.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if err := spool.Append(items[0]); !errors.Is(err, logger.ErrSpoolClosed) {
+		t.Errorf("append after close: want %v, got %v", logger.ErrSpoolClosed, err)
+	}
+	spool, err = logger.OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("reopen spool failed: %v", err)
+	}
+	defer spool.Close()
+	spool.RetryBackoff = time.Minute
+	if got := spool.Stats(); got.PendingItems != 1 || got.RetryingItems != 1 {
+		t.Errorf("stats after reopen: %+v", got)
+	}
+	if err := spool.Ship(context.Background(), post("")); err != nil {
+		t.Fatalf("ship failed: %v", err)
+	}
+	if got := spool.Stats().PendingItems; got != 1 {
+		t.Errorf("the item in backoff is shipped, pending items: %d", got)
+	}
+	if len(posted) != 2 {
+		t.Errorf("the item in backoff is posted: %v", posted)
+	}
+
+	flextime.Fix(now.Add(time.Minute))
+	if err := spool.Ship(context.Background(), post("")); err != nil {
+		t.Fatalf("ship failed: %v", err)
+	}
+	if diff := cmp.Diff([]string{"/a", "/c", "/b"}, posted); diff != "" {
+		t.Errorf("posted items differ:\n%s", diff)
+	}
+	if got, want := spool.Stats(), (logger.SpoolStats{ShippedItems: 1}); got != want {
+		t.Errorf("stats after all shipped: want %+v, got %+v", want, got)
+	}
+	segments, err := filepath.Glob(filepath.Join(dir, "segment-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 0 {
+		t.Errorf("shipped segments are left: %v", segments)
+	}
+}
+
+func TestSpool_BatchAndRetryInPlace(t *testing.T) {
+	now := time.Date(2022, time.January, 4, 10, 0, 0, 0, time.UTC)
+	restore := flextime.Fix(now)
+	defer restore()
+
+	dir := t.TempDir()
+	spool, err := logger.OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("open spool failed: %v", err)
+	}
+	defer spool.Close()
+	spool.RetryBackoff = time.Minute
+	spool.ShipBatchSize = 2
+
+	for _, path := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		if err := spool.Append(logger.LogItem{Key: "key", Path: path}); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+
+	var posted []string
+	post := func(_ context.Context, items []logger.LogItem) []error {
+		errs := make([]error, len(items))
+		for i, item := range items {
+			if item.Path == "/b" && !flextime.Now().After(now) {
+				errs[i] = errors.New("db is unavailable")
+				continue
+			}
+			posted = append(posted, item.Path)
+		}
+		return errs
+	}
+	ship := func(want []string) {
+		t.Helper()
+		posted = nil
+		if err := spool.Ship(context.Background(), post); err != nil {
+			t.Fatalf("ship failed: %v", err)
+		}
+		if diff := cmp.Diff(want, posted); diff != "" {
+			t.Errorf("posted items differ:\n%s", diff)
+		}
+	}
+
+	// items are put in batches, and the failed item is kept in its segment
+	ship([]string{"/a"})
+	segments, err := filepath.Glob(filepath.Join(dir, "segment-*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 {
+		t.Fatalf("wrong segments: %v", segments)
+	}
+	before, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ship([]string{"/c", "/d"})
+	ship([]string{"/e"})
+	ship(nil)
+	if got, want := spool.Stats(), (logger.SpoolStats{PendingItems: 1, RetryingItems: 1, ShippedItems: 4, FailedAttempts: 1}); got != want {
+		t.Errorf("stats after batches: want %+v, got %+v", want, got)
+	}
+	after, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Errorf("segment is rewritten:\n%s\n%s", before, after)
+	}
+
+	// the retry state is kept in the index over a reopen
+	if err := spool.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	spool, err = logger.OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("reopen spool failed: %v", err)
+	}
+	defer spool.Close()
+	spool.RetryBackoff = time.Minute
+	if got := spool.Stats(); got.PendingItems != 1 || got.RetryingItems != 1 {
+		t.Errorf("stats after reopen: %+v", got)
+	}
+	ship(nil)
+
+	flextime.Fix(now.Add(time.Minute))
+	ship([]string{"/b"})
+	files, err := filepath.Glob(filepath.Join(dir, "segment-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("shipped segments are left: %v", files)
+	}
+}
+
+func TestSpool_Timeout(t *testing.T) {
+	spool, err := logger.OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("open spool failed: %v", err)
+	}
+	defer spool.Close()
+	if err := spool.Append(logger.LogItem{Key: "key", Path: "/a"}); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	// items not put before the deadline are kept without a backoff
+	ctx, cancel := context.WithCancel(context.Background())
+	err = spool.Ship(ctx, func(ctx context.Context, items []logger.LogItem) []error {
+		cancel()
+		errs := make([]error, len(items))
+		for i := range errs {
+			errs[i] = ctx.Err()
+		}
+		return errs
+	})
+	if err != nil {
+		t.Fatalf("ship failed: %v", err)
+	}
+	if got, want := spool.Stats(), (logger.SpoolStats{PendingItems: 1}); got != want {
+		t.Errorf("stats after timeout: want %+v, got %+v", want, got)
+	}
+}
+
+func TestSpool_ConcurrentAppend(t *testing.T) {
+	spool, err := logger.OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("open spool failed: %v", err)
+	}
+	defer spool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := spool.Append(logger.LogItem{Key: "key", StatusCode: i}); err != nil {
+				t.Errorf("append failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	posted := make(map[int]bool)
+	err = spool.Ship(context.Background(), func(_ context.Context, items []logger.LogItem) []error {
+		for _, item := range items {
+			posted[item.StatusCode] = true
+		}
+		return make([]error, len(items))
+	})
+	if err != nil {
+		t.Fatalf("ship failed: %v", err)
+	}
+	if len(posted) != 100 {
+		t.Errorf("posted items: want 100, got %d", len(posted))
+	}
+}
+
+func TestSpool_TornLine(t *testing.T) {
+	dir := t.TempDir()
+	segment := `{"item":{"TimeStamp":"2022-01-04T10:00:00Z","Key":"key","Path":"/a","StatusCode":200,"BillingStatus":1}}
+{"item":{"TimeStamp":"2022-01-04T10:00:01Z","Key":"key","Pa`
+	if err := os.WriteFile(filepath.Join(dir, "segment-00000000000000000003.log"), []byte(segment), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spool, err := logger.OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("open spool failed: %v", err)
+	}
+	defer spool.Close()
+	if got := spool.Stats().PendingItems; got != 1 {
+		t.Errorf("pending items: want 1, got %d", got)
+	}
+
+	// a new segment does not overwrite the replayed one
+	if err := spool.Append(logger.LogItem{Key: "key", Path: "/b"}); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	var posted []logger.LogItem
+	err = spool.Ship(context.Background(), func(_ context.Context, items []logger.LogItem) []error {
+		posted = append(posted, items...)
+		return make([]error, len(items))
+	})
+	if err != nil {
+		t.Fatalf("ship failed: %v", err)
+	}
+	want := []logger.LogItem{
+		{TimeStamp: "2022-01-04T10:00:00Z", Key: "key", Path: "/a", StatusCode: 200, BillingStatus: logger.Billing},
+		{Key: "key", Path: "/b"},
+	}
+	if diff := cmp.Diff(want, posted); diff != "" {
+		t.Errorf("posted items differ:\n%s", diff)
+	}
+}
@@ -0,0 +1,120 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const defaultStreamBatchSize = 500
+
+// StreamMessage is a message of a stream, such as a record of a Kafka topic
+type StreamMessage struct {
+	Key   []byte
+	Value []byte
+}
+
+// StreamProducer sends messages to a stream, which a client of the stream implements
+type StreamProducer interface {
+	Produce(ctx context.Context, messages []StreamMessage) error
+}
+
+// StreamSink produces the access logs as messages whose key is the api key and value is the json record of JSONAppender,
+// so that the logs of an api key keep their order in a partition
+type StreamSink struct {
+	Producer StreamProducer
+	// BatchSize is the number of messages produced at once, 500 if it is 0
+	BatchSize int
+}
+
+func (ss StreamSink) Put(ctx context.Context, items []LogItem) []error {
+	size := ss.BatchSize
+	if size <= 0 {
+		size = defaultStreamBatchSize
+	}
+	return putInBatches(ctx, items, size, ss.produce)
+}
+
+func (ss StreamSink) produce(ctx context.Context, items []LogItem) error {
+	messages := make([]StreamMessage, len(items))
+	for i, item := range items {
+		value, err := json.Marshal(newJSONLogRecord(item))
+		if err != nil {
+			return err
+		}
+		messages[i] = StreamMessage{Key: []byte(item.Key), Value: value}
+	}
+	return ss.Producer.Produce(ctx, messages)
+}
+
+// KafkaRESTProducer produces messages to a topic by the Kafka REST Proxy API v2,
+// which is served by Confluent REST Proxy and Redpanda
+type KafkaRESTProducer struct {
+	// URL is the base url of the proxy, such as http://localhost:8082
+	URL    string
+	Topic  string
+	Client *http.Client
+}
+
+type kafkaRESTRecords struct {
+	Records []kafkaRESTRecord `json:"records"`
+}
+
+type kafkaRESTRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// kafkaRESTOffsets is the response of a produce request, which has the error of each record
+type kafkaRESTOffsets struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+func (kp KafkaRESTProducer) Produce(ctx context.Context, messages []StreamMessage) error {
+	records := kafkaRESTRecords{Records: make([]kafkaRESTRecord, len(messages))}
+	for i, m := range messages {
+		records.Records[i] = kafkaRESTRecord{Key: string(m.Key), Value: m.Value}
+	}
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, kp.URL+"/topics/"+url.PathEscape(kp.Topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	client := kp.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("produce access logs to %s: %w", kp.Topic, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, res.Body)
+		return fmt.Errorf("kafka rest proxy returned status %d", res.StatusCode)
+	}
+	var offsets kafkaRESTOffsets
+	if err := json.NewDecoder(res.Body).Decode(&offsets); err != nil {
+		return fmt.Errorf("decode kafka rest proxy response: %w", err)
+	}
+	for _, offset := range offsets.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("kafka rest proxy failed to produce a record: %d %s", *offset.ErrorCode, offset.Error)
+		}
+	}
+	return nil
+}
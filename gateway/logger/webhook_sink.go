@@ -0,0 +1,66 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const defaultWebhookBatchSize = 100
+
+// WebhookSink posts the access logs to the url as a json array of the records of JSONAppender.
+// A batch succeeds if the response status is 2xx.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+	// Header is added to the requests, such as Authorization
+	Header http.Header
+	// BatchSize is the number of records posted by a request, 100 if it is 0
+	BatchSize int
+}
+
+func (ws WebhookSink) Put(ctx context.Context, items []LogItem) []error {
+	size := ws.BatchSize
+	if size <= 0 {
+		size = defaultWebhookBatchSize
+	}
+	return putInBatches(ctx, items, size, ws.post)
+}
+
+func (ws WebhookSink) post(ctx context.Context, items []LogItem) error {
+	records := make([]jsonLogRecord, len(items))
+	for i, item := range items {
+		records[i] = newJSONLogRecord(item)
+	}
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ws.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range ws.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := ws.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post access logs to webhook: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", res.StatusCode)
+	}
+	return nil
+}
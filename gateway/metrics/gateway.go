@@ -0,0 +1,84 @@
+// Package metrics defines the Prometheus metrics of the gateway, which are registered to the default registry
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// RouteUnmatched is the route label of requests which match no routing
+const RouteUnmatched = "unmatched"
+
+var (
+	// Requests counts requests by the route template, the status class and the billing status
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apidoor_gateway_requests_total",
+		Help: "Number of requests handled by the gateway.",
+	}, []string{"route", "status_class", "billing_status"})
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "apidoor_gateway_request_duration_seconds",
+		Help:    "Time to handle a request in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "status_class", "billing_status"})
+	// UpstreamErrors counts failed calls of APIs by the error class in the access log
+	UpstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apidoor_gateway_upstream_errors_total",
+		Help: "Number of API calls which failed or returned 5xx.",
+	}, []string{"route", "error_class"})
+	// DataSourceLookupDuration observes lookups of routings and access tokens by the operation
+	DataSourceLookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "apidoor_gateway_datasource_lookup_duration_seconds",
+		Help:    "Time to look up the data source in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+	// CounterLookups counts lookups of the API call counter by the result, hit or miss of the cache
+	CounterLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apidoor_gateway_api_call_counter_lookups_total",
+		Help: "Number of lookups of the API call counter by the cache result.",
+	}, []string{"result"})
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apidoor_gateway_rate_limit_rejections_total",
+		Help: "Number of requests rejected by the API limit.",
+	}, []string{"route"})
+)
+
+var (
+	bufferedItemsOnce sync.Once
+	// bufferedItemsSource holds the func() int64 the gauge reports, replaced by every call of BufferedItems
+	bufferedItemsSource atomic.Value
+	bufferedItems       = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "apidoor_gateway_access_log_buffer_items",
+		Help: "Number of access logs which are not put to the db yet.",
+	}, func() float64 {
+		f, ok := bufferedItemsSource.Load().(func() int64)
+		if !ok {
+			return 0
+		}
+		return float64(f())
+	})
+)
+
+// BufferedItems makes the gauge of the access logs which are not put to the db yet report f.
+// the gauge is registered on the first call, and later calls replace f instead of registering it again
+func BufferedItems(f func() int64) {
+	bufferedItemsSource.Store(f)
+	bufferedItemsOnce.Do(func() {
+		prometheus.MustRegister(bufferedItems)
+	})
+}
+
+// Handler serves the metrics in the default registry to Prometheus
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// StatusClass returns the class of the status code such as "2xx"
+func StatusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
@@ -0,0 +1,17 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBufferedItems(t *testing.T) {
+	BufferedItems(func() int64 { return 1 })
+	// a second appender replaces the source instead of registering the gauge again
+	BufferedItems(func() int64 { return 2 })
+
+	if got := testutil.ToFloat64(bufferedItems); got != 2 {
+		t.Errorf("wrong buffered items: got %v, want 2", got)
+	}
+}
@@ -1,9 +1,17 @@
 package model
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
 	"strings"
 )
 
@@ -13,62 +21,250 @@ const (
 	Header          ParamType = "header"
 	Query                     = "query"
 	BodyFormEncoded           = "body_form_encoded"
+	// BodyJSON sets a string field of a json request body, whose Key is a json pointer, ex.) /auth/token
+	BodyJSON = "body_json"
+	// BodyMultipart adds a field to a multipart/form-data request body
+	BodyMultipart = "body_multipart"
+	Cookie        = "cookie"
+	// OAuth2ClientCredentials is a bearer token fetched from TokenURL with the OAuth2 client credentials grant
+	OAuth2ClientCredentials = "oauth2_client_credentials"
+)
+
+var (
+	// ErrTokenNotApplicable is wrapped by errors of tokens which cannot be added to the body of the request,
+	// such as a json field to a form request
+	ErrTokenNotApplicable = errors.New("access token is not applicable to the request")
+	// ErrTokenUnavailable is wrapped by errors of tokens which could not be fetched from the token endpoint
+	ErrTokenUnavailable = errors.New("access token is unavailable")
 )
 
 type AccessToken struct {
 	ParamType ParamType `dynamo:"param_type"`
 	Key       string    `dynamo:"key"`
 	Value     string    `dynamo:"value"`
+	// ClientID, ClientSecret, TokenURL and Scopes are used by the oauth2_client_credentials type
+	ClientID     string   `dynamo:"client_id"`
+	ClientSecret string   `dynamo:"client_secret"`
+	TokenURL     string   `dynamo:"token_url"`
+	Scopes       []string `dynamo:"scopes"`
+}
+
+// TokenSource returns an access token of the oauth2_client_credentials type, which may be cached
+type TokenSource interface {
+	Token(ctx context.Context, at AccessToken) (string, error)
 }
 
 type NotSupportedParamType string
 
 func (nsp NotSupportedParamType) Error() string { return string(nsp) }
 
-func (at AccessToken) addTokenToRequest(r *http.Request) error {
+// addTokenToRequest adds the token to the outbound request unless the request already has the parameter of the key
+func (at AccessToken) addTokenToRequest(r *http.Request, source TokenSource) error {
 	switch at.ParamType {
 	case Header:
 		if r.Header.Get(at.Key) == "" {
 			r.Header.Add(at.Key, at.Value)
 		}
 	case Query:
-		if !r.URL.Query().Has(at.Key) {
-			query := r.URL.Query()
-			query.Add(at.Key, at.Value)
-			r.URL.RawQuery = query.Encode()
+		if _, ok := r.URL.Query()[at.Key]; !ok {
+			// the existing query is kept as it is, not re-encoded
+			param := url.QueryEscape(at.Key) + "=" + url.QueryEscape(at.Value)
+			if r.URL.RawQuery == "" {
+				r.URL.RawQuery = param
+			} else {
+				r.URL.RawQuery += "&" + param
+			}
+		}
+	case Cookie:
+		if _, err := r.Cookie(at.Key); err == http.ErrNoCookie {
+			r.AddCookie(&http.Cookie{Name: at.Key, Value: at.Value})
 		}
 	case BodyFormEncoded:
-		contentType := r.Header.Get("Content-Type")
-		if contentType != "application/x-www-form-urlencoded" {
-			return fmt.Errorf("content-Type header is not application/x-www-form-urlencoded, got %s", contentType)
+		if !isForm(r.Header) {
+			return fmt.Errorf("%w: content-Type header is not application/x-www-form-urlencoded, got %s",
+				ErrTokenNotApplicable, r.Header.Get("Content-Type"))
 		}
-		if err := r.ParseForm(); err != nil {
-			return fmt.Errorf("reading body as form data failed: %w", err)
+		return rewriteBody(r, at.addToForm)
+	case BodyJSON:
+		if !isJSON(r.Header) {
+			return fmt.Errorf("%w: content-Type header is not json, got %s", ErrTokenNotApplicable, r.Header.Get("Content-Type"))
 		}
-		if !r.PostForm.Has(at.Key) {
-			r.PostForm.Add(at.Key, at.Value)
-			r.Body = io.NopCloser(strings.NewReader(r.PostForm.Encode()))
+		return rewriteBody(r, at.addToJSON)
+	case BodyMultipart:
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+			return fmt.Errorf("%w: content-Type header is not multipart/form-data, got %s",
+				ErrTokenNotApplicable, r.Header.Get("Content-Type"))
 		}
+		return rewriteBody(r, func(body []byte) ([]byte, error) {
+			return at.addToMultipart(body, params["boundary"])
+		})
+	case OAuth2ClientCredentials:
+		if r.Header.Get("Authorization") != "" {
+			return nil
+		}
+		if source == nil {
+			return fmt.Errorf("no token source is configured for %s", at.ParamType)
+		}
+		token, err := source.Token(r.Context(), at)
+		if err != nil {
+			return fmt.Errorf("%w: fetching oauth2 access token failed: %v", ErrTokenUnavailable, err)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
 	default:
-		return fmt.Errorf("unsupported param type: %v", at.ParamType)
+		return NotSupportedParamType(fmt.Sprintf("unsupported param type: %v", at.ParamType))
 	}
 	return nil
 }
 
+// rewriteBody reads the body of the request into memory, and replaces it with the one the function returns.
+// the function returns nil if the body is not changed
+func rewriteBody(r *http.Request, rewrite func(body []byte) ([]byte, error)) error {
+	var body []byte
+	if r.Body != nil {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return fmt.Errorf("read body failed: %w", err)
+		}
+		r.Body.Close()
+		body = data
+	}
+	rewritten, err := rewrite(body)
+	if err != nil {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		return err
+	}
+	if rewritten != nil {
+		body = rewritten
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return nil
+}
+
+func (at AccessToken) addToForm(body []byte) ([]byte, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading body as form data failed: %v", ErrTokenNotApplicable, err)
+	}
+	if _, ok := form[at.Key]; ok {
+		return nil, nil
+	}
+	param := url.QueryEscape(at.Key) + "=" + url.QueryEscape(at.Value)
+	if len(body) == 0 {
+		return []byte(param), nil
+	}
+	return append(append(body, '&'), param...), nil
+}
+
+// addToJSON sets the value at the json pointer of the key, creating intermediate objects.
+// an empty body is treated as an empty object
+func (at AccessToken) addToJSON(body []byte) ([]byte, error) {
+	if !strings.HasPrefix(at.Key, "/") {
+		return nil, fmt.Errorf("key of %s must be a json pointer, got %s", at.ParamType, at.Key)
+	}
+	var obj map[string]interface{}
+	if len(bytes.TrimSpace(body)) == 0 {
+		obj = make(map[string]interface{})
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		// numbers are kept as they are written
+		decoder.UseNumber()
+		if err := decoder.Decode(&obj); err != nil || obj == nil {
+			return nil, fmt.Errorf("%w: body is not a json object", ErrTokenNotApplicable)
+		}
+	}
+
+	tokens := strings.Split(at.Key[1:], "/")
+	for i, v := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(v, "~1", "/"), "~0", "~")
+	}
+	parent := obj
+	for _, name := range tokens[:len(tokens)-1] {
+		child, ok := parent[name]
+		if !ok {
+			created := make(map[string]interface{})
+			parent[name] = created
+			parent = created
+			continue
+		}
+		if parent, ok = child.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("%w: %s of the json body is not an object", ErrTokenNotApplicable, name)
+		}
+	}
+	last := tokens[len(tokens)-1]
+	if _, ok := parent[last]; ok {
+		return nil, nil
+	}
+	parent[last] = at.Value
+
+	converted, err := json.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("marshal json body failed: %w", err)
+	}
+	return converted, nil
+}
+
+// addToMultipart appends a form field to the multipart body, whose parts are copied with the same boundary
+func (at AccessToken) addToMultipart(body []byte, boundary string) ([]byte, error) {
+	type part struct {
+		header textproto.MIMEHeader
+		data   []byte
+	}
+	var parts []part
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		p, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading body as multipart failed: %v", ErrTokenNotApplicable, err)
+		}
+		if p.FormName() == at.Key {
+			return nil, nil
+		}
+		data, err := io.ReadAll(p)
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading body as multipart failed: %v", ErrTokenNotApplicable, err)
+		}
+		parts = append(parts, part{header: p.Header, data: data})
+	}
+
+	buf := new(bytes.Buffer)
+	writer := multipart.NewWriter(buf)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return nil, fmt.Errorf("%w: invalid boundary: %v", ErrTokenNotApplicable, err)
+	}
+	for _, p := range parts {
+		w, err := writer.CreatePart(p.header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(p.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.WriteField(at.Key, at.Value); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 type AccessTokens struct {
 	Tokens []AccessToken `dynamo:"tokens"`
 }
 
-func (ats AccessTokens) AddTokensToRequest(r *http.Request) error {
-	var errors error
+// AddTokensToRequest adds the tokens to the outbound request, source fetches tokens of the oauth2_client_credentials type.
+// it stops at the first token which fails, because the request must not be forwarded without the token
+func (ats AccessTokens) AddTokensToRequest(r *http.Request, source TokenSource) error {
 	for _, v := range ats.Tokens {
-		if err := v.addTokenToRequest(r); err != nil {
-			if errors == nil {
-				errors = err
-			} else {
-				errors = fmt.Errorf("; %w", err)
-			}
+		if err := v.addTokenToRequest(r, source); err != nil {
+			return fmt.Errorf("adding %s token failed: %w", v.ParamType, err)
 		}
 	}
-	return errors
+	return nil
 }
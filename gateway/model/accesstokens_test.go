@@ -0,0 +1,196 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAccessTokens_AddTokensToRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		token       AccessToken
+		url         string
+		contentType string
+		body        string
+		wantURL     string
+		wantBody    string
+		wantCookie  string
+		wantErr     error
+	}{
+		{
+			name:    "query is appended without re-encoding the existing query",
+			token:   AccessToken{ParamType: Query, Key: "token", Value: "a b"},
+			url:     "http://example.com/users?b=2&a=1",
+			wantURL: "http://example.com/users?b=2&a=1&token=a+b",
+		},
+		{
+			name:       "cookie",
+			token:      AccessToken{ParamType: Cookie, Key: "session", Value: "token_value"},
+			url:        "http://example.com/users",
+			wantURL:    "http://example.com/users",
+			wantCookie: "session=token_value",
+		},
+		{
+			name:        "json field at a nested pointer",
+			token:       AccessToken{ParamType: BodyJSON, Key: "/auth/api~1key", Value: "token_value"},
+			contentType: "application/json; charset=utf-8",
+			body:        `{"count": 10000000000000001, "auth": {"user": "a"}}`,
+			wantBody:    `{"auth":{"api/key":"token_value","user":"a"},"count":10000000000000001}`,
+		},
+		{
+			name:        "json field of an empty body",
+			token:       AccessToken{ParamType: BodyJSON, Key: "/token", Value: "token_value"},
+			contentType: "application/json",
+			wantBody:    `{"token":"token_value"}`,
+		},
+		{
+			name:        "existing json field is not overwritten",
+			token:       AccessToken{ParamType: BodyJSON, Key: "/token", Value: "token_value"},
+			contentType: "application/json",
+			body:        `{"token": "original"}`,
+			wantBody:    `{"token": "original"}`,
+		},
+		{
+			name:        "json field under a non-object field",
+			token:       AccessToken{ParamType: BodyJSON, Key: "/auth/token", Value: "token_value"},
+			contentType: "application/json",
+			body:        `{"auth": [1]}`,
+			wantErr:     ErrTokenNotApplicable,
+		},
+		{
+			name:        "json field to a json array",
+			token:       AccessToken{ParamType: BodyJSON, Key: "/token", Value: "token_value"},
+			contentType: "application/json",
+			body:        `[1]`,
+			wantErr:     ErrTokenNotApplicable,
+		},
+		{
+			name:        "json field to a form request",
+			token:       AccessToken{ParamType: BodyJSON, Key: "/token", Value: "token_value"},
+			contentType: "application/x-www-form-urlencoded",
+			body:        "a=1",
+			wantErr:     ErrTokenNotApplicable,
+		},
+		{
+			name:        "multipart field to a json request",
+			token:       AccessToken{ParamType: BodyMultipart, Key: "token", Value: "token_value"},
+			contentType: "application/json",
+			body:        `{}`,
+			wantErr:     ErrTokenNotApplicable,
+		},
+		{
+			name:    "oauth2 token without a token source",
+			token:   AccessToken{ParamType: OAuth2ClientCredentials, ClientID: "id", TokenURL: "http://example.com/token"},
+			url:     "http://example.com/users",
+			wantErr: errors.New("no token source"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.url == "" {
+				tt.url = "http://example.com/users"
+				tt.wantURL = tt.url
+			}
+			r, err := http.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			err = AccessTokens{Tokens: []AccessToken{tt.token}}.AddTokensToRequest(r, nil)
+			if tt.wantErr != nil {
+				if err == nil || (!errors.Is(err, tt.wantErr) && !strings.Contains(err.Error(), tt.wantErr.Error())) {
+					t.Errorf("wrong error: want %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if r.URL.String() != tt.wantURL {
+				t.Errorf("wrong url: want %s, got %s", tt.wantURL, r.URL)
+			}
+			if got := r.Header.Get("Cookie"); got != tt.wantCookie {
+				t.Errorf("wrong cookie: want %s, got %s", tt.wantCookie, got)
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantBody := tt.wantBody
+			if wantBody == "" {
+				wantBody = tt.body
+			}
+			if string(body) != wantBody {
+				t.Errorf("wrong body: want %s, got %s", wantBody, body)
+			}
+			if r.ContentLength != int64(len(body)) {
+				t.Errorf("wrong content length: want %d, got %d", len(body), r.ContentLength)
+			}
+		})
+	}
+}
+
+func TestAccessTokens_AddTokensToRequest_Multipart(t *testing.T) {
+	newRequest := func(t *testing.T, fields map[string]string) *http.Request {
+		t.Helper()
+		buf := new(bytes.Buffer)
+		w := multipart.NewWriter(buf)
+		for k, v := range fields {
+			if err := w.WriteField(k, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fw, err := w.CreateFormFile("file", "data.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte{0, 1, 2, 3})
+		w.Close()
+		r, err := http.NewRequest(http.MethodPost, "http://example.com/upload", buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", w.FormDataContentType())
+		return r
+	}
+	tokens := AccessTokens{Tokens: []AccessToken{{ParamType: BodyMultipart, Key: "token", Value: "token_value"}}}
+
+	r := newRequest(t, map[string]string{"name": "apidoor"})
+	if err := tokens.AddTokensToRequest(r, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatalf("parse multipart failed: %v", err)
+	}
+	if got := r.MultipartForm.Value["token"]; len(got) != 1 || got[0] != "token_value" {
+		t.Errorf("token field is %v", got)
+	}
+	if got := r.MultipartForm.Value["name"]; len(got) != 1 || got[0] != "apidoor" {
+		t.Errorf("name field is %v", got)
+	}
+	files := r.MultipartForm.File["file"]
+	if len(files) != 1 || files[0].Filename != "data.bin" || files[0].Size != 4 {
+		t.Fatalf("file part is not kept: %v", files)
+	}
+
+	// an existing field is not overwritten
+	r = newRequest(t, map[string]string{"token": "original"})
+	if err := tokens.AddTokensToRequest(r, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatalf("parse multipart failed: %v", err)
+	}
+	if got := r.MultipartForm.Value["token"]; len(got) != 1 || got[0] != "original" {
+		t.Errorf("token field is %v", got)
+	}
+}
@@ -3,10 +3,18 @@ package model
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
 	"strings"
+	"unicode"
 )
 
-var ErrUnauthorizedRequest = &MyError{Message: "unauthorized request"}
+var (
+	ErrUnauthorizedRequest = &MyError{Message: "unauthorized request"}
+
+	placeholderPattern = regexp.MustCompile(`{([A-Za-z_][A-Za-z0-9_]*)}`)
+)
 
 type MyError struct {
 	Message string `json:"message"`
@@ -20,7 +28,20 @@ type Field struct {
 	// Template is a gateway path
 	Template URITemplate
 	// Path is a  destination api path
-	Path          URITemplate
+	Path URITemplate
+	// Query is a query string of the destination api, which may contain placeholders, ex.) id={user_id}
+	Query string
+	// Headers are templates of headers added to the forward request, which may contain placeholders
+	Headers map[string]string
+	// Builtins are values of built-in placeholders such as apikey_id and contract_id.
+	// a path parameter of the same name takes precedence
+	Builtins map[string]string
+	// Transform is applied to the request before forwarding it and to the response before returning it, nil if not set
+	Transform *Transform
+	// Cache enables the response cache for GET requests, nil if not set
+	Cache *CacheConfig
+	// Operations are parsed from the swagger file of the api, which requests are validated against
+	Operations    []Operation
 	ForwardSchema string
 	// Num represents the recent number of api calls.
 	Num int
@@ -42,23 +63,83 @@ func (f Field) createForwardURL(query map[string]string) string {
 	if f.ForwardSchema != "" {
 		schema = f.ForwardSchema + "://"
 	}
-	return schema + strings.Join(nodes, "/")
+	forwardURL := schema + strings.Join(nodes, "/")
+	if f.Query != "" {
+		forwardURL += "?" + expandPlaceholders(f.Query, query, url.QueryEscape)
+	}
+	return forwardURL
+}
+
+func (f Field) createForwardHeaders(params map[string]string) http.Header {
+	header := make(http.Header, len(f.Headers))
+	for k, v := range f.Headers {
+		header.Set(k, expandPlaceholders(v, params, stripControlChars))
+	}
+	return header
+}
+
+// stripControlChars removes control characters such as CR and LF, which may come from an encoded path parameter,
+// so that a value cannot inject other headers
+func stripControlChars(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// params returns built-in values overwritten by the path parameters
+func (f Field) params(pathParams map[string]string) map[string]string {
+	ret := make(map[string]string, len(f.Builtins)+len(pathParams))
+	for k, v := range f.Builtins {
+		ret[k] = v
+	}
+	for k, v := range pathParams {
+		ret[k] = v
+	}
+	return ret
+}
+
+// expandPlaceholders replaces {name} in s with the escaped value, and an unknown name with an empty string
+func expandPlaceholders(s string, params map[string]string, escape func(string) string) string {
+	return placeholderPattern.ReplaceAllStringFunc(s, func(m string) string {
+		return escape(params[m[1:len(m)-1]])
+	})
 }
 
 type Fields []Field
 
+// CacheConfig is the response cache setting of a routing
+type CacheConfig struct {
+	// DefaultTTL is the seconds a response without Cache-Control or Expires is fresh
+	DefaultTTL int `json:"default_ttl" dynamo:"default_ttl"`
+	// BillHits reports whether responses served from the cache are billed
+	BillHits bool `json:"bill_hits" dynamo:"bill_hits"`
+}
+
 type FieldResult struct {
 	Field        Field
 	ForwardURL   string
 	TemplatePath string
+	// ForwardHeaders are headers added to the forward request
+	ForwardHeaders http.Header
+	// PathParams are values of the placeholders in the gateway path
+	PathParams map[string]string
 }
 
 func (f Fields) LookupTemplate(path string) (*FieldResult, error) {
 	u := NewURITemplate(path)
 	for _, v := range f {
-		if params, ok := u.Match(v.Template); ok {
-			forwardURL := v.createForwardURL(params)
-			return &FieldResult{Field: v, ForwardURL: forwardURL, TemplatePath: v.Template.JoinPath()}, nil
+		if pathParams, ok := u.Match(v.Template); ok {
+			params := v.params(pathParams)
+			return &FieldResult{
+				Field:          v,
+				ForwardURL:     v.createForwardURL(params),
+				TemplatePath:   v.Template.JoinPath(),
+				ForwardHeaders: v.createForwardHeaders(params),
+				PathParams:     pathParams,
+			}, nil
 		}
 	}
 	return nil, ErrUnauthorizedRequest // Not found path
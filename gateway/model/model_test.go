@@ -2,6 +2,8 @@ package model
 
 import (
 	"errors"
+	"github.com/google/go-cmp/cmp"
+	"net/http"
 	"testing"
 )
 
@@ -190,3 +192,75 @@ func TestFields_URI(t *testing.T) {
 		})
 	}
 }
+
+func TestFields_ForwardQueryAndHeaders(t *testing.T) {
+	fields := Fields{
+		{
+			Template: NewURITemplate("/users/{user_id}"),
+			Path:     NewURITemplate("example.com/users"),
+			Query:    "id={user_id}&consumer={apikey_id}",
+			Headers: map[string]string{
+				"X-User-Id":  "{user_id}",
+				"X-Contract": "contract-{contract_id}",
+			},
+			Builtins: map[string]string{
+				"apikey_id":   "10",
+				"contract_id": "20",
+			},
+		},
+		{
+			Template: NewURITemplate("/contracts/{contract_id}"),
+			Path:     NewURITemplate("example.com/contracts/{contract_id}"),
+			Builtins: map[string]string{
+				"contract_id": "20",
+			},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		wantForwardURI string
+		wantHeaders    http.Header
+	}{
+		{
+			name:           "path parameters and built-in values are put into the query string and headers",
+			path:           "/users/a&b",
+			wantForwardURI: "example.com/users?id=a%26b&consumer=10",
+			wantHeaders: http.Header{
+				"X-User-Id":  []string{"a&b"},
+				"X-Contract": []string{"contract-20"},
+			},
+		},
+		{
+			name:           "control characters of a path parameter are removed from headers",
+			path:           "/users/a\r\nX-Injected: 1",
+			wantForwardURI: "example.com/users?id=a%0D%0AX-Injected%3A+1&consumer=10",
+			wantHeaders: http.Header{
+				"X-User-Id":  []string{"aX-Injected: 1"},
+				"X-Contract": []string{"contract-20"},
+			},
+		},
+		{
+			name:           "a path parameter takes precedence over the built-in value of the same name",
+			path:           "/contracts/30",
+			wantForwardURI: "example.com/contracts/30",
+			wantHeaders:    http.Header{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := fields.LookupTemplate(tt.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.ForwardURL != tt.wantForwardURI {
+				t.Errorf("uri in the result differs: want %s, got %s", tt.wantForwardURI, result.ForwardURL)
+			}
+			if diff := cmp.Diff(tt.wantHeaders, result.ForwardHeaders); diff != "" {
+				t.Errorf("headers differ:\n%v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Operation is an operation of the api parsed from its swagger file, which requests are validated against
+type Operation struct {
+	// Method is an upper case http method, ex.) GET
+	Method     string               `json:"method" dynamo:"method"`
+	Parameters []OperationParameter `json:"parameters" dynamo:"parameters"`
+	// RequestBody is the json encoded schema of the json request body, empty if not defined
+	RequestBody         string `json:"request_body" dynamo:"request_body"`
+	RequestBodyRequired bool   `json:"request_body_required" dynamo:"request_body_required"`
+}
+
+type OperationParameter struct {
+	Name string `json:"name" dynamo:"name"`
+	// In is path, query or header
+	In       string `json:"in" dynamo:"in"`
+	Required bool   `json:"required" dynamo:"required"`
+	// Schema is the json encoded schema of the parameter
+	Schema string `json:"schema" dynamo:"schema"`
+}
+
+// ValidateRequest validates parameters and the json body of the request against the operation of its method.
+// a request whose method has no operation is not validated. the body is read and replaced with the one in memory
+func (f Field) ValidateRequest(r *http.Request, pathParams map[string]string) error {
+	var op *Operation
+	for i := range f.Operations {
+		if f.Operations[i].Method == r.Method {
+			op = &f.Operations[i]
+			break
+		}
+	}
+	if op == nil {
+		return nil
+	}
+
+	for _, param := range op.Parameters {
+		if err := param.validate(r, pathParams); err != nil {
+			return err
+		}
+	}
+	if op.RequestBody == "" {
+		return nil
+	}
+	return op.validateBody(r)
+}
+
+func (op Operation) validateBody(r *http.Request) error {
+	var body []byte
+	if r.Body != nil {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return fmt.Errorf("read request body failed: %w", err)
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		body = data
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBodyRequired {
+			return schemaErrorf("body", "is required")
+		}
+		return nil
+	}
+	// the schema is defined only for json, so a body of the other content types is passed through
+	if !isJSON(r.Header) {
+		return nil
+	}
+
+	schema, err := ParseSchema(op.RequestBody)
+	if err != nil {
+		return err
+	}
+	var v interface{}
+	if err = json.Unmarshal(body, &v); err != nil {
+		return schemaErrorf("body", "is not a valid json")
+	}
+	return schema.Validate("body", v)
+}
+
+func (op OperationParameter) validate(r *http.Request, pathParams map[string]string) error {
+	var values []string
+	switch op.In {
+	case "path":
+		if v, ok := pathParams[op.Name]; ok {
+			values = []string{v}
+		}
+	case "query":
+		values = r.URL.Query()[op.Name]
+	case "header":
+		values = r.Header.Values(op.Name)
+	default:
+		return nil
+	}
+
+	field := op.In + "." + op.Name
+	if len(values) == 0 {
+		if op.Required {
+			return schemaErrorf(field, "is required")
+		}
+		return nil
+	}
+
+	schema, err := ParseSchema(op.Schema)
+	if err != nil {
+		return err
+	}
+	v, err := convertParameter(schema, values)
+	if err != nil {
+		return schemaErrorf(field, "%s", err.Error())
+	}
+	return schema.Validate(field, v)
+}
+
+// convertParameter converts the raw values into the type of the schema.
+// an array is given as repeated parameters or comma separated values
+func convertParameter(schema Schema, values []string) (interface{}, error) {
+	types := schema.types()
+	if len(types) == 0 {
+		return values[0], nil
+	}
+	switch types[0] {
+	case "array":
+		if len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+		items, _ := schema.sub("items")
+		ret := make([]interface{}, len(values))
+		for i, v := range values {
+			item, err := convertParameter(items, []string{v})
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = item
+		}
+		return ret, nil
+	case "integer", "number":
+		n, err := strconv.ParseFloat(values[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be %s", types[0])
+		}
+		return n, nil
+	case "boolean":
+		b, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("must be boolean")
+		}
+		return b, nil
+	}
+	return values[0], nil
+}
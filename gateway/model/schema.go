@@ -0,0 +1,304 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a json schema parsed from an OpenAPI document, whose references are already resolved by the management api.
+// the subset of keywords used by OpenAPI 2.0 and 3.x is supported, and the other keywords such as format are ignored
+type Schema map[string]interface{}
+
+// ParseSchema parses a json encoded schema
+func ParseSchema(data string) (Schema, error) {
+	var ret Schema
+	if err := json.Unmarshal([]byte(data), &ret); err != nil {
+		return nil, fmt.Errorf("parse schema failed: %w", err)
+	}
+	return ret, nil
+}
+
+// SchemaError is a validation error, whose Field is a dot separated path to the invalid value
+type SchemaError struct {
+	Field   string
+	Message string
+}
+
+func (se *SchemaError) Error() string {
+	return fmt.Sprintf("%s %s", se.Field, se.Message)
+}
+
+func schemaErrorf(field, format string, args ...interface{}) error {
+	return &SchemaError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// Validate validates the value decoded from json, numbers must be float64
+func (s Schema) Validate(field string, v interface{}) error {
+	if v == nil {
+		if s.nullable() || len(s.types()) == 0 {
+			return nil
+		}
+		return schemaErrorf(field, "must not be null")
+	}
+
+	if types := s.types(); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if matchesType(t, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return schemaErrorf(field, "must be %s", strings.Join(types, " or "))
+		}
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return schemaErrorf(field, "must be one of %v", enum)
+		}
+	}
+
+	var err error
+	switch value := v.(type) {
+	case string:
+		err = s.validateString(field, value)
+	case float64:
+		err = s.validateNumber(field, value)
+	case []interface{}:
+		err = s.validateArray(field, value)
+	case map[string]interface{}:
+		err = s.validateObject(field, value)
+	}
+	if err != nil {
+		return err
+	}
+	return s.validateCombinations(field, v)
+}
+
+func (s Schema) types() []string {
+	switch t := s["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		// OpenAPI 3.1 allows a list of types
+		ret := make([]string, 0, len(t))
+		for _, v := range t {
+			if name, ok := v.(string); ok && name != "null" {
+				ret = append(ret, name)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+func (s Schema) nullable() bool {
+	if v, _ := s["nullable"].(bool); v {
+		return true
+	}
+	if v, _ := s["x-nullable"].(bool); v {
+		return true
+	}
+	if t, ok := s["type"].([]interface{}); ok {
+		for _, v := range t {
+			if v == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s Schema) sub(key string) (Schema, bool) {
+	v, ok := s[key].(map[string]interface{})
+	return v, ok
+}
+
+func (s Schema) number(key string) (float64, bool) {
+	v, ok := s[key].(float64)
+	return v, ok
+}
+
+func matchesType(t string, v interface{}) bool {
+	switch t {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+	// an unknown type is not validated
+	return true
+}
+
+func (s Schema) validateString(field, v string) error {
+	length := float64(utf8.RuneCountInString(v))
+	if min, ok := s.number("minLength"); ok && length < min {
+		return schemaErrorf(field, "must be at least %v characters", min)
+	}
+	if max, ok := s.number("maxLength"); ok && length > max {
+		return schemaErrorf(field, "must be at most %v characters", max)
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		// a pattern which go does not support, such as a lookahead, is not validated
+		if err == nil && !re.MatchString(v) {
+			return schemaErrorf(field, "must match the pattern %s", pattern)
+		}
+	}
+	return nil
+}
+
+func (s Schema) validateNumber(field string, v float64) error {
+	if min, ok := s.number("minimum"); ok {
+		// exclusiveMinimum is a boolean until OpenAPI 3.0, and a number since 3.1
+		if exclusive, _ := s["exclusiveMinimum"].(bool); exclusive && v <= min {
+			return schemaErrorf(field, "must be greater than %v", min)
+		} else if v < min {
+			return schemaErrorf(field, "must be greater than or equal to %v", min)
+		}
+	}
+	if min, ok := s.number("exclusiveMinimum"); ok && v <= min {
+		return schemaErrorf(field, "must be greater than %v", min)
+	}
+	if max, ok := s.number("maximum"); ok {
+		if exclusive, _ := s["exclusiveMaximum"].(bool); exclusive && v >= max {
+			return schemaErrorf(field, "must be less than %v", max)
+		} else if v > max {
+			return schemaErrorf(field, "must be less than or equal to %v", max)
+		}
+	}
+	if max, ok := s.number("exclusiveMaximum"); ok && v >= max {
+		return schemaErrorf(field, "must be less than %v", max)
+	}
+	if m, ok := s.number("multipleOf"); ok && m > 0 {
+		if q := v / m; q != math.Trunc(q) {
+			return schemaErrorf(field, "must be a multiple of %v", m)
+		}
+	}
+	return nil
+}
+
+func (s Schema) validateArray(field string, v []interface{}) error {
+	if min, ok := s.number("minItems"); ok && float64(len(v)) < min {
+		return schemaErrorf(field, "must have at least %v items", min)
+	}
+	if max, ok := s.number("maxItems"); ok && float64(len(v)) > max {
+		return schemaErrorf(field, "must have at most %v items", max)
+	}
+	if items, ok := s.sub("items"); ok {
+		for i, item := range v {
+			if err := items.Validate(fmt.Sprintf("%s[%d]", field, i), item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s Schema) validateObject(field string, v map[string]interface{}) error {
+	properties, _ := s.sub("properties")
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := v[name]; ok {
+				continue
+			}
+			// a read only property is required only in responses
+			if prop, ok := properties[name].(map[string]interface{}); ok {
+				if readOnly, _ := prop["readOnly"].(bool); readOnly {
+					continue
+				}
+			}
+			return schemaErrorf(field+"."+name, "is required")
+		}
+	}
+
+	// properties are validated in the order of names, so that the error is deterministic
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if prop, ok := properties[name].(map[string]interface{}); ok {
+			if err := Schema(prop).Validate(field+"."+name, v[name]); err != nil {
+				return err
+			}
+			continue
+		}
+		switch additional := s["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return schemaErrorf(field+"."+name, "is not allowed")
+			}
+		case map[string]interface{}:
+			if err := Schema(additional).Validate(field+"."+name, v[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s Schema) validateCombinations(field string, v interface{}) error {
+	if allOf, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			if schema, ok := sub.(map[string]interface{}); ok {
+				if err := Schema(schema).Validate(field, v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok && len(anyOf) > 0 {
+		if s.countMatches(field, anyOf, v) == 0 {
+			return schemaErrorf(field, "must match any of the schemas")
+		}
+	}
+	if oneOf, ok := s["oneOf"].([]interface{}); ok && len(oneOf) > 0 {
+		if s.countMatches(field, oneOf, v) != 1 {
+			return schemaErrorf(field, "must match exactly one of the schemas")
+		}
+	}
+	return nil
+}
+
+func (s Schema) countMatches(field string, schemas []interface{}, v interface{}) int {
+	count := 0
+	for _, sub := range schemas {
+		schema, ok := sub.(map[string]interface{})
+		if !ok || Schema(schema).Validate(field, v) == nil {
+			count++
+		}
+	}
+	return count
+}
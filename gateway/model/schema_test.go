@@ -0,0 +1,204 @@
+package model
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSchema_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		value   string
+		wantErr string
+	}{
+		{
+			name:   "valid object",
+			schema: `{"type":"object","required":["name"],"properties":{"name":{"type":"string"},"age":{"type":"integer","minimum":0}}}`,
+			value:  `{"name":"foo","age":20}`,
+		},
+		{
+			name:    "missing required property",
+			schema:  `{"type":"object","required":["name"],"properties":{"name":{"type":"string"}}}`,
+			value:   `{}`,
+			wantErr: "body.name is required",
+		},
+		{
+			name:   "read only property is not required",
+			schema: `{"type":"object","required":["id"],"properties":{"id":{"type":"integer","readOnly":true}}}`,
+			value:  `{}`,
+		},
+		{
+			name:    "wrong type",
+			schema:  `{"type":"integer"}`,
+			value:   `1.5`,
+			wantErr: "body must be integer",
+		},
+		{
+			name:    "null is not allowed",
+			schema:  `{"type":"string"}`,
+			value:   `null`,
+			wantErr: "body must not be null",
+		},
+		{
+			name:   "nullable",
+			schema: `{"type":"string","nullable":true}`,
+			value:  `null`,
+		},
+		{
+			name:    "enum",
+			schema:  `{"type":"string","enum":["a","b"]}`,
+			value:   `"c"`,
+			wantErr: "body must be one of [a b]",
+		},
+		{
+			name:    "exclusive maximum of openapi 3.0",
+			schema:  `{"type":"number","maximum":10,"exclusiveMaximum":true}`,
+			value:   `10`,
+			wantErr: "body must be less than 10",
+		},
+		{
+			name:    "exclusive minimum of openapi 3.1",
+			schema:  `{"type":"number","exclusiveMinimum":0}`,
+			value:   `0`,
+			wantErr: "body must be greater than 0",
+		},
+		{
+			name:    "pattern",
+			schema:  `{"type":"string","pattern":"^[0-9]+$"}`,
+			value:   `"12a"`,
+			wantErr: "body must match the pattern ^[0-9]+$",
+		},
+		{
+			name:    "array items",
+			schema:  `{"type":"array","maxItems":3,"items":{"type":"string","minLength":2}}`,
+			value:   `["ab","c"]`,
+			wantErr: "body[1] must be at least 2 characters",
+		},
+		{
+			name:    "additional properties are not allowed",
+			schema:  `{"type":"object","properties":{"name":{"type":"string"}},"additionalProperties":false}`,
+			value:   `{"name":"foo","age":1}`,
+			wantErr: "body.age is not allowed",
+		},
+		{
+			name:    "all of",
+			schema:  `{"allOf":[{"type":"object","required":["a"]},{"type":"object","required":["b"]}]}`,
+			value:   `{"a":1}`,
+			wantErr: "body.b is required",
+		},
+		{
+			name:    "one of matches both",
+			schema:  `{"oneOf":[{"type":"number"},{"type":"integer"}]}`,
+			value:   `1`,
+			wantErr: "body must match exactly one of the schemas",
+		},
+		{
+			name:   "any of",
+			schema: `{"anyOf":[{"type":"string"},{"type":"integer"}]}`,
+			value:  `1`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := ParseSchema(tt.schema)
+			if err != nil {
+				t.Fatalf("parse schema failed: %v", err)
+			}
+			var v interface{}
+			if err = json.Unmarshal([]byte(tt.value), &v); err != nil {
+				t.Fatalf("parse value failed: %v", err)
+			}
+			err = schema.Validate("body", v)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("wrong error: got %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestField_ValidateRequest(t *testing.T) {
+	field := Field{
+		Operations: []Operation{
+			{
+				Method: http.MethodGet,
+				Parameters: []OperationParameter{
+					{Name: "ids", In: "query", Required: true, Schema: `{"type":"array","items":{"type":"integer"}}`},
+					{Name: "X-Version", In: "header", Schema: `{"type":"string","enum":["1","2"]}`},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		header  map[string]string
+		wantErr string
+	}{
+		{
+			name:   "comma separated array",
+			method: http.MethodGet,
+			url:    "/users?ids=1,2",
+		},
+		{
+			name:   "repeated array",
+			method: http.MethodGet,
+			url:    "/users?ids=1&ids=2",
+		},
+		{
+			name:    "invalid array item",
+			method:  http.MethodGet,
+			url:     "/users?ids=1,a",
+			wantErr: "query.ids must be integer",
+		},
+		{
+			name:    "missing required parameter",
+			method:  http.MethodGet,
+			url:     "/users",
+			wantErr: "query.ids is required",
+		},
+		{
+			name:    "invalid header",
+			method:  http.MethodGet,
+			url:     "/users?ids=1",
+			header:  map[string]string{"X-Version": "3"},
+			wantErr: "header.X-Version must be one of [1 2]",
+		},
+		{
+			name:   "method without operation is not validated",
+			method: http.MethodDelete,
+			url:    "/users",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.url, strings.NewReader(""))
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			err := field.ValidateRequest(r, nil)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("wrong error: got %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// types of TransformStep, which the management api validates
+const (
+	TransformRenameHeader    = "rename_header"
+	TransformRemoveHeader    = "remove_header"
+	TransformSetHeader       = "set_header"
+	TransformSetJSONField    = "set_json_field"
+	TransformRemoveJSONField = "remove_json_field"
+	TransformFormToJSON      = "form_to_json"
+)
+
+// Transform is a pipeline applied to the request before forwarding it, and to the response before returning it
+type Transform struct {
+	Request  []TransformStep `json:"request" dynamo:"request"`
+	Response []TransformStep `json:"response" dynamo:"response"`
+}
+
+type TransformStep struct {
+	Type string `json:"type" dynamo:"type"`
+	// Name is a header name, or a dot separated path of a json field.
+	// a name of remove_header ending with "*" matches all headers with the prefix
+	Name string `json:"name" dynamo:"name"`
+	// To is the new header name of rename_header
+	To string `json:"to" dynamo:"to"`
+	// Value is a string for set_header, and any json value for set_json_field
+	Value interface{} `json:"value" dynamo:"value"`
+}
+
+// ApplyToRequest transforms headers and body of the request in place
+func (t *Transform) ApplyToRequest(r *http.Request) error {
+	if t == nil || len(t.Request) == 0 {
+		return nil
+	}
+	body := &transformBody{src: r.Body}
+	for _, step := range t.Request {
+		if err := step.apply(r.Header, body); err != nil {
+			return err
+		}
+	}
+	if !body.read {
+		return nil
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body.data))
+	r.ContentLength = int64(len(body.data))
+	return nil
+}
+
+// ApplyToResponse transforms headers and body of the response in place
+func (t *Transform) ApplyToResponse(res *http.Response) error {
+	if t == nil || len(t.Response) == 0 {
+		return nil
+	}
+	body := &transformBody{src: res.Body}
+	for _, step := range t.Response {
+		if err := step.apply(res.Header, body); err != nil {
+			return err
+		}
+	}
+	if !body.read {
+		return nil
+	}
+	if res.Body != nil {
+		res.Body.Close()
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body.data))
+	if body.changed {
+		res.ContentLength = int64(len(body.data))
+		res.Header.Set("Content-Length", strconv.Itoa(len(body.data)))
+	}
+	return nil
+}
+
+// transformBody is a body read into memory lazily, only when some step touches it
+type transformBody struct {
+	src     io.Reader
+	data    []byte
+	read    bool
+	changed bool
+}
+
+func (tb *transformBody) bytes() ([]byte, error) {
+	if tb.read {
+		return tb.data, nil
+	}
+	tb.read = true
+	if tb.src == nil {
+		return nil, nil
+	}
+	data, err := io.ReadAll(tb.src)
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %w", err)
+	}
+	tb.data = data
+	return data, nil
+}
+
+func (tb *transformBody) set(data []byte) {
+	tb.data = data
+	tb.changed = true
+}
+
+func (ts TransformStep) apply(header http.Header, body *transformBody) error {
+	switch ts.Type {
+	case TransformRenameHeader:
+		if values, ok := header[http.CanonicalHeaderKey(ts.Name)]; ok {
+			header.Del(ts.Name)
+			header[http.CanonicalHeaderKey(ts.To)] = values
+		}
+	case TransformRemoveHeader:
+		if prefix := strings.TrimSuffix(ts.Name, "*"); prefix != ts.Name {
+			for key := range header {
+				if strings.HasPrefix(strings.ToLower(key), strings.ToLower(prefix)) {
+					delete(header, key)
+				}
+			}
+		} else {
+			header.Del(ts.Name)
+		}
+	case TransformSetHeader:
+		// an empty string is not stored in dynamodb, so nil means an empty value
+		value, _ := ts.Value.(string)
+		header.Set(ts.Name, value)
+	case TransformSetJSONField, TransformRemoveJSONField:
+		if !isJSON(header) {
+			return nil
+		}
+		return ts.applyJSON(body)
+	case TransformFormToJSON:
+		if !isForm(header) {
+			return nil
+		}
+		data, err := body.bytes()
+		if err != nil {
+			return err
+		}
+		form, err := url.ParseQuery(string(data))
+		if err != nil {
+			return fmt.Errorf("parse form body failed: %w", err)
+		}
+		obj := make(map[string]interface{}, len(form))
+		for k, v := range form {
+			if len(v) == 1 {
+				obj[k] = v[0]
+			} else {
+				obj[k] = v
+			}
+		}
+		converted, err := json.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("marshal form body failed: %w", err)
+		}
+		body.set(converted)
+		header.Set("Content-Type", "application/json")
+	default:
+		return fmt.Errorf("unsupported transform type: %s", ts.Type)
+	}
+	return nil
+}
+
+func (ts TransformStep) applyJSON(body *transformBody) error {
+	data, err := body.bytes()
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	var obj map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// numbers are kept as they are written, so that the fields not transformed are not changed
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err != nil {
+		return fmt.Errorf("body is not a json object: %w", err)
+	}
+	if obj == nil {
+		return errors.New("body is not a json object")
+	}
+
+	names := strings.Split(ts.Name, ".")
+	parent := obj
+	for _, name := range names[:len(names)-1] {
+		child, ok := parent[name].(map[string]interface{})
+		if !ok {
+			if ts.Type == TransformRemoveJSONField {
+				return nil
+			}
+			child = make(map[string]interface{})
+			parent[name] = child
+		}
+		parent = child
+	}
+	last := names[len(names)-1]
+	if ts.Type == TransformSetJSONField {
+		parent[last] = ts.Value
+	} else {
+		if _, ok := parent[last]; !ok {
+			return nil
+		}
+		delete(parent, last)
+	}
+
+	converted, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("marshal json body failed: %w", err)
+	}
+	body.set(converted)
+	return nil
+}
+
+func isJSON(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+func isForm(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
@@ -0,0 +1,203 @@
+package model
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTransform_ApplyToRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		transform   *Transform
+		contentType string
+		body        string
+		header      http.Header
+		wantBody    string
+		wantHeader  http.Header
+		wantErr     bool
+	}{
+		{
+			name:        "nil transform does nothing",
+			contentType: "application/json",
+			body:        `{"a":1}`,
+			wantBody:    `{"a":1}`,
+			wantHeader:  http.Header{"Content-Type": {"application/json"}},
+		},
+		{
+			name: "headers are renamed, removed and set",
+			transform: &Transform{
+				Request: []TransformStep{
+					{Type: TransformRenameHeader, Name: "x-old", To: "X-New"},
+					{Type: TransformRemoveHeader, Name: "X-Debug-*"},
+					{Type: TransformSetHeader, Name: "X-Version", Value: "2"},
+				},
+			},
+			contentType: "text/plain",
+			body:        "text",
+			header: http.Header{
+				"X-Old":         {"value"},
+				"X-Debug-Trace": {"1"},
+				"X-Debug-Level": {"2"},
+			},
+			wantBody: "text",
+			wantHeader: http.Header{
+				"Content-Type": {"text/plain"},
+				"X-New":        {"value"},
+				"X-Version":    {"2"},
+			},
+		},
+		{
+			name: "json fields are set and removed",
+			transform: &Transform{
+				Request: []TransformStep{
+					{Type: TransformSetJSONField, Name: "meta.version", Value: float64(2)},
+					{Type: TransformRemoveJSONField, Name: "secret"},
+					{Type: TransformRemoveJSONField, Name: "missing.field"},
+				},
+			},
+			contentType: "application/json; charset=utf-8",
+			body:        `{"name":"foo","secret":"s"}`,
+			wantBody:    `{"meta":{"version":2},"name":"foo"}`,
+			wantHeader:  http.Header{"Content-Type": {"application/json; charset=utf-8"}},
+		},
+		{
+			name: "integers not transformed keep their precision",
+			transform: &Transform{
+				Request: []TransformStep{
+					{Type: TransformRemoveJSONField, Name: "secret"},
+				},
+			},
+			contentType: "application/json",
+			body:        `{"id":9007199254740993,"price":1.50,"secret":"s"}`,
+			wantBody:    `{"id":9007199254740993,"price":1.50}`,
+			wantHeader:  http.Header{"Content-Type": {"application/json"}},
+		},
+		{
+			name: "json body is null",
+			transform: &Transform{
+				Request: []TransformStep{
+					{Type: TransformSetJSONField, Name: "name", Value: "bar"},
+				},
+			},
+			contentType: "application/json",
+			body:        `null`,
+			wantErr:     true,
+		},
+		{
+			name: "json steps are skipped if the body is not json",
+			transform: &Transform{
+				Request: []TransformStep{
+					{Type: TransformSetJSONField, Name: "name", Value: "bar"},
+				},
+			},
+			contentType: "text/plain",
+			body:        "name=foo",
+			wantBody:    "name=foo",
+			wantHeader:  http.Header{"Content-Type": {"text/plain"}},
+		},
+		{
+			name: "form body is converted into json",
+			transform: &Transform{
+				Request: []TransformStep{
+					{Type: TransformFormToJSON},
+					{Type: TransformSetJSONField, Name: "source", Value: "legacy"},
+				},
+			},
+			contentType: "application/x-www-form-urlencoded",
+			body:        "name=foo&tag=a&tag=b",
+			wantBody:    `{"name":"foo","source":"legacy","tag":["a","b"]}`,
+			wantHeader:  http.Header{"Content-Type": {"application/json"}},
+		},
+		{
+			name: "json body is not an object",
+			transform: &Transform{
+				Request: []TransformStep{
+					{Type: TransformRemoveJSONField, Name: "name"},
+				},
+			},
+			contentType: "application/json",
+			body:        `["foo"]`,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodPost, "http://localhost/test", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			r.Header.Set("Content-Type", tt.contentType)
+
+			err = tt.transform.ApplyToRequest(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("error is expected, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("wrong body: got %s, want %s", body, tt.wantBody)
+			}
+			if r.ContentLength != int64(len(tt.wantBody)) {
+				t.Errorf("wrong content length: got %d, want %d", r.ContentLength, len(tt.wantBody))
+			}
+			if len(r.Header) != len(tt.wantHeader) {
+				t.Errorf("wrong headers: got %v, want %v", r.Header, tt.wantHeader)
+			}
+			for k := range tt.wantHeader {
+				if got, want := r.Header.Get(k), tt.wantHeader.Get(k); got != want {
+					t.Errorf("wrong header %s: got %s, want %s", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTransform_ApplyToResponse(t *testing.T) {
+	transform := &Transform{
+		Response: []TransformStep{
+			{Type: TransformRemoveHeader, Name: "X-Internal-*"},
+			{Type: TransformRemoveJSONField, Name: "data.internal_id"},
+		},
+	}
+	res := &http.Response{
+		Header: http.Header{
+			"Content-Type":     {"application/json"},
+			"Content-Length":   {"42"},
+			"X-Internal-Trace": {"trace"},
+		},
+		Body:          io.NopCloser(strings.NewReader(`{"data":{"id":1,"internal_id":2}}`)),
+		ContentLength: 42,
+	}
+
+	if err := transform.ApplyToResponse(res); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"data":{"id":1}}`; string(body) != want {
+		t.Errorf("wrong body: got %s, want %s", body, want)
+	}
+	if got := res.Header.Get("Content-Length"); got != "17" || res.ContentLength != 17 {
+		t.Errorf("wrong content length: header %s, field %d", got, res.ContentLength)
+	}
+	if got := res.Header.Get("X-Internal-Trace"); got != "" {
+		t.Errorf("internal header is not removed: got %s", got)
+	}
+}
@@ -0,0 +1,138 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/future-architect/apidoor/gateway/model"
+)
+
+var (
+	// DefaultTokenTTL is how long a token is used when the token endpoint does not return expires_in
+	DefaultTokenTTL = 5 * time.Minute
+	// RefreshMargin is how long before the expiry a token is refreshed, so that it does not expire in flight
+	RefreshMargin = 30 * time.Second
+)
+
+// ClientCredentials fetches access tokens with the OAuth2 client credentials grant, RFC 6749 section 4.4,
+// and caches them until they are about to expire. it is safe for concurrent use
+type ClientCredentials struct {
+	client *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]*cachedToken
+}
+
+// cachedToken has its own lock, so that concurrent requests with the same credentials fetch a token only once
+type cachedToken struct {
+	mu        sync.Mutex
+	value     string
+	expiresAt time.Time
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func NewClientCredentials(client *http.Client) *ClientCredentials {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &ClientCredentials{
+		client: client,
+		tokens: make(map[string]*cachedToken),
+	}
+}
+
+// Token returns the cached token of the credentials, or fetches a new one if it is about to expire.
+// when fetching fails, the cached token is returned as long as it has not expired
+func (cc *ClientCredentials) Token(ctx context.Context, at model.AccessToken) (string, error) {
+	entry := cc.entry(at)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	now := flextime.Now()
+	if entry.value != "" && now.Before(entry.expiresAt.Add(-RefreshMargin)) {
+		return entry.value, nil
+	}
+
+	token, ttl, err := cc.fetch(ctx, at)
+	if err != nil {
+		if entry.value != "" && now.Before(entry.expiresAt) {
+			return entry.value, nil
+		}
+		return "", err
+	}
+	entry.value, entry.expiresAt = token, now.Add(ttl)
+	return token, nil
+}
+
+func (cc *ClientCredentials) entry(at model.AccessToken) *cachedToken {
+	scopes := append([]string(nil), at.Scopes...)
+	sort.Strings(scopes)
+	key := strings.Join([]string{at.TokenURL, at.ClientID, at.ClientSecret, strings.Join(scopes, " ")}, "\n")
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	entry, ok := cc.tokens[key]
+	if !ok {
+		entry = &cachedToken{}
+		cc.tokens[key] = entry
+	}
+	return entry
+}
+
+func (cc *ClientCredentials) fetch(ctx context.Context, at model.AccessToken) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(at.Scopes) > 0 {
+		form.Set("scope", strings.Join(at.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, at.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("create token request failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client_secret_basic, the client id and secret are form encoded before the basic authentication, RFC 6749 section 2.3.1
+	req.SetBasicAuth(url.QueryEscape(at.ClientID), url.QueryEscape(at.ClientSecret))
+
+	res, err := cc.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("token request failed: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", 0, fmt.Errorf("read token response failed: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint returned status %d: %s", res.StatusCode, body)
+	}
+
+	var token tokenResponse
+	if err = json.Unmarshal(body, &token); err != nil {
+		return "", 0, fmt.Errorf("parse token response failed: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", 0, fmt.Errorf("token response has no access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported token type: %s", token.TokenType)
+	}
+	ttl := DefaultTokenTTL
+	if token.ExpiresIn > 0 {
+		ttl = time.Duration(token.ExpiresIn) * time.Second
+	}
+	return token.AccessToken, ttl, nil
+}
@@ -0,0 +1,159 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/future-architect/apidoor/gateway/model"
+)
+
+// tokenServer is a stand-in of an OAuth2 token endpoint, which issues a numbered token on each request
+type tokenServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	calls     int
+	expiresIn int
+	status    int
+	gotScope  string
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	ts := &tokenServer{expiresIn: expiresIn, status: http.StatusOK}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client%3A1" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"unsupported_grant_type"}`))
+			return
+		}
+		ts.gotScope = r.PostForm.Get("scope")
+		if ts.status != http.StatusOK {
+			w.WriteHeader(ts.status)
+			return
+		}
+		ts.calls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token%d","token_type":"Bearer","expires_in":%d}`, ts.calls, ts.expiresIn)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestClientCredentials_Token(t *testing.T) {
+	now := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	restore := flextime.Fix(now)
+	defer restore()
+
+	ts := newTokenServer(t, 3600)
+	at := model.AccessToken{
+		ParamType:    model.OAuth2ClientCredentials,
+		ClientID:     "client:1",
+		ClientSecret: "secret",
+		TokenURL:     ts.URL,
+		Scopes:       []string{"read", "write"},
+	}
+	cc := NewClientCredentials(ts.Client())
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		status    int
+		want      string
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "token is fetched",
+			now:       now,
+			want:      "token1",
+			wantCalls: 1,
+		},
+		{
+			name:      "cached token is used",
+			now:       now.Add(30 * time.Minute),
+			want:      "token1",
+			wantCalls: 1,
+		},
+		{
+			name:      "token about to expire is refreshed",
+			now:       now.Add(time.Hour - 10*time.Second),
+			want:      "token2",
+			wantCalls: 2,
+		},
+		{
+			name:      "cached token is used if refreshing fails before the expiry",
+			now:       now.Add(2*time.Hour - 20*time.Second),
+			status:    http.StatusInternalServerError,
+			want:      "token2",
+			wantCalls: 2,
+		},
+		{
+			name:      "error is returned if the token has expired",
+			now:       now.Add(3 * time.Hour),
+			status:    http.StatusInternalServerError,
+			wantCalls: 2,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flextime.Fix(tt.now)
+			ts.status = http.StatusOK
+			if tt.status != 0 {
+				ts.status = tt.status
+			}
+
+			got, err := cc.Token(context.Background(), at)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("error is expected, but got token %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("wrong token: got %s, want %s", got, tt.want)
+			}
+			if ts.calls != tt.wantCalls {
+				t.Errorf("wrong number of token requests: got %d, want %d", ts.calls, tt.wantCalls)
+			}
+			if ts.gotScope != "read write" {
+				t.Errorf("wrong scope: got %s", ts.gotScope)
+			}
+		})
+	}
+}
+
+func TestClientCredentials_TokenError(t *testing.T) {
+	ts := newTokenServer(t, 0)
+	cc := NewClientCredentials(ts.Client())
+
+	_, err := cc.Token(context.Background(), model.AccessToken{
+		ParamType:    model.OAuth2ClientCredentials,
+		ClientID:     "client:1",
+		ClientSecret: "wrong",
+		TokenURL:     ts.URL,
+	})
+	if err == nil {
+		t.Fatal("error is expected for invalid client")
+	}
+	if want := `token endpoint returned status 401: {"error":"invalid_client"}`; err.Error() != want {
+		t.Errorf("wrong error: got %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,194 @@
+package logtail
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFingerprintLine is the max length of the first line read as the fingerprint
+const maxFingerprintLine = 64 * 1024
+
+// rotatedTimeLayout is the time format in the name of the files rotated by the gateway
+const rotatedTimeLayout = "20060102T150405"
+
+// ErrRotatedNotFound is returned if a rotated file between the processed file and the current file is not found
+var ErrRotatedNotFound = errors.New("rotated log file is not found")
+
+// logReader reads a log file, which is decompressed if it is a rotated file compressed by gzip
+type logReader struct {
+	io.Reader
+	file *os.File
+}
+
+func openLog(path string) (*logReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return &logReader{Reader: f, file: f}, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &logReader{Reader: gz, file: f}, nil
+}
+
+// skip moves to the offset of the uncompressed content
+func (lr *logReader) skip(offset int64) error {
+	if lr.Reader == lr.file {
+		_, err := lr.file.Seek(offset, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, lr.Reader, offset)
+	return err
+}
+
+func (lr *logReader) Close() error {
+	return lr.file.Close()
+}
+
+// fingerprint identifies a log file by the hash of its first line, which does not change while lines are appended.
+// It returns an empty string if the file has no complete line yet.
+func fingerprint(path string) (string, error) {
+	lr, err := openLog(path)
+	if err != nil {
+		return "", err
+	}
+	defer lr.Close()
+
+	line, err := bufio.NewReader(io.LimitReader(lr, maxFingerprintLine)).ReadBytes('\n')
+	if errors.Is(err, io.EOF) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// rotatedFile is a file rotated from the log file
+type rotatedFile struct {
+	path string
+	// t and seq order the files rotated by the gateway, <name>-<time>[.<seq>]<ext>
+	t   time.Time
+	seq int
+	// num orders the files rotated by logrotate, <name><ext>.<num>, whose larger number is older
+	num int
+}
+
+func (f rotatedFile) before(g rotatedFile) bool {
+	if !f.t.Equal(g.t) {
+		return f.t.Before(g.t)
+	}
+	if f.num != g.num {
+		return f.num > g.num
+	}
+	return f.seq < g.seq
+}
+
+// rotatedFiles returns the files rotated from the log path in rotation order, the oldest first.
+// Rotated files are looked up in the same directory by the naming of the gateway, <name>-<time>[.<seq>]<ext>,
+// and by the naming of logrotate, <name><ext>.<num>, both of which may be compressed by gzip.
+// If a file is being compressed, the uncompressed one is used.
+func rotatedFiles(path string) ([]rotatedFile, error) {
+	dir, base := filepath.Split(path)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]rotatedFile)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".gz")
+		if prev, ok := found[name]; ok && !strings.HasSuffix(prev.path, ".gz") {
+			continue
+		}
+		file := rotatedFile{path: filepath.Join(dir, entry.Name())}
+		switch {
+		case strings.HasPrefix(name, base+"."):
+			n, err := strconv.Atoi(strings.TrimPrefix(name, base+"."))
+			if err != nil || n <= 0 {
+				continue
+			}
+			file.num = n
+		case strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ext):
+			stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+			if i := strings.IndexByte(stamp, '.'); i >= 0 {
+				n, err := strconv.Atoi(stamp[i+1:])
+				if err != nil {
+					continue
+				}
+				stamp, file.seq = stamp[:i], n
+			}
+			if file.t, err = time.Parse(rotatedTimeLayout, stamp); err != nil {
+				continue
+			}
+		default:
+			continue
+		}
+		found[name] = file
+	}
+
+	rotated := make([]rotatedFile, 0, len(found))
+	for _, file := range found {
+		rotated = append(rotated, file)
+	}
+	sort.Slice(rotated, func(i, j int) bool { return rotated[i].before(rotated[j]) })
+	return rotated, nil
+}
+
+// rotatedChain returns the rotated files from the file whose fingerprint is fp to the newest one in rotation order.
+// It fails with ErrRotatedNotFound if the file of fp is not found, or some files rotated after it are missing.
+// Rotated files without a complete line are left out, since they have no line to read.
+func rotatedChain(path, fp string) ([]string, error) {
+	rotated, err := rotatedFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the files are checked from the newest one, which is likely to be the processed file
+	var chain []string
+	matched := false
+	nextNum := 1
+	for i := len(rotated) - 1; i >= 0 && !matched; i-- {
+		file := rotated[i]
+		// the numbers of the files rotated by logrotate are continuous from 1 to the processed one
+		if file.num > 0 {
+			if file.num != nextNum {
+				return nil, fmt.Errorf("%w: %s.%d", ErrRotatedNotFound, path, nextNum)
+			}
+			nextNum++
+		}
+		got, err := fingerprint(file.path)
+		if err != nil {
+			return nil, fmt.Errorf("fingerprint of rotated log file %s: %w", file.path, err)
+		}
+		matched = got == fp
+		if got != "" {
+			chain = append([]string{file.path}, chain...)
+		}
+	}
+	if !matched {
+		return nil, fmt.Errorf("%w: the file processed before, fingerprint %s", ErrRotatedNotFound, fp)
+	}
+	return chain, nil
+}
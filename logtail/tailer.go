@@ -0,0 +1,164 @@
+// Package logtail reads the lines appended to a log file exactly once, following its rotation.
+package logtail
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+const defaultBatchSize = 1000
+
+// Position is the offset of the lines read from the log file identified by the fingerprint
+type Position struct {
+	Fingerprint string
+	Offset      int64
+}
+
+// Line is a complete line of a log file without the line break
+type Line struct {
+	Path string
+	// Offset is the offset of the line in the uncompressed file
+	Offset  int64
+	Content []byte
+}
+
+// Store stores the position of the log file with the lines read before it
+type Store interface {
+	// Load returns the stored position, which is the zero value if no line is read yet
+	Load(ctx context.Context) (Position, error)
+	// Commit stores the lines and moves the position from prev to next atomically, and returns the number of the
+	// stored lines. It must fail if the stored position is not prev, e.g. it is moved by another reader.
+	Commit(ctx context.Context, prev, next Position, lines []Line) (int, error)
+}
+
+// Tailer commits the complete lines appended to the log file to the store in batches.
+// If the log file is rotated, the rest of the file read before and the files rotated after it are read in rotation
+// order before the current file.
+type Tailer struct {
+	Path  string
+	Store Store
+	// BatchSize is the number of lines committed at once, 1000 if it is 0
+	BatchSize int
+}
+
+// ReadOnce commits the complete lines after the stored position, and returns the number of the stored lines.
+// It fails with ErrRotatedNotFound if the file read before or a file rotated after it is not found.
+func (t Tailer) ReadOnce(ctx context.Context) (int, error) {
+	fp, err := fingerprint(t.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if fp == "" {
+		return 0, nil
+	}
+
+	pos, err := t.Store.Load(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	stored := 0
+	if pos.Fingerprint != fp {
+		var chain []string
+		if pos.Fingerprint != "" {
+			if chain, err = rotatedChain(t.Path, pos.Fingerprint); err != nil {
+				return 0, err
+			}
+		}
+		for i, path := range chain {
+			n, last, err := t.readFile(ctx, path, pos)
+			stored += n
+			if err != nil {
+				return stored, err
+			}
+			// the next file is read from the beginning
+			next := Position{Fingerprint: fp}
+			if i+1 < len(chain) {
+				if next.Fingerprint, err = fingerprint(chain[i+1]); err != nil {
+					return stored, err
+				}
+			}
+			if _, err := t.Store.Commit(ctx, last, next, nil); err != nil {
+				return stored, err
+			}
+			pos = next
+		}
+		if pos.Fingerprint != fp {
+			next := Position{Fingerprint: fp}
+			if _, err := t.Store.Commit(ctx, pos, next, nil); err != nil {
+				return stored, err
+			}
+			pos = next
+		}
+	} else if info, err := os.Stat(t.Path); err != nil {
+		return stored, err
+	} else if info.Size() < pos.Offset {
+		log.Printf("[WARN] the log file %s is truncated to %d bytes, which is read from the beginning", t.Path, info.Size())
+		next := Position{Fingerprint: fp}
+		if _, err := t.Store.Commit(ctx, pos, next, nil); err != nil {
+			return stored, err
+		}
+		pos = next
+	}
+
+	n, _, err := t.readFile(ctx, t.Path, pos)
+	return stored + n, err
+}
+
+// readFile commits the complete lines of the file after the offset of the position in batches,
+// and returns the position of the last committed batch
+func (t Tailer) readFile(ctx context.Context, path string, pos Position) (int, Position, error) {
+	lr, err := openLog(path)
+	if err != nil {
+		return 0, pos, err
+	}
+	defer lr.Close()
+	if err := lr.skip(pos.Offset); err != nil {
+		return 0, pos, err
+	}
+
+	size := t.BatchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	reader := bufio.NewReader(lr)
+	stored := 0
+	for {
+		next := pos
+		var lines []Line
+		read := 0
+		for read < size {
+			line, err := reader.ReadBytes('\n')
+			if errors.Is(err, io.EOF) {
+				// an incomplete line is read after it is completed
+				break
+			} else if err != nil {
+				return stored, pos, err
+			}
+			read++
+			offset := next.Offset
+			next.Offset += int64(len(line))
+			content := strings.TrimRight(string(line), "\r\n")
+			if content == "" {
+				continue
+			}
+			lines = append(lines, Line{Path: path, Offset: offset, Content: []byte(content)})
+		}
+		if read == 0 {
+			return stored, pos, nil
+		}
+		n, err := t.Store.Commit(ctx, pos, next, lines)
+		if err != nil {
+			return stored, pos, err
+		}
+		stored += n
+		pos = next
+	}
+}
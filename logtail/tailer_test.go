@@ -0,0 +1,172 @@
+package logtail_test
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/future-architect/apidoor/logtail"
+)
+
+// memStore keeps the position and the committed lines in memory
+type memStore struct {
+	pos   logtail.Position
+	lines []string
+}
+
+func (m *memStore) Load(context.Context) (logtail.Position, error) {
+	return m.pos, nil
+}
+
+func (m *memStore) Commit(_ context.Context, prev, next logtail.Position, lines []logtail.Line) (int, error) {
+	if m.pos != prev {
+		return 0, fmt.Errorf("position conflict: stored %v, prev %v", m.pos, prev)
+	}
+	for _, line := range lines {
+		m.lines = append(m.lines, string(line.Content))
+	}
+	m.pos = next
+	return len(lines), nil
+}
+
+func appendLog(t *testing.T, path string, lines ...int) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, i := range lines {
+		if _, err := fmt.Fprintf(f, "line%d\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// rotate moves the log file to the rotated path, which is compressed if it ends with .gz
+func rotate(t *testing.T, path, rotated string) {
+	t.Helper()
+	if filepath.Ext(rotated) != ".gz" {
+		if err := os.Rename(path, rotated); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write(content)
+	gz.Close()
+	f.Close()
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTailer_ReadOnce(t *testing.T) {
+	read := func(t *testing.T, tailer logtail.Tailer, want int) {
+		t.Helper()
+		n, err := tailer.ReadOnce(context.Background())
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		if n != want {
+			t.Errorf("unexpected read lines %d, expected %d", n, want)
+		}
+	}
+
+	t.Run("files rotated by the gateway between reads are read in rotation order", func(t *testing.T) {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "log.csv")
+		store := &memStore{}
+		tailer := logtail.Tailer{Path: logPath, Store: store, BatchSize: 2}
+
+		appendLog(t, logPath, 0, 1, 2)
+		read(t, tailer, 3)
+		appendLog(t, logPath, 3)
+		rotate(t, logPath, filepath.Join(dir, "log-20220301T100000.csv.gz"))
+		appendLog(t, logPath, 4, 5)
+		rotate(t, logPath, filepath.Join(dir, "log-20220301T110000.csv.gz"))
+		appendLog(t, logPath, 6)
+		rotate(t, logPath, filepath.Join(dir, "log-20220301T110000.1.csv"))
+		appendLog(t, logPath, 7)
+		read(t, tailer, 5)
+		read(t, tailer, 0)
+
+		if got, want := fmt.Sprint(store.lines), "[line0 line1 line2 line3 line4 line5 line6 line7]"; got != want {
+			t.Errorf("unexpected read lines %s, expected %s", got, want)
+		}
+	})
+
+	t.Run("files rotated by logrotate between reads are read in rotation order", func(t *testing.T) {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "log.csv")
+		store := &memStore{}
+		tailer := logtail.Tailer{Path: logPath, Store: store}
+
+		appendLog(t, logPath, 0)
+		read(t, tailer, 1)
+		appendLog(t, logPath, 1)
+		rotate(t, logPath, logPath+".1")
+		appendLog(t, logPath, 2)
+		rotate(t, logPath+".1", logPath+".2.gz")
+		rotate(t, logPath, logPath+".1")
+		appendLog(t, logPath, 3)
+		read(t, tailer, 3)
+
+		if got, want := fmt.Sprint(store.lines), "[line0 line1 line2 line3]"; got != want {
+			t.Errorf("unexpected read lines %s, expected %s", got, want)
+		}
+	})
+
+	t.Run("a missing file rotated after the read file is an error", func(t *testing.T) {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "log.csv")
+		store := &memStore{}
+		tailer := logtail.Tailer{Path: logPath, Store: store}
+
+		appendLog(t, logPath, 0)
+		read(t, tailer, 1)
+		rotate(t, logPath, logPath+".1")
+		appendLog(t, logPath, 1)
+		rotate(t, logPath+".1", logPath+".3")
+		rotate(t, logPath, logPath+".1")
+		appendLog(t, logPath, 2)
+
+		pos := store.pos
+		if _, err := tailer.ReadOnce(context.Background()); !errors.Is(err, logtail.ErrRotatedNotFound) {
+			t.Errorf("unexpected error %v, expected %v", err, logtail.ErrRotatedNotFound)
+		}
+		if store.pos != pos {
+			t.Errorf("position is moved to %v, expected %v", store.pos, pos)
+		}
+	})
+
+	t.Run("a missing file read before is an error", func(t *testing.T) {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "log.csv")
+		store := &memStore{}
+		tailer := logtail.Tailer{Path: logPath, Store: store}
+
+		appendLog(t, logPath, 0)
+		read(t, tailer, 1)
+		if err := os.Remove(logPath); err != nil {
+			t.Fatal(err)
+		}
+		appendLog(t, logPath, 1)
+
+		if _, err := tailer.ReadOnce(context.Background()); !errors.Is(err, logtail.ErrRotatedNotFound) {
+			t.Errorf("unexpected error %v, expected %v", err, logtail.ErrRotatedNotFound)
+		}
+	})
+}
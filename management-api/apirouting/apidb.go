@@ -6,7 +6,6 @@ import (
 	"github.com/future-architect/apidoor/managementapi/apirouting/dynamo"
 	"github.com/future-architect/apidoor/managementapi/apirouting/redis"
 	"github.com/future-architect/apidoor/managementapi/model"
-	swaggerparser "github.com/future-architect/apidoor/managementapi/swagger-parser"
 	"log"
 	"os"
 )
@@ -22,13 +21,24 @@ func init() {
 }
 
 type APIDB interface {
-	PostRouting(ctx context.Context, apiKey, path, forwardURL string) error
-	BatchPostRouting(ctx context.Context, items []model.Routing) (int, error)
+	PostRouting(ctx context.Context, routing model.Routing) error
+	// BatchPostRouting and BatchDeleteRouting return an error if some routings fail,
+	// and the result contains the failed routings even in that case
+	BatchPostRouting(ctx context.Context, items []model.Routing) (model.BatchRoutingResult, error)
+	BatchDeleteRouting(ctx context.Context, items []model.Routing) (model.BatchRoutingResult, error)
+	// GetRoutings returns the routings of the key sorted by path
+	GetRoutings(ctx context.Context, apiKey string) ([]model.Routing, error)
+	// DeleteRouting returns false if the routing does not exist
+	DeleteRouting(ctx context.Context, apiKey, path string) (bool, error)
+	// DeleteRoutingsByContract deletes all routings linked to the contract, whichever key they belong to
+	DeleteRoutingsByContract(ctx context.Context, contractID int) (model.BatchRoutingResult, error)
 	PostAPIToken(ctx context.Context, req model.PostAPITokenReq) error
 	DeleteAPIToken(ctx context.Context, req model.DeleteAPITokenReq) error
+	// ReencryptAPITokens encrypts stored tokens again with the current key, with dryRun it only counts the tokens to be written
+	ReencryptAPITokens(ctx context.Context, dryRun bool) (model.ReencryptResult, error)
 	CountRouting(ctx context.Context, apikey, path string) (int64, error)
-	PostSwagger(ctx context.Context, productID int, info *swaggerparser.Swagger) error
-	BatchGetSwagger(ctx context.Context, productIDs []int) ([]model.Swagger, error)
+	PostSwagger(ctx context.Context, swagger model.Swagger) error
+	BatchGetSwagger(ctx context.Context, productIDs []int) (model.BatchSwaggerResult, error)
 }
 
 func createDBDriver(dbType string) (APIDB, error) {
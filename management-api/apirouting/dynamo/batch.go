@@ -0,0 +1,256 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/guregu/dynamo"
+	"time"
+)
+
+const (
+	// maxBatchWriteItems is the limit of items in a BatchWriteItem request
+	maxBatchWriteItems = 25
+	// maxBatchGetKeys is the limit of keys in a BatchGetItem request
+	maxBatchGetKeys = 100
+
+	maxBatchAttempts = 5
+	batchBaseDelay   = 50 * time.Millisecond
+)
+
+// batchWriteRoutings writes routings in chunks of maxBatchWriteItems, and retries unprocessed items with backoff.
+// routings which are not written after maxBatchAttempts attempts are returned in the result as failed
+func (ar APIRouting) batchWriteRoutings(ctx context.Context, items []model.Routing, isDelete bool) (model.BatchRoutingResult, error) {
+	result := model.BatchRoutingResult{
+		Failed: make([]model.Routing, 0),
+	}
+	var lastErr error
+
+	// BatchWriteItem rejects a request which contains the same key twice, so the last one wins
+	items = uniqueRoutings(items)
+	for start := 0; start < len(items); start += maxBatchWriteItems {
+		end := start + maxBatchWriteItems
+		if end > len(items) {
+			end = len(items)
+		}
+		chunk := items[start:end]
+
+		byKey := make(map[string]model.Routing, len(chunk))
+		reqs := make([]*dynamodb.WriteRequest, 0, len(chunk))
+		for _, v := range chunk {
+			req, err := routingWriteRequest(v, isDelete)
+			if err != nil {
+				lastErr = err
+				result.Failed = append(result.Failed, v)
+				continue
+			}
+			byKey[routingKey(v.APIKey, v.Path)] = v
+			reqs = append(reqs, req)
+		}
+
+		unprocessed, err := ar.runBatchWrite(ctx, reqs)
+		if err != nil {
+			lastErr = err
+		}
+		result.Processed += len(reqs) - len(unprocessed)
+		for _, r := range unprocessed {
+			result.Failed = append(result.Failed, byKey[writeRequestKey(r)])
+		}
+	}
+
+	if len(result.Failed) > 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("unprocessed after %d attempts", maxBatchAttempts)
+		}
+		return result, fmt.Errorf("%d of %d routings failed: %w", len(result.Failed), len(items), lastErr)
+	}
+	return result, nil
+}
+
+// runBatchWrite returns write requests which are not processed
+func (ar APIRouting) runBatchWrite(ctx context.Context, reqs []*dynamodb.WriteRequest) ([]*dynamodb.WriteRequest, error) {
+	for attempt := 1; len(reqs) > 0; attempt++ {
+		res, err := ar.client.Client().BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{
+				ar.apiRoutingTable: reqs,
+			},
+		})
+		if err != nil && !canRetry(err) {
+			return reqs, err
+		}
+		if err == nil {
+			reqs = res.UnprocessedItems[ar.apiRoutingTable]
+			if len(reqs) == 0 {
+				return nil, nil
+			}
+		}
+		if attempt >= maxBatchAttempts {
+			return reqs, err
+		}
+		if err := aws.SleepWithContext(ctx, batchBackoff(attempt)); err != nil {
+			return reqs, err
+		}
+	}
+	return nil, nil
+}
+
+// batchGetSwaggers gets swagger info in chunks of maxBatchGetKeys, and retries unprocessed keys with backoff
+func (ar APIRouting) batchGetSwaggers(ctx context.Context, productIDs []int) (model.BatchSwaggerResult, error) {
+	result := model.BatchSwaggerResult{
+		Swaggers:          make([]model.Swagger, 0, len(productIDs)),
+		MissingProductIDs: make([]int, 0),
+	}
+
+	// BatchGetItem rejects a request which contains the same key twice
+	uniqueIDs := make([]int, 0, len(productIDs))
+	requested := make(map[int]struct{}, len(productIDs))
+	for _, id := range productIDs {
+		if _, ok := requested[id]; ok {
+			continue
+		}
+		requested[id] = struct{}{}
+		uniqueIDs = append(uniqueIDs, id)
+	}
+
+	found := make(map[int]struct{}, len(uniqueIDs))
+	for start := 0; start < len(uniqueIDs); start += maxBatchGetKeys {
+		end := start + maxBatchGetKeys
+		if end > len(uniqueIDs) {
+			end = len(uniqueIDs)
+		}
+
+		keys := make([]map[string]*dynamodb.AttributeValue, 0, end-start)
+		for _, id := range uniqueIDs[start:end] {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				"product_id": {N: aws.String(fmt.Sprint(id))},
+			})
+		}
+
+		items, err := ar.runBatchGet(ctx, ar.swaggerTable, keys)
+		if err != nil {
+			return result, err
+		}
+		for _, item := range items {
+			var swagger model.Swagger
+			if err := dynamo.UnmarshalItem(item, &swagger); err != nil {
+				return result, fmt.Errorf("unmarshal swagger: %w", err)
+			}
+			found[swagger.ProductID] = struct{}{}
+			result.Swaggers = append(result.Swaggers, swagger)
+		}
+	}
+
+	for _, id := range uniqueIDs {
+		if _, ok := found[id]; !ok {
+			result.MissingProductIDs = append(result.MissingProductIDs, id)
+		}
+	}
+	return result, nil
+}
+
+func (ar APIRouting) runBatchGet(ctx context.Context, table string, keys []map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, error) {
+	var items []map[string]*dynamodb.AttributeValue
+	for attempt := 1; len(keys) > 0; attempt++ {
+		res, err := ar.client.Client().BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*dynamodb.KeysAndAttributes{
+				table: {Keys: keys},
+			},
+		})
+		if err != nil && !canRetry(err) {
+			return nil, err
+		}
+		if err == nil {
+			items = append(items, res.Responses[table]...)
+			keys = nil
+			if unprocessed, ok := res.UnprocessedKeys[table]; ok && unprocessed != nil {
+				keys = unprocessed.Keys
+			}
+			if len(keys) == 0 {
+				return items, nil
+			}
+		}
+		if attempt >= maxBatchAttempts {
+			if err == nil {
+				err = fmt.Errorf("%d keys unprocessed after %d attempts", len(keys), maxBatchAttempts)
+			}
+			return nil, err
+		}
+		if err := aws.SleepWithContext(ctx, batchBackoff(attempt)); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+func uniqueRoutings(items []model.Routing) []model.Routing {
+	index := make(map[string]int, len(items))
+	ret := make([]model.Routing, 0, len(items))
+	for _, v := range items {
+		key := routingKey(v.APIKey, v.Path)
+		if i, ok := index[key]; ok {
+			ret[i] = v
+			continue
+		}
+		index[key] = len(ret)
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+func routingWriteRequest(v model.Routing, isDelete bool) (*dynamodb.WriteRequest, error) {
+	if isDelete {
+		return &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{
+				Key: map[string]*dynamodb.AttributeValue{
+					"api_key": {S: aws.String(v.APIKey)},
+					"path":    {S: aws.String(v.Path)},
+				},
+			},
+		}, nil
+	}
+	item, err := dynamo.MarshalItem(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal routing: %w", err)
+	}
+	return &dynamodb.WriteRequest{
+		PutRequest: &dynamodb.PutRequest{Item: item},
+	}, nil
+}
+
+func writeRequestKey(r *dynamodb.WriteRequest) string {
+	var key map[string]*dynamodb.AttributeValue
+	if r.PutRequest != nil {
+		key = r.PutRequest.Item
+	} else if r.DeleteRequest != nil {
+		key = r.DeleteRequest.Key
+	}
+	return routingKey(aws.StringValue(key["api_key"].S), aws.StringValue(key["path"].S))
+}
+
+func routingKey(apiKey, path string) string {
+	return apiKey + "#" + path
+}
+
+// batchBackoff returns the wait before the next attempt, doubling from batchBaseDelay
+func batchBackoff(attempt int) time.Duration {
+	return batchBaseDelay << uint(attempt-1)
+}
+
+// canRetry reports whether the request failed due to throttling or a temporary server error
+func canRetry(err error) bool {
+	if ae, ok := err.(awserr.RequestFailure); ok {
+		switch ae.StatusCode() {
+		case 500, 503:
+			return true
+		case 400:
+			switch ae.Code() {
+			case dynamodb.ErrCodeProvisionedThroughputExceededException, "ThrottlingException":
+				return true
+			}
+		}
+	}
+	return false
+}
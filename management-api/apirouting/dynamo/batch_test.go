@@ -0,0 +1,204 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/google/go-cmp/cmp"
+	"github.com/guregu/dynamo"
+	"sort"
+	"testing"
+)
+
+// fakeClient leaves the first unprocessedPerCall items of every request unprocessed,
+// until failCalls calls have been made
+type fakeClient struct {
+	dynamodbiface.DynamoDBAPI
+	unprocessedPerCall int
+	failCalls          int
+
+	calls        int
+	requestSizes []int
+	written      map[string]struct{}
+	swaggers     map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (fc *fakeClient) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	fc.calls++
+	reqs := input.RequestItems["api_routing"]
+	fc.requestSizes = append(fc.requestSizes, len(reqs))
+
+	var unprocessed []*dynamodb.WriteRequest
+	if fc.calls <= fc.failCalls && len(reqs) > fc.unprocessedPerCall {
+		unprocessed = reqs[:fc.unprocessedPerCall]
+		reqs = reqs[fc.unprocessedPerCall:]
+	} else if fc.calls <= fc.failCalls {
+		unprocessed = reqs
+		reqs = nil
+	}
+	for _, r := range reqs {
+		fc.written[writeRequestKey(r)] = struct{}{}
+	}
+
+	out := &dynamodb.BatchWriteItemOutput{}
+	if len(unprocessed) > 0 {
+		out.UnprocessedItems = map[string][]*dynamodb.WriteRequest{"api_routing": unprocessed}
+	}
+	return out, nil
+}
+
+func (fc *fakeClient) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	fc.calls++
+	keys := input.RequestItems["swagger"].Keys
+	fc.requestSizes = append(fc.requestSizes, len(keys))
+
+	out := &dynamodb.BatchGetItemOutput{
+		Responses: map[string][]map[string]*dynamodb.AttributeValue{},
+	}
+	for i, key := range keys {
+		if fc.calls <= fc.failCalls && i < fc.unprocessedPerCall {
+			if out.UnprocessedKeys == nil {
+				out.UnprocessedKeys = map[string]*dynamodb.KeysAndAttributes{"swagger": {}}
+			}
+			out.UnprocessedKeys["swagger"].Keys = append(out.UnprocessedKeys["swagger"].Keys, key)
+			continue
+		}
+		if item, ok := fc.swaggers[aws.StringValue(key["product_id"].N)]; ok {
+			out.Responses["swagger"] = append(out.Responses["swagger"], item)
+		}
+	}
+	return out, nil
+}
+
+func newTestRouting(client dynamodbiface.DynamoDBAPI) APIRouting {
+	return APIRouting{
+		client:          dynamo.NewFromIface(client),
+		apiRoutingTable: "api_routing",
+		swaggerTable:    "swagger",
+	}
+}
+
+func testRoutings(n int) []model.Routing {
+	ret := make([]model.Routing, n)
+	for i := range ret {
+		ret[i] = model.Routing{
+			APIKey:     "key",
+			Path:       fmt.Sprintf("/path/%d", i),
+			ForwardURL: fmt.Sprintf("https://example.com/%d", i),
+		}
+	}
+	return ret
+}
+
+func TestBatchPostRouting(t *testing.T) {
+	tests := []struct {
+		name          string
+		items         []model.Routing
+		client        *fakeClient
+		wantProcessed int
+		wantFailed    int
+		wantErr       bool
+		wantSizes     []int
+	}{
+		{
+			name:          "routings are split into chunks of 25",
+			items:         testRoutings(60),
+			client:        &fakeClient{},
+			wantProcessed: 60,
+			wantSizes:     []int{25, 25, 10},
+		},
+		{
+			name:          "unprocessed items are retried",
+			items:         testRoutings(30),
+			client:        &fakeClient{unprocessedPerCall: 5, failCalls: 2},
+			wantProcessed: 30,
+			// the first chunk is retried twice, and then the second chunk is sent
+			wantSizes: []int{25, 5, 5, 5},
+		},
+		{
+			name:          "items unprocessed after the retry limit are reported",
+			items:         testRoutings(10),
+			client:        &fakeClient{unprocessedPerCall: 3, failCalls: maxBatchAttempts},
+			wantProcessed: 7,
+			wantFailed:    3,
+			wantErr:       true,
+			wantSizes:     []int{10, 3, 3, 3, 3},
+		},
+		{
+			name:          "duplicate keys are merged",
+			items:         append(testRoutings(3), testRoutings(3)...),
+			client:        &fakeClient{},
+			wantProcessed: 3,
+			wantSizes:     []int{3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.client.written = make(map[string]struct{})
+			ar := newTestRouting(tt.client)
+
+			got, err := ar.BatchPostRouting(context.Background(), tt.items)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if got.Processed != tt.wantProcessed {
+				t.Errorf("wrong processed count: got %d, want %d", got.Processed, tt.wantProcessed)
+			}
+			if len(got.Failed) != tt.wantFailed {
+				t.Errorf("wrong failed count: got %d, want %d", len(got.Failed), tt.wantFailed)
+			}
+			for _, v := range got.Failed {
+				if _, ok := tt.client.written[routingKey(v.APIKey, v.Path)]; ok {
+					t.Errorf("written routing is reported as failed: %v", v)
+				}
+			}
+			if diff := cmp.Diff(tt.wantSizes, tt.client.requestSizes); diff != "" {
+				t.Errorf("request sizes differ:\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestBatchGetSwagger(t *testing.T) {
+	swaggers := make(map[string]map[string]*dynamodb.AttributeValue)
+	for i := 1; i <= 150; i++ {
+		if i%50 == 0 {
+			// swagger of products 50, 100, and 150 is missing
+			continue
+		}
+		item, err := dynamo.MarshalItem(model.Swagger{ProductID: i, PathBase: fmt.Sprintf("/p%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		swaggers[fmt.Sprint(i)] = item
+	}
+
+	ids := make([]int, 0, 151)
+	for i := 1; i <= 150; i++ {
+		ids = append(ids, i)
+	}
+	ids = append(ids, 1) // duplicate
+
+	client := &fakeClient{unprocessedPerCall: 10, failCalls: 1, swaggers: swaggers}
+	ar := newTestRouting(client)
+
+	got, err := ar.BatchGetSwagger(context.Background(), ids)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.Swaggers) != 147 {
+		t.Errorf("wrong number of swaggers: got %d, want %d", len(got.Swaggers), 147)
+	}
+	sort.Ints(got.MissingProductIDs)
+	if diff := cmp.Diff([]int{50, 100, 150}, got.MissingProductIDs); diff != "" {
+		t.Errorf("missing product ids differ:\n%v", diff)
+	}
+	if diff := cmp.Diff([]int{100, 10, 50}, client.requestSizes); diff != "" {
+		t.Errorf("request sizes differ:\n%v", diff)
+	}
+}
@@ -2,11 +2,13 @@ package dynamo
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/future-architect/apidoor/managementapi/apirouting/tokencrypt"
 	"github.com/future-architect/apidoor/managementapi/model"
-	swaggerparser "github.com/future-architect/apidoor/managementapi/swagger-parser"
+	"github.com/future-architect/apidoor/secret"
 	"github.com/guregu/dynamo"
 	"log"
 	"os"
@@ -17,6 +19,8 @@ type APIRouting struct {
 	apiRoutingTable  string
 	accessTokenTable string
 	swaggerTable     string
+	// envelope encrypts secrets of access tokens, which are stored in plaintext if it is nil
+	envelope *secret.Envelope
 }
 
 func New() *APIRouting {
@@ -34,6 +38,14 @@ func New() *APIRouting {
 		log.Fatal("missing DYNAMO_TABLE_SWAGGER env")
 	}
 
+	envelope, err := secret.NewRequiredEnvelopeFromEnv()
+	if err != nil {
+		log.Fatalf("load token encryption key failed: %v", err)
+	}
+	if envelope == nil {
+		log.Printf("%s is true, access tokens are stored in plaintext", secret.AllowPlaintextEnv)
+	}
+
 	var client *dynamo.DB
 
 	dbEndpoint := os.Getenv("DYNAMO_ENDPOINT")
@@ -52,27 +64,59 @@ func New() *APIRouting {
 		apiRoutingTable:  apiRoutingTable,
 		accessTokenTable: accessTokenTable,
 		swaggerTable:     swaggerTable,
+		envelope:         envelope,
 	}
 }
 
-// TODO: contractの追加
-func (ar APIRouting) PostRouting(ctx context.Context, apikey, path, forwardURL string) error {
-	routing := routing{
-		Apikey:     apikey,
-		Path:       path,
-		ForwardURL: forwardURL,
-	}
+func (ar APIRouting) PostRouting(ctx context.Context, routing model.Routing) error {
 	return ar.client.Table(ar.apiRoutingTable).
 		Put(routing).RunWithContext(ctx)
 }
 
-func (ar APIRouting) BatchPostRouting(ctx context.Context, items []model.Routing) (int, error) {
-	req := make([]interface{}, len(items))
-	for i, v := range items {
-		req[i] = v
+func (ar APIRouting) BatchPostRouting(ctx context.Context, items []model.Routing) (model.BatchRoutingResult, error) {
+	return ar.batchWriteRoutings(ctx, items, false)
+}
+
+func (ar APIRouting) BatchDeleteRouting(ctx context.Context, items []model.Routing) (model.BatchRoutingResult, error) {
+	return ar.batchWriteRoutings(ctx, items, true)
+}
+
+func (ar APIRouting) GetRoutings(ctx context.Context, apiKey string) ([]model.Routing, error) {
+	ret := make([]model.Routing, 0)
+	err := ar.client.Table(ar.apiRoutingTable).
+		Get("api_key", apiKey).AllWithContext(ctx, &ret)
+	if err == dynamo.ErrNotFound {
+		return ret, nil
 	}
-	return ar.client.Table(ar.apiRoutingTable).
-		Batch().Write().Put(req...).RunWithContext(ctx)
+	return ret, err
+}
+
+func (ar APIRouting) DeleteRouting(ctx context.Context, apiKey, path string) (bool, error) {
+	var old model.Routing
+	err := ar.client.Table(ar.apiRoutingTable).
+		Delete("api_key", apiKey).
+		Range("path", path).
+		OldValueWithContext(ctx, &old)
+	if err == dynamo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteRoutingsByContract scans the whole table because contract_id is not a key of the table
+func (ar APIRouting) DeleteRoutingsByContract(ctx context.Context, contractID int) (model.BatchRoutingResult, error) {
+	var routings []model.Routing
+	err := ar.client.Table(ar.apiRoutingTable).
+		Scan().
+		Filter("'contract_id' = ?", contractID).
+		AllWithContext(ctx, &routings)
+	if err != nil && err != dynamo.ErrNotFound {
+		return model.BatchRoutingResult{Failed: make([]model.Routing, 0)}, err
+	}
+	return ar.batchWriteRoutings(ctx, routings, true)
 }
 
 func (ar APIRouting) CountRouting(ctx context.Context, apikey, path string) (int64, error) {
@@ -84,6 +128,13 @@ func (ar APIRouting) CountRouting(ctx context.Context, apikey, path string) (int
 
 func (ar APIRouting) PostAPIToken(ctx context.Context, req model.PostAPITokenReq) error {
 	accessTokens := newAccessToken(req)
+	if ar.envelope != nil {
+		tokens, err := tokencrypt.Encrypt(ctx, ar.envelope, accessTokens.AccessTokens, false)
+		if err != nil {
+			return err
+		}
+		accessTokens.AccessTokens = tokens
+	}
 	return ar.client.Table(ar.accessTokenTable).
 		Put(accessTokens).RunWithContext(ctx)
 }
@@ -95,61 +146,54 @@ func (ar APIRouting) DeleteAPIToken(ctx context.Context, req model.DeleteAPIToke
 		RunWithContext(ctx)
 }
 
-func (ar APIRouting) PostSwagger(ctx context.Context, productID int, info *swaggerparser.Swagger) error {
-	swagger := newSwagger(productID, info)
-	return ar.client.Table(ar.swaggerTable).
-		Put(swagger).RunWithContext(ctx)
-}
-
-func (ar APIRouting) BatchGetSwagger(ctx context.Context, productIDs []int) ([]model.Swagger, error) {
-	ret := make([]model.Swagger, 0, len(productIDs))
-	req := make([]dynamo.Keyed, len(productIDs))
-	for i, v := range productIDs {
-		req[i] = dynamo.Keys{v, nil}
+// ReencryptAPITokens scans the access token table, and writes token sets encrypted with an old key or stored in plaintext
+// again with the current key. a token set written by PostAPIToken during the scan may be overwritten with the scanned one
+func (ar APIRouting) ReencryptAPITokens(ctx context.Context, dryRun bool) (model.ReencryptResult, error) {
+	result := model.ReencryptResult{
+		Failed: make([]string, 0),
 	}
-	err := ar.client.Table(ar.swaggerTable).Batch("product_id").
-		Get(req...).AllWithContext(ctx, &ret)
-	return ret, err
-}
-
-func newSwagger(productID int, info *swaggerparser.Swagger) swagger {
-	return swagger{
-		ProductID:      productID,
-		Schemes:        info.Schemes,
-		ForwardURLBase: info.ForwardURLBase,
-		PathBase:       info.PathBase,
-		APIList:        newAPIList(info.APIs),
+	if ar.envelope == nil {
+		return result, errors.New(secret.KeyFileEnv + " is not set")
 	}
-}
-
-type swagger struct {
-	ProductID      int      `dynamo:"product_id"`
-	Schemes        []string `dynamo:"schemes"`
-	ForwardURLBase string   `dynamo:"forward_url_base"`
-	PathBase       string   `dynamo:"path_base"`
-	APIList        []api    `dynamo:"api_list"`
-}
 
-type api struct {
-	ForwardURL string `dynamo:"forward_url"`
-	Path       string `dynamo:"path"`
-}
-
-func newAPIList(apis []swaggerparser.API) []api {
-	ret := make([]api, len(apis))
-	for i, v := range apis {
-		ret[i] = api{
-			ForwardURL: v.ForwardURL,
-			Path:       v.Path,
+	var items []accessTokens
+	err := ar.client.Table(ar.accessTokenTable).
+		Scan().
+		AllWithContext(ctx, &items)
+	if err != nil && err != dynamo.ErrNotFound {
+		return result, err
+	}
+	for _, item := range items {
+		result.Scanned++
+		if !tokencrypt.NeedsReencryption(ar.envelope, item.AccessTokens) {
+			continue
+		}
+		tokens, err := tokencrypt.Encrypt(ctx, ar.envelope, item.AccessTokens, true)
+		if err != nil {
+			log.Printf("re-encrypt access tokens of %s failed: %v", item.Key, err)
+			result.Failed = append(result.Failed, item.Key)
+			continue
+		}
+		if !dryRun {
+			item.AccessTokens = tokens
+			if err = ar.client.Table(ar.accessTokenTable).Put(item).RunWithContext(ctx); err != nil {
+				log.Printf("put re-encrypted access tokens of %s failed: %v", item.Key, err)
+				result.Failed = append(result.Failed, item.Key)
+				continue
+			}
 		}
+		result.Reencrypted++
 	}
-	return ret
+	return result, nil
+}
+
+func (ar APIRouting) PostSwagger(ctx context.Context, swagger model.Swagger) error {
+	return ar.client.Table(ar.swaggerTable).
+		Put(swagger).RunWithContext(ctx)
 }
 
-type routing struct {
-	Apikey     string `dynamo:"api_key"`
-	Path       string `dynamo:"path"`
-	ForwardURL string `dynamo:"forward_url"`
+func (ar APIRouting) BatchGetSwagger(ctx context.Context, productIDs []int) (model.BatchSwaggerResult, error) {
+	return ar.batchGetSwaggers(ctx, productIDs)
 }
 
 type accessTokens struct {
@@ -0,0 +1,266 @@
+package dynamo
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/secret"
+	"github.com/google/go-cmp/cmp"
+	"github.com/guregu/dynamo"
+	"strings"
+	"testing"
+)
+
+// routingClient stores routings in memory, and answers Scan and DeleteItem requests
+type routingClient struct {
+	fakeClient
+	routings map[string]map[string]*dynamodb.AttributeValue
+}
+
+func newRoutingClient(t *testing.T, routings []model.Routing) *routingClient {
+	t.Helper()
+	rc := &routingClient{
+		fakeClient: fakeClient{written: make(map[string]struct{})},
+		routings:   make(map[string]map[string]*dynamodb.AttributeValue),
+	}
+	for _, v := range routings {
+		item, err := dynamo.MarshalItem(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.routings[routingKey(v.APIKey, v.Path)] = item
+	}
+	return rc
+}
+
+// ScanWithContext supports only the filter by contract_id
+func (rc *routingClient) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	var want string
+	for _, v := range input.ExpressionAttributeValues {
+		want = aws.StringValue(v.N)
+	}
+	out := &dynamodb.ScanOutput{}
+	for _, item := range rc.routings {
+		if aws.StringValue(item["contract_id"].N) == want {
+			out.Items = append(out.Items, item)
+		}
+	}
+	out.Count = aws.Int64(int64(len(out.Items)))
+	return out, nil
+}
+
+func (rc *routingClient) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	key := routingKey(aws.StringValue(input.Key["api_key"].S), aws.StringValue(input.Key["path"].S))
+	out := &dynamodb.DeleteItemOutput{}
+	if item, ok := rc.routings[key]; ok {
+		out.Attributes = item
+		delete(rc.routings, key)
+	}
+	return out, nil
+}
+
+func (rc *routingClient) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	for _, r := range input.RequestItems["api_routing"] {
+		delete(rc.routings, writeRequestKey(r))
+	}
+	return rc.fakeClient.BatchWriteItemWithContext(ctx, input, opts...)
+}
+
+func TestDeleteRouting(t *testing.T) {
+	client := newRoutingClient(t, []model.Routing{
+		{APIKey: "key", Path: "/a", ForwardURL: "https://example.com/a", ContractID: 1},
+	})
+	ar := newTestRouting(client)
+
+	ok, err := ar.DeleteRouting(context.Background(), "key", "/a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Error("existing routing is reported as not found")
+	}
+	if len(client.routings) != 0 {
+		t.Errorf("routing is not deleted, got %v", client.routings)
+	}
+
+	ok, err = ar.DeleteRouting(context.Background(), "key", "/a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Error("deleted routing is reported as found")
+	}
+}
+
+func TestDeleteRoutingsByContract(t *testing.T) {
+	routings := append(testRoutings(30), model.Routing{
+		APIKey:     "other",
+		Path:       "/other",
+		ForwardURL: "https://example.com/other",
+		ContractID: 2,
+	})
+	for i := range routings[:30] {
+		routings[i].ContractID = 1
+	}
+	client := newRoutingClient(t, routings)
+	ar := newTestRouting(client)
+
+	got, err := ar.DeleteRoutingsByContract(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Processed != 30 {
+		t.Errorf("wrong processed count: got %d, want %d", got.Processed, 30)
+	}
+	if len(client.routings) != 1 {
+		t.Fatalf("wrong number of remaining routings: got %d, want %d", len(client.routings), 1)
+	}
+	if _, ok := client.routings[routingKey("other", "/other")]; !ok {
+		t.Error("routing of another contract is deleted")
+	}
+}
+
+// tokenClient stores access tokens in memory, and answers PutItem and Scan requests
+type tokenClient struct {
+	dynamodbiface.DynamoDBAPI
+	items map[string]map[string]*dynamodb.AttributeValue
+	puts  int
+}
+
+func (tc *tokenClient) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	tc.puts++
+	tc.items[aws.StringValue(input.Item["key"].S)] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (tc *tokenClient) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	out := &dynamodb.ScanOutput{}
+	for _, item := range tc.items {
+		out.Items = append(out.Items, item)
+	}
+	out.Count = aws.Int64(int64(len(out.Items)))
+	return out, nil
+}
+
+func (tc *tokenClient) tokens(t *testing.T, key string) []model.AccessToken {
+	t.Helper()
+	var item accessTokens
+	if err := dynamo.UnmarshalItem(tc.items[key], &item); err != nil {
+		t.Fatal(err)
+	}
+	return item.AccessTokens
+}
+
+func newTestEnvelope(t *testing.T, keys ...string) *secret.Envelope {
+	t.Helper()
+	lines := make([]string, len(keys))
+	for i, id := range keys {
+		lines[i] = id + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(id[:1], 32)))
+	}
+	keyFile, err := secret.ParseKeyFile([]byte(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret.NewEnvelope(keyFile)
+}
+
+func TestAPIToken_Encryption(t *testing.T) {
+	ctx := context.Background()
+	client := &tokenClient{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	ar := APIRouting{
+		client:           dynamo.NewFromIface(client),
+		accessTokenTable: "access_token",
+		envelope:         newTestEnvelope(t, "old"),
+	}
+
+	req := model.PostAPITokenReq{
+		APIKey: "key",
+		Path:   "/a",
+		AccessTokens: []model.AccessToken{
+			{ParamType: model.Header, Key: "X-Token", Value: "token-value"},
+			{ParamType: model.OAuth2ClientCredentials, ClientID: "id", ClientSecret: "client-secret",
+				TokenURL: "https://auth.example.com/token"},
+		},
+	}
+	if err := ar.PostAPIToken(ctx, req); err != nil {
+		t.Fatalf("post api token failed: %v", err)
+	}
+	if req.AccessTokens[0].Value != "token-value" {
+		t.Error("tokens of the request are modified")
+	}
+	stored := client.tokens(t, "key#/a")
+	if keyID, ok := secret.KeyID(stored[0].Value); !ok || keyID != "old" {
+		t.Errorf("value is not encrypted with the current key: %s", stored[0].Value)
+	}
+	if keyID, ok := secret.KeyID(stored[1].ClientSecret); !ok || keyID != "old" {
+		t.Errorf("client secret is not encrypted with the current key: %s", stored[1].ClientSecret)
+	}
+	if stored[0].Key != "X-Token" || stored[1].ClientID != "id" {
+		t.Errorf("fields other than secrets are modified: %v", stored)
+	}
+
+	// a token set stored in plaintext before encryption
+	plain, err := dynamo.MarshalItem(accessTokens{
+		Key:          "key#/b",
+		AccessTokens: []model.AccessToken{{ParamType: model.Query, Key: "token", Value: "plain-value"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.items["key#/b"] = plain
+
+	// rotate the key
+	ar.envelope = newTestEnvelope(t, "new", "old")
+	got, err := ar.ReencryptAPITokens(ctx, true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	want := model.ReencryptResult{Scanned: 2, Reencrypted: 2, Failed: []string{}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("dry run result differs:\n%s", diff)
+	}
+	if client.puts != 1 {
+		t.Errorf("dry run writes tokens")
+	}
+
+	if got, err = ar.ReencryptAPITokens(ctx, false); err != nil {
+		t.Fatalf("re-encrypt failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("re-encrypt result differs:\n%s", diff)
+	}
+	for key, plaintexts := range map[string][]string{"key#/a": {"token-value", "client-secret"}, "key#/b": {"plain-value"}} {
+		tokens := client.tokens(t, key)
+		var secrets []string
+		for _, v := range tokens {
+			for _, field := range []string{v.Value, v.ClientSecret} {
+				if field == "" {
+					continue
+				}
+				if keyID, ok := secret.KeyID(field); !ok || keyID != "new" {
+					t.Errorf("secret of %s is not encrypted with the new key: %s", key, field)
+				}
+				plaintext, err := ar.envelope.Decrypt(ctx, field)
+				if err != nil {
+					t.Fatalf("decrypt failed: %v", err)
+				}
+				secrets = append(secrets, plaintext)
+			}
+		}
+		if diff := cmp.Diff(plaintexts, secrets); diff != "" {
+			t.Errorf("secrets of %s differ:\n%s", key, diff)
+		}
+	}
+
+	// nothing is written again with the current key
+	if got, err = ar.ReencryptAPITokens(ctx, false); err != nil {
+		t.Fatalf("re-encrypt failed: %v", err)
+	}
+	if got.Reencrypted != 0 {
+		t.Errorf("tokens encrypted with the current key are re-encrypted: %d", got.Reencrypted)
+	}
+}
@@ -2,60 +2,388 @@ package redis
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/future-architect/apidoor/managementapi/apirouting/tokencrypt"
 	"github.com/future-architect/apidoor/managementapi/model"
-	swaggerparser "github.com/future-architect/apidoor/managementapi/swagger-parser"
+	"github.com/future-architect/apidoor/secret"
 	"github.com/go-redis/redis/v8"
+	"log"
 	"os"
+	"sort"
+	"strings"
 )
 
 type APIRouting struct {
 	client *redis.Client
+	// envelope encrypts secrets of access tokens, which are stored in plaintext if it is nil
+	envelope *secret.Envelope
 }
 
 func New() *APIRouting {
 	host := os.Getenv("REDIS_HOST")
 	port := os.Getenv("REDIS_PORT")
 	addr := fmt.Sprintf("%s:%s", host, port)
+
+	envelope, err := secret.NewRequiredEnvelopeFromEnv()
+	if err != nil {
+		log.Fatalf("load token encryption key failed: %v", err)
+	}
+	if envelope == nil {
+		log.Printf("%s is true, access tokens are stored in plaintext", secret.AllowPlaintextEnv)
+	}
+
 	return &APIRouting{
 		client: redis.NewClient(&redis.Options{
 			Addr:     addr,
 			Password: "",
 			DB:       0,
 		}),
+		envelope: envelope,
 	}
 }
 
-func (ar APIRouting) PostRouting(ctx context.Context, apikey, path, forwardURL string) error {
-	err := ar.client.HSet(ctx, apikey, path, forwardURL).Err()
-	return err
+// routings are stored in the hash named after the api key, whose fields are paths and values are forward urls.
+// the other attributes of each routing are kept apart as json in a hash of the same fields,
+// and each routing linked to a contract is a member of the set of the contract
+
+// routingMeta is the attributes of a routing other than the forward url
+type routingMeta struct {
+	ContractID     int                 `json:"contract_id,omitempty"`
+	APIKeyID       int                 `json:"apikey_id,omitempty"`
+	ProductID      int                 `json:"product_id,omitempty"`
+	ForwardHeaders map[string]string   `json:"forward_headers,omitempty"`
+	Transform      *model.Transform    `json:"transform,omitempty"`
+	Cache          *model.RoutingCache `json:"cache,omitempty"`
+	Operations     []model.Operation   `json:"operations,omitempty"`
+}
+
+func (rm routingMeta) isZero() bool {
+	return rm.ContractID == 0 && rm.APIKeyID == 0 && rm.ProductID == 0 && len(rm.ForwardHeaders) == 0 && rm.Transform == nil && rm.Cache == nil &&
+		len(rm.Operations) == 0
+}
+
+func metaHashKey(apiKey string) string {
+	return "routing_meta:" + apiKey
+}
+
+func contractSetKey(contractID int) string {
+	return fmt.Sprintf("contract_routing:%d", contractID)
+}
+
+func contractSetMember(apiKey, path string) string {
+	return apiKey + "#" + path
+}
+
+func (ar APIRouting) PostRouting(ctx context.Context, routing model.Routing) error {
+	return ar.writeRouting(ctx, routing)
 }
 func (ar APIRouting) CountRouting(ctx context.Context, apikey, path string) (int64, error) {
 	//TODO: impl
 	return 0, nil
 }
 
+// access tokens are stored in the hash "access_token:<api key>", whose fields are paths and values are the tokens as json
+
+const accessTokenKeyPrefix = "access_token:"
+
+func accessTokenKey(apiKey string) string {
+	return accessTokenKeyPrefix + apiKey
+}
+
 func (ar APIRouting) PostAPIToken(ctx context.Context, req model.PostAPITokenReq) error {
-	//TODO: impl
-	return nil
+	tokens := req.AccessTokens
+	if ar.envelope != nil {
+		encrypted, err := tokencrypt.Encrypt(ctx, ar.envelope, tokens, false)
+		if err != nil {
+			return err
+		}
+		tokens = encrypted
+	}
+	v, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	return ar.client.HSet(ctx, accessTokenKey(req.APIKey), req.Path, v).Err()
 }
 
 func (ar APIRouting) DeleteAPIToken(ctx context.Context, req model.DeleteAPITokenReq) error {
-	//TODO: impl
-	return nil
+	return ar.client.HDel(ctx, accessTokenKey(req.APIKey), req.Path).Err()
 }
 
-func (ar APIRouting) PostSwagger(ctx context.Context, productID int, info *swaggerparser.Swagger) error {
-	//TODO: impl
-	return nil
+// ReencryptAPITokens scans the access token hashes, and writes token sets encrypted with an old key or stored in plaintext
+// again with the current key. a token set written by PostAPIToken during the scan may be overwritten with the scanned one
+func (ar APIRouting) ReencryptAPITokens(ctx context.Context, dryRun bool) (model.ReencryptResult, error) {
+	result := model.ReencryptResult{
+		Failed: make([]string, 0),
+	}
+	if ar.envelope == nil {
+		return result, errors.New(secret.KeyFileEnv + " is not set")
+	}
+
+	iter := ar.client.Scan(ctx, 0, accessTokenKeyPrefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		hashKey := iter.Val()
+		apiKey := strings.TrimPrefix(hashKey, accessTokenKeyPrefix)
+		items, err := ar.client.HGetAll(ctx, hashKey).Result()
+		if err != nil {
+			return result, err
+		}
+		for path, v := range items {
+			result.Scanned++
+			// the same name as the key of the dynamodb table
+			name := apiKey + "#" + path
+			var tokens []model.AccessToken
+			if err = json.Unmarshal([]byte(v), &tokens); err != nil {
+				log.Printf("parse access tokens of %s failed: %v", name, err)
+				result.Failed = append(result.Failed, name)
+				continue
+			}
+			if !tokencrypt.NeedsReencryption(ar.envelope, tokens) {
+				continue
+			}
+			encrypted, err := tokencrypt.Encrypt(ctx, ar.envelope, tokens, true)
+			if err != nil {
+				log.Printf("re-encrypt access tokens of %s failed: %v", name, err)
+				result.Failed = append(result.Failed, name)
+				continue
+			}
+			if !dryRun {
+				b, err := json.Marshal(encrypted)
+				if err != nil {
+					return result, err
+				}
+				if err = ar.client.HSet(ctx, hashKey, path, b).Err(); err != nil {
+					log.Printf("put re-encrypted access tokens of %s failed: %v", name, err)
+					result.Failed = append(result.Failed, name)
+					continue
+				}
+			}
+			result.Reencrypted++
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return result, err
+	}
+	return result, nil
 }
 
-func (ar APIRouting) BatchPostRouting(ctx context.Context, items []model.Routing) (int, error) {
-	//TODO implement me
-	panic("implement me")
+// swagger info of each product is stored as json in the key of the product
+func swaggerKey(productID int) string {
+	return fmt.Sprintf("swagger:%d", productID)
+}
+
+func (ar APIRouting) PostSwagger(ctx context.Context, swagger model.Swagger) error {
+	v, err := json.Marshal(swagger)
+	if err != nil {
+		return err
+	}
+	return ar.client.Set(ctx, swaggerKey(swagger.ProductID), v, 0).Err()
+}
+
+func (ar APIRouting) BatchPostRouting(ctx context.Context, items []model.Routing) (model.BatchRoutingResult, error) {
+	result := model.BatchRoutingResult{
+		Failed: make([]model.Routing, 0),
+	}
+	for i, v := range items {
+		if err := ar.writeRouting(ctx, v); err != nil {
+			result.Failed = append(result.Failed, items[i:]...)
+			return result, err
+		}
+		result.Processed++
+	}
+	return result, nil
 }
 
-func (ar APIRouting) BatchGetSwagger(ctx context.Context, productIDs []int) ([]model.Swagger, error) {
-	//TODO implement me
-	panic("implement me")
+func (ar APIRouting) BatchDeleteRouting(ctx context.Context, items []model.Routing) (model.BatchRoutingResult, error) {
+	result := model.BatchRoutingResult{
+		Failed: make([]model.Routing, 0),
+	}
+	for i, v := range items {
+		ok, err := ar.deleteRouting(ctx, v.APIKey, v.Path)
+		if err != nil {
+			result.Failed = append(result.Failed, items[i:]...)
+			return result, err
+		}
+		if ok {
+			result.Processed++
+		}
+	}
+	return result, nil
+}
+
+func (ar APIRouting) GetRoutings(ctx context.Context, apiKey string) ([]model.Routing, error) {
+	res, err := ar.client.HGetAll(ctx, apiKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	metas, err := ar.client.HGetAll(ctx, metaHashKey(apiKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]model.Routing, 0, len(res))
+	for path, forwardURL := range res {
+		var meta routingMeta
+		if v, ok := metas[path]; ok {
+			if err = json.Unmarshal([]byte(v), &meta); err != nil {
+				return nil, fmt.Errorf("invalid attributes of routing %s %s: %w", apiKey, path, err)
+			}
+		}
+		ret = append(ret, model.Routing{
+			APIKey:         apiKey,
+			Path:           path,
+			ForwardURL:     forwardURL,
+			ContractID:     meta.ContractID,
+			APIKeyID:       meta.APIKeyID,
+			ProductID:      meta.ProductID,
+			ForwardHeaders: meta.ForwardHeaders,
+			Transform:      meta.Transform,
+			Cache:          meta.Cache,
+			Operations:     meta.Operations,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Path < ret[j].Path
+	})
+	return ret, nil
+}
+
+func (ar APIRouting) DeleteRouting(ctx context.Context, apiKey, path string) (bool, error) {
+	return ar.deleteRouting(ctx, apiKey, path)
+}
+
+func (ar APIRouting) DeleteRoutingsByContract(ctx context.Context, contractID int) (model.BatchRoutingResult, error) {
+	members, err := ar.client.SMembers(ctx, contractSetKey(contractID)).Result()
+	if err != nil {
+		return model.BatchRoutingResult{Failed: make([]model.Routing, 0)}, err
+	}
+	items := make([]model.Routing, 0, len(members))
+	for _, m := range members {
+		kv := strings.SplitN(m, "#", 2)
+		if len(kv) != 2 {
+			return model.BatchRoutingResult{Failed: make([]model.Routing, 0)},
+				fmt.Errorf("invalid member of %s: %s", contractSetKey(contractID), m)
+		}
+		items = append(items, model.Routing{
+			APIKey:     kv[0],
+			Path:       kv[1],
+			ContractID: contractID,
+		})
+	}
+	return ar.BatchDeleteRouting(ctx, items)
+}
+
+// writeRouting puts the routing and moves it from the set of the previous contract
+func (ar APIRouting) writeRouting(ctx context.Context, v model.Routing) error {
+	prev, err := ar.getMeta(ctx, v.APIKey, v.Path)
+	if err != nil {
+		return err
+	}
+	meta := routingMeta{
+		ContractID:     v.ContractID,
+		APIKeyID:       v.APIKeyID,
+		ProductID:      v.ProductID,
+		ForwardHeaders: v.ForwardHeaders,
+		Transform:      v.Transform,
+		Cache:          v.Cache,
+		Operations:     v.Operations,
+	}
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	member := contractSetMember(v.APIKey, v.Path)
+	_, err = ar.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if prev.ContractID != 0 && prev.ContractID != v.ContractID {
+			pipe.SRem(ctx, contractSetKey(prev.ContractID), member)
+		}
+		pipe.HSet(ctx, v.APIKey, v.Path, v.ForwardURL)
+		if meta.isZero() {
+			pipe.HDel(ctx, metaHashKey(v.APIKey), v.Path)
+		} else {
+			pipe.HSet(ctx, metaHashKey(v.APIKey), v.Path, metaJSON)
+		}
+		if v.ContractID != 0 {
+			pipe.SAdd(ctx, contractSetKey(v.ContractID), member)
+		}
+		return nil
+	})
+	return err
+}
+
+func (ar APIRouting) getMeta(ctx context.Context, apiKey, path string) (routingMeta, error) {
+	var meta routingMeta
+	v, err := ar.client.HGet(ctx, metaHashKey(apiKey), path).Bytes()
+	if err == redis.Nil {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, err
+	}
+	if err = json.Unmarshal(v, &meta); err != nil {
+		return meta, fmt.Errorf("invalid attributes of routing %s %s: %w", apiKey, path, err)
+	}
+	return meta, nil
+}
+
+// deleteRouting returns false if the routing does not exist
+func (ar APIRouting) deleteRouting(ctx context.Context, apiKey, path string) (bool, error) {
+	prev, err := ar.getMeta(ctx, apiKey, path)
+	if err != nil {
+		return false, err
+	}
+	var deleted *redis.IntCmd
+	_, err = ar.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.HDel(ctx, apiKey, path)
+		pipe.HDel(ctx, metaHashKey(apiKey), path)
+		if prev.ContractID != 0 {
+			pipe.SRem(ctx, contractSetKey(prev.ContractID), contractSetMember(apiKey, path))
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted.Val() > 0, nil
+}
+
+func (ar APIRouting) BatchGetSwagger(ctx context.Context, productIDs []int) (model.BatchSwaggerResult, error) {
+	result := model.BatchSwaggerResult{
+		Swaggers:          make([]model.Swagger, 0, len(productIDs)),
+		MissingProductIDs: make([]int, 0),
+	}
+	if len(productIDs) == 0 {
+		return result, nil
+	}
+	// each product is returned once like the dynamodb driver
+	uniqueIDs := make([]int, 0, len(productIDs))
+	keys := make([]string, 0, len(productIDs))
+	requested := make(map[int]struct{}, len(productIDs))
+	for _, id := range productIDs {
+		if _, ok := requested[id]; ok {
+			continue
+		}
+		requested[id] = struct{}{}
+		uniqueIDs = append(uniqueIDs, id)
+		keys = append(keys, swaggerKey(id))
+	}
+	values, err := ar.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return result, err
+	}
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			result.MissingProductIDs = append(result.MissingProductIDs, uniqueIDs[i])
+			continue
+		}
+		var swagger model.Swagger
+		if err = json.Unmarshal([]byte(s), &swagger); err != nil {
+			return result, fmt.Errorf("invalid swagger info of product %d: %w", uniqueIDs[i], err)
+		}
+		result.Swaggers = append(result.Swaggers, swagger)
+	}
+	return result, nil
 }
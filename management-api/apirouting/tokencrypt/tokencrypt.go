@@ -0,0 +1,58 @@
+// Package tokencrypt encrypts secrets of access tokens with the envelope shared with the gateway,
+// for every routing store the management api writes access tokens to
+package tokencrypt
+
+import (
+	"context"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/secret"
+)
+
+// secrets returns the fields of the token which are encrypted
+func secrets(token *model.AccessToken) []*string {
+	return []*string{&token.Value, &token.ClientSecret}
+}
+
+// Encrypt returns a copy of the tokens whose secrets are encrypted with the current key.
+// with decrypt, secrets already encrypted are decrypted before encryption
+func Encrypt(ctx context.Context, envelope *secret.Envelope, tokens []model.AccessToken, decrypt bool) ([]model.AccessToken, error) {
+	ret := make([]model.AccessToken, len(tokens))
+	copy(ret, tokens)
+	for i := range ret {
+		for _, field := range secrets(&ret[i]) {
+			if *field == "" {
+				continue
+			}
+			value := *field
+			if decrypt {
+				plaintext, err := envelope.Decrypt(ctx, value)
+				if err != nil {
+					return nil, err
+				}
+				value = plaintext
+			}
+			encrypted, err := envelope.Encrypt(ctx, value)
+			if err != nil {
+				return nil, fmt.Errorf("encrypt access token failed: %w", err)
+			}
+			*field = encrypted
+		}
+	}
+	return ret, nil
+}
+
+// NeedsReencryption reports whether some secrets of the tokens are not encrypted with the current key
+func NeedsReencryption(envelope *secret.Envelope, tokens []model.AccessToken) bool {
+	for i := range tokens {
+		for _, field := range secrets(&tokens[i]) {
+			if *field == "" {
+				continue
+			}
+			if keyID, ok := secret.KeyID(*field); !ok || keyID != envelope.CurrentKeyID() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+)
+
+// invoice generates the invoices of a month from the billable calls in log_list, which replaces the invoices
+// generated before for the month. without -month, it generates the invoices of the previous month in UTC.
+// it exits with 1 if some billable calls are not attributed to a contract and a product, unless -allow-unattributed,
+// or if some billable calls are on products without a pricing plan, unless -force.
+// with -finalize, the month is finalized after the invoices are generated without the failures above,
+// and its invoices are not generated again unless -force
+func main() {
+	now := time.Now().UTC()
+	previous := now.AddDate(0, 0, -now.Day())
+	month := flag.String("month", previous.Format(model.InvoiceMonthLayout), "billing month, ex.) 2022-01")
+	allowUnattributed := flag.Bool("allow-unattributed", false, "exit successfully even if some billable calls are not charged since they have no contract or product")
+	finalize := flag.Bool("finalize", false, "finalize the month after generating the invoices")
+	force := flag.Bool("force", false, "generate the invoices of the finalized month again, and exit successfully even if some products have no pricing plan")
+	flag.Parse()
+
+	m, err := time.Parse(model.InvoiceMonthLayout, *month)
+	if err != nil {
+		log.Fatalf("invalid month %s: %v", *month, err)
+	}
+
+	ctx := context.Background()
+	result, err := usecase.GenerateInvoices(ctx, m, now, *force)
+	if err != nil {
+		log.Fatalf("generate invoices failed: %v", err)
+	}
+
+	failed := false
+	if result.UnattributedCalls > 0 {
+		log.Printf("[WARN] %d billable calls in %s are not charged since they have no contract_id or product_id",
+			result.UnattributedCalls, result.Month)
+		failed = !*allowUnattributed
+	}
+	for _, p := range result.UnpricedProducts {
+		log.Printf("[WARN] %d billable calls of contract %d in %s are not charged since product %d (%s) has no pricing plan",
+			p.BillableCalls, p.ContractID, result.Month, p.ProductID, p.ProductName)
+		failed = failed || !*force
+	}
+	if *finalize && !failed {
+		finalizedAt, err := usecase.FinalizeInvoices(ctx, m, now)
+		if err != nil {
+			log.Fatalf("finalize invoices failed: %v", err)
+		}
+		result.FinalizedAt = &finalizedAt
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(result); err != nil {
+		log.Fatalf("write result failed: %v", err)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/usecase"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -21,6 +25,8 @@ func main() {
 		})
 		r.Route("/routing", func(r chi.Router) {
 			r.Post("/", managementapi.PostAPIRouting)
+			r.Get("/", managementapi.GetAPIRoutings)
+			r.Delete("/", managementapi.DeleteAPIRouting)
 		})
 		r.Route("/api", func(r chi.Router) {
 			r.Post("/token", managementapi.PostAPIToken)
@@ -28,22 +34,48 @@ func main() {
 		})
 		r.Route("/users", func(r chi.Router) {
 			r.Post("/", managementapi.PostUser)
+			r.Post("/verify_email", managementapi.VerifyEmail)
+			r.Get("/{account_id}", managementapi.GetUser)
+			r.Patch("/{account_id}", managementapi.PatchUser)
+			r.Delete("/{account_id}", managementapi.DeleteUser)
+			r.Put("/{account_id}/password", managementapi.PutUserPassword)
+			r.Post("/{account_id}/email/verification", managementapi.PostEmailVerification)
 		})
 		r.Route("/products", func(r chi.Router) {
 			r.Post("/", managementapi.PostProduct)
 			r.Get("/", managementapi.GetProducts)
 			r.Get("/search", managementapi.SearchProduct)
+			r.Post("/{id}/swagger/refresh", managementapi.RefreshProductSwagger)
+			r.Put("/{id}/transform", managementapi.PutProductTransform)
+			r.Get("/{id}/credentials", managementapi.GetProductCredentials)
+			r.Put("/{id}/credentials", managementapi.PutProductCredentials)
+			r.Put("/{id}/pricing", managementapi.PutPricingPlan)
 		})
 		r.Route("/contracts", func(r chi.Router) {
 			r.Post("/", managementapi.PostContract)
+			r.Get("/", managementapi.GetContracts)
+			r.Get("/{id}", managementapi.GetContract)
+			r.Patch("/{id}", managementapi.PatchContract)
+			r.Delete("/{id}", managementapi.DeleteContract)
+			r.Delete("/{id}/routings", managementapi.DeleteContractRoutings)
 		})
 		r.Route("/keys", func(r chi.Router) {
 			r.Post("/", managementapi.PostAPIKey)
 			r.Post("/products", managementapi.PostAPIKeyProducts)
 		})
+		r.Route("/usage", func(r chi.Router) {
+			r.Get("/", managementapi.GetUsage)
+		})
+		r.Route("/invoices", func(r chi.Router) {
+			r.Get("/", managementapi.GetInvoices)
+			r.Get("/{id}", managementapi.GetInvoice)
+		})
 
 	})
 
+	go expireContractsRoutine(context.Background(), contractExpiryInterval())
+	go usecase.RunRoutingOutboxWorker(context.Background(), routingOutboxInterval())
+
 	s := &http.Server{
 		Addr:    ":3001",
 		Handler: r,
@@ -53,3 +85,35 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+func contractExpiryInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("CONTRACT_EXPIRY_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return time.Minute
+	}
+	return interval
+}
+
+func routingOutboxInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("ROUTING_OUTBOX_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return 5 * time.Second
+	}
+	return interval
+}
+
+// expireContractsRoutine periodically removes authorizations linked to expired contracts
+func expireContractsRoutine(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := usecase.ExpireContracts(ctx)
+		if err != nil {
+			log.Printf("expire contracts failed: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("%d authorizations linked to expired contracts are removed", n)
+		}
+	}
+}
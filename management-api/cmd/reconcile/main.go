@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/future-architect/apidoor/managementapi/usecase"
+)
+
+// reconcile detects drift between PostgreSQL and the routing store, and fixes it.
+// with -dry-run, it only reports the drift
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report drift without fixing it")
+	flag.Parse()
+
+	report, err := usecase.Reconcile(context.Background(), *dryRun)
+	if err != nil {
+		log.Fatalf("reconcile failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(report); err != nil {
+		log.Fatalf("write report failed: %v", err)
+	}
+	if report.UnresolvedErrors > 0 {
+		os.Exit(1)
+	}
+}
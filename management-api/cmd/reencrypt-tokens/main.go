@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+)
+
+type reencryptResult struct {
+	AccessTokens       model.ReencryptResult `json:"access_tokens"`
+	ProductCredentials model.ReencryptResult `json:"product_credentials"`
+}
+
+// reencrypt-tokens encrypts the stored access tokens and credentials of products again with the first key of
+// TOKEN_ENCRYPTION_KEY_FILE. with -dry-run, it only reports the number of them to be re-encrypted
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report token sets and credentials to be re-encrypted without writing them")
+	flag.Parse()
+
+	ctx := context.Background()
+	var (
+		result reencryptResult
+		err    error
+	)
+	if result.AccessTokens, err = usecase.ReencryptAPITokens(ctx, *dryRun); err != nil {
+		log.Fatalf("re-encrypt tokens failed: %v", err)
+	}
+	if result.ProductCredentials, err = usecase.ReencryptProductCredentials(ctx, *dryRun); err != nil {
+		log.Fatalf("re-encrypt product credentials failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(result); err != nil {
+		log.Fatalf("write result failed: %v", err)
+	}
+	if len(result.AccessTokens.Failed) > 0 || len(result.ProductCredentials.Failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,71 @@
+package managementapi
+
+import (
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"log"
+	"net/http"
+)
+
+// DeleteAPIRouting godoc
+// @Summary Delete an API routing
+// @Description Delete a routing of the API key.
+// @Description The routing is deleted asynchronously through the routing outbox.
+// @Description A routing generated from an authorization is restored by reconcile while the authorization remains.
+// @Param api_key query string true "api key"
+// @Param path query string true "path of the routing"
+// @Success 202 {object} model.EmptyResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /routing [delete]
+func DeleteAPIRouting(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		log.Printf("parse param error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.DeleteRoutingReq
+	if err := model.SchemaDecoder.Decode(&req, r.Form); err != nil {
+		log.Printf("parse query param error: %v", err)
+		http.Error(w, "failed to parse query parameters", http.StatusBadRequest)
+		return
+	}
+
+	if err := validator.ValidateStruct(req); err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	if err := usecase.DeleteRouting(r.Context(), req); err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// DeleteContractRoutings godoc
+// @Summary Delete API routings of a contract
+// @Description Revoke authorizations linked to the contract, and delete all routings linked to it from the routing store.
+// @Description The contract is kept, so use DELETE /contracts/{id} to terminate the contract itself.
+// @Description The routings are deleted asynchronously through the routing outbox.
+// @produce json
+// @Param id path int true "contract id"
+// @Success 202 {object} model.EmptyResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /contracts/{id}/routings [delete]
+func DeleteContractRoutings(w http.ResponseWriter, r *http.Request) {
+	contractID, err := parseIDParam(r, "id", "contract id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	if err := usecase.DeleteContractRoutings(r.Context(), contractID); err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,257 @@
+package managementapi_test
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"github.com/guregu/dynamo"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func setupRoutingTable(t *testing.T, routings ...model.Routing) (*dynamo.DB, string) {
+	t.Helper()
+	managementapi.Setup(t,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/api_routing_table.json`,
+	)
+	t.Cleanup(func() {
+		managementapi.Teardown(t,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table api_routing`,
+		)
+	})
+
+	dbDynamo := dynamo.New(session.Must(session.NewSessionWithOptions(session.Options{
+		Profile:           "local",
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            aws.Config{Endpoint: aws.String("http://localhost:4566")},
+	})))
+	routingTable := os.Getenv("DYNAMO_TABLE_API_ROUTING")
+	for _, v := range routings {
+		if err := dbDynamo.Table(routingTable).Put(v).Run(); err != nil {
+			t.Fatalf("put routing failed: %v", err)
+		}
+	}
+	return dbDynamo, routingTable
+}
+
+func TestDeleteAPIRouting(t *testing.T) {
+	dbType := managementapi.GetAPIDBType(t)
+	if dbType != managementapi.DYNAMO {
+		log.Println("this test is valid when dynamodb is used, skip")
+		return
+	}
+
+	db.Exec("TRUNCATE routing_outbox")
+	defer db.Exec("TRUNCATE routing_outbox")
+
+	dbDynamo, routingTable := setupRoutingTable(t, model.Routing{
+		APIKey:     "key",
+		Path:       "/product1/user",
+		ForwardURL: "https://example.com/v1/user",
+		ContractID: 1,
+	})
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantResp   *validator.BadRequestResp
+	}{
+		{
+			name:       "delete a routing properly",
+			query:      "api_key=key&path=/product1/user",
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "routing does not exist",
+			query:      "api_key=key&path=/product1/user",
+			wantStatus: http.StatusBadRequest,
+			wantResp: &validator.BadRequestResp{
+				Message: "routing not found, api_key key, path /product1/user",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete, "localhost:3000/mgmt/routing?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			managementapi.DeleteAPIRouting(w, r)
+			processRoutingOutbox(t)
+
+			rw := w.Result()
+			resp, err := io.ReadAll(rw.Body)
+			if err != nil {
+				t.Errorf("read response body error: %v", err)
+				return
+			}
+
+			if rw.StatusCode != tt.wantStatus {
+				t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, tt.wantStatus)
+			}
+			if tt.wantResp != nil {
+				testBadRequestResp(t, tt.wantResp, resp)
+				return
+			}
+
+			cnt, err := dbDynamo.Table(routingTable).Get("api_key", "key").Count()
+			if err != nil {
+				t.Errorf("count routings db error: %v", err)
+			}
+			if cnt != 0 {
+				t.Errorf("routing is not removed, got %d items", cnt)
+			}
+		})
+	}
+}
+
+func TestDeleteContractRoutings(t *testing.T) {
+	dbType := managementapi.GetAPIDBType(t)
+	if dbType != managementapi.DYNAMO {
+		log.Println("this test is valid when dynamodb is used, skip")
+		return
+	}
+
+	cleanup := func() {
+		db.Exec("TRUNCATE apikey_contract_product_authorized")
+		db.Exec("TRUNCATE routing_outbox")
+		db.Exec("DELETE FROM contract_product_content")
+		db.Exec("DELETE FROM contract")
+		db.Exec("DELETE FROM product")
+		db.Exec("DELETE FROM apikey")
+		db.Exec("DELETE FROM apiuser")
+	}
+	cleanup()
+	defer cleanup()
+
+	var userID, productID, contractID, apikeyID, contractProductID int
+	if err := db.QueryRowx(`INSERT INTO apiuser(account_id, email_address, login_password_hash, name, created_at, updated_at)
+			VALUES ('user1', 'a', 'password', 'a', current_timestamp, current_timestamp) RETURNING id`).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO product(name, source, description, thumbnail, display_name, base_path, swagger_url, created_at, updated_at)
+			VALUES ('product1', 'a', 'a', 'a', 'a', '/product1', 'a', current_timestamp, current_timestamp) RETURNING id`).Scan(&productID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO contract(user_id, start_at, created_at, updated_at)
+			VALUES ($1, current_timestamp, current_timestamp, current_timestamp) RETURNING id`, userID).Scan(&contractID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO apikey(user_id, access_key, created_at, updated_at)
+			VALUES ($1, 'key1', current_timestamp, current_timestamp) RETURNING id`, userID).Scan(&apikeyID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO contract_product_content(contract_id, product_id, created_at, updated_at)
+			VALUES ($1, $2, current_timestamp, current_timestamp) RETURNING id`, contractID, productID).Scan(&contractProductID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO apikey_contract_product_authorized(apikey_id, contract_product_id, created_at, updated_at)
+			VALUES ($1, $2, current_timestamp, current_timestamp)`, apikeyID, contractProductID); err != nil {
+		t.Fatal(err)
+	}
+
+	// revoke events generate routings to delete from the swagger info
+	managementapi.Setup(t,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/swagger_table.json`,
+	)
+	t.Cleanup(func() {
+		managementapi.Teardown(t,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table swagger`,
+		)
+	})
+
+	dbDynamo, routingTable := setupRoutingTable(t,
+		model.Routing{APIKey: "key1", Path: "/product1/user", ForwardURL: "https://example.com/v1/user", ContractID: contractID},
+		model.Routing{APIKey: "key2", Path: "/product1/user", ForwardURL: "https://example.com/v1/user", ContractID: contractID},
+		model.Routing{APIKey: "key2", Path: "/product2/user", ForwardURL: "https://example.com/v2/user", ContractID: contractID + 1},
+	)
+	swagger := model.Swagger{
+		ProductID:      productID,
+		Schemes:        []string{"https"},
+		ForwardURLBase: "example.com/v1",
+		PathBase:       "/product1",
+		APIList: []model.API{
+			{
+				ForwardURL: "/user",
+				Path:       "/user",
+			},
+		},
+	}
+	if err := dbDynamo.Table(os.Getenv("DYNAMO_TABLE_SWAGGER")).Put(swagger).Run(); err != nil {
+		t.Fatalf("put swagger failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		contractID string
+		wantStatus int
+		wantResp   interface{}
+		wantRemain int
+	}{
+		{
+			name:       "delete routings of a contract",
+			contractID: fmt.Sprint(contractID),
+			wantStatus: http.StatusAccepted,
+			wantRemain: 1,
+		},
+		{
+			name:       "contract does not exist",
+			contractID: "-1",
+			wantStatus: http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: "contract not found, id -1",
+			},
+			wantRemain: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete,
+				fmt.Sprintf("localhost:3000/mgmt/contracts/%s/routings", tt.contractID), nil)
+			r = withURLParam(r, "id", tt.contractID)
+
+			w := httptest.NewRecorder()
+			managementapi.DeleteContractRoutings(w, r)
+			processRoutingOutbox(t)
+
+			rw := w.Result()
+			resp, err := io.ReadAll(rw.Body)
+			if err != nil {
+				t.Errorf("read response body error: %v", err)
+				return
+			}
+
+			if rw.StatusCode != tt.wantStatus {
+				t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, tt.wantStatus)
+			}
+			if br, ok := tt.wantResp.(validator.BadRequestResp); ok {
+				testBadRequestResp(t, &br, resp)
+			}
+
+			var remain []model.Routing
+			if err := dbDynamo.Table(routingTable).Scan().All(&remain); err != nil {
+				t.Errorf("scan routings db error: %v", err)
+			}
+			if len(remain) != tt.wantRemain {
+				t.Errorf("wrong number of remaining routings: got %d, want %d, %v", len(remain), tt.wantRemain, remain)
+			}
+
+			// authorizations are revoked not to let reconcile restore the routings
+			var cnt int
+			if err := db.QueryRowx(`SELECT COUNT(*) FROM apikey_contract_product_authorized WHERE apikey_id = $1`, apikeyID).Scan(&cnt); err != nil {
+				t.Errorf("count authorizations error: %v", err)
+			}
+			if cnt != 0 {
+				t.Errorf("authorizations are not removed, got %d items", cnt)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package managementapi
+
+import (
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"net/http"
+)
+
+// DeleteContract godoc
+// @Summary Terminate a contract
+// @Description Terminate a contract at the current time, and remove authorizations and routings linked to it
+// @Param id path int true "contract id"
+// @Success 204 {object} model.EmptyResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /contracts/{id} [delete]
+func DeleteContract(w http.ResponseWriter, r *http.Request) {
+	contractID, err := parseIDParam(r, "id", "contract id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	if err := usecase.TerminateContract(r.Context(), contractID); err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,183 @@
+package managementapi_test
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"github.com/guregu/dynamo"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestDeleteContract(t *testing.T) {
+	dbType := managementapi.GetAPIDBType(t)
+	if dbType != managementapi.DYNAMO {
+		log.Println("this test is valid when dynamodb is used, skip")
+		return
+	}
+
+	managementapi.Setup(t,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/api_routing_table.json`,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/swagger_table.json`,
+	)
+	t.Cleanup(func() {
+		managementapi.Teardown(t,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table swagger`,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table api_routing`,
+		)
+	})
+
+	cleanup := func() {
+		db.Exec("TRUNCATE apikey_contract_product_authorized")
+		db.Exec("TRUNCATE routing_outbox")
+		db.Exec("DELETE FROM contract_product_content")
+		db.Exec("DELETE FROM contract")
+		db.Exec("DELETE FROM product")
+		db.Exec("DELETE FROM apikey")
+		db.Exec("DELETE FROM apiuser")
+	}
+	cleanup()
+	defer cleanup()
+
+	// DB setup
+	var userID, productID, contractID, apikeyID, contractProductID int
+	if err := db.QueryRowx(`INSERT INTO apiuser(account_id, email_address, login_password_hash, name, created_at, updated_at)
+			VALUES ('user1', 'a', 'password', 'a', current_timestamp, current_timestamp) RETURNING id`).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO product(name, source, description, thumbnail, display_name, base_path, swagger_url, created_at, updated_at)
+			VALUES ('product1', 'a', 'a', 'a', 'a', '/product1', 'a', current_timestamp, current_timestamp) RETURNING id`).Scan(&productID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO contract(user_id, start_at, created_at, updated_at)
+			VALUES ($1, current_timestamp, current_timestamp, current_timestamp) RETURNING id`, userID).Scan(&contractID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO apikey(user_id, access_key, created_at, updated_at)
+			VALUES ($1, 'key', current_timestamp, current_timestamp) RETURNING id`, userID).Scan(&apikeyID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO contract_product_content(contract_id, product_id, created_at, updated_at)
+			VALUES ($1, $2, current_timestamp, current_timestamp) RETURNING id`, contractID, productID).Scan(&contractProductID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO apikey_contract_product_authorized(apikey_id, contract_product_id, created_at, updated_at)
+			VALUES ($1, $2, current_timestamp, current_timestamp)`, apikeyID, contractProductID); err != nil {
+		t.Fatal(err)
+	}
+
+	// dynamodb setup
+	dbDynamo := dynamo.New(session.Must(session.NewSessionWithOptions(session.Options{
+		Profile:           "local",
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            aws.Config{Endpoint: aws.String("http://localhost:4566")},
+	})))
+	swaggerTable := os.Getenv("DYNAMO_TABLE_SWAGGER")
+	routingTable := os.Getenv("DYNAMO_TABLE_API_ROUTING")
+
+	swagger := model.Swagger{
+		ProductID:      productID,
+		Schemes:        []string{"https"},
+		ForwardURLBase: "example.com/v1",
+		PathBase:       "/product1",
+		APIList: []model.API{
+			{
+				ForwardURL: "/user",
+				Path:       "/user",
+			},
+		},
+	}
+	if err := dbDynamo.Table(swaggerTable).Put(swagger).Run(); err != nil {
+		t.Fatalf("put swagger failed: %v", err)
+	}
+	routing := model.Routing{
+		APIKey:     "key",
+		Path:       "/product1/user",
+		ForwardURL: "https://example.com/v1/user",
+		ContractID: contractID,
+	}
+	if err := dbDynamo.Table(routingTable).Put(routing).Run(); err != nil {
+		t.Fatalf("put routing failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		contractID string
+		wantStatus int
+		wantResp   *validator.BadRequestResp
+	}{
+		{
+			name:       "terminate a contract properly",
+			contractID: fmt.Sprint(contractID),
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "contract does not exist",
+			contractID: "-1",
+			wantStatus: http.StatusBadRequest,
+			wantResp: &validator.BadRequestResp{
+				Message: "contract not found, id -1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete,
+				fmt.Sprintf("localhost:3000/mgmt/contracts/%s", tt.contractID), nil)
+			r = withURLParam(r, "id", tt.contractID)
+
+			w := httptest.NewRecorder()
+			managementapi.DeleteContract(w, r)
+			processRoutingOutbox(t)
+
+			rw := w.Result()
+			resp, err := io.ReadAll(rw.Body)
+			if err != nil {
+				t.Errorf("read response body error: %v", err)
+				return
+			}
+
+			if rw.StatusCode != tt.wantStatus {
+				t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, tt.wantStatus)
+			}
+			if tt.wantResp != nil {
+				testBadRequestResp(t, tt.wantResp, resp)
+				return
+			}
+
+			// db check
+			var ended bool
+			if err := db.QueryRowx(`SELECT end_at <= current_timestamp FROM contract WHERE id = $1`, contractID).Scan(&ended); err != nil {
+				t.Errorf("get contract end_at error: %v", err)
+			}
+			if !ended {
+				t.Error("end_at of the contract is not set")
+			}
+
+			var cnt int
+			if err := db.QueryRowx(`SELECT COUNT(*) FROM apikey_contract_product_authorized WHERE apikey_id = $1`, apikeyID).Scan(&cnt); err != nil {
+				t.Errorf("count authorizations error: %v", err)
+			}
+			if cnt != 0 {
+				t.Errorf("authorizations are not removed, got %d items", cnt)
+			}
+
+			var gotRoutings []model.Routing
+			err = dbDynamo.Table(routingTable).Get("api_key", "key").All(&gotRoutings)
+			if err != nil && err != dynamo.ErrNotFound {
+				t.Errorf("get routings db error: %v", err)
+			}
+			if len(gotRoutings) != 0 {
+				t.Errorf("routings are not removed, got %v", gotRoutings)
+			}
+		})
+	}
+}
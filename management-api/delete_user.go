@@ -0,0 +1,23 @@
+package managementapi
+
+import (
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+)
+
+// DeleteUser godoc
+// @Summary Delete a user
+// @Description Soft-delete a user. API keys of the user are revoked, and contracts of the user are ended
+// @Param account_id path string true "account id of the user"
+// @Success 204 {object} model.EmptyResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /users/{account_id} [delete]
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if err := usecase.DeleteUser(r.Context(), chi.URLParam(r, "account_id")); err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
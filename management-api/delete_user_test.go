@@ -0,0 +1,99 @@
+package managementapi_test
+
+import (
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeleteUser(t *testing.T) {
+	cleanup := func() {
+		db.Exec("DELETE FROM contract")
+		db.Exec("DELETE FROM apikey")
+		db.Exec("DELETE FROM apiuser")
+	}
+	cleanup()
+	defer cleanup()
+
+	var userID int
+	if err := db.QueryRowx(`INSERT INTO apiuser(account_id, email_address, login_password_hash, name, created_at, updated_at)
+			VALUES ('user1', 'a', 'password', 'a', current_timestamp, current_timestamp) RETURNING id`).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO apikey(user_id, access_key, created_at, updated_at)
+			VALUES ($1, 'key', current_timestamp, current_timestamp)`, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO contract(user_id, start_at, created_at, updated_at)
+			VALUES ($1, current_timestamp, current_timestamp, current_timestamp)`, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		accountID  string
+		wantStatus int
+		wantResp   *validator.BadRequestResp
+	}{
+		{
+			name:       "delete a user properly",
+			accountID:  "user1",
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "deleted user cannot be deleted again",
+			accountID:  "user1",
+			wantStatus: http.StatusBadRequest,
+			wantResp: &validator.BadRequestResp{
+				Message: "account_id user1 does not exist",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete, "localhost:3000/mgmt/users/"+tt.accountID, nil)
+			r = withURLParam(r, "account_id", tt.accountID)
+
+			w := httptest.NewRecorder()
+			managementapi.DeleteUser(w, r)
+
+			rw := w.Result()
+			resp, err := io.ReadAll(rw.Body)
+			if err != nil {
+				t.Errorf("read response body error: %v", err)
+				return
+			}
+
+			if rw.StatusCode != tt.wantStatus {
+				t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, tt.wantStatus)
+			}
+			if tt.wantResp != nil {
+				testBadRequestResp(t, tt.wantResp, resp)
+				return
+			}
+
+			var deleted, keys, openContracts int
+			if err := db.QueryRowx(`SELECT
+					(SELECT COUNT(*) FROM apiuser WHERE id = $1 AND deleted_at IS NOT NULL),
+					(SELECT COUNT(*) FROM apikey WHERE user_id = $1),
+					(SELECT COUNT(*) FROM contract WHERE user_id = $1 AND (end_at IS NULL OR end_at > current_timestamp))`,
+				userID).Scan(&deleted, &keys, &openContracts); err != nil {
+				t.Errorf("db check error: %v", err)
+				return
+			}
+			if deleted != 1 {
+				t.Error("user is not marked as deleted")
+			}
+			if keys != 0 {
+				t.Errorf("api keys are not revoked, got %d keys", keys)
+			}
+			if openContracts != 0 {
+				t.Errorf("contracts are not ended, got %d contracts", openContracts)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package managementapi
+
+import (
+	"bytes"
+	"errors"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log"
+	"net/http"
+)
+
+// PostEmailVerification godoc
+// @Summary Send a verification mail again
+// @Description Send the mail to verify the email address of a user again
+// @Param account_id path string true "account id of the user"
+// @Success 204 {object} model.EmptyResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /users/{account_id}/email/verification [post]
+func PostEmailVerification(w http.ResponseWriter, r *http.Request) {
+	if err := usecase.SendVerificationMail(r.Context(), chi.URLParam(r, "account_id")); err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyEmail godoc
+// @Summary Verify an email address
+// @Description Verify the email address of a user with the token sent by mail
+// @produce json
+// @Param token body model.VerifyEmailReq true "verification token"
+// @Success 200 {object} model.UserResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /users/verify_email [post]
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		log.Printf("unexpected request content: %s", r.Header.Get("Content-Type"))
+		writeErrResponse(w, usecase.NewClientError(errors.New(`unexpected request Content-Type, it must be "application/json"`)))
+		return
+	}
+	body := new(bytes.Buffer)
+	if _, err := io.Copy(body, r.Body); err != nil {
+		log.Printf("reading request body failed: %v", err)
+		writeErrResponse(w, usecase.NewServerError(errors.New(`server error`)))
+		return
+	}
+
+	var req model.VerifyEmailReq
+	if ok := unmarshalJSONAndValidate(w, body.Bytes(), &req); !ok {
+		return
+	}
+
+	resp, err := usecase.VerifyEmail(r.Context(), req)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, resp)
+}
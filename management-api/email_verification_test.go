@@ -0,0 +1,135 @@
+package managementapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/mail"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestEmailVerification(t *testing.T) {
+	cleanup := func() {
+		db.Exec("DELETE FROM apiuser")
+	}
+	cleanup()
+	defer cleanup()
+
+	sender := &mail.MemorySender{}
+	defaultSender := usecase.MailSender
+	usecase.MailSender = sender
+	defer func() {
+		usecase.MailSender = defaultSender
+	}()
+	tokenRegex := regexp.MustCompile(`(?m)^[0-9a-f]{64}$`)
+
+	lastToken := func(t *testing.T, to string) string {
+		t.Helper()
+		msgs := sender.Messages()
+		if len(msgs) == 0 {
+			t.Fatal("no mail is sent")
+		}
+		msg := msgs[len(msgs)-1]
+		if msg.To != to {
+			t.Fatalf("mail is sent to the wrong address: got %s, want %s", msg.To, to)
+		}
+		token := tokenRegex.FindString(msg.Body)
+		if token == "" {
+			t.Fatalf("token not found in mail body: %s", msg.Body)
+		}
+		return token
+	}
+
+	verify := func(t *testing.T, token string) (*http.Response, []byte) {
+		t.Helper()
+		bodyBytes, _ := json.Marshal(model.VerifyEmailReq{Token: token})
+		r := httptest.NewRequest(http.MethodPost, "localhost:3000/mgmt/users/verify_email", bytes.NewReader(bodyBytes))
+		r.Header.Add("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		managementapi.VerifyEmail(w, r)
+		rw := w.Result()
+		resp, err := io.ReadAll(rw.Body)
+		if err != nil {
+			t.Fatalf("read response body error: %v", err)
+		}
+		return rw, resp
+	}
+
+	verified := func(t *testing.T) bool {
+		t.Helper()
+		var ret bool
+		if err := db.QueryRowx(`SELECT email_verified FROM apiuser WHERE account_id = 'user1'`).Scan(&ret); err != nil {
+			t.Fatalf("get email_verified error: %v", err)
+		}
+		return ret
+	}
+
+	// a verification mail is sent on registration
+	bodyBytes, _ := json.Marshal(model.PostUserReq{
+		AccountID:    "user1",
+		EmailAddress: "user1@example.com",
+		Password:     "password",
+	})
+	r := httptest.NewRequest(http.MethodPost, "localhost:3000/mgmt/users", bytes.NewReader(bodyBytes))
+	r.Header.Add("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	managementapi.PostUser(w, r)
+	if w.Result().StatusCode != http.StatusCreated {
+		t.Fatalf("post user failed, status %d", w.Result().StatusCode)
+	}
+	token := lastToken(t, "user1@example.com")
+
+	t.Run("verify with the token properly", func(t *testing.T) {
+		rw, resp := verify(t, token)
+		if rw.StatusCode != http.StatusOK {
+			t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, http.StatusOK)
+		}
+		var got model.UserResp
+		if err := json.Unmarshal(resp, &got); err != nil {
+			t.Errorf("parse response body failed: %v", err)
+		}
+		if !got.EmailVerified || !verified(t) {
+			t.Error("email address is not marked as verified")
+		}
+	})
+
+	t.Run("used token is rejected", func(t *testing.T) {
+		rw, resp := verify(t, token)
+		if rw.StatusCode != http.StatusBadRequest {
+			t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, http.StatusBadRequest)
+		}
+		testBadRequestResp(t, &validator.BadRequestResp{Message: "token is invalid or expired"}, resp)
+	})
+
+	t.Run("changing email address resets verification", func(t *testing.T) {
+		newAddress := "user1-new@example.com"
+		bodyBytes, _ := json.Marshal(model.PatchUserReq{EmailAddress: &newAddress})
+		r := httptest.NewRequest(http.MethodPatch, "localhost:3000/mgmt/users/user1", bytes.NewReader(bodyBytes))
+		r.Header.Add("Content-Type", "application/json")
+		r = withURLParam(r, "account_id", "user1")
+		w := httptest.NewRecorder()
+		managementapi.PatchUser(w, r)
+
+		if w.Result().StatusCode != http.StatusOK {
+			t.Fatalf("wrong http status code: got %d, want %d", w.Result().StatusCode, http.StatusOK)
+		}
+		if verified(t) {
+			t.Error("email address is still marked as verified")
+		}
+
+		rw, _ := verify(t, lastToken(t, newAddress))
+		if rw.StatusCode != http.StatusOK {
+			t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, http.StatusOK)
+		}
+		if !verified(t) {
+			t.Error("new email address is not marked as verified")
+		}
+	})
+}
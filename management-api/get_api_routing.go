@@ -0,0 +1,47 @@
+package managementapi
+
+import (
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"log"
+	"net/http"
+)
+
+// GetAPIRoutings godoc
+// @Summary Get list of API routings of a key
+// @Description Get list of routings which the API key can reach, sorted by path
+// @produce json
+// @Param api_key query string true "api key"
+// @Param limit query int false "the maximum number of results" default(50) minimum(1) maximum(100)
+// @Param offset query int false "the starting point for the result set" default(0)
+// @Success 200 {object} model.RoutingListResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /routing [get]
+func GetAPIRoutings(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		log.Printf("parse param error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.GetRoutingsReq
+	if err := model.SchemaDecoder.Decode(&req, r.Form); err != nil {
+		log.Printf("parse query param error: %v", err)
+		http.Error(w, "failed to parse query parameters", http.StatusBadRequest)
+		return
+	}
+
+	if err := validator.ValidateStruct(req); err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	resp, err := usecase.GetRoutings(r.Context(), req)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, resp)
+}
@@ -0,0 +1,145 @@
+package managementapi_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"github.com/google/go-cmp/cmp"
+	"github.com/guregu/dynamo"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestGetAPIRoutings(t *testing.T) {
+	dbType := managementapi.GetAPIDBType(t)
+	if dbType != managementapi.DYNAMO {
+		log.Println("this test is valid when dynamodb is used, skip")
+		return
+	}
+
+	managementapi.Setup(t,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/api_routing_table.json`,
+	)
+	t.Cleanup(func() {
+		managementapi.Teardown(t,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table api_routing`,
+		)
+	})
+
+	dbDynamo := dynamo.New(session.Must(session.NewSessionWithOptions(session.Options{
+		Profile:           "local",
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            aws.Config{Endpoint: aws.String("http://localhost:4566")},
+	})))
+	routingTable := os.Getenv("DYNAMO_TABLE_API_ROUTING")
+
+	routings := make([]model.Routing, 3)
+	for i := range routings {
+		routings[i] = model.Routing{
+			APIKey:     "key",
+			Path:       fmt.Sprintf("/product1/api%d", i),
+			ForwardURL: fmt.Sprintf("https://example.com/v1/api%d", i),
+			ContractID: 1,
+		}
+		if err := dbDynamo.Table(routingTable).Put(routings[i]).Run(); err != nil {
+			t.Fatalf("put routing failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantResp   interface{}
+	}{
+		{
+			name:       "get routings of a key",
+			query:      "api_key=key",
+			wantStatus: http.StatusOK,
+			wantResp: model.RoutingListResp{
+				RoutingList: routings,
+				MetaData: model.RoutingListMetaData{
+					ResultSet: model.ResultSet{Count: 3, Limit: 50, Offset: 0},
+				},
+			},
+		},
+		{
+			name:       "get routings with limit and offset",
+			query:      "api_key=key&limit=1&offset=1",
+			wantStatus: http.StatusOK,
+			wantResp: model.RoutingListResp{
+				RoutingList: routings[1:2],
+				MetaData: model.RoutingListMetaData{
+					ResultSet: model.ResultSet{Count: 3, Limit: 1, Offset: 1},
+				},
+			},
+		},
+		{
+			name:       "key without routings",
+			query:      "api_key=unknown",
+			wantStatus: http.StatusOK,
+			wantResp: model.RoutingListResp{
+				RoutingList: []model.Routing{},
+				MetaData: model.RoutingListMetaData{
+					ResultSet: model.ResultSet{Count: 0, Limit: 50, Offset: 0},
+				},
+			},
+		},
+		{
+			name:       "api_key is missing",
+			query:      "",
+			wantStatus: http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: "input validation error",
+				ValidationErrors: &validator.ValidationErrors{
+					{
+						Field:          "api_key",
+						ConstraintType: "required",
+						Message:        "required field, but got empty",
+						Got:            "",
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "localhost:3000/mgmt/routing?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			managementapi.GetAPIRoutings(w, r)
+
+			rw := w.Result()
+			resp, err := io.ReadAll(rw.Body)
+			if err != nil {
+				t.Errorf("read response body error: %v", err)
+				return
+			}
+
+			if rw.StatusCode != tt.wantStatus {
+				t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, tt.wantStatus)
+			}
+			if br, ok := tt.wantResp.(validator.BadRequestResp); ok {
+				testBadRequestResp(t, &br, resp)
+				return
+			}
+
+			var got model.RoutingListResp
+			if err := json.Unmarshal(resp, &got); err != nil {
+				t.Errorf("parse response body error: %v", err)
+				return
+			}
+			if diff := cmp.Diff(tt.wantResp, got); diff != "" {
+				t.Errorf("response differs:\n%v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package managementapi
+
+import (
+	"encoding/json"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"log"
+	"net/http"
+)
+
+// GetContracts godoc
+// @Summary Get list of contracts of a user
+// @Description Get list of contracts which the user has
+// @produce json
+// @Param user_account_id query string true "account id of the user"
+// @Param limit query int false "the maximum number of results" default(50) minimum(1) maximum(100)
+// @Param offset query int false "the starting point for the result set" default(0)
+// @Success 200 {object} model.ContractListResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /contracts [get]
+func GetContracts(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		log.Printf("parse param error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.GetContractsReq
+	if err := model.SchemaDecoder.Decode(&req, r.Form); err != nil {
+		log.Printf("parse query param error: %v", err)
+		http.Error(w, "failed to parse query parameters", http.StatusBadRequest)
+		return
+	}
+
+	if err := validator.ValidateStruct(req); err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	resp, err := usecase.GetContracts(r.Context(), req)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	respBody, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("create json response error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
+
+// GetContract godoc
+// @Summary Get a contract
+// @Description Get a contract and products contained in it
+// @produce json
+// @Param id path int true "contract id"
+// @Success 200 {object} model.ContractDetail
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /contracts/{id} [get]
+func GetContract(w http.ResponseWriter, r *http.Request) {
+	contractID, err := parseIDParam(r, "id", "contract id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	resp, err := usecase.GetContract(r.Context(), contractID)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	respBody, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("create json response error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
@@ -0,0 +1,142 @@
+package managementapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// invoiceCSVHeader is the header of the csv export of invoices, which has a row for each item
+var invoiceCSVHeader = []string{
+	"invoice_id", "contract_id", "billing_month", "currency",
+	"product_id", "product_name", "billable_calls", "free_calls", "amount",
+}
+
+// GetInvoices godoc
+// @Summary Get list of invoices
+// @Description Get list of monthly invoices generated by the invoice command
+// @produce json
+// @Param contract_id query int false "filter by the contract id"
+// @Param month query string false "filter by the billing month (ex. 2022-01)"
+// @Param limit query int false "the maximum number of results" default(50) minimum(1) maximum(100)
+// @Param offset query int false "the starting point for the result set" default(0)
+// @Success 200 {object} model.InvoiceListResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /invoices [get]
+func GetInvoices(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		log.Printf("parse param error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.GetInvoicesReq
+	if err := model.SchemaDecoder.Decode(&req, r.Form); err != nil {
+		log.Printf("parse query param error: %v", err)
+		http.Error(w, "failed to parse query parameters", http.StatusBadRequest)
+		return
+	}
+
+	if err := validator.ValidateStruct(req); err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	resp, err := usecase.GetInvoices(r.Context(), req)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	respBody, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("create json response error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
+
+// GetInvoice godoc
+// @Summary Get an invoice
+// @Description Get an invoice and its items for each product, amounts are in micro units of the currency in json and decimal numbers in csv
+// @produce json
+// @produce text/csv
+// @Param id path int true "invoice id"
+// @Param format query string false "response format, csv returns an attachment" Enums(json, csv) default(json)
+// @Success 200 {object} model.InvoiceDetail
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /invoices/{id} [get]
+func GetInvoice(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := parseIDParam(r, "id", "invoice id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	resp, err := usecase.GetInvoice(r.Context(), invoiceID)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "csv":
+		writeInvoiceCSV(w, resp)
+		return
+	case "", "json":
+	default:
+		writeErrResponse(w, usecase.NewClientError(fmt.Errorf("unsupported format %s, it must be json or csv", format)))
+		return
+	}
+
+	respBody, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("create json response error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
+
+// writeInvoiceCSV writes the items of the invoice as a csv attachment
+func writeInvoiceCSV(w http.ResponseWriter, invoice *model.InvoiceDetail) {
+	month := invoice.BillingMonth.Format(model.InvoiceMonthLayout)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="invoice_%d_%s.csv"`, invoice.ID, month))
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write(invoiceCSVHeader)
+	for _, item := range invoice.Items {
+		writer.Write([]string{
+			strconv.Itoa(invoice.ID),
+			strconv.Itoa(invoice.ContractID),
+			month,
+			invoice.Currency,
+			strconv.Itoa(item.ProductID),
+			item.ProductName,
+			strconv.FormatInt(item.BillableCalls, 10),
+			strconv.FormatInt(item.FreeCalls, 10),
+			model.FormatMicros(item.AmountMicros),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("write csv response error: %v", err)
+	}
+}
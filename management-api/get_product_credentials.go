@@ -0,0 +1,30 @@
+package managementapi
+
+import (
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"net/http"
+)
+
+// GetProductCredentials godoc
+// @Summary Get security schemes of a product which need credentials
+// @Description Get the upstream security schemes parsed from the swagger file of a product, and whether their credentials are stored. Credential values are not returned
+// @produce json
+// @Param id path int true "product id"
+// @Success 200 {object} model.ProductCredentialsResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /products/{id}/credentials [get]
+func GetProductCredentials(w http.ResponseWriter, r *http.Request) {
+	productID, err := parseIDParam(r, "id", "product id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	resp, err := usecase.GetProductCredentials(r.Context(), productID)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, resp)
+}
@@ -0,0 +1,123 @@
+package managementapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// usageCSVHeader is the header of the csv export of usage
+var usageCSVHeader = []string{
+	"period_start", "group", "calls", "billable_calls", "errors", "error_rate",
+	"latency_p50_ms", "latency_p95_ms", "latency_p99_ms",
+}
+
+// GetUsage godoc
+// @Summary Get usage of APIs
+// @Description Aggregate access logs into call counts, billable counts, error rates and latency percentiles by period and group
+// @produce json
+// @produce text/csv
+// @Param from query string true "the first day of the report in UTC (ex. 2022-01-01)"
+// @Param to query string true "the last day of the report in UTC, inclusive. up to 31 days for hour granularity and 366 days for day granularity"
+// @Param granularity query string false "length of a period" Enums(day, hour) default(day)
+// @Param group_by query string false "group of the usage" Enums(key, product, contract, path) default(key)
+// @Param api_key query string false "filter by the api key"
+// @Param product_id query int false "filter by the product id"
+// @Param contract_id query int false "filter by the contract id"
+// @Param path query string false "filter by the api path"
+// @Param format query string false "response format, csv returns an attachment" Enums(json, csv) default(json)
+// @Success 200 {object} model.UsageResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /usage [get]
+func GetUsage(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		log.Printf("parse param error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.GetUsageReq
+	if err := model.SchemaDecoder.Decode(&req, r.Form); err != nil {
+		log.Printf("parse query param error: %v", err)
+		http.Error(w, "failed to parse query parameters", http.StatusBadRequest)
+		return
+	}
+
+	params, err := req.CreateParams()
+	if err != nil {
+		if ve, ok := err.(validator.ValidationErrors); ok {
+			log.Printf("input validation failed:\n%v", err)
+			writeErrResponse(w, ve)
+		} else {
+			log.Printf("validate query param error: %v", err)
+			writeErrResponse(w, usecase.NewClientError(errors.New("param validation error")))
+		}
+		return
+	}
+
+	resp, err := usecase.GetUsage(r.Context(), params)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	if req.Format == "csv" {
+		writeUsageCSV(w, resp)
+		return
+	}
+
+	respBody, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("create json response error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
+
+// writeUsageCSV writes the usage as a csv attachment, whose empty latency cells mean no latency is logged
+func writeUsageCSV(w http.ResponseWriter, resp *model.UsageResp) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage_%s_%s_%s.csv"`,
+		resp.MetaData.From, resp.MetaData.To, resp.MetaData.GroupBy))
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write(usageCSVHeader)
+	for _, usage := range resp.UsageList {
+		writer.Write([]string{
+			usage.PeriodStart.Format(time.RFC3339),
+			usage.Group,
+			strconv.Itoa(usage.Calls),
+			strconv.Itoa(usage.BillableCalls),
+			strconv.Itoa(usage.Errors),
+			strconv.FormatFloat(usage.ErrorRate, 'f', -1, 64),
+			formatLatency(usage.LatencyP50Milli),
+			formatLatency(usage.LatencyP95Milli),
+			formatLatency(usage.LatencyP99Milli),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("write csv response error: %v", err)
+	}
+}
+
+func formatLatency(latency *float64) string {
+	if latency == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*latency, 'f', -1, 64)
+}
@@ -0,0 +1,146 @@
+package managementapi_test
+
+import (
+	"encoding/json"
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestGetUsage(t *testing.T) {
+	if _, err := db.Exec("DELETE FROM log_list"); err != nil {
+		t.Fatal(err)
+	}
+	var productID int
+	if err := db.QueryRow(`INSERT INTO product(name, source, display_name, description, thumbnail, swagger_url, base_path, created_at, updated_at)
+		VALUES('usage product', 'source', 'usage', 'description', 'thumbnail', 'example.com/usage', '/usage', current_timestamp, current_timestamp) RETURNING id`).
+		Scan(&productID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM log_list")
+		db.Exec("DELETE FROM product WHERE id = $1", productID)
+	})
+
+	contractID := 1
+	usage := func(runDate, apiKey, path string, statusCode int, billingStatus string, latency int64) gatewayLog {
+		return gatewayLog{Time: runDate, APIKey: apiKey, Path: "api.example.com" + path, Method: http.MethodGet,
+			StatusCode: statusCode, BillingStatus: billingStatus, LatencyMillis: latency, UpstreamHost: "api.example.com",
+			ContractID: &contractID, ProductID: &productID}
+	}
+	// a call of the routing which is not linked to a contract nor generated from a product
+	other := usage("2022-01-01T11:00:00Z", "key2", "/other", http.StatusOK, "billing", 5)
+	other.ContractID, other.ProductID = nil, nil
+	// a call with an unknown billing status is not billable, as it is not charged in the invoices
+	unknownStatus := other
+	unknownStatus.BillingStatus = ""
+	logs := []gatewayLog{
+		usage("2022-01-01T10:00:00Z", "key1", "/usage/a", http.StatusOK, "billing", 10),
+		usage("2022-01-01T10:30:00Z", "key1", "/usage/a", http.StatusInternalServerError, "not billing", 30),
+		other,
+		unknownStatus,
+		usage("2022-01-02T09:00:00Z", "key1", "/usage/b", http.StatusNotFound, "billing", 20),
+		// out of the range
+		usage("2022-01-03T00:00:00Z", "key1", "/usage/a", http.StatusOK, "billing", 10),
+	}
+	for _, l := range logs {
+		insertGatewayLog(t, l)
+	}
+
+	f := func(v float64) *float64 {
+		return &v
+	}
+	day1 := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	day2 := time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []model.Usage
+	}{
+		{
+			name:  "api keyごとに日単位で集計できる",
+			query: "from=2022-01-01&to=2022-01-02",
+			want: []model.Usage{
+				{PeriodStart: day1, Group: "key1", Calls: 2, BillableCalls: 1, Errors: 1, ErrorRate: 0.5,
+					LatencyP50Milli: f(20), LatencyP95Milli: f(29), LatencyP99Milli: f(29.8)},
+				{PeriodStart: day1, Group: "key2", Calls: 2, BillableCalls: 1,
+					LatencyP50Milli: f(5), LatencyP95Milli: f(5), LatencyP99Milli: f(5)},
+				{PeriodStart: day2, Group: "key1", Calls: 1, BillableCalls: 1, Errors: 1, ErrorRate: 1,
+					LatencyP50Milli: f(20), LatencyP95Milli: f(20), LatencyP99Milli: f(20)},
+			},
+		},
+		{
+			name:  "productで絞り込み、時間単位で集計できる",
+			query: "from=2022-01-01&to=2022-01-02&granularity=hour&group_by=product&product_id=" + strconv.Itoa(productID),
+			want: []model.Usage{
+				{PeriodStart: day1.Add(10 * time.Hour), Group: "usage product", Calls: 2, BillableCalls: 1, Errors: 1, ErrorRate: 0.5,
+					LatencyP50Milli: f(20), LatencyP95Milli: f(29), LatencyP99Milli: f(29.8)},
+				{PeriodStart: day2.Add(9 * time.Hour), Group: "usage product", Calls: 1, BillableCalls: 1, Errors: 1, ErrorRate: 1,
+					LatencyP50Milli: f(20), LatencyP95Milli: f(20), LatencyP99Milli: f(20)},
+			},
+		},
+		{
+			name:  "contractごとに集計し、contractのないアクセスログは空文字列にまとめられる",
+			query: "from=2022-01-01&to=2022-01-01&group_by=contract",
+			want: []model.Usage{
+				{PeriodStart: day1, Group: "", Calls: 2, BillableCalls: 1,
+					LatencyP50Milli: f(5), LatencyP95Milli: f(5), LatencyP99Milli: f(5)},
+				{PeriodStart: day1, Group: "1", Calls: 2, BillableCalls: 1, Errors: 1, ErrorRate: 0.5,
+					LatencyP50Milli: f(20), LatencyP95Milli: f(29), LatencyP99Milli: f(29.8)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "localhost:3001/usage?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			managementapi.GetUsage(w, r)
+
+			rw := w.Result()
+			defer rw.Body.Close()
+			if rw.StatusCode != http.StatusOK {
+				t.Fatalf("wrong status code, want %d, got %d", http.StatusOK, rw.StatusCode)
+			}
+			var res model.UsageResp
+			if err := json.NewDecoder(rw.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			// percentiles are interpolated in float
+			if diff := cmp.Diff(tt.want, res.UsageList, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+				t.Errorf("unexpected response: differs=\n%v", diff)
+			}
+		})
+	}
+
+	t.Run("csvで出力できる", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "localhost:3001/usage?from=2022-01-02&to=2022-01-02&group_by=path&format=csv", nil)
+		w := httptest.NewRecorder()
+		managementapi.GetUsage(w, r)
+
+		rw := w.Result()
+		defer rw.Body.Close()
+		if got := rw.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+			t.Errorf("wrong content type: %s", got)
+		}
+		body, err := io.ReadAll(rw.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "period_start,group,calls,billable_calls,errors,error_rate,latency_p50_ms,latency_p95_ms,latency_p99_ms\n" +
+			"2022-01-02T00:00:00Z,api.example.com/usage/b,1,1,1,1,20,20,20\n"
+		if diff := cmp.Diff(want, string(body)); diff != "" {
+			t.Errorf("unexpected csv: differs=\n%v", diff)
+		}
+	})
+}
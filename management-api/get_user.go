@@ -0,0 +1,25 @@
+package managementapi
+
+import (
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+)
+
+// GetUser godoc
+// @Summary Get a user
+// @Description Get the profile of a user
+// @produce json
+// @Param account_id path string true "account id of the user"
+// @Success 200 {object} model.UserResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /users/{account_id} [get]
+func GetUser(w http.ResponseWriter, r *http.Request) {
+	resp, err := usecase.GetUser(r.Context(), chi.URLParam(r, "account_id"))
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, resp)
+}
@@ -0,0 +1,105 @@
+package managementapi_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetUser(t *testing.T) {
+	cleanup := func() {
+		db.Exec("DELETE FROM apiuser")
+	}
+	cleanup()
+	defer cleanup()
+
+	if _, err := db.Exec(`INSERT INTO apiuser(account_id, email_address, login_password_hash, name, created_at, updated_at)
+			VALUES ('user1', 'user1@example.com', crypt('password', gen_salt('bf')), 'name1', current_timestamp, current_timestamp)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO apiuser(account_id, email_address, login_password_hash, name, deleted_at, created_at, updated_at)
+			VALUES ('deleted', 'deleted@example.com', 'password', 'name2', current_timestamp, current_timestamp, current_timestamp)`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		accountID  string
+		wantStatus int
+		wantResp   interface{}
+	}{
+		{
+			name:       "get a user properly",
+			accountID:  "user1",
+			wantStatus: http.StatusOK,
+			wantResp: model.UserResp{
+				AccountID:      "user1",
+				EmailAddress:   "user1@example.com",
+				EmailVerified:  false,
+				Name:           "name1",
+				PermissionFlag: "00",
+			},
+		},
+		{
+			name:       "user does not exist",
+			accountID:  "unknown",
+			wantStatus: http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: "account_id unknown does not exist",
+			},
+		},
+		{
+			name:       "deleted user is not returned",
+			accountID:  "deleted",
+			wantStatus: http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: "account_id deleted does not exist",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("localhost:3000/mgmt/users/%s", tt.accountID), nil)
+			r = withURLParam(r, "account_id", tt.accountID)
+
+			w := httptest.NewRecorder()
+			managementapi.GetUser(w, r)
+
+			rw := w.Result()
+			resp, err := io.ReadAll(rw.Body)
+			if err != nil {
+				t.Errorf("read response body error: %v", err)
+				return
+			}
+
+			if rw.StatusCode != tt.wantStatus {
+				t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, tt.wantStatus)
+			}
+
+			switch want := tt.wantResp.(type) {
+			case model.UserResp:
+				var got model.UserResp
+				if err := json.Unmarshal(resp, &got); err != nil {
+					t.Errorf("parse response body failed: %v", err)
+					return
+				}
+				if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(model.UserResp{}, "CreatedAt", "UpdatedAt")); diff != "" {
+					t.Errorf("response differs:\n%v", diff)
+				}
+			case validator.BadRequestResp:
+				testBadRequestResp(t, &want, resp)
+			default:
+				t.Errorf("type of wantResp is not supported")
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/aws/aws-sdk-go v1.38.0
+	github.com/future-architect/apidoor/secret v0.0.0
 	github.com/ghodss/yaml v1.0.0
 	github.com/go-chi/chi/v5 v5.0.3
 	github.com/go-playground/validator/v10 v10.9.0
@@ -18,3 +19,5 @@ require (
 	golang.org/x/sys v0.0.0-20211124211545-fe61309f8881 // indirect
 //gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 )
+
+replace github.com/future-architect/apidoor/secret => ../secret
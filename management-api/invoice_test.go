@@ -0,0 +1,203 @@
+package managementapi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestGenerateInvoices(t *testing.T) {
+	cleanup := func() {
+		db.Exec("DELETE FROM log_list")
+		db.Exec("DELETE FROM invoice")
+		db.Exec("DELETE FROM invoice_month")
+		db.Exec("DELETE FROM pricing_plan")
+	}
+	cleanup()
+
+	var productIDs [2]int
+	for i, name := range []string{"invoice product a", "invoice product b"} {
+		if err := db.QueryRow(`INSERT INTO product(name, source, display_name, description, thumbnail, swagger_url, base_path, created_at, updated_at)
+			VALUES($1, 'source', $1, 'description', 'thumbnail', 'example.com/invoice', $2, current_timestamp, current_timestamp) RETURNING id`,
+			name, fmt.Sprintf("/invoice%d", i)).Scan(&productIDs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var contractIDs [2]int
+	for i := range contractIDs {
+		if err := db.QueryRow(`INSERT INTO contract(start_at, created_at, updated_at) VALUES(current_timestamp, current_timestamp, current_timestamp) RETURNING id`).
+			Scan(&contractIDs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		cleanup()
+		db.Exec("DELETE FROM contract WHERE id = ANY($1)", fmt.Sprintf("{%d,%d}", contractIDs[0], contractIDs[1]))
+		db.Exec("DELETE FROM product WHERE id = ANY($1)", fmt.Sprintf("{%d,%d}", productIDs[0], productIDs[1]))
+	})
+
+	// product a is charged by tiers after 2 free calls, and product b is charged by a per-call price only in contract 2
+	putPlan := func(productID int, body string) {
+		r := httptest.NewRequest(http.MethodPut,
+			fmt.Sprintf("localhost:3001/mgmt/products/%d/pricing", productID), bytes.NewBufferString(body))
+		r.Header.Add("Content-Type", "application/json")
+		r = withURLParam(r, "id", strconv.Itoa(productID))
+		w := httptest.NewRecorder()
+		managementapi.PutPricingPlan(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("put pricing plan failed: %d %s", w.Code, w.Body.String())
+		}
+	}
+	putPlan(productIDs[0], `{"currency": "JPY", "free_calls": 2,
+		"tiers": [{"up_to": 2, "unit_price_micros": 1000000}, {"up_to": null, "unit_price_micros": 500000}]}`)
+	putPlan(productIDs[1], fmt.Sprintf(`{"contract_id": %d, "currency": "JPY",
+		"tiers": [{"up_to": null, "unit_price_micros": 1500}]}`, contractIDs[1]))
+
+	// the calls are forwarded to the same upstream, so they are attributed only by the ids logged by the gateway
+	insertLogs := func(n int, runDate string, contractID, productID int, billingStatus string) {
+		for i := 0; i < n; i++ {
+			l := gatewayLog{Time: runDate, APIKey: "key", Path: "api.example.com/v1/users", Method: http.MethodGet,
+				StatusCode: http.StatusOK, BillingStatus: billingStatus, UpstreamHost: "api.example.com"}
+			if contractID != 0 {
+				l.ContractID = &contractID
+			}
+			if productID != 0 {
+				l.ProductID = &productID
+			}
+			insertGatewayLog(t, l)
+		}
+	}
+	insertLogs(7, "2022-01-10T00:00:00Z", contractIDs[0], productIDs[0], "billing")
+	insertLogs(3, "2022-01-10T00:00:00Z", contractIDs[0], productIDs[0], "not billing")
+	insertLogs(1, "2022-02-01T00:00:00Z", contractIDs[0], productIDs[0], "billing")
+	insertLogs(3, "2022-01-31T23:59:59Z", contractIDs[1], productIDs[1], "billing")
+	// product b has no default plan
+	insertLogs(2, "2022-01-10T00:00:00Z", contractIDs[0], productIDs[1], "billing")
+	// calls without a contract or a product are not charged
+	insertLogs(1, "2022-01-10T00:00:00Z", 0, productIDs[0], "billing")
+	insertLogs(1, "2022-01-10T00:00:00Z", contractIDs[0], 0, "billing")
+
+	month := time.Date(2022, time.January, 15, 0, 0, 0, 0, time.UTC)
+	generatedAt := time.Date(2022, time.February, 1, 9, 0, 0, 0, time.UTC)
+	// the invoices generated again are the same
+	for i := 0; i < 2; i++ {
+		result, err := usecase.GenerateInvoices(context.Background(), month, generatedAt, false)
+		if err != nil {
+			t.Fatalf("generate invoices failed: %v", err)
+		}
+		want := &model.GenerateInvoicesResult{
+			Month:    "2022-01",
+			Invoices: 2,
+			UnpricedProducts: []model.UnpricedProduct{
+				{ContractID: contractIDs[0], ProductID: productIDs[1], ProductName: "invoice product b", BillableCalls: 2},
+			},
+			UnattributedCalls: 2,
+		}
+		if diff := cmp.Diff(want, result); diff != "" {
+			t.Errorf("result differs:\n%v", diff)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "localhost:3001/mgmt/invoices?month=2022-01", nil)
+	w := httptest.NewRecorder()
+	managementapi.GetInvoices(w, r)
+	var list model.InvoiceListResp
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	billingMonth := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	wantList := []model.Invoice{
+		{ContractID: contractIDs[0], BillingMonth: billingMonth, Currency: "JPY", TotalMicros: 3500000},
+		{ContractID: contractIDs[1], BillingMonth: billingMonth, Currency: "JPY", TotalMicros: 4500},
+	}
+	if diff := cmp.Diff(wantList, list.InvoiceList, cmpopts.IgnoreFields(model.Invoice{}, "ID", "GeneratedAt")); diff != "" {
+		t.Fatalf("invoices differ:\n%v", diff)
+	}
+
+	invoiceID := strconv.Itoa(list.InvoiceList[0].ID)
+	r = httptest.NewRequest(http.MethodGet, "localhost:3001/mgmt/invoices/"+invoiceID+"?format=csv", nil)
+	r = withURLParam(r, "id", invoiceID)
+	w = httptest.NewRecorder()
+	managementapi.GetInvoice(w, r)
+	body, err := io.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCSV := "invoice_id,contract_id,billing_month,currency,product_id,product_name,billable_calls,free_calls,amount\n" +
+		fmt.Sprintf("%s,%d,2022-01,JPY,%d,invoice product a,7,2,3.5\n", invoiceID, contractIDs[0], productIDs[0])
+	if diff := cmp.Diff(wantCSV, string(body)); diff != "" {
+		t.Errorf("csv differs:\n%v", diff)
+	}
+
+	// the invoices of the finalized month are not replaced unless forced
+	if _, err := usecase.FinalizeInvoices(context.Background(), month, generatedAt); err != nil {
+		t.Fatalf("finalize invoices failed: %v", err)
+	}
+	insertLogs(1, "2022-01-20T00:00:00Z", contractIDs[0], productIDs[0], "billing")
+	if _, err := usecase.GenerateInvoices(context.Background(), month, generatedAt, false); !errors.As(err, &usecase.ClientError{}) {
+		t.Errorf("unexpected error %v, expected a client error", err)
+	}
+	var total int64
+	if err := db.QueryRow("SELECT total_micros FROM invoice WHERE contract_id = $1", contractIDs[0]).Scan(&total); err != nil {
+		t.Fatal(err)
+	}
+	if total != 3500000 {
+		t.Errorf("finalized invoice is replaced, total %d", total)
+	}
+	if _, err := usecase.GenerateInvoices(context.Background(), month, generatedAt, true); err != nil {
+		t.Fatalf("generate invoices by force failed: %v", err)
+	}
+	if err := db.QueryRow("SELECT total_micros FROM invoice WHERE contract_id = $1", contractIDs[0]).Scan(&total); err != nil {
+		t.Fatal(err)
+	}
+	if total != 4000000 {
+		t.Errorf("unexpected total %d after generating by force, expected 4000000", total)
+	}
+}
+
+// gatewayLog is a row of log_list inserted by PostgresSink of the gateway, whose api_path is the forwarded host and path
+// and whose custom_log is the json record of JSONAppender
+type gatewayLog struct {
+	Time          string `json:"time"`
+	APIKey        string `json:"api_key"`
+	Path          string `json:"path"`
+	Method        string `json:"method"`
+	StatusCode    int    `json:"status_code"`
+	BillingStatus string `json:"billing_status"`
+	CacheStatus   string `json:"cache_status"`
+	LatencyMillis int64  `json:"latency_ms"`
+	UpstreamHost  string `json:"upstream_host"`
+	RequestBytes  int64  `json:"request_bytes"`
+	ResponseBytes int64  `json:"response_bytes"`
+	ClientIP      string `json:"client_ip"`
+	RequestID     string `json:"request_id"`
+	ContractID    *int   `json:"contract_id"`
+	ProductID     *int   `json:"product_id"`
+	ErrorClass    string `json:"error_class"`
+}
+
+func insertGatewayLog(t *testing.T, l gatewayLog) {
+	t.Helper()
+	custom, err := json.Marshal(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO log_list(run_date, api_key, api_path, custom_log) VALUES($1, $2, $3, $4)",
+		l.Time, l.APIKey, l.Path, string(custom)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,127 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// SenderLog is the MAIL_SENDER value to write mails to the standard logger
+	SenderLog = "log"
+	// SenderFile is the MAIL_SENDER value to write mails to files in MAIL_FILE_DIR
+	SenderFile = "file"
+	// SenderSMTP is the MAIL_SENDER value to send mails to the SMTP server
+	SenderSMTP = "smtp"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers mails to users
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewSenderFromEnv creates the Sender specified by MAIL_SENDER env.
+// if MAIL_SENDER is not set, it returns LogSender
+func NewSenderFromEnv() Sender {
+	switch senderType := os.Getenv("MAIL_SENDER"); senderType {
+	case SenderFile:
+		dir := os.Getenv("MAIL_FILE_DIR")
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		return NewFileSender(dir)
+	case SenderSMTP:
+		return SMTPSender{
+			Addr:     net.JoinHostPort(os.Getenv("SMTP_HOST"), os.Getenv("SMTP_PORT")),
+			Host:     os.Getenv("SMTP_HOST"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	case "", SenderLog:
+		return LogSender{}
+	default:
+		log.Printf("unknown MAIL_SENDER %s, use %s sender", senderType, SenderLog)
+		return LogSender{}
+	}
+}
+
+// LogSender writes mails to the standard logger, it is intended for local development
+type LogSender struct{}
+
+func (ls LogSender) Send(ctx context.Context, msg Message) error {
+	log.Printf("send mail to %s, subject: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileSender writes each mail to a file in Dir, it is intended for local testing
+type FileSender struct {
+	Dir string
+	seq uint64
+}
+
+func NewFileSender(dir string) *FileSender {
+	return &FileSender{
+		Dir: dir,
+	}
+}
+
+func (fs *FileSender) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(fs.Dir, 0755); err != nil {
+		return fmt.Errorf("create mail directory: %w", err)
+	}
+	name := fmt.Sprintf("%s_%d.eml", time.Now().Format("20060102150405"), atomic.AddUint64(&fs.seq, 1))
+	if err := os.WriteFile(filepath.Join(fs.Dir, name), buildMessage("", msg), 0644); err != nil {
+		return fmt.Errorf("write mail file: %w", err)
+	}
+	return nil
+}
+
+// SMTPSender sends mails to the SMTP server with PLAIN authentication.
+// if Username is empty, it sends mails without authentication
+type SMTPSender struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (ss SMTPSender) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if ss.Username != "" {
+		auth = smtp.PlainAuth("", ss.Username, ss.Password, ss.Host)
+	}
+	if err := smtp.SendMail(ss.Addr, auth, ss.From, []string{msg.To}, buildMessage(ss.From, msg)); err != nil {
+		return fmt.Errorf("send mail via smtp: %w", err)
+	}
+	return nil
+}
+
+func buildMessage(from string, msg Message) []byte {
+	var b bytes.Buffer
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
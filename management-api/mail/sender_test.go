@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mails")
+	sender := NewFileSender(dir)
+
+	msgs := []Message{
+		{To: "a@example.com", Subject: "first", Body: "hello\nworld"},
+		{To: "b@example.com", Subject: "second", Body: "bye"},
+	}
+	for _, msg := range msgs {
+		if err := sender.Send(context.Background(), msg); err != nil {
+			t.Fatalf("send mail failed: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read mail directory failed: %v", err)
+	}
+	if len(entries) != len(msgs) {
+		t.Fatalf("wrong number of mail files: got %d, want %d", len(entries), len(msgs))
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatalf("read mail file failed: %v", err)
+	}
+	want := "To: a@example.com\r\nSubject: first\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nhello\r\nworld"
+	if string(got) != want {
+		t.Errorf("wrong mail file content:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestMemorySender(t *testing.T) {
+	sender := &MemorySender{}
+	msg := Message{To: "a@example.com", Subject: "subject", Body: "body"}
+	if err := sender.Send(context.Background(), msg); err != nil {
+		t.Fatalf("send mail failed: %v", err)
+	}
+	got := sender.Messages()
+	if len(got) != 1 || got[0] != msg {
+		t.Errorf("wrong messages: got %v, want [%v]", got, msg)
+	}
+}
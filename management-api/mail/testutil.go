@@ -0,0 +1,28 @@
+package mail
+
+import (
+	"context"
+	"sync"
+)
+
+// MemorySender keeps sent mails in memory so that tests can read them
+type MemorySender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (ms *MemorySender) Send(ctx context.Context, msg Message) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.messages = append(ms.messages, msg)
+	return nil
+}
+
+// Messages returns mails sent so far
+func (ms *MemorySender) Messages() []Message {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ret := make([]Message, len(ms.messages))
+	copy(ret, ms.messages)
+	return ret
+}
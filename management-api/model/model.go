@@ -1,11 +1,17 @@
 package model
 
 import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/future-architect/apidoor/managementapi/validator"
 	"net/url"
+	"reflect"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/gorilla/schema"
 )
@@ -57,6 +63,8 @@ type Product struct {
 	IsAvailableCode int    `json:"is_available" db:"is_available"`
 	CreatedAt       string `json:"created_at" db:"created_at"`
 	UpdatedAt       string `json:"updated_at" db:"updated_at"`
+	// Transform is applied by the gateway to every api of the product
+	Transform *Transform `json:"transform,omitempty" db:"transform"`
 }
 
 type ProductList struct {
@@ -221,8 +229,93 @@ type User struct {
 	LoginPasswordHash string `json:"login_password_hash" db:"login_password_hash"`
 	Name              string `json:"name" db:"name"`
 	PermissionFlag    string `json:"permission_flag" db:"permission_flag"`
-	CreatedAt         string `json:"created_at" db:"created_at"`
-	UpdatedAt         string `json:"updated_at" db:"updated_at"`
+	EmailVerified     bool   `json:"email_verified" db:"email_verified"`
+	// DeletedAt is not nil if the user is deleted
+	DeletedAt *string `json:"deleted_at" db:"deleted_at"`
+	CreatedAt string  `json:"created_at" db:"created_at"`
+	UpdatedAt string  `json:"updated_at" db:"updated_at"`
+}
+
+// UserResp is the user profile returned to clients, which does not contain the password hash
+type UserResp struct {
+	AccountID      string `json:"account_id"`
+	EmailAddress   string `json:"email_address"`
+	EmailVerified  bool   `json:"email_verified"`
+	Name           string `json:"name"`
+	PermissionFlag string `json:"permission_flag"`
+	CreatedAt      string `json:"created_at"`
+	UpdatedAt      string `json:"updated_at"`
+}
+
+func NewUserResp(user User) UserResp {
+	return UserResp{
+		AccountID:      user.AccountID,
+		EmailAddress:   user.EmailAddress,
+		EmailVerified:  user.EmailVerified,
+		Name:           user.Name,
+		PermissionFlag: user.PermissionFlag,
+		CreatedAt:      user.CreatedAt,
+		UpdatedAt:      user.UpdatedAt,
+	}
+}
+
+// PatchUserReq updates the profile of a user, omitted fields are not changed.
+// when the email address is changed, the address is marked as unverified
+type PatchUserReq struct {
+	EmailAddress *string `json:"email_address,omitempty" validate:"omitempty,email"`
+	Name         *string `json:"name,omitempty"`
+}
+
+func (pu *PatchUserReq) UnmarshalJSON(data []byte) error {
+	type Alias PatchUserReq
+	target := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(pu),
+	}
+	return validator.UnmarshalJSON(pu, data, target)
+}
+
+type PatchUserDB struct {
+	ID           int     `db:"id"`
+	EmailAddress *string `db:"email_address"`
+	Name         *string `db:"name"`
+}
+
+type PutUserPasswordReq struct {
+	CurrentPassword string `json:"current_password" validate:"required,printascii"`
+	NewPassword     string `json:"new_password" validate:"required,printascii"`
+}
+
+func (pp *PutUserPasswordReq) UnmarshalJSON(data []byte) error {
+	type Alias PutUserPasswordReq
+	target := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(pp),
+	}
+	return validator.UnmarshalJSON(pp, data, target)
+}
+
+type VerifyEmailReq struct {
+	Token string `json:"token" validate:"required,hexadecimal"`
+}
+
+func (ve *VerifyEmailReq) UnmarshalJSON(data []byte) error {
+	type Alias VerifyEmailReq
+	target := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(ve),
+	}
+	return validator.UnmarshalJSON(ve, data, target)
+}
+
+type EmailVerificationDB struct {
+	UserID       int       `db:"user_id"`
+	EmailAddress string    `db:"email_address"`
+	TokenHash    string    `db:"token_hash"`
+	ExpiresAt    time.Time `db:"expires_at"`
 }
 
 //////////////
@@ -231,16 +324,37 @@ type User struct {
 
 //TODO: 他にcontractに含めるべきカラムの検討
 
+// ContractTimeLayout is the format of start_at and end_at fields in requests
+const ContractTimeLayout = time.RFC3339
+
 type Contract struct {
-	ID        int    `json:"id" db:"id"`
-	UserID    int    `json:"user_id" db:"user_id"`
-	CreatedAt string `json:"created_at" db:"created_at"`
-	UpdatedAt string `json:"updated_at" db:"updated_at"`
+	ID     int `json:"id" db:"id"`
+	UserID int `json:"user_id" db:"user_id"`
+	// StartAt is nil if the contract is created before the contract period is introduced
+	StartAt *string `json:"start_at" db:"start_at"`
+	// EndAt is nil if the contract has no end date
+	EndAt     *string `json:"end_at" db:"end_at"`
+	CreatedAt string  `json:"created_at" db:"created_at"`
+	UpdatedAt string  `json:"updated_at" db:"updated_at"`
+}
+
+type ContractDetail struct {
+	Contract
+	Products []ContractProductDetail `json:"products"`
+}
+
+type ContractProductDetail struct {
+	ID          int    `json:"id" db:"id"`
+	ProductID   int    `json:"product_id" db:"product_id"`
+	ProductName string `json:"product_name" db:"product_name"`
+	Description string `json:"description" db:"description"`
 }
 
 type PostContractReq struct {
 	UserAccountID string              `json:"user_id" validate:"required,printascii"`
 	Products      []*ContractProducts `json:"products" validate:"required,gte=1,dive,required"`
+	StartAt       string              `json:"start_at,omitempty" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	EndAt         string              `json:"end_at,omitempty" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
 }
 
 func (pr *PostContractReq) UnmarshalJSON(data []byte) error {
@@ -260,9 +374,59 @@ type ContractProducts struct {
 
 type PostContractDB struct {
 	UserID   int
+	StartAt  *time.Time
+	EndAt    *time.Time
 	Products []*ContractProductContentDB
 }
 
+type GetContractsReq struct {
+	UserAccountID string `json:"user_account_id" schema:"user_account_id" validate:"required,printascii"`
+	Limit         int    `json:"limit" schema:"limit" validate:"gte=0,lte=100"`
+	Offset        int    `json:"offset" schema:"offset" validate:"gte=0"`
+}
+
+type ContractListMetaData struct {
+	ResultSet ResultSet `json:"result_set"`
+}
+
+type ContractListResp struct {
+	ContractList []Contract           `json:"contract_list"`
+	MetaData     ContractListMetaData `json:"metadata"`
+}
+
+type PatchContractReq struct {
+	// AddProducts is the list of products added to the contract
+	AddProducts []*ContractProducts `json:"add_products,omitempty" validate:"dive,required"`
+	// RemoveProducts is the list of product names removed from the contract
+	RemoveProducts []string `json:"remove_products,omitempty" validate:"dive,required"`
+	EndAt          string   `json:"end_at,omitempty" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+}
+
+func (pc *PatchContractReq) UnmarshalJSON(data []byte) error {
+	type Alias PatchContractReq
+	target := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(pc),
+	}
+	return validator.UnmarshalJSON(pc, data, target)
+}
+
+type PatchContractDB struct {
+	ContractID       int
+	AddProducts      []*ContractProductContentDB
+	RemoveProductIDs []int
+	EndAt            *time.Time
+}
+
+// AuthorizationDB is a row of apikey_contract_product_authorized with the access key and the product linked to it
+type AuthorizationDB struct {
+	ID         int    `db:"id"`
+	AccessKey  string `db:"access_key"`
+	ContractID int    `db:"contract_id"`
+	ProductID  int    `db:"product_id"`
+}
+
 type ContractProductContentDB struct {
 	ProductID   int    `db:"product_name"`
 	Description string `db:"description"`
@@ -276,6 +440,21 @@ type PostAPIRoutingReq struct {
 	ApiKey     string `json:"api_key" validate:"required"`
 	Path       string `json:"path" validate:"required"`
 	ForwardURL string `json:"forward_url" validate:"required,url"`
+	// ForwardHeaders are headers added to the forward request, ex.) {"X-User-Id": "{user_id}"}
+	ForwardHeaders map[string]string `json:"forward_headers,omitempty"`
+	Transform      *Transform        `json:"transform,omitempty"`
+	Cache          *RoutingCache     `json:"cache,omitempty"`
+}
+
+func (pr PostAPIRoutingReq) Routing() Routing {
+	return Routing{
+		APIKey:         pr.ApiKey,
+		Path:           pr.Path,
+		ForwardURL:     pr.ForwardURL,
+		ForwardHeaders: pr.ForwardHeaders,
+		Transform:      pr.Transform,
+		Cache:          pr.Cache,
+	}
 }
 
 func (pr *PostAPIRoutingReq) UnmarshalJSON(data []byte) error {
@@ -285,9 +464,340 @@ func (pr *PostAPIRoutingReq) UnmarshalJSON(data []byte) error {
 	}{
 		Alias: (*Alias)(pr),
 	}
+	if err := validator.UnmarshalJSON(pr, data, target); err != nil {
+		return err
+	}
+	if err := pr.validateTemplate(); err != nil {
+		return err
+	}
+	return pr.Transform.validate("transform")
+}
+
+/////////////////////////
+// product credentials //
+/////////////////////////
+
+// types of SecurityScheme, following OpenAPI 3
+const (
+	SecuritySchemeAPIKey = "apiKey"
+	SecuritySchemeHTTP   = "http"
+	SecuritySchemeOAuth2 = "oauth2"
+)
+
+// SecurityScheme is a scheme of the upstream authentication parsed from the swagger file of a product
+type SecurityScheme struct {
+	Name string `dynamo:"name" json:"name"`
+	// Type is apiKey, http, oauth2 or openIdConnect
+	Type string `dynamo:"type" json:"type"`
+	// In is header, query or cookie for an apiKey scheme
+	In string `dynamo:"in,omitempty" json:"in,omitempty"`
+	// ParamName is the name of the header, query parameter or cookie for an apiKey scheme
+	ParamName string `dynamo:"param_name,omitempty" json:"param_name,omitempty"`
+	// Scheme is the http authentication scheme, bearer or basic are supported
+	Scheme string `dynamo:"scheme,omitempty" json:"scheme,omitempty"`
+	// TokenURL is the token endpoint of the client credentials flow of an oauth2 scheme, empty if the flow is not defined
+	TokenURL string `dynamo:"token_url,omitempty" json:"token_url,omitempty"`
+}
+
+// AccessToken returns the token the gateway adds to requests to authenticate with the credential.
+// a credential of a basic scheme is "user:password", and the one of an oauth2 scheme is "client_id:client_secret".
+// ok is false if the scheme is not supported
+func (ss SecurityScheme) AccessToken(credential string) (token AccessToken, ok bool) {
+	switch {
+	case ss.Type == SecuritySchemeAPIKey && ss.In == string(Header):
+		return AccessToken{ParamType: Header, Key: ss.ParamName, Value: credential}, true
+	case ss.Type == SecuritySchemeAPIKey && ss.In == Query:
+		return AccessToken{ParamType: Query, Key: ss.ParamName, Value: credential}, true
+	case ss.Type == SecuritySchemeAPIKey && ss.In == Cookie:
+		return AccessToken{ParamType: Cookie, Key: ss.ParamName, Value: credential}, true
+	case ss.Type == SecuritySchemeHTTP && strings.EqualFold(ss.Scheme, "bearer"):
+		return AccessToken{ParamType: Header, Key: "Authorization", Value: "Bearer " + credential}, true
+	case ss.Type == SecuritySchemeHTTP && strings.EqualFold(ss.Scheme, "basic"):
+		return AccessToken{ParamType: Header, Key: "Authorization",
+			Value: "Basic " + base64.StdEncoding.EncodeToString([]byte(credential))}, true
+	case ss.Type == SecuritySchemeOAuth2 && ss.TokenURL != "":
+		clientID, clientSecret := credential, ""
+		if i := strings.Index(credential, ":"); i >= 0 {
+			clientID, clientSecret = credential[:i], credential[i+1:]
+		}
+		return AccessToken{ParamType: OAuth2ClientCredentials, ClientID: clientID, ClientSecret: clientSecret,
+			TokenURL: ss.TokenURL}, true
+	}
+	return AccessToken{}, false
+}
+
+// ProductCredentialDB is a credential of a security scheme of a product
+type ProductCredentialDB struct {
+	ProductID  int    `db:"product_id"`
+	SchemeName string `db:"scheme_name"`
+	Value      string `db:"value"`
+}
+
+type PutProductCredentialsReq struct {
+	// Credentials are values of the security schemes keyed by their names, which replace all credentials of the product
+	Credentials map[string]string `json:"credentials" validate:"dive,required"`
+}
+
+func (pr *PutProductCredentialsReq) UnmarshalJSON(data []byte) error {
+	type Alias PutProductCredentialsReq
+	target := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(pr),
+	}
 	return validator.UnmarshalJSON(pr, data, target)
 }
 
+// ProductCredentialsResp tells which security schemes of the product need credentials, values are never returned
+type ProductCredentialsResp struct {
+	ProductID       int                    `json:"product_id"`
+	SecuritySchemes []SecuritySchemeStatus `json:"security_schemes"`
+}
+
+type SecuritySchemeStatus struct {
+	SecurityScheme
+	// Supported reports whether the gateway can add a token of the scheme to requests
+	Supported bool `json:"supported"`
+	// Configured reports whether a credential of the scheme is stored
+	Configured bool `json:"configured"`
+}
+
+// validateTemplate checks that the gateway can parse the path,
+// and every parameter used in the forward url and headers is captured from the path or built in
+func (pr PostAPIRoutingReq) validateTemplate() error {
+	template, err := validator.ParsePathTemplate(pr.Path)
+	if err != nil {
+		return validator.ValidationErrors{
+			{
+				Field:          "path",
+				ConstraintType: "path_template",
+				Message:        err.Error(),
+				Got:            pr.Path,
+			},
+		}
+	}
+
+	params, err := validator.ParseForwardURLParams(pr.ForwardURL)
+	if err != nil {
+		return validator.ValidationErrors{
+			{
+				Field:          "forward_url",
+				ConstraintType: "forward_url",
+				Message:        err.Error(),
+				Got:            pr.ForwardURL,
+			},
+		}
+	}
+
+	captured := make(map[string]struct{})
+	for _, v := range template.Params() {
+		captured[v] = struct{}{}
+	}
+	for _, v := range RoutingBuiltinParams {
+		captured[v] = struct{}{}
+	}
+	for _, v := range params {
+		if _, ok := captured[v]; !ok {
+			return validator.ValidationErrors{
+				{
+					Field:          "forward_url",
+					ConstraintType: "path_params",
+					Message:        fmt.Sprintf("parameter %s does not exist in the path", v),
+					Got:            pr.ForwardURL,
+				},
+			}
+		}
+	}
+
+	names := make([]string, 0, len(pr.ForwardHeaders))
+	for name := range pr.ForwardHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := "forward_headers." + name
+		if err := validator.ValidateHeaderName(name); err != nil {
+			return validator.ValidationErrors{
+				{
+					Field:          field,
+					ConstraintType: "header_name",
+					Message:        err.Error(),
+					Got:            name,
+				},
+			}
+		}
+		value := pr.ForwardHeaders[name]
+		params, err := validator.ParseTemplateParams(value)
+		if err != nil {
+			return validator.ValidationErrors{
+				{
+					Field:          field,
+					ConstraintType: "header_template",
+					Message:        err.Error(),
+					Got:            value,
+				},
+			}
+		}
+		for _, v := range params {
+			if _, ok := captured[v]; !ok {
+				return validator.ValidationErrors{
+					{
+						Field:          field,
+						ConstraintType: "path_params",
+						Message:        fmt.Sprintf("parameter %s does not exist in the path", v),
+						Got:            value,
+					},
+				}
+			}
+		}
+	}
+	return nil
+}
+
+///////////////
+// transform //
+///////////////
+
+// types of TransformStep
+const (
+	// TransformRenameHeader renames the header Name to To
+	TransformRenameHeader = "rename_header"
+	// TransformRemoveHeader removes the header Name, which removes all headers with the prefix if it ends with "*"
+	TransformRemoveHeader = "remove_header"
+	// TransformSetHeader sets the header Name to Value, which must be a string
+	TransformSetHeader = "set_header"
+	// TransformSetJSONField sets the field Name of the json body to Value
+	TransformSetJSONField = "set_json_field"
+	// TransformRemoveJSONField removes the field Name of the json body
+	TransformRemoveJSONField = "remove_json_field"
+	// TransformFormToJSON converts a form body into a json object, only for requests
+	TransformFormToJSON = "form_to_json"
+)
+
+// Transform is a declarative pipeline which the gateway applies to the request before forwarding it,
+// and to the response before returning it. steps are applied in order
+type Transform struct {
+	Request  []TransformStep `json:"request,omitempty" dynamo:"request,omitempty"`
+	Response []TransformStep `json:"response,omitempty" dynamo:"response,omitempty"`
+}
+
+type TransformStep struct {
+	Type string `json:"type" dynamo:"type"`
+	// Name is a header name, or a dot separated path of a json field, ex.) data.internal_id
+	Name string `json:"name,omitempty" dynamo:"name,omitempty"`
+	// To is the new header name of rename_header
+	To    string      `json:"to,omitempty" dynamo:"to,omitempty"`
+	Value interface{} `json:"value,omitempty" dynamo:"value,omitempty"`
+}
+
+// Scan implements sql.Scanner to read a jsonb column
+func (t *Transform) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	default:
+		return fmt.Errorf("unsupported type %T for transform", src)
+	}
+}
+
+// Value implements driver.Valuer to write a jsonb column
+func (t Transform) Value() (driver.Value, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// validate returns validator.ValidationErrors whose fields are prefixed with field, nil transform is valid
+func (t *Transform) validate(field string) error {
+	if t == nil {
+		return nil
+	}
+	if err := validateTransformSteps(field+".request", t.Request, true); err != nil {
+		return err
+	}
+	return validateTransformSteps(field+".response", t.Response, false)
+}
+
+func validateTransformSteps(field string, steps []TransformStep, isRequest bool) error {
+	for i, step := range steps {
+		stepField := fmt.Sprintf("%s[%d]", field, i)
+		if err := step.validate(isRequest); err != nil {
+			return validator.ValidationErrors{
+				{
+					Field:          stepField,
+					ConstraintType: "transform",
+					Message:        err.Error(),
+					Got:            step,
+				},
+			}
+		}
+	}
+	return nil
+}
+
+func (ts TransformStep) validate(isRequest bool) error {
+	switch ts.Type {
+	case TransformRenameHeader:
+		if err := validator.ValidateHeaderName(ts.Name); err != nil {
+			return err
+		}
+		return validator.ValidateHeaderName(ts.To)
+	case TransformRemoveHeader:
+		name := strings.TrimSuffix(ts.Name, "*")
+		if name == "" {
+			return errors.New("name must not be empty")
+		}
+		return validator.ValidateHeaderName(name)
+	case TransformSetHeader:
+		if err := validator.ValidateHeaderName(ts.Name); err != nil {
+			return err
+		}
+		if _, ok := ts.Value.(string); !ok {
+			return errors.New("value of set_header must be a string")
+		}
+		return nil
+	case TransformSetJSONField, TransformRemoveJSONField:
+		for _, v := range strings.Split(ts.Name, ".") {
+			if v == "" {
+				return fmt.Errorf("json field %s contains an empty name", ts.Name)
+			}
+		}
+		if ts.Type == TransformSetJSONField && ts.Value == nil {
+			return errors.New("value of set_json_field must not be null")
+		}
+		return nil
+	case TransformFormToJSON:
+		if !isRequest {
+			return errors.New("form_to_json can be applied only to requests")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown transform type %s", ts.Type)
+	}
+}
+
+type PutProductTransformReq struct {
+	// Transform is removed from the product if it is null
+	Transform *Transform `json:"transform"`
+}
+
+func (pr *PutProductTransformReq) UnmarshalJSON(data []byte) error {
+	type Alias PutProductTransformReq
+	target := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(pr),
+	}
+	if err := validator.UnmarshalJSON(pr, data, target); err != nil {
+		return err
+	}
+	return pr.Transform.validate("transform")
+}
+
 ////////////////
 // api tokens //
 ////////////////
@@ -298,12 +808,24 @@ const (
 	Header          ParamType = "header"
 	Query                     = "query"
 	BodyFormEncoded           = "body_form_encoded"
+	// BodyJSON sets a string field of a json request body, whose key is a json pointer, ex.) /auth/token
+	BodyJSON = "body_json"
+	// BodyMultipart adds a field to a multipart/form-data request body
+	BodyMultipart = "body_multipart"
+	Cookie        = "cookie"
+	// OAuth2ClientCredentials makes the gateway fetch a bearer token from TokenURL with the OAuth2 client credentials grant
+	OAuth2ClientCredentials = "oauth2_client_credentials"
 )
 
 type AccessToken struct {
-	ParamType ParamType `dynamo:"param_type" json:"param_type" validate:"required,eq=header|eq=query|eq=body_from_encoded"`
-	Key       string    `dynamo:"key" json:"key" validate:"required"`
-	Value     string    `dynamo:"value" json:"value" validate:"required"`
+	ParamType ParamType `dynamo:"param_type" json:"param_type" validate:"required,eq=header|eq=query|eq=body_form_encoded|eq=body_json|eq=body_multipart|eq=cookie|eq=oauth2_client_credentials"`
+	Key       string    `dynamo:"key" json:"key" validate:"required_unless=ParamType oauth2_client_credentials"`
+	Value     string    `dynamo:"value" json:"value" validate:"required_unless=ParamType oauth2_client_credentials"`
+	// ClientID, ClientSecret, TokenURL and Scopes are used by the oauth2_client_credentials type
+	ClientID     string   `dynamo:"client_id,omitempty" json:"client_id,omitempty" validate:"required_if=ParamType oauth2_client_credentials"`
+	ClientSecret string   `dynamo:"client_secret,omitempty" json:"client_secret,omitempty" validate:"required_if=ParamType oauth2_client_credentials"`
+	TokenURL     string   `dynamo:"token_url,omitempty" json:"token_url,omitempty" validate:"required_if=ParamType oauth2_client_credentials,omitempty,url"`
+	Scopes       []string `dynamo:"scopes,omitempty" json:"scopes,omitempty"`
 }
 
 type PostAPITokenReq struct {
@@ -322,6 +844,19 @@ func (pp *PostAPITokenReq) UnmarshalJSON(data []byte) error {
 	return validator.UnmarshalJSON(pp, data, target)
 }
 
+// ReencryptResult is the result of re-encrypting stored access tokens or product credentials with the current key
+type ReencryptResult struct {
+	// Scanned is the number of stored token sets, each of which is the tokens of an api key and a path,
+	// or the number of stored product credentials
+	Scanned int `json:"scanned"`
+	// Reencrypted is the number of token sets or credentials encrypted with an old key or stored in plaintext,
+	// which are written again
+	Reencrypted int `json:"reencrypted"`
+	// Failed is the keys, <api_key>#<path> of token sets or <product_id>#<scheme_name> of credentials,
+	// which could not be re-encrypted
+	Failed []string `json:"failed"`
+}
+
 type DeleteAPITokenReq struct {
 	APIKey string `json:"api_key" schema:"api_key" validate:"required"`
 	Path   string `json:"path" schema:"path" validate:"required"`
@@ -415,11 +950,118 @@ type ContractProductDB struct {
 	ProductID  int `db:"product_id"`
 }
 
+type AuthorizedAPIKeyDB struct {
+	APIKeyID   int    `db:"apikey_id"`
+	AccessKey  string `db:"access_key"`
+	ContractID int    `db:"contract_id"`
+}
+
+// RoutingBuiltinParams are placeholders which the gateway fills with values of the routing, not of the request path
+var RoutingBuiltinParams = []string{"apikey_id", "contract_id"}
+
 type Routing struct {
-	APIKey     string `dynamo:"api_key"`
-	Path       string `dynamo:"path"`
-	ForwardURL string `dynamo:"forward_url"`
-	ContractID int    `dynamo:"contract_id"`
+	APIKey string `dynamo:"api_key" json:"api_key"`
+	Path   string `dynamo:"path" json:"path"`
+	// ForwardURL may contain placeholders in its path and query string, ex.) https://example.com/users?id={user_id}
+	ForwardURL string `dynamo:"forward_url" json:"forward_url"`
+	// ContractID is 0 if the routing is not linked to any contract
+	ContractID int `dynamo:"contract_id" json:"contract_id"`
+	// APIKeyID is the id of the api key, 0 if it is unknown
+	APIKeyID int `dynamo:"apikey_id" json:"apikey_id"`
+	// ProductID is the id of the product the routing is generated from, 0 if it is not generated from a product.
+	// the gateway writes it to access logs, which calls are billed to the product by
+	ProductID int `dynamo:"product_id,omitempty" json:"product_id,omitempty"`
+	// ForwardHeaders are headers added to the forward request, whose values may contain placeholders
+	ForwardHeaders map[string]string `dynamo:"forward_headers,omitempty" json:"forward_headers,omitempty"`
+	// Transform is given by the routing itself, or copied from the product the routing is generated from
+	Transform *Transform `dynamo:"transform,omitempty" json:"transform,omitempty"`
+	// Cache enables the response cache of the gateway for GET requests, nil if disabled
+	Cache *RoutingCache `dynamo:"cache,omitempty" json:"cache,omitempty"`
+	// Operations are copied from the api the routing is generated from, which the gateway validates requests against
+	Operations []Operation `dynamo:"operations,omitempty" json:"operations,omitempty"`
+}
+
+// RoutingCache is the response cache setting of a routing, the gateway follows Cache-Control, Expires, ETag and Vary of the response
+type RoutingCache struct {
+	// DefaultTTL is the seconds a response without Cache-Control or Expires is fresh, 0 means it is not cached
+	DefaultTTL int `dynamo:"default_ttl" json:"default_ttl" validate:"gte=0"`
+	// BillHits reports whether responses served from the cache without calling the api are billed
+	BillHits bool `dynamo:"bill_hits" json:"bill_hits"`
+}
+
+func (r Routing) Equal(o Routing) bool {
+	if !reflect.DeepEqual(r.Transform, o.Transform) || !reflect.DeepEqual(r.Cache, o.Cache) ||
+		!reflect.DeepEqual(r.Operations, o.Operations) {
+		return false
+	}
+	if r.APIKey != o.APIKey || r.Path != o.Path || r.ForwardURL != o.ForwardURL ||
+		r.ContractID != o.ContractID || r.APIKeyID != o.APIKeyID || r.ProductID != o.ProductID ||
+		len(r.ForwardHeaders) != len(o.ForwardHeaders) {
+		return false
+	}
+	for k, v := range r.ForwardHeaders {
+		if ov, ok := o.ForwardHeaders[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+type GetRoutingsReq struct {
+	APIKey string `json:"api_key" schema:"api_key" validate:"required"`
+	Limit  int    `json:"limit" schema:"limit" validate:"gte=0,lte=100"`
+	Offset int    `json:"offset" schema:"offset" validate:"gte=0"`
+}
+
+type RoutingListMetaData struct {
+	ResultSet ResultSet `json:"result_set"`
+}
+
+type RoutingListResp struct {
+	RoutingList []Routing           `json:"routing_list"`
+	MetaData    RoutingListMetaData `json:"metadata"`
+}
+
+type DeleteRoutingReq struct {
+	APIKey string `json:"api_key" schema:"api_key" validate:"required"`
+	Path   string `json:"path" schema:"path" validate:"required"`
+}
+
+// BatchRoutingResult is the result of writing or deleting routings in batches
+type BatchRoutingResult struct {
+	// Processed is the number of routings written or deleted
+	Processed int
+	// Failed is the list of routings which are not processed even after retries
+	Failed []Routing
+}
+
+////////////////////
+// routing outbox //
+////////////////////
+
+// RoutingOutboxEvent is a change to be applied to the routing store, written in the same transaction as PostgreSQL
+type RoutingOutboxEvent struct {
+	ID        int64  `db:"id"`
+	EventType string `db:"event_type"`
+	Payload   []byte `db:"payload"`
+	Attempts  int    `db:"attempts"`
+}
+
+// ReconcileReport is the result of comparing PostgreSQL with the routing store
+type ReconcileReport struct {
+	CheckedAPIKeys int `json:"checked_api_keys"`
+	// SkippedAPIKeys is the number of keys not checked because swagger info of their products is missing
+	SkippedAPIKeys  int `json:"skipped_api_keys"`
+	MissingSwaggers int `json:"missing_swaggers"`
+	// StaleSwaggers is the number of swagger info whose transformation differs from the product
+	StaleSwaggers    int `json:"stale_swaggers"`
+	MissingRoutings  int `json:"missing_routings"`
+	StaleRoutings    int `json:"stale_routings"`
+	ExtraRoutings    int `json:"extra_routings"`
+	PostedSwaggers   int `json:"posted_swaggers"`
+	PostedRoutings   int `json:"posted_routings"`
+	DeletedRoutings  int `json:"deleted_routings"`
+	UnresolvedErrors int `json:"unresolved_errors"`
 }
 
 /////////////
@@ -432,9 +1074,418 @@ type Swagger struct {
 	ForwardURLBase string   `dynamo:"forward_url_base"`
 	PathBase       string   `dynamo:"path_base"`
 	APIList        []API    `dynamo:"api_list"`
+	// Transform is the transformation of the product, which is copied into every routing generated from the swagger
+	Transform *Transform `dynamo:"transform,omitempty"`
+	// SecuritySchemes are the schemes of the upstream authentication, which are sorted by their names
+	SecuritySchemes []SecurityScheme `dynamo:"security_schemes,omitempty"`
+}
+
+// BatchSwaggerResult is the result of getting swagger info in batches
+type BatchSwaggerResult struct {
+	Swaggers []Swagger
+	// MissingProductIDs is the list of products whose swagger info is not stored
+	MissingProductIDs []int
 }
 
 type API struct {
-	ForwardURL string `dynamo:"forward_url"`
-	Path       string `dynamo:"path"`
+	ForwardURL string `dynamo:"forward_url" json:"forward_url"`
+	Path       string `dynamo:"path" json:"path"`
+	// Operations are parsed from the swagger file, which the gateway validates requests against
+	Operations []Operation `dynamo:"operations,omitempty" json:"operations,omitempty"`
+	// Security is names of the security schemes required by any operation of the api
+	Security []string `dynamo:"security,omitempty" json:"security,omitempty"`
+}
+
+// Operation is an operation of an api which has parameters or a json request body to be validated
+type Operation struct {
+	// Method is an upper case http method, ex.) GET
+	Method     string               `dynamo:"method" json:"method"`
+	Parameters []OperationParameter `dynamo:"parameters,omitempty" json:"parameters,omitempty"`
+	// RequestBody is the json encoded json schema of the request body, empty if not defined
+	RequestBody         string `dynamo:"request_body,omitempty" json:"request_body,omitempty"`
+	RequestBodyRequired bool   `dynamo:"request_body_required" json:"request_body_required"`
+}
+
+type OperationParameter struct {
+	Name string `dynamo:"name" json:"name"`
+	// In is path, query or header
+	In       string `dynamo:"in" json:"in"`
+	Required bool   `dynamo:"required" json:"required"`
+	// Schema is the json encoded json schema of the parameter
+	Schema string `dynamo:"schema" json:"schema"`
+}
+
+type SwaggerRefreshResp struct {
+	ProductID       int   `json:"product_id"`
+	AddedAPIs       []API `json:"added_apis"`
+	RemovedAPIs     []API `json:"removed_apis"`
+	UpdatedAPIs     []API `json:"updated_apis"`
+	AffectedAPIKeys int   `json:"affected_api_keys"`
+	// PostedRoutings and DeletedRoutings are the numbers of routings to be posted and deleted through the outbox
+	PostedRoutings  int `json:"posted_routings"`
+	DeletedRoutings int `json:"deleted_routings"`
+}
+
+///////////
+// usage //
+///////////
+
+// UsageDateLayout is the format of from and to of usage requests
+const UsageDateLayout = "2006-01-02"
+
+// max number of days in a usage report of each granularity
+var usageMaxDays = map[string]int{
+	"hour": 31,
+	"day":  366,
+}
+
+type GetUsageReq struct {
+	// From and To are the first and the last day of the report in UTC, both inclusive
+	From        string `json:"from" schema:"from" validate:"required,datetime=2006-01-02"`
+	To          string `json:"to" schema:"to" validate:"required,datetime=2006-01-02"`
+	Granularity string `json:"granularity" schema:"granularity" validate:"omitempty,eq=day|eq=hour"`
+	GroupBy     string `json:"group_by" schema:"group_by" validate:"omitempty,eq=key|eq=product|eq=contract|eq=path"`
+	APIKey      string `json:"api_key" schema:"api_key"`
+	ProductID   int    `json:"product_id" schema:"product_id" validate:"gte=0"`
+	ContractID  int    `json:"contract_id" schema:"contract_id" validate:"gte=0"`
+	Path        string `json:"path" schema:"path"`
+	Format      string `json:"format" schema:"format" validate:"omitempty,eq=json|eq=csv"`
+}
+
+func (ur GetUsageReq) CreateParams() (*UsageParams, error) {
+	if err := validator.ValidateStruct(ur); err != nil {
+		return nil, err
+	}
+	from, err := time.Parse(UsageDateLayout, ur.From)
+	if err != nil {
+		return nil, fmt.Errorf("parse from error: %w", err)
+	}
+	to, err := time.Parse(UsageDateLayout, ur.To)
+	if err != nil {
+		return nil, fmt.Errorf("parse to error: %w", err)
+	}
+
+	granularity := ur.Granularity
+	if granularity == "" {
+		granularity = "day"
+	}
+	groupBy := ur.GroupBy
+	if groupBy == "" {
+		groupBy = "key"
+	}
+
+	days := int(to.Sub(from).Hours()/24) + 1
+	if days < 1 {
+		return nil, validator.ValidationErrors{
+			{
+				Field:          "to",
+				ConstraintType: "gte",
+				Message:        fmt.Sprintf("input value is %s, but it must be greater than or equal to %s", ur.To, ur.From),
+				Gte:            ur.From,
+				Got:            ur.To,
+			},
+		}
+	}
+	if maxDays := usageMaxDays[granularity]; days > maxDays {
+		return nil, validator.ValidationErrors{
+			{
+				Field:          "to",
+				ConstraintType: "usage_range",
+				Message:        fmt.Sprintf("the range is %d days, but it must be within %d days for granularity %s", days, maxDays, granularity),
+				Got:            ur.To,
+			},
+		}
+	}
+
+	return &UsageParams{
+		From:        from,
+		To:          to.AddDate(0, 0, 1),
+		Granularity: granularity,
+		GroupBy:     groupBy,
+		APIKey:      ur.APIKey,
+		ProductID:   ur.ProductID,
+		ContractID:  ur.ContractID,
+		Path:        ur.Path,
+	}, nil
+}
+
+// UsageParams is the condition of a usage report, which aggregates access logs run in [From, To)
+type UsageParams struct {
+	From        time.Time
+	To          time.Time
+	Granularity string
+	GroupBy     string
+	// APIKey, ProductID, ContractID and Path filter access logs if they are not zero values
+	APIKey     string
+	ProductID  int
+	ContractID int
+	Path       string
+}
+
+// Usage is the usage of a group in a period. the group is an api key, a product name, a contract id or a path,
+// which is empty if access logs cannot be linked to a product or a contract.
+// latency percentiles are nil if no access log of the group has latency_ms
+type Usage struct {
+	PeriodStart   time.Time `json:"period_start" db:"period_start"`
+	Group         string    `json:"group" db:"group_key"`
+	Calls         int       `json:"calls" db:"calls"`
+	BillableCalls int       `json:"billable_calls" db:"billable_calls"`
+	// Errors is the number of calls whose status code is 400 or more
+	Errors          int      `json:"errors" db:"errors"`
+	ErrorRate       float64  `json:"error_rate" db:"error_rate"`
+	LatencyP50Milli *float64 `json:"latency_p50_ms" db:"latency_p50_ms"`
+	LatencyP95Milli *float64 `json:"latency_p95_ms" db:"latency_p95_ms"`
+	LatencyP99Milli *float64 `json:"latency_p99_ms" db:"latency_p99_ms"`
+}
+
+type UsageMetaData struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Granularity string `json:"granularity"`
+	GroupBy     string `json:"group_by"`
+}
+
+type UsageResp struct {
+	UsageList []Usage       `json:"usage_list"`
+	MetaData  UsageMetaData `json:"metadata"`
+}
+
+/////////////
+// invoice //
+/////////////
+
+// InvoiceMonthLayout is the format of billing months
+const InvoiceMonthLayout = "2006-01"
+
+// PricingTier charges UnitPriceMicros per call until the number of charged calls reaches UpTo.
+// prices are in micro units of the currency, ex.) 10000 is 0.01 USD
+type PricingTier struct {
+	// UpTo is the cumulative number of charged calls, nil for no limit
+	UpTo            *int64 `json:"up_to"`
+	UnitPriceMicros int64  `json:"unit_price_micros" validate:"gte=0"`
+}
+
+// PricingTiers is a graduated price, each call after the free calls is charged by the tier it falls in.
+// a per-call price is a single tier without UpTo
+type PricingTiers []PricingTier
+
+// Scan implements sql.Scanner to read a jsonb column
+func (pt *PricingTiers) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, pt)
+	case string:
+		return json.Unmarshal([]byte(v), pt)
+	default:
+		return fmt.Errorf("unsupported type %T for pricing tiers", src)
+	}
+}
+
+// Value implements driver.Valuer to write a jsonb column
+func (pt PricingTiers) Value() (driver.Value, error) {
+	b, err := json.Marshal(pt)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// validate checks that UpTo increases and only the last tier has no limit
+func (pt PricingTiers) validate(field string) error {
+	var prev int64
+	for i, tier := range pt {
+		tierField := fmt.Sprintf("%s[%d].up_to", field, i)
+		last := i == len(pt)-1
+		if tier.UpTo == nil {
+			if !last {
+				return validator.ValidationErrors{
+					{
+						Field:          tierField,
+						ConstraintType: "pricing_tier",
+						Message:        "only the last tier can have no limit",
+					},
+				}
+			}
+			continue
+		}
+		if last || *tier.UpTo <= prev {
+			return validator.ValidationErrors{
+				{
+					Field:          tierField,
+					ConstraintType: "pricing_tier",
+					Message:        fmt.Sprintf("input value is %d, but it must be greater than the previous tier, %d, and the last tier must have no limit", *tier.UpTo, prev),
+					Got:            *tier.UpTo,
+				},
+			}
+		}
+		prev = *tier.UpTo
+	}
+	return nil
+}
+
+type PricingPlan struct {
+	ID        int `json:"id" db:"id"`
+	ProductID int `json:"product_id" db:"product_id"`
+	// ContractID is nil if the plan is the default of the product
+	ContractID *int         `json:"contract_id" db:"contract_id"`
+	Currency   string       `json:"currency" db:"currency"`
+	FreeCalls  int64        `json:"free_calls" db:"free_calls"`
+	Tiers      PricingTiers `json:"tiers" db:"tiers"`
+	CreatedAt  string       `json:"created_at" db:"created_at"`
+	UpdatedAt  string       `json:"updated_at" db:"updated_at"`
+}
+
+// Charge returns the number of free calls and the amount in micro units for the billable calls of a month
+func (pp PricingPlan) Charge(billableCalls int64) (freeCalls, amountMicros int64) {
+	freeCalls = billableCalls
+	if freeCalls > pp.FreeCalls {
+		freeCalls = pp.FreeCalls
+	}
+	charged := billableCalls - freeCalls
+	var prev int64
+	for _, tier := range pp.Tiers {
+		n := charged - prev
+		if tier.UpTo != nil && *tier.UpTo-prev < n {
+			n = *tier.UpTo - prev
+		}
+		if n <= 0 {
+			break
+		}
+		amountMicros += n * tier.UnitPriceMicros
+		if tier.UpTo == nil {
+			break
+		}
+		prev = *tier.UpTo
+	}
+	return freeCalls, amountMicros
+}
+
+// PutPricingPlanReq replaces the default plan of a product, or the plan of the product in a contract
+type PutPricingPlanReq struct {
+	ContractID *int         `json:"contract_id,omitempty" validate:"omitempty,gte=1"`
+	Currency   string       `json:"currency" validate:"required,iso4217"`
+	FreeCalls  int64        `json:"free_calls" validate:"gte=0"`
+	Tiers      PricingTiers `json:"tiers" validate:"required,gte=1,dive"`
+}
+
+func (pr *PutPricingPlanReq) UnmarshalJSON(data []byte) error {
+	type Alias PutPricingPlanReq
+	target := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(pr),
+	}
+	if err := validator.UnmarshalJSON(pr, data, target); err != nil {
+		return err
+	}
+	return pr.Tiers.validate("tiers")
+}
+
+type Invoice struct {
+	ID         int `json:"id" db:"id"`
+	ContractID int `json:"contract_id" db:"contract_id"`
+	// BillingMonth is the first day of the month in UTC
+	BillingMonth time.Time `json:"billing_month" db:"billing_month"`
+	Currency     string    `json:"currency" db:"currency"`
+	TotalMicros  int64     `json:"total_micros" db:"total_micros"`
+	GeneratedAt  time.Time `json:"generated_at" db:"generated_at"`
+}
+
+// InvoiceItem is the charge of a product in an invoice, whose pricing is the plan applied when the invoice is generated
+type InvoiceItem struct {
+	ProductID     int            `json:"product_id" db:"product_id"`
+	ProductName   string         `json:"product_name" db:"product_name"`
+	BillableCalls int64          `json:"billable_calls" db:"billable_calls"`
+	FreeCalls     int64          `json:"free_calls" db:"free_calls"`
+	AmountMicros  int64          `json:"amount_micros" db:"amount_micros"`
+	Pricing       InvoicePricing `json:"pricing" db:"pricing"`
+}
+
+// InvoicePricing is the snapshot of the pricing plan applied to an invoice item
+type InvoicePricing struct {
+	PlanID int `json:"plan_id"`
+	// ContractID is nil if the default plan of the product is applied
+	ContractID *int         `json:"contract_id"`
+	FreeCalls  int64        `json:"free_calls"`
+	Tiers      PricingTiers `json:"tiers"`
+}
+
+// Scan implements sql.Scanner to read a jsonb column
+func (ip *InvoicePricing) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, ip)
+	case string:
+		return json.Unmarshal([]byte(v), ip)
+	default:
+		return fmt.Errorf("unsupported type %T for invoice pricing", src)
+	}
+}
+
+// Value implements driver.Valuer to write a jsonb column
+func (ip InvoicePricing) Value() (driver.Value, error) {
+	b, err := json.Marshal(ip)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+type InvoiceDetail struct {
+	Invoice
+	Items []InvoiceItem `json:"items"`
+}
+
+// BillableCallsDB is the number of billable calls of a product in a contract in a month
+type BillableCallsDB struct {
+	ContractID    int    `db:"contract_id"`
+	ProductID     int    `db:"product_id"`
+	ProductName   string `db:"product_name"`
+	BillableCalls int64  `db:"billable_calls"`
+}
+
+type GetInvoicesReq struct {
+	ContractID int    `json:"contract_id" schema:"contract_id" validate:"gte=0"`
+	Month      string `json:"month" schema:"month" validate:"omitempty,datetime=2006-01"`
+	Limit      int    `json:"limit" schema:"limit" validate:"gte=0,lte=100"`
+	Offset     int    `json:"offset" schema:"offset" validate:"gte=0"`
+}
+
+type InvoiceListMetaData struct {
+	ResultSet ResultSet `json:"result_set"`
+}
+
+type InvoiceListResp struct {
+	InvoiceList []Invoice           `json:"invoice_list"`
+	MetaData    InvoiceListMetaData `json:"metadata"`
+}
+
+// GenerateInvoicesResult is the result of generating invoices of a month
+type GenerateInvoicesResult struct {
+	Month    string `json:"month"`
+	Invoices int    `json:"invoices"`
+	// UnpricedProducts are products with billable calls but without a pricing plan, which are not charged
+	UnpricedProducts []UnpricedProduct `json:"unpriced_products"`
+	// UnattributedCalls is the number of billable calls without a contract or a product, which are not charged
+	UnattributedCalls int64 `json:"unattributed_calls"`
+	// FinalizedAt is the time when the month is finalized, which is nil if it is not finalized
+	FinalizedAt *time.Time `json:"finalized_at,omitempty"`
+}
+
+type UnpricedProduct struct {
+	ContractID    int    `json:"contract_id"`
+	ProductID     int    `json:"product_id"`
+	ProductName   string `json:"product_name"`
+	BillableCalls int64  `json:"billable_calls"`
+}
+
+// FormatMicros formats an amount in micro units as a decimal number, ex.) 1234500 is "1.2345"
+func FormatMicros(micros int64) string {
+	sign := ""
+	if micros < 0 {
+		sign = "-"
+		micros = -micros
+	}
+	s := fmt.Sprintf("%s%d.%06d", sign, micros/1000000, micros%1000000)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
 }
@@ -3,6 +3,7 @@ package model
 import (
 	"github.com/future-architect/apidoor/managementapi/validator"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -223,3 +224,590 @@ func testValidateErrors(t *testing.T, want validator.ValidationErrors, got error
 	}
 
 }
+
+func TestPostAPIRoutingReq_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr validator.ValidationErrors
+	}{
+		{
+			name:  "forward url uses parameters of the path",
+			input: `{"api_key": "key", "path": "/users/{user_id}", "forward_url": "https://example.com/users/{user_id}"}`,
+		},
+		{
+			name: "query string and headers use parameters of the path and built-in parameters",
+			input: `{"api_key": "key", "path": "/users/{user_id}", "forward_url": "https://example.com/users?id={user_id}&contract={contract_id}",
+				"forward_headers": {"X-User-Id": "{user_id}", "X-Consumer": "key-{apikey_id}"}}`,
+		},
+		{
+			name:  "header uses a parameter which the path does not have",
+			input: `{"api_key": "key", "path": "/users", "forward_url": "https://example.com/users", "forward_headers": {"X-User-Id": "{user_id}"}}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "forward_headers.X-User-Id",
+					ConstraintType: "path_params",
+					Message:        "parameter user_id does not exist in the path",
+					Got:            "{user_id}",
+				},
+			},
+		},
+		{
+			name:  "header which the gateway manages",
+			input: `{"api_key": "key", "path": "/users", "forward_url": "https://example.com/users", "forward_headers": {"host": "example.com"}}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "forward_headers.host",
+					ConstraintType: "header_name",
+					Message:        "header host cannot be set",
+					Got:            "host",
+				},
+			},
+		},
+		{
+			name: "transform is validated",
+			input: `{"api_key": "key", "path": "/users", "forward_url": "https://example.com/users",
+				"transform": {"request": [{"type": "set_json_field", "name": "source"}]}}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "transform.request[0]",
+					ConstraintType: "transform",
+					Message:        "value of set_json_field must not be null",
+					Got:            TransformStep{Type: TransformSetJSONField, Name: "source"},
+				},
+			},
+		},
+		{
+			name: "default ttl of the cache is negative",
+			input: `{"api_key": "key", "path": "/users", "forward_url": "https://example.com/users",
+				"cache": {"default_ttl": -1, "bill_hits": true}}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "cache.default_ttl",
+					ConstraintType: "gte",
+					Message:        "input value is -1, but it must be greater than or equal to 0",
+					Gte:            "0",
+					Got:            -1,
+				},
+			},
+		},
+		{
+			name:  "path is not a valid template",
+			input: `{"api_key": "key", "path": "/users/{id}/items/{id}", "forward_url": "https://example.com/users"}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "path",
+					ConstraintType: "path_template",
+					Message:        "parameter id is duplicated",
+					Got:            "/users/{id}/items/{id}",
+				},
+			},
+		},
+		{
+			name:  "forward url has an unsupported scheme",
+			input: `{"api_key": "key", "path": "/users", "forward_url": "ftp://example.com/users"}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "forward_url",
+					ConstraintType: "forward_url",
+					Message:        "scheme must be http or https, but got ftp",
+					Got:            "ftp://example.com/users",
+				},
+			},
+		},
+		{
+			name:  "forward url uses a parameter which the path does not have",
+			input: `{"api_key": "key", "path": "/users/{id}", "forward_url": "https://example.com/users/{user_id}"}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "forward_url",
+					ConstraintType: "path_params",
+					Message:        "parameter user_id does not exist in the path",
+					Got:            "https://example.com/users/{user_id}",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req PostAPIRoutingReq
+			err := req.UnmarshalJSON([]byte(tt.input))
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if diff := cmp.Diff(tt.wantErr, err); diff != "" {
+				t.Errorf("error differs:\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestPutProductTransformReq_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *Transform
+		wantErr validator.ValidationErrors
+	}{
+		{
+			name: "every type of steps",
+			input: `{"transform": {
+				"request": [
+					{"type": "form_to_json"},
+					{"type": "rename_header", "name": "X-Legacy-Id", "to": "X-Id"},
+					{"type": "set_json_field", "name": "meta.version", "value": 2}
+				],
+				"response": [
+					{"type": "remove_header", "name": "X-Internal-*"},
+					{"type": "set_header", "name": "Cache-Control", "value": "no-store"},
+					{"type": "remove_json_field", "name": "internal_id"}
+				]}}`,
+			want: &Transform{
+				Request: []TransformStep{
+					{Type: TransformFormToJSON},
+					{Type: TransformRenameHeader, Name: "X-Legacy-Id", To: "X-Id"},
+					{Type: TransformSetJSONField, Name: "meta.version", Value: float64(2)},
+				},
+				Response: []TransformStep{
+					{Type: TransformRemoveHeader, Name: "X-Internal-*"},
+					{Type: TransformSetHeader, Name: "Cache-Control", Value: "no-store"},
+					{Type: TransformRemoveJSONField, Name: "internal_id"},
+				},
+			},
+		},
+		{
+			name:  "null removes the transformation",
+			input: `{"transform": null}`,
+		},
+		{
+			name:  "form_to_json is applied to a response",
+			input: `{"transform": {"response": [{"type": "form_to_json"}]}}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "transform.response[0]",
+					ConstraintType: "transform",
+					Message:        "form_to_json can be applied only to requests",
+					Got:            TransformStep{Type: TransformFormToJSON},
+				},
+			},
+		},
+		{
+			name:  "header which the gateway manages",
+			input: `{"transform": {"request": [{"type": "rename_header", "name": "X-Length", "to": "Content-Length"}]}}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "transform.request[0]",
+					ConstraintType: "transform",
+					Message:        "header Content-Length cannot be set",
+					Got:            TransformStep{Type: TransformRenameHeader, Name: "X-Length", To: "Content-Length"},
+				},
+			},
+		},
+		{
+			name:  "value of set_header is not a string",
+			input: `{"transform": {"request": [{"type": "set_header", "name": "X-Version", "value": 1}]}}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "transform.request[0]",
+					ConstraintType: "transform",
+					Message:        "value of set_header must be a string",
+					Got:            TransformStep{Type: TransformSetHeader, Name: "X-Version", Value: float64(1)},
+				},
+			},
+		},
+		{
+			name:  "json field has an empty name",
+			input: `{"transform": {"response": [{"type": "remove_json_field", "name": "data..id"}]}}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "transform.response[0]",
+					ConstraintType: "transform",
+					Message:        "json field data..id contains an empty name",
+					Got:            TransformStep{Type: TransformRemoveJSONField, Name: "data..id"},
+				},
+			},
+		},
+		{
+			name:  "unknown type",
+			input: `{"transform": {"request": [{"type": "rewrite_body"}]}}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "transform.request[0]",
+					ConstraintType: "transform",
+					Message:        "unknown transform type rewrite_body",
+					Got:            TransformStep{Type: "rewrite_body"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req PutProductTransformReq
+			err := req.UnmarshalJSON([]byte(tt.input))
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				if diff := cmp.Diff(tt.want, req.Transform); diff != "" {
+					t.Errorf("transform differs:\n%v", diff)
+				}
+				return
+			}
+			if diff := cmp.Diff(tt.wantErr, err); diff != "" {
+				t.Errorf("error differs:\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestTransform_Scan(t *testing.T) {
+	want := Transform{
+		Response: []TransformStep{
+			{Type: TransformRemoveHeader, Name: "X-Internal-*"},
+		},
+	}
+	value, err := want.Value()
+	if err != nil {
+		t.Fatalf("value error: %v", err)
+	}
+	var got Transform
+	if err = got.Scan([]byte(value.(string))); err != nil {
+		t.Fatalf("scan error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("transform differs:\n%v", diff)
+	}
+}
+
+func TestSecurityScheme_AccessToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme SecurityScheme
+		want   AccessToken
+		wantOK bool
+	}{
+		{
+			name:   "api key in header",
+			scheme: SecurityScheme{Name: "key", Type: SecuritySchemeAPIKey, In: "header", ParamName: "X-API-Key"},
+			want:   AccessToken{ParamType: Header, Key: "X-API-Key", Value: "secret"},
+			wantOK: true,
+		},
+		{
+			name:   "api key in query",
+			scheme: SecurityScheme{Name: "key", Type: SecuritySchemeAPIKey, In: "query", ParamName: "key"},
+			want:   AccessToken{ParamType: Query, Key: "key", Value: "secret"},
+			wantOK: true,
+		},
+		{
+			name:   "bearer token",
+			scheme: SecurityScheme{Name: "bearer", Type: SecuritySchemeHTTP, Scheme: "Bearer"},
+			want:   AccessToken{ParamType: Header, Key: "Authorization", Value: "Bearer secret"},
+			wantOK: true,
+		},
+		{
+			name:   "basic authentication",
+			scheme: SecurityScheme{Name: "basic", Type: SecuritySchemeHTTP, Scheme: "basic"},
+			want:   AccessToken{ParamType: Header, Key: "Authorization", Value: "Basic c2VjcmV0"},
+			wantOK: true,
+		},
+		{
+			name:   "api key in cookie",
+			scheme: SecurityScheme{Name: "key", Type: SecuritySchemeAPIKey, In: "cookie", ParamName: "session"},
+			want:   AccessToken{ParamType: Cookie, Key: "session", Value: "secret"},
+			wantOK: true,
+		},
+		{
+			name:   "oauth2 client credentials",
+			scheme: SecurityScheme{Name: "oauth", Type: SecuritySchemeOAuth2, TokenURL: "https://auth.example.com/token"},
+			want: AccessToken{ParamType: OAuth2ClientCredentials, ClientID: "secret",
+				TokenURL: "https://auth.example.com/token"},
+			wantOK: true,
+		},
+		{
+			name:   "oauth2 without the client credentials flow is not supported",
+			scheme: SecurityScheme{Name: "oauth", Type: SecuritySchemeOAuth2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.scheme.AccessToken("secret")
+			if ok != tt.wantOK {
+				t.Fatalf("wrong ok: got %v, want %v", ok, tt.wantOK)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("access token differs:\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestGetUsageReq_CreateParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   GetUsageReq
+		want    *UsageParams
+		wantErr validator.ValidationErrors
+	}{
+		{
+			name: "granularityとgroup_byのデフォルト値が設定され、toの翌日までの期間となる",
+			input: GetUsageReq{
+				From:   "2022-01-01",
+				To:     "2022-01-31",
+				APIKey: "key",
+			},
+			want: &UsageParams{
+				From:        time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+				To:          time.Date(2022, time.February, 1, 0, 0, 0, 0, time.UTC),
+				Granularity: "day",
+				GroupBy:     "key",
+				APIKey:      "key",
+			},
+		},
+		{
+			name: "fromとtoが同じ日のとき、1日分の期間となる",
+			input: GetUsageReq{
+				From:        "2022-01-01",
+				To:          "2022-01-01",
+				Granularity: "hour",
+				GroupBy:     "product",
+				ProductID:   1,
+			},
+			want: &UsageParams{
+				From:        time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+				To:          time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC),
+				Granularity: "hour",
+				GroupBy:     "product",
+				ProductID:   1,
+			},
+		},
+		{
+			name: "fromの形式が不正",
+			input: GetUsageReq{
+				From: "2022/01/01",
+				To:   "2022-01-01",
+			},
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "from",
+					ConstraintType: "datetime",
+					Message:        "input value, 2022/01/01, does not satisfy the format, datetime",
+					Got:            "2022/01/01",
+				},
+			},
+		},
+		{
+			name: "granularityが不正",
+			input: GetUsageReq{
+				From:        "2022-01-01",
+				To:          "2022-01-01",
+				Granularity: "month",
+			},
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "granularity",
+					ConstraintType: "enum",
+					Message:        "input value is month, but it must be one of the following values: [day hour]",
+					Enum:           []string{"day", "hour"},
+					Got:            "month",
+				},
+			},
+		},
+		{
+			name: "toがfromより前",
+			input: GetUsageReq{
+				From: "2022-01-02",
+				To:   "2022-01-01",
+			},
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "to",
+					ConstraintType: "gte",
+					Message:        "input value is 2022-01-01, but it must be greater than or equal to 2022-01-02",
+					Gte:            "2022-01-02",
+					Got:            "2022-01-01",
+				},
+			},
+		},
+		{
+			name: "granularityがhourのとき、期間が31日を超える",
+			input: GetUsageReq{
+				From:        "2022-01-01",
+				To:          "2022-02-01",
+				Granularity: "hour",
+			},
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "to",
+					ConstraintType: "usage_range",
+					Message:        "the range is 32 days, but it must be within 31 days for granularity hour",
+					Got:            "2022-02-01",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tt.input.CreateParams()
+			if diff := cmp.Diff(tt.want, resp); diff != "" {
+				t.Errorf("retruned struct differ:\n%s", diff)
+			}
+
+			if err == nil {
+				if tt.wantErr != nil {
+					t.Errorf("returned error is nil, but expected error is not nil: %v", tt.wantErr)
+				}
+				return
+			}
+			testValidateErrors(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestPricingPlan_Charge(t *testing.T) {
+	upTo := func(n int64) *int64 {
+		return &n
+	}
+	tiered := PricingPlan{
+		FreeCalls: 100,
+		Tiers: PricingTiers{
+			{UpTo: upTo(1000), UnitPriceMicros: 10000},
+			{UpTo: upTo(5000), UnitPriceMicros: 8000},
+			{UnitPriceMicros: 5000},
+		},
+	}
+	tests := []struct {
+		name          string
+		plan          PricingPlan
+		billableCalls int64
+		wantFree      int64
+		wantAmount    int64
+	}{
+		{
+			name:          "無料枠の範囲内では課金されない",
+			plan:          tiered,
+			billableCalls: 80,
+			wantFree:      80,
+			wantAmount:    0,
+		},
+		{
+			name:          "無料枠を超えた呼び出しが最初の段階の単価で課金される",
+			plan:          tiered,
+			billableCalls: 600,
+			wantFree:      100,
+			wantAmount:    500 * 10000,
+		},
+		{
+			name:          "段階ごとの単価で課金され、最後の段階は上限がない",
+			plan:          tiered,
+			billableCalls: 10100,
+			wantFree:      100,
+			wantAmount:    1000*10000 + 4000*8000 + 5000*5000,
+		},
+		{
+			name: "呼び出しごとの単価で課金される",
+			plan: PricingPlan{
+				Tiers: PricingTiers{{UnitPriceMicros: 1500}},
+			},
+			billableCalls: 3,
+			wantFree:      0,
+			wantAmount:    4500,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			free, amount := tt.plan.Charge(tt.billableCalls)
+			if free != tt.wantFree || amount != tt.wantAmount {
+				t.Errorf("wrong charge, want (%d, %d), got (%d, %d)", tt.wantFree, tt.wantAmount, free, amount)
+			}
+		})
+	}
+}
+
+func TestPutPricingPlanReq_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr validator.ValidationErrors
+	}{
+		{
+			name:  "段階的な料金を設定できる",
+			input: `{"currency": "JPY", "free_calls": 100, "tiers": [{"up_to": 1000, "unit_price_micros": 10000}, {"up_to": null, "unit_price_micros": 5000}]}`,
+		},
+		{
+			name:  "上限のない段階が最後でない",
+			input: `{"currency": "JPY", "tiers": [{"up_to": null, "unit_price_micros": 10000}, {"up_to": 1000, "unit_price_micros": 5000}]}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "tiers[0].up_to",
+					ConstraintType: "pricing_tier",
+					Message:        "only the last tier can have no limit",
+				},
+			},
+		},
+		{
+			name:  "段階の上限が増加していない",
+			input: `{"currency": "JPY", "tiers": [{"up_to": 1000, "unit_price_micros": 10000}, {"up_to": 1000, "unit_price_micros": 5000}, {"unit_price_micros": 1000}]}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "tiers[1].up_to",
+					ConstraintType: "pricing_tier",
+					Message:        "input value is 1000, but it must be greater than the previous tier, 1000, and the last tier must have no limit",
+					Got:            int64(1000),
+				},
+			},
+		},
+		{
+			name:  "通貨コードが不正",
+			input: `{"currency": "YEN", "tiers": [{"unit_price_micros": 10000}]}`,
+			wantErr: validator.ValidationErrors{
+				{
+					Field:          "currency",
+					ConstraintType: "iso4217",
+					Message:        "input value, YEN, does not satisfy the format, iso4217",
+					Got:            "YEN",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req PutPricingPlanReq
+			err := req.UnmarshalJSON([]byte(tt.input))
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			testValidateErrors(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestFormatMicros(t *testing.T) {
+	tests := []struct {
+		micros int64
+		want   string
+	}{
+		{micros: 0, want: "0"},
+		{micros: 1234500, want: "1.2345"},
+		{micros: 3000000, want: "3"},
+		{micros: 1500, want: "0.0015"},
+		{micros: -2500000, want: "-2.5"},
+	}
+	for _, tt := range tests {
+		if got := FormatMicros(tt.micros); got != tt.want {
+			t.Errorf("FormatMicros(%d): want %s, got %s", tt.micros, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package managementapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"io"
+	"log"
+	"net/http"
+)
+
+// PatchContract godoc
+// @Summary Amend a contract
+// @Description Add or remove products of a contract, or change its end date. Authorizations of removed products are deleted
+// @produce json
+// @Param id path int true "contract id"
+// @Param contract body model.PatchContractReq true "amendment of the contract"
+// @Success 200 {object} model.ContractDetail
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /contracts/{id} [patch]
+func PatchContract(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		log.Printf("unexpected request content: %s", r.Header.Get("Content-Type"))
+		writeErrResponse(w, usecase.NewClientError(errors.New(`unexpected request Content-Type, it must be "application/json"`)))
+		return
+	}
+
+	contractID, err := parseIDParam(r, "id", "contract id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	body := new(bytes.Buffer)
+	if _, err := io.Copy(body, r.Body); err != nil {
+		log.Printf("reading request body failed: %v", err)
+		writeErrResponse(w, usecase.NewServerError(errors.New(`server error`)))
+		return
+	}
+
+	var req model.PatchContractReq
+	if ok := unmarshalJSONAndValidate(w, body.Bytes(), &req); !ok {
+		return
+	}
+
+	resp, err := usecase.PatchContract(r.Context(), contractID, req)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	respBody, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("create json response error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
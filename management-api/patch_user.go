@@ -0,0 +1,48 @@
+package managementapi
+
+import (
+	"bytes"
+	"errors"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log"
+	"net/http"
+)
+
+// PatchUser godoc
+// @Summary Update a user
+// @Description Update the profile of a user. If the email address is changed, it must be verified again
+// @produce json
+// @Param account_id path string true "account id of the user"
+// @Param user body model.PatchUserReq true "fields to update"
+// @Success 200 {object} model.UserResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /users/{account_id} [patch]
+func PatchUser(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		log.Printf("unexpected request content: %s", r.Header.Get("Content-Type"))
+		writeErrResponse(w, usecase.NewClientError(errors.New(`unexpected request Content-Type, it must be "application/json"`)))
+		return
+	}
+	body := new(bytes.Buffer)
+	if _, err := io.Copy(body, r.Body); err != nil {
+		log.Printf("reading request body failed: %v", err)
+		writeErrResponse(w, usecase.NewServerError(errors.New(`server error`)))
+		return
+	}
+
+	var req model.PatchUserReq
+	if ok := unmarshalJSONAndValidate(w, body.Bytes(), &req); !ok {
+		return
+	}
+
+	resp, err := usecase.PatchUser(r.Context(), chi.URLParam(r, "account_id"), req)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, resp)
+}
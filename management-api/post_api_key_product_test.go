@@ -41,6 +41,9 @@ func TestPostAPIKeyProducts(t *testing.T) {
 	if _, err := db.Exec("TRUNCATE apikey_contract_product_authorized"); err != nil {
 		t.Fatal(err)
 	}
+	if _, err := db.Exec("TRUNCATE routing_outbox"); err != nil {
+		t.Fatal(err)
+	}
 	if _, err := db.Exec("DELETE FROM contract_product_content"); err != nil {
 		t.Fatal(err)
 	}
@@ -58,6 +61,7 @@ func TestPostAPIKeyProducts(t *testing.T) {
 	}
 	defer func() {
 		db.Exec("TRUNCATE apikey_contract_product_authorized")
+		db.Exec("TRUNCATE routing_outbox")
 		db.Exec("DELETE FROM contract_product_content")
 		db.Exec("DELETE FROM contract")
 		db.Exec("DELETE FROM product")
@@ -96,7 +100,7 @@ func TestPostAPIKeyProducts(t *testing.T) {
 		productIDs[i] = id
 	}
 
-	contractUserIDs := []int{userIds[0], userIds[1], userIds[1]}
+	contractUserIDs := []int{userIds[0], userIds[1], userIds[1], userIds[1]}
 	contractIDs := make([]int, len(contractUserIDs))
 	for i, userID := range contractUserIDs {
 		stmt, err := db.Preparex(
@@ -110,6 +114,11 @@ func TestPostAPIKeyProducts(t *testing.T) {
 		stmt.QueryRowx(userID).Scan(&id)
 		contractIDs[i] = id
 	}
+	// the last contract has not started yet
+	if _, err := db.Exec(`UPDATE contract SET start_at = current_timestamp + interval '1 day' WHERE id = $1`, contractIDs[3]); err != nil {
+		t.Error(err)
+		return
+	}
 
 	apikeyUserIDs := []int{userIds[0], userIds[1]}
 	apikeyIDs := make([]int, len(apikeyUserIDs))
@@ -127,8 +136,8 @@ func TestPostAPIKeyProducts(t *testing.T) {
 		apikeyIDs[i] = id
 	}
 
-	contractProductContentContractIDs := []int{contractIDs[0], contractIDs[0], contractIDs[1], contractIDs[1], contractIDs[2], contractIDs[2]}
-	contractProductContentProductIDs := []int{productIDs[0], productIDs[1], productIDs[0], productIDs[1], productIDs[2], productIDs[3]}
+	contractProductContentContractIDs := []int{contractIDs[0], contractIDs[0], contractIDs[1], contractIDs[1], contractIDs[2], contractIDs[2], contractIDs[3]}
+	contractProductContentProductIDs := []int{productIDs[0], productIDs[1], productIDs[0], productIDs[1], productIDs[2], productIDs[3], productIDs[0]}
 	contractProductContentIDs := make([]int, len(contractProductContentContractIDs))
 	for i := range contractProductContentContractIDs {
 		stmt, err := db.Preparex(
@@ -255,12 +264,16 @@ func TestPostAPIKeyProducts(t *testing.T) {
 					Path:       "/product1/path_user",
 					ForwardURL: "http://example.com/v1/user",
 					ContractID: contractIDs[0],
+					ProductID:  productIDs[0],
+					APIKeyID:   apikeyIDs[0],
 				},
 				{
 					APIKey:     "0",
 					Path:       "/product1/path_user/{user_id}",
 					ForwardURL: "http://example.com/v1/user/{user_id}",
 					ContractID: contractIDs[0],
+					ProductID:  productIDs[0],
+					APIKeyID:   apikeyIDs[0],
 				},
 			},
 		},
@@ -291,24 +304,32 @@ func TestPostAPIKeyProducts(t *testing.T) {
 					Path:       "/product1/path_user",
 					ForwardURL: "http://example.com/v1/user",
 					ContractID: contractIDs[1],
+					ProductID:  productIDs[0],
+					APIKeyID:   apikeyIDs[1],
 				},
 				{
 					APIKey:     "1",
 					Path:       "/product1/path_user/{user_id}",
 					ForwardURL: "http://example.com/v1/user/{user_id}",
 					ContractID: contractIDs[1],
+					ProductID:  productIDs[0],
+					APIKeyID:   apikeyIDs[1],
 				},
 				{
 					APIKey:     "1",
 					Path:       "/product3/user",
 					ForwardURL: "http://example.com/v3/user",
 					ContractID: contractIDs[2],
+					ProductID:  productIDs[2],
+					APIKeyID:   apikeyIDs[1],
 				},
 				{
 					APIKey:     "1",
 					Path:       "/product4/user",
 					ForwardURL: "http://example.com/v4/user",
 					ContractID: contractIDs[2],
+					ProductID:  productIDs[3],
+					APIKeyID:   apikeyIDs[1],
 				},
 			},
 		},
@@ -343,6 +364,21 @@ func TestPostAPIKeyProducts(t *testing.T) {
 				Message: fmt.Sprintf("following product ids is not found in ids linked to contract %d: [%d]", contractIDs[0], productIDs[2]),
 			},
 		},
+		{
+			name: "the contract has not started yet",
+			req: model.PostAPIKeyProductsReq{
+				ApiKeyID: &apikeyIDs[1],
+				Contracts: []model.AuthorizedContractProducts{
+					{
+						ContractID: contractIDs[3],
+					},
+				},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: fmt.Sprintf("contract %d does not exist or is not yours, or some products in contract %d are wrong", contractIDs[3], contractIDs[3]),
+			},
+		},
 		{
 			name: "the apikey does not exist",
 			req: model.PostAPIKeyProductsReq{
@@ -440,6 +476,7 @@ func TestPostAPIKeyProducts(t *testing.T) {
 
 			w := httptest.NewRecorder()
 			managementapi.PostAPIKeyProducts(w, r)
+			processRoutingOutbox(t)
 
 			rw := w.Result()
 
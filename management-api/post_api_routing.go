@@ -14,7 +14,7 @@ import (
 
 // PostAPIRouting godoc
 // @Summary Post an API routing
-// @Description Post a new API routing
+// @Description Post a new API routing. The path must not match the same requests as another routing of the key
 // @Produce json
 // @Param api_routing body model.PostAPIRoutingReq true "routing parameters"
 // @Success 201 {string} string
@@ -40,8 +40,8 @@ func PostAPIRouting(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := usecase.PostRouting(r.Context(), req); err != nil {
-		log.Printf("post api routing db error: %v", err)
-		http.Error(w, "server error", http.StatusInternalServerError)
+		log.Printf("post api routing error: %v", err)
+		writeErrResponse(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusCreated)
@@ -103,6 +103,26 @@ func TestPostAPIRouting(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:          "[異常系] 既存のルーティングと同じリクエストにマッチするパスの場合、ルーティングを登録しない",
+			apiKey:        targetKey,
+			path:          "{name}",
+			forwardURL:    "http://localhost/{name}",
+			checkHgetArgs: []string{targetKey, "{name}"},
+			checkHgetResp: "",
+			httpStatus:    http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: "input validation error",
+				ValidationErrors: &validator.ValidationErrors{
+					{
+						Field:          "path",
+						ConstraintType: "conflict",
+						Message:        "path conflicts with the existing routing test",
+						Got:            "{name}",
+					},
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
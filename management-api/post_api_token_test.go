@@ -118,8 +118,8 @@ func TestPostAPIToken(t *testing.T) {
 					&validator.ValidationError{
 						Field:          "tokens[0].param_type",
 						ConstraintType: "enum",
-						Message:        "input value is wrong_type, but it must be one of the following values: [header query body_from_encoded]",
-						Enum:           []string{"header", "query", "body_from_encoded"},
+						Message:        "input value is wrong_type, but it must be one of the following values: [header query body_form_encoded body_json body_multipart cookie oauth2_client_credentials]",
+						Enum:           []string{"header", "query", "body_form_encoded", "body_json", "body_multipart", "cookie", "oauth2_client_credentials"},
 						Got:            "wrong_type",
 					},
 				},
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestPostContract(t *testing.T) {
@@ -49,7 +50,7 @@ func TestPostContract(t *testing.T) {
 		productIDs[i] = id
 	}
 
-	userAccountIDs := []string{"user1", "user2"}
+	userAccountIDs := []string{"user1", "user2", "user3"}
 	userIds := make([]int, len(userAccountIDs))
 	for i, name := range userAccountIDs {
 		stmt, err := db.Preparex(
@@ -70,6 +71,7 @@ func TestPostContract(t *testing.T) {
 		wantStatus            int
 		wantResp              interface{}
 		wantDBID              *int
+		wantStartAt           time.Time
 		wantContractProductDB []contractProduct
 	}{
 		{
@@ -122,6 +124,29 @@ func TestPostContract(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "start_at with a non-UTC offset is stored as the same instant",
+			req: model.PostContractReq{
+				UserAccountID: userAccountIDs[2],
+				StartAt:       "2030-01-02T03:04:05+09:00",
+				Products: []*model.ContractProducts{
+					{
+						ProductName: productNames[0],
+						Description: "api1",
+					},
+				},
+			},
+			wantStatus:  http.StatusCreated,
+			wantResp:    "Created",
+			wantDBID:    &userIds[2],
+			wantStartAt: time.Date(2030, 1, 1, 18, 4, 5, 0, time.UTC),
+			wantContractProductDB: []contractProduct{
+				{
+					ProductID:   productIDs[0],
+					Description: "api1",
+				},
+			},
+		},
 		{
 			name: "user item with the requested account id does not exist",
 			req: model.PostContractReq{
@@ -238,7 +263,7 @@ func TestPostContract(t *testing.T) {
 				return
 			}
 
-			rows, err := db.Queryx(`SELECT id
+			rows, err := db.Queryx(`SELECT id, start_at
 					       				FROM contract WHERE user_id=$1 `, tt.wantDBID)
 			if err != nil {
 				t.Errorf("db get contracts  error: %v", err)
@@ -246,8 +271,9 @@ func TestPostContract(t *testing.T) {
 			}
 
 			contractID := -1
+			var startAt time.Time
 			for rows.Next() {
-				err = rows.Scan(&contractID)
+				err = rows.Scan(&contractID, &startAt)
 				if err != nil {
 					t.Errorf("scan contract id failed: %v", err)
 				}
@@ -257,6 +283,10 @@ func TestPostContract(t *testing.T) {
 				return
 			}
 
+			if !tt.wantStartAt.IsZero() && !startAt.Equal(tt.wantStartAt) {
+				t.Errorf("wrong start_at: got %v, want %v", startAt, tt.wantStartAt)
+			}
+
 			rows, err = db.Queryx(`SELECT product_id, description
 					       				FROM contract_product_content WHERE contract_id=$1 ORDER BY product_id`, contractID)
 			if err != nil {
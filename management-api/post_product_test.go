@@ -37,6 +37,9 @@ func TestPostProduct(t *testing.T) {
 	if _, err := db.Exec("DELETE FROM product"); err != nil {
 		t.Fatal(err)
 	}
+	if _, err := db.Exec("TRUNCATE routing_outbox"); err != nil {
+		t.Fatal(err)
+	}
 
 	usecase.Parser = swaggerparser.NewParser(swaggerparser.TestFetcher{})
 
@@ -192,6 +195,9 @@ func TestPostProduct(t *testing.T) {
 	if _, err := db.Exec("DELETE FROM product"); err != nil {
 		t.Fatal(err)
 	}
+	if _, err := db.Exec("TRUNCATE routing_outbox"); err != nil {
+		t.Fatal(err)
+	}
 
 }
 
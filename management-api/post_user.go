@@ -12,11 +12,12 @@ import (
 
 // PostUser godoc
 // @Summary Create a user
-// @Description Create a user
+// @Description Create a user, and send the mail to verify the email address
 // @produce json
 // @Param user body model.PostUserReq true "user description"
 // @Success 201 {string} string
 // @Failure 400 {object} validator.BadRequestResp
+// @Failure 409 {object} validator.BadRequestResp
 // @Failure 500 {string} error
 // @Router /users [post]
 func PostUser(w http.ResponseWriter, r *http.Request) {
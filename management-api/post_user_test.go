@@ -51,6 +51,21 @@ func TestPostUser(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:        "account_idが既に登録されているとき、409を返す",
+			contentType: "application/json",
+			req: model.PostUserReq{
+				AccountID:    "user",
+				EmailAddress: "test07@example.com",
+				Password:     "password",
+				Name:         "full name",
+			},
+			wantHttpStatus: http.StatusConflict,
+			wantBadRequestResp: &validator.BadRequestResp{
+				Message: "account_id user already exists",
+			},
+			wantRecords: []model.User{},
+		},
 		{
 			name:        "パスワードに記号が含まれており、正常に登録できる",
 			contentType: "application/json",
@@ -204,7 +219,7 @@ func TestPostUser(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			if rw.StatusCode == http.StatusBadRequest {
+			if rw.StatusCode == http.StatusBadRequest || rw.StatusCode == http.StatusConflict {
 				testBadRequestResp(t, tt.wantBadRequestResp, resp)
 				return
 			}
@@ -0,0 +1,54 @@
+package managementapi
+
+import (
+	"bytes"
+	"errors"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"io"
+	"log"
+	"net/http"
+)
+
+// PutProductCredentials godoc
+// @Summary Replace credentials of a product
+// @Description Replace credentials of the upstream security schemes of a product. Access tokens of the apis which require the schemes are created for every authorized api key
+// @produce json
+// @Param id path int true "product id"
+// @Param credentials body model.PutProductCredentialsReq true "credentials keyed by security scheme names"
+// @Success 200 {object} model.ProductCredentialsResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /products/{id}/credentials [put]
+func PutProductCredentials(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		log.Printf("unexpected request content: %s", r.Header.Get("Content-Type"))
+		writeErrResponse(w, usecase.NewClientError(errors.New(`unexpected request Content-Type, it must be "application/json"`)))
+		return
+	}
+
+	productID, err := parseIDParam(r, "id", "product id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	body := new(bytes.Buffer)
+	if _, err := io.Copy(body, r.Body); err != nil {
+		log.Printf("reading request body failed: %v", err)
+		writeErrResponse(w, usecase.NewServerError(errors.New(`server error`)))
+		return
+	}
+
+	var req model.PutProductCredentialsReq
+	if ok := unmarshalJSONAndValidate(w, body.Bytes(), &req); !ok {
+		return
+	}
+
+	resp, err := usecase.PutProductCredentials(r.Context(), productID, req)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, resp)
+}
@@ -0,0 +1,250 @@
+package managementapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"github.com/future-architect/apidoor/secret"
+	"github.com/google/go-cmp/cmp"
+	"github.com/guregu/dynamo"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestPutProductCredentials(t *testing.T) {
+	dbType := managementapi.GetAPIDBType(t)
+	if dbType != managementapi.DYNAMO {
+		log.Println("this test is valid when dynamodb is used, skip")
+		return
+	}
+
+	managementapi.Setup(t,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/access_token_table.json`,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/swagger_table.json`,
+	)
+	t.Cleanup(func() {
+		managementapi.Teardown(t,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table swagger`,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table access_token`,
+		)
+	})
+
+	cleanup := func() {
+		db.Exec("TRUNCATE routing_outbox")
+		db.Exec("TRUNCATE product_credential")
+		db.Exec("TRUNCATE apikey_contract_product_authorized")
+		db.Exec("DELETE FROM contract_product_content")
+		db.Exec("DELETE FROM contract")
+		db.Exec("DELETE FROM product")
+		db.Exec("DELETE FROM apikey")
+		db.Exec("DELETE FROM apiuser")
+	}
+	cleanup()
+	defer cleanup()
+
+	// DB setup
+	var userID, productID, contractID, apikeyID, contractProductID int
+	if err := db.QueryRowx(`INSERT INTO apiuser(account_id, email_address, login_password_hash, name, created_at, updated_at)
+			VALUES ('user1', 'a', 'password', 'a', current_timestamp, current_timestamp) RETURNING id`).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO product(name, source, description, thumbnail, display_name, base_path, swagger_url, created_at, updated_at)
+			VALUES ('product1', 'a', 'a', 'a', 'a', '/sample_gateway', 'http://api.example.com/v2/swagger.json', current_timestamp, current_timestamp) RETURNING id`).Scan(&productID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO contract(user_id, created_at, updated_at)
+			VALUES ($1, current_timestamp, current_timestamp) RETURNING id`, userID).Scan(&contractID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO apikey(user_id, access_key, created_at, updated_at)
+			VALUES ($1, 'key', current_timestamp, current_timestamp) RETURNING id`, userID).Scan(&apikeyID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO contract_product_content(contract_id, product_id, created_at, updated_at)
+			VALUES ($1, $2, current_timestamp, current_timestamp) RETURNING id`, contractID, productID).Scan(&contractProductID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO apikey_contract_product_authorized(apikey_id, contract_product_id, created_at, updated_at)
+			VALUES ($1, $2, current_timestamp, current_timestamp)`, apikeyID, contractProductID); err != nil {
+		t.Fatal(err)
+	}
+
+	// dynamodb setup
+	dbDynamo := dynamo.New(session.Must(session.NewSessionWithOptions(session.Options{
+		Profile:           "local",
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            aws.Config{Endpoint: aws.String("http://localhost:4566")},
+	})))
+	swaggerTable := os.Getenv("DYNAMO_TABLE_SWAGGER")
+	tokenTable := os.Getenv("DYNAMO_TABLE_ACCESS_TOKEN")
+
+	schemes := []model.SecurityScheme{
+		{Name: "api_key", Type: model.SecuritySchemeAPIKey, In: "header", ParamName: "X-API-Key"},
+		{Name: "oauth", Type: "oauth2"},
+	}
+	swagger := model.Swagger{
+		ProductID:      productID,
+		Schemes:        []string{"https"},
+		ForwardURLBase: "api.example.com/sample",
+		PathBase:       "/sample_gateway",
+		APIList: []model.API{
+			{
+				ForwardURL: "/users",
+				Path:       "/users",
+				Security:   []string{"api_key"},
+			},
+			{
+				ForwardURL: "/health",
+				Path:       "/health",
+			},
+		},
+		SecuritySchemes: schemes,
+	}
+	if err := dbDynamo.Table(swaggerTable).Put(swagger).Run(); err != nil {
+		t.Fatalf("put swagger failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		productID  string
+		body       string
+		wantStatus int
+		wantResp   interface{}
+		// wantTokens is the tokens of /sample_gateway/users, nil means they do not exist
+		wantTokens []model.AccessToken
+	}{
+		{
+			name:       "credentials are stored and access tokens are created",
+			productID:  fmt.Sprint(productID),
+			body:       `{"credentials": {"api_key": "secret"}}`,
+			wantStatus: http.StatusOK,
+			wantResp: model.ProductCredentialsResp{
+				ProductID: productID,
+				SecuritySchemes: []model.SecuritySchemeStatus{
+					{SecurityScheme: schemes[0], Supported: true, Configured: true},
+					{SecurityScheme: schemes[1]},
+				},
+			},
+			wantTokens: []model.AccessToken{
+				{ParamType: model.Header, Key: "X-API-Key", Value: "secret"},
+			},
+		},
+		{
+			name:       "removing credentials deletes access tokens",
+			productID:  fmt.Sprint(productID),
+			body:       `{"credentials": {}}`,
+			wantStatus: http.StatusOK,
+			wantResp: model.ProductCredentialsResp{
+				ProductID: productID,
+				SecuritySchemes: []model.SecuritySchemeStatus{
+					{SecurityScheme: schemes[0], Supported: true},
+					{SecurityScheme: schemes[1]},
+				},
+			},
+		},
+		{
+			name:       "scheme is not defined",
+			productID:  fmt.Sprint(productID),
+			body:       `{"credentials": {"undefined": "secret"}}`,
+			wantStatus: http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: "security scheme undefined is not defined in the swagger file of the product",
+			},
+		},
+		{
+			name:       "scheme is not supported",
+			productID:  fmt.Sprint(productID),
+			body:       `{"credentials": {"oauth": "secret"}}`,
+			wantStatus: http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: "security scheme oauth of type oauth2 is not supported",
+			},
+		},
+		{
+			name:       "product does not exist",
+			productID:  "-1",
+			body:       `{"credentials": {}}`,
+			wantStatus: http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: "product not found, id -1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut,
+				fmt.Sprintf("localhost:3000/mgmt/products/%s/credentials", tt.productID), bytes.NewBufferString(tt.body))
+			r.Header.Add("Content-Type", "application/json")
+			r = withURLParam(r, "id", tt.productID)
+
+			w := httptest.NewRecorder()
+			managementapi.PutProductCredentials(w, r)
+			processRoutingOutbox(t)
+
+			rw := w.Result()
+			resp, err := io.ReadAll(rw.Body)
+			if err != nil {
+				t.Errorf("read response body error: %v", err)
+				return
+			}
+
+			if rw.StatusCode != tt.wantStatus {
+				t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, tt.wantStatus)
+			}
+
+			switch want := tt.wantResp.(type) {
+			case model.ProductCredentialsResp:
+				var got model.ProductCredentialsResp
+				if err := json.Unmarshal(resp, &got); err != nil {
+					t.Errorf("parse response body failed: %v", err)
+					return
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Errorf("response differs:\n%v", diff)
+				}
+				// credentials are encrypted in the table if the key file is set
+				var stored []string
+				if err := db.Select(&stored, "SELECT value FROM product_credential WHERE product_id = $1", productID); err != nil {
+					t.Errorf("select product credentials db error: %v", err)
+				}
+				encryption := os.Getenv(secret.KeyFileEnv) != ""
+				for _, v := range stored {
+					if secret.IsEncrypted(v) != encryption {
+						t.Errorf("wrong stored credential %s, whether it is encrypted must be %v", v, encryption)
+					}
+				}
+			case validator.BadRequestResp:
+				testBadRequestResp(t, &want, resp)
+				return
+			default:
+				t.Errorf("type of wantResp is not supported")
+			}
+
+			var gotTokens accessToken
+			err = dbDynamo.Table(tokenTable).Get("key", "key#/sample_gateway/users").One(&gotTokens)
+			if err == dynamo.ErrNotFound {
+				if tt.wantTokens != nil {
+					t.Errorf("access tokens are not created")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("get access tokens db error: %v", err)
+				return
+			}
+			if diff := cmp.Diff(tt.wantTokens, gotTokens.AccessTokens); diff != "" {
+				t.Errorf("access tokens differ:\n%v", diff)
+			}
+		})
+	}
+}
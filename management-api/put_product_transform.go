@@ -0,0 +1,65 @@
+package managementapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"io"
+	"log"
+	"net/http"
+)
+
+// PutProductTransform godoc
+// @Summary Replace the transformation of a product
+// @Description Replace the request and response transformation applied by the gateway to every api of a product. Routings of authorized api keys are updated
+// @produce json
+// @Param id path int true "product id"
+// @Param transform body model.PutProductTransformReq true "transformation, null removes it"
+// @Success 200 {object} model.Product
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /products/{id}/transform [put]
+func PutProductTransform(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		log.Printf("unexpected request content: %s", r.Header.Get("Content-Type"))
+		writeErrResponse(w, usecase.NewClientError(errors.New(`unexpected request Content-Type, it must be "application/json"`)))
+		return
+	}
+
+	productID, err := parseIDParam(r, "id", "product id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	body := new(bytes.Buffer)
+	if _, err := io.Copy(body, r.Body); err != nil {
+		log.Printf("reading request body failed: %v", err)
+		writeErrResponse(w, usecase.NewServerError(errors.New(`server error`)))
+		return
+	}
+
+	var req model.PutProductTransformReq
+	if ok := unmarshalJSONAndValidate(w, body.Bytes(), &req); !ok {
+		return
+	}
+
+	resp, err := usecase.PutProductTransform(r.Context(), productID, req)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	respBody, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("create json response error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
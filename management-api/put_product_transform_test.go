@@ -0,0 +1,231 @@
+package managementapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/guregu/dynamo"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestPutProductTransform(t *testing.T) {
+	dbType := managementapi.GetAPIDBType(t)
+	if dbType != managementapi.DYNAMO {
+		log.Println("this test is valid when dynamodb is used, skip")
+		return
+	}
+
+	managementapi.Setup(t,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/api_routing_table.json`,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/swagger_table.json`,
+	)
+	t.Cleanup(func() {
+		managementapi.Teardown(t,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table swagger`,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table api_routing`,
+		)
+	})
+
+	cleanup := func() {
+		db.Exec("TRUNCATE routing_outbox")
+		db.Exec("TRUNCATE apikey_contract_product_authorized")
+		db.Exec("DELETE FROM contract_product_content")
+		db.Exec("DELETE FROM contract")
+		db.Exec("DELETE FROM product")
+		db.Exec("DELETE FROM apikey")
+		db.Exec("DELETE FROM apiuser")
+	}
+	cleanup()
+	defer cleanup()
+
+	// DB setup
+	var userID, productID, contractID, apikeyID, contractProductID int
+	if err := db.QueryRowx(`INSERT INTO apiuser(account_id, email_address, login_password_hash, name, created_at, updated_at)
+			VALUES ('user1', 'a', 'password', 'a', current_timestamp, current_timestamp) RETURNING id`).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO product(name, source, description, thumbnail, display_name, base_path, swagger_url, created_at, updated_at)
+			VALUES ('product1', 'a', 'a', 'a', 'a', '/sample_gateway', 'http://api.example.com/v2/swagger.json', current_timestamp, current_timestamp) RETURNING id`).Scan(&productID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO contract(user_id, created_at, updated_at)
+			VALUES ($1, current_timestamp, current_timestamp) RETURNING id`, userID).Scan(&contractID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO apikey(user_id, access_key, created_at, updated_at)
+			VALUES ($1, 'key', current_timestamp, current_timestamp) RETURNING id`, userID).Scan(&apikeyID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO contract_product_content(contract_id, product_id, created_at, updated_at)
+			VALUES ($1, $2, current_timestamp, current_timestamp) RETURNING id`, contractID, productID).Scan(&contractProductID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO apikey_contract_product_authorized(apikey_id, contract_product_id, created_at, updated_at)
+			VALUES ($1, $2, current_timestamp, current_timestamp)`, apikeyID, contractProductID); err != nil {
+		t.Fatal(err)
+	}
+
+	// dynamodb setup
+	dbDynamo := dynamo.New(session.Must(session.NewSessionWithOptions(session.Options{
+		Profile:           "local",
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            aws.Config{Endpoint: aws.String("http://localhost:4566")},
+	})))
+	swaggerTable := os.Getenv("DYNAMO_TABLE_SWAGGER")
+	routingTable := os.Getenv("DYNAMO_TABLE_API_ROUTING")
+
+	swagger := model.Swagger{
+		ProductID:      productID,
+		Schemes:        []string{"https"},
+		ForwardURLBase: "api.example.com/sample",
+		PathBase:       "/sample_gateway",
+		APIList: []model.API{
+			{
+				ForwardURL: "/users",
+				Path:       "/users",
+			},
+		},
+	}
+	if err := dbDynamo.Table(swaggerTable).Put(swagger).Run(); err != nil {
+		t.Fatalf("put swagger failed: %v", err)
+	}
+	routing := model.Routing{
+		APIKey:     "key",
+		Path:       "/sample_gateway/users",
+		ForwardURL: "https://api.example.com/sample/users",
+		ContractID: contractID,
+		ProductID:  productID,
+		APIKeyID:   apikeyID,
+	}
+	if err := dbDynamo.Table(routingTable).Put(routing).Run(); err != nil {
+		t.Fatalf("put routing failed: %v", err)
+	}
+
+	transform := &model.Transform{
+		Request: []model.TransformStep{
+			{Type: model.TransformFormToJSON},
+		},
+		Response: []model.TransformStep{
+			{Type: model.TransformRemoveHeader, Name: "X-Internal-*"},
+		},
+	}
+	routing.Transform = transform
+
+	tests := []struct {
+		name         string
+		productID    string
+		body         string
+		wantStatus   int
+		wantResp     interface{}
+		wantRoutings []model.Routing
+	}{
+		{
+			name:      "transform is stored and copied into routings",
+			productID: fmt.Sprint(productID),
+			body: `{"transform": {"request": [{"type": "form_to_json"}],
+				"response": [{"type": "remove_header", "name": "X-Internal-*"}]}}`,
+			wantStatus:   http.StatusOK,
+			wantResp:     transform,
+			wantRoutings: []model.Routing{routing},
+		},
+		{
+			name:       "product does not exist",
+			productID:  "-1",
+			body:       `{"transform": null}`,
+			wantStatus: http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: "product not found, id -1",
+			},
+		},
+		{
+			name:       "transform is invalid",
+			productID:  fmt.Sprint(productID),
+			body:       `{"transform": {"response": [{"type": "form_to_json"}]}}`,
+			wantStatus: http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: "input validation error",
+				ValidationErrors: &validator.ValidationErrors{
+					{
+						Field:          "transform.response[0]",
+						ConstraintType: "transform",
+						Message:        "form_to_json can be applied only to requests",
+						Got:            map[string]interface{}{"type": "form_to_json"},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut,
+				fmt.Sprintf("localhost:3000/mgmt/products/%s/transform", tt.productID), bytes.NewBufferString(tt.body))
+			r.Header.Add("Content-Type", "application/json")
+			r = withURLParam(r, "id", tt.productID)
+
+			w := httptest.NewRecorder()
+			managementapi.PutProductTransform(w, r)
+			processRoutingOutbox(t)
+
+			rw := w.Result()
+			resp, err := io.ReadAll(rw.Body)
+			if err != nil {
+				t.Errorf("read response body error: %v", err)
+				return
+			}
+
+			if rw.StatusCode != tt.wantStatus {
+				t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, tt.wantStatus)
+			}
+
+			switch want := tt.wantResp.(type) {
+			case *model.Transform:
+				var got model.Product
+				if err := json.Unmarshal(resp, &got); err != nil {
+					t.Errorf("parse response body failed: %v", err)
+					return
+				}
+				if diff := cmp.Diff(want, got.Transform); diff != "" {
+					t.Errorf("transform of the product differs:\n%v", diff)
+				}
+			case validator.BadRequestResp:
+				testBadRequestResp(t, &want, resp)
+			default:
+				t.Errorf("type of wantResp is not supported")
+			}
+
+			if tt.wantRoutings == nil {
+				return
+			}
+			var gotRoutings []model.Routing
+			if err = dbDynamo.Table(routingTable).Get("api_key", "key").All(&gotRoutings); err != nil {
+				t.Errorf("get routings db error: %v", err)
+				return
+			}
+			if diff := cmp.Diff(tt.wantRoutings, gotRoutings,
+				cmpopts.SortSlices(func(a, b model.Routing) bool { return a.Path < b.Path })); diff != "" {
+				t.Errorf("gotten routings differ:\n%v", diff)
+			}
+			var gotSwagger model.Swagger
+			if err = dbDynamo.Table(swaggerTable).Get("product_id", productID).One(&gotSwagger); err != nil {
+				t.Errorf("get swagger db error: %v", err)
+				return
+			}
+			if diff := cmp.Diff(tt.wantResp, gotSwagger.Transform); diff != "" {
+				t.Errorf("transform of the swagger differs:\n%v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package managementapi
+
+import (
+	"bytes"
+	"errors"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log"
+	"net/http"
+)
+
+// PutUserPassword godoc
+// @Summary Change the password of a user
+// @Description Change the password of a user, the current password is required
+// @Param account_id path string true "account id of the user"
+// @Param password body model.PutUserPasswordReq true "current and new password"
+// @Success 204 {object} model.EmptyResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /users/{account_id}/password [put]
+func PutUserPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		log.Printf("unexpected request content: %s", r.Header.Get("Content-Type"))
+		writeErrResponse(w, usecase.NewClientError(errors.New(`unexpected request Content-Type, it must be "application/json"`)))
+		return
+	}
+	body := new(bytes.Buffer)
+	if _, err := io.Copy(body, r.Body); err != nil {
+		log.Printf("reading request body failed: %v", err)
+		writeErrResponse(w, usecase.NewServerError(errors.New(`server error`)))
+		return
+	}
+
+	var req model.PutUserPasswordReq
+	if ok := unmarshalJSONAndValidate(w, body.Bytes(), &req); !ok {
+		return
+	}
+
+	if err := usecase.PutUserPassword(r.Context(), chi.URLParam(r, "account_id"), req); err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
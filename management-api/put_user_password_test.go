@@ -0,0 +1,96 @@
+package managementapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPutUserPassword(t *testing.T) {
+	cleanup := func() {
+		db.Exec("DELETE FROM apiuser")
+	}
+	cleanup()
+	defer cleanup()
+
+	if _, err := db.Exec(`INSERT INTO apiuser(account_id, email_address, login_password_hash, name, created_at, updated_at)
+			VALUES ('user1', 'user1@example.com', crypt('password', gen_salt('bf')), 'name1', current_timestamp, current_timestamp)`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		req          model.PutUserPasswordReq
+		wantStatus   int
+		wantResp     *validator.BadRequestResp
+		wantPassword string
+	}{
+		{
+			name: "current password is wrong",
+			req: model.PutUserPasswordReq{
+				CurrentPassword: "wrong",
+				NewPassword:     "newpassword",
+			},
+			wantStatus: http.StatusBadRequest,
+			wantResp: &validator.BadRequestResp{
+				Message: "current_password is incorrect",
+			},
+			wantPassword: "password",
+		},
+		{
+			name: "change password properly",
+			req: model.PutUserPasswordReq{
+				CurrentPassword: "password",
+				NewPassword:     "newpassword",
+			},
+			wantStatus:   http.StatusNoContent,
+			wantPassword: "newpassword",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodyBytes, err := json.Marshal(tt.req)
+			if err != nil {
+				t.Errorf("create request body error: %v", err)
+				return
+			}
+			r := httptest.NewRequest(http.MethodPut, "localhost:3000/mgmt/users/user1/password", bytes.NewReader(bodyBytes))
+			r.Header.Add("Content-Type", "application/json")
+			r = withURLParam(r, "account_id", "user1")
+
+			w := httptest.NewRecorder()
+			managementapi.PutUserPassword(w, r)
+
+			rw := w.Result()
+			resp, err := io.ReadAll(rw.Body)
+			if err != nil {
+				t.Errorf("read response body error: %v", err)
+				return
+			}
+
+			if rw.StatusCode != tt.wantStatus {
+				t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, tt.wantStatus)
+			}
+			if tt.wantResp != nil {
+				testBadRequestResp(t, tt.wantResp, resp)
+			}
+
+			var matched bool
+			if err := db.QueryRowx(`SELECT login_password_hash = crypt($1, login_password_hash) FROM apiuser WHERE account_id = 'user1'`,
+				tt.wantPassword).Scan(&matched); err != nil {
+				t.Errorf("check password error: %v", err)
+				return
+			}
+			if !matched {
+				t.Errorf("stored password is not %s", tt.wantPassword)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package managementapi_test
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/model"
+	swaggerparser "github.com/future-architect/apidoor/managementapi/swagger-parser"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/guregu/dynamo"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	dbType := managementapi.GetAPIDBType(t)
+	if dbType != managementapi.DYNAMO {
+		log.Println("this test is valid when dynamodb is used, skip")
+		return
+	}
+
+	managementapi.Setup(t,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/api_routing_table.json`,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/swagger_table.json`,
+	)
+	t.Cleanup(func() {
+		managementapi.Teardown(t,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table swagger`,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table api_routing`,
+		)
+	})
+
+	cleanup := func() {
+		db.Exec("TRUNCATE apikey_contract_product_authorized")
+		db.Exec("TRUNCATE routing_outbox")
+		db.Exec("DELETE FROM contract_product_content")
+		db.Exec("DELETE FROM contract")
+		db.Exec("DELETE FROM product")
+		db.Exec("DELETE FROM apikey")
+		db.Exec("DELETE FROM apiuser")
+	}
+	cleanup()
+	defer cleanup()
+
+	usecase.Parser = swaggerparser.NewParser(swaggerparser.TestFetcher{})
+
+	// DB setup, the swagger info of the product has not been stored in the routing store
+	var userID, productID, contractID, apikeyID, contractProductID int
+	if err := db.QueryRowx(`INSERT INTO apiuser(account_id, email_address, login_password_hash, name, created_at, updated_at)
+			VALUES ('user1', 'a', 'password', 'a', current_timestamp, current_timestamp) RETURNING id`).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO product(name, source, description, thumbnail, display_name, base_path, swagger_url, created_at, updated_at)
+			VALUES ('product1', 'a', 'a', 'a', 'a', '/sample_gateway', 'http://api.example.com/v2/swagger.json', current_timestamp, current_timestamp) RETURNING id`).Scan(&productID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO contract(user_id, start_at, created_at, updated_at)
+			VALUES ($1, current_timestamp, current_timestamp, current_timestamp) RETURNING id`, userID).Scan(&contractID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO apikey(user_id, access_key, created_at, updated_at)
+			VALUES ($1, 'key', current_timestamp, current_timestamp) RETURNING id`, userID).Scan(&apikeyID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO contract_product_content(contract_id, product_id, created_at, updated_at)
+			VALUES ($1, $2, current_timestamp, current_timestamp) RETURNING id`, contractID, productID).Scan(&contractProductID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO apikey_contract_product_authorized(apikey_id, contract_product_id, created_at, updated_at)
+			VALUES ($1, $2, current_timestamp, current_timestamp)`, apikeyID, contractProductID); err != nil {
+		t.Fatal(err)
+	}
+
+	// dynamodb setup, a stale routing and a routing whose authorization does not exist
+	dbDynamo := dynamo.New(session.Must(session.NewSessionWithOptions(session.Options{
+		Profile:           "local",
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            aws.Config{Endpoint: aws.String("http://localhost:4566")},
+	})))
+	routingTable := os.Getenv("DYNAMO_TABLE_API_ROUTING")
+	drifted := []interface{}{
+		model.Routing{
+			APIKey:     "key",
+			Path:       "/sample_gateway/sample_users",
+			ForwardURL: "https://api.example.com/old/users",
+			ContractID: contractID,
+		},
+		model.Routing{
+			APIKey:     "key",
+			Path:       "/sample_gateway/unknown",
+			ForwardURL: "https://api.example.com/sample/unknown",
+			ContractID: contractID,
+		},
+	}
+	if _, err := dbDynamo.Table(routingTable).Batch().Write().Put(drifted...).Run(); err != nil {
+		t.Fatalf("put routings failed: %v", err)
+	}
+
+	wantDrift := model.ReconcileReport{
+		SkippedAPIKeys:  1,
+		MissingSwaggers: 1,
+	}
+	got, err := usecase.Reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if diff := cmp.Diff(wantDrift, *got); diff != "" {
+		t.Errorf("dry run report differs:\n%v", diff)
+	}
+
+	wantFixed := model.ReconcileReport{
+		CheckedAPIKeys:  1,
+		MissingSwaggers: 1,
+		MissingRoutings: 1,
+		StaleRoutings:   1,
+		ExtraRoutings:   1,
+		PostedSwaggers:  1,
+		PostedRoutings:  2,
+		DeletedRoutings: 1,
+	}
+	got, err = usecase.Reconcile(context.Background(), false)
+	if err != nil {
+		t.Fatalf("reconcile failed: %v", err)
+	}
+	if diff := cmp.Diff(wantFixed, *got); diff != "" {
+		t.Errorf("reconcile report differs:\n%v", diff)
+	}
+
+	wantRoutings := []model.Routing{
+		{
+			APIKey:     "key",
+			Path:       "/sample_gateway/sample_users",
+			ForwardURL: "https://api.example.com/sample/users",
+			ContractID: contractID,
+			ProductID:  productID,
+			APIKeyID:   apikeyID,
+		},
+		{
+			APIKey:     "key",
+			Path:       "/sample_gateway/users/{user_id}",
+			ForwardURL: "https://api.example.com/sample/users/{user_id}",
+			ContractID: contractID,
+			ProductID:  productID,
+			APIKeyID:   apikeyID,
+		},
+	}
+	var gotRoutings []model.Routing
+	if err = dbDynamo.Table(routingTable).Get("api_key", "key").All(&gotRoutings); err != nil {
+		t.Fatalf("get routings db error: %v", err)
+	}
+	if diff := cmp.Diff(wantRoutings, gotRoutings,
+		cmpopts.SortSlices(func(a, b model.Routing) bool { return a.Path < b.Path })); diff != "" {
+		t.Errorf("gotten routings differ:\n%v", diff)
+	}
+
+	// no drift remains
+	got, err = usecase.Reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if diff := cmp.Diff(model.ReconcileReport{CheckedAPIKeys: 1}, *got); diff != "" {
+		t.Errorf("report after reconcile differs:\n%v", diff)
+	}
+}
@@ -0,0 +1,42 @@
+package managementapi
+
+import (
+	"encoding/json"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"log"
+	"net/http"
+)
+
+// RefreshProductSwagger godoc
+// @Summary Re-import the swagger file of a product
+// @Description Re-import the swagger file of a product, and add or remove routings of all api keys which have the product authorized
+// @produce json
+// @Param id path int true "product id"
+// @Success 200 {object} model.SwaggerRefreshResp
+// @Failure 400 {object} validator.BadRequestResp
+// @Failure 500 {string} error
+// @Router /products/{id}/swagger/refresh [post]
+func RefreshProductSwagger(w http.ResponseWriter, r *http.Request) {
+	productID, err := parseIDParam(r, "id", "product id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	resp, err := usecase.RefreshProductSwagger(r.Context(), productID)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	respBody, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("create json response error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
@@ -0,0 +1,268 @@
+package managementapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/future-architect/apidoor/managementapi"
+	"github.com/future-architect/apidoor/managementapi/model"
+	swaggerparser "github.com/future-architect/apidoor/managementapi/swagger-parser"
+	"github.com/future-architect/apidoor/managementapi/usecase"
+	"github.com/future-architect/apidoor/managementapi/validator"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/guregu/dynamo"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestRefreshProductSwagger(t *testing.T) {
+	dbType := managementapi.GetAPIDBType(t)
+	if dbType != managementapi.DYNAMO {
+		log.Println("this test is valid when dynamodb is used, skip")
+		return
+	}
+
+	managementapi.Setup(t,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/api_routing_table.json`,
+		`aws dynamodb --profile local --endpoint-url http://localhost:4566 create-table --cli-input-json file://../dynamo_table/swagger_table.json`,
+	)
+	t.Cleanup(func() {
+		managementapi.Teardown(t,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table swagger`,
+			`aws dynamodb --profile local --endpoint-url http://localhost:4566 delete-table --table api_routing`,
+		)
+	})
+
+	cleanup := func() {
+		db.Exec("TRUNCATE apikey_contract_product_authorized")
+		db.Exec("DELETE FROM contract_product_content")
+		db.Exec("DELETE FROM contract")
+		db.Exec("DELETE FROM product")
+		db.Exec("DELETE FROM apikey")
+		db.Exec("DELETE FROM apiuser")
+		db.Exec("TRUNCATE routing_outbox")
+	}
+	cleanup()
+	defer cleanup()
+
+	usecase.Parser = swaggerparser.NewParser(swaggerparser.TestFetcher{})
+
+	// DB setup
+	var userID, productID, contractID, apikeyID, contractProductID int
+	if err := db.QueryRowx(`INSERT INTO apiuser(account_id, email_address, login_password_hash, name, created_at, updated_at)
+			VALUES ('user1', 'a', 'password', 'a', current_timestamp, current_timestamp) RETURNING id`).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO product(name, source, description, thumbnail, display_name, base_path, swagger_url, created_at, updated_at)
+			VALUES ('product1', 'a', 'a', 'a', 'a', '/sample_gateway', 'http://api.example.com/v2/swagger.json', current_timestamp, current_timestamp) RETURNING id`).Scan(&productID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO contract(user_id, created_at, updated_at)
+			VALUES ($1, current_timestamp, current_timestamp) RETURNING id`, userID).Scan(&contractID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO apikey(user_id, access_key, created_at, updated_at)
+			VALUES ($1, 'key', current_timestamp, current_timestamp) RETURNING id`, userID).Scan(&apikeyID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowx(`INSERT INTO contract_product_content(contract_id, product_id, created_at, updated_at)
+			VALUES ($1, $2, current_timestamp, current_timestamp) RETURNING id`, contractID, productID).Scan(&contractProductID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO apikey_contract_product_authorized(apikey_id, contract_product_id, created_at, updated_at)
+			VALUES ($1, $2, current_timestamp, current_timestamp)`, apikeyID, contractProductID); err != nil {
+		t.Fatal(err)
+	}
+
+	// dynamodb setup, the swagger before the provider changed the file
+	dbDynamo := dynamo.New(session.Must(session.NewSessionWithOptions(session.Options{
+		Profile:           "local",
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            aws.Config{Endpoint: aws.String("http://localhost:4566")},
+	})))
+	swaggerTable := os.Getenv("DYNAMO_TABLE_SWAGGER")
+	routingTable := os.Getenv("DYNAMO_TABLE_API_ROUTING")
+
+	oldSwagger := model.Swagger{
+		ProductID:      productID,
+		Schemes:        []string{"https"},
+		ForwardURLBase: "api.example.com/sample",
+		PathBase:       "/sample_gateway",
+		APIList: []model.API{
+			{
+				ForwardURL: "/users",
+				Path:       "/sample_users",
+			},
+			{
+				ForwardURL: "/old",
+				Path:       "/old",
+			},
+		},
+	}
+	if err := dbDynamo.Table(swaggerTable).Put(oldSwagger).Run(); err != nil {
+		t.Fatalf("put swagger failed: %v", err)
+	}
+	oldRoutings := []interface{}{
+		model.Routing{
+			APIKey:     "key",
+			Path:       "/sample_gateway/sample_users",
+			ForwardURL: "https://api.example.com/sample/users",
+			ContractID: contractID,
+			ProductID:  productID,
+			APIKeyID:   apikeyID,
+		},
+		model.Routing{
+			APIKey:     "key",
+			Path:       "/sample_gateway/old",
+			ForwardURL: "https://api.example.com/sample/old",
+			ContractID: contractID,
+			ProductID:  productID,
+			APIKeyID:   apikeyID,
+		},
+	}
+	if _, err := dbDynamo.Table(routingTable).Batch().Write().Put(oldRoutings...).Run(); err != nil {
+		t.Fatalf("put routings failed: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		productID    string
+		wantStatus   int
+		wantResp     interface{}
+		wantRoutings []model.Routing
+	}{
+		{
+			name:       "refresh swagger and resync routings properly",
+			productID:  fmt.Sprint(productID),
+			wantStatus: http.StatusOK,
+			wantResp: model.SwaggerRefreshResp{
+				ProductID: productID,
+				AddedAPIs: []model.API{
+					{
+						ForwardURL: "/users/{user_id}",
+						Path:       "/users/{user_id}",
+					},
+				},
+				RemovedAPIs: []model.API{
+					{
+						ForwardURL: "/old",
+						Path:       "/old",
+					},
+				},
+				UpdatedAPIs:     []model.API{},
+				AffectedAPIKeys: 1,
+				PostedRoutings:  1,
+				DeletedRoutings: 1,
+			},
+			wantRoutings: []model.Routing{
+				{
+					APIKey:     "key",
+					Path:       "/sample_gateway/sample_users",
+					ForwardURL: "https://api.example.com/sample/users",
+					ContractID: contractID,
+					ProductID:  productID,
+					APIKeyID:   apikeyID,
+				},
+				{
+					APIKey:     "key",
+					Path:       "/sample_gateway/users/{user_id}",
+					ForwardURL: "https://api.example.com/sample/users/{user_id}",
+					ContractID: contractID,
+					ProductID:  productID,
+					APIKeyID:   apikeyID,
+				},
+			},
+		},
+		{
+			name:       "product does not exist",
+			productID:  "-1",
+			wantStatus: http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: "product not found, id -1",
+			},
+		},
+		{
+			name:       "product id is not an integer",
+			productID:  "foo",
+			wantStatus: http.StatusBadRequest,
+			wantResp: validator.BadRequestResp{
+				Message: "product id must be an integer",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost,
+				fmt.Sprintf("localhost:3000/mgmt/products/%s/swagger/refresh", tt.productID), nil)
+			r = withURLParam(r, "id", tt.productID)
+
+			w := httptest.NewRecorder()
+			managementapi.RefreshProductSwagger(w, r)
+			processRoutingOutbox(t)
+
+			rw := w.Result()
+			resp, err := io.ReadAll(rw.Body)
+			if err != nil {
+				t.Errorf("read response body error: %v", err)
+				return
+			}
+
+			if rw.StatusCode != tt.wantStatus {
+				t.Errorf("wrong http status code: got %d, want %d", rw.StatusCode, tt.wantStatus)
+			}
+
+			switch want := tt.wantResp.(type) {
+			case model.SwaggerRefreshResp:
+				var got model.SwaggerRefreshResp
+				if err := json.Unmarshal(resp, &got); err != nil {
+					t.Errorf("parse response body failed: %v", err)
+					return
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Errorf("response differs:\n%v", diff)
+				}
+			case validator.BadRequestResp:
+				testBadRequestResp(t, &want, resp)
+			default:
+				t.Errorf("type of wantResp is not supported")
+			}
+
+			if tt.wantRoutings == nil {
+				return
+			}
+			var gotRoutings []model.Routing
+			if err = dbDynamo.Table(routingTable).Get("api_key", "key").All(&gotRoutings); err != nil {
+				t.Errorf("get routings db error: %v", err)
+				return
+			}
+			if diff := cmp.Diff(tt.wantRoutings, gotRoutings,
+				cmpopts.SortSlices(func(a, b model.Routing) bool { return a.Path < b.Path })); diff != "" {
+				t.Errorf("gotten routings differ:\n%v", diff)
+			}
+		})
+	}
+}
+
+// withURLParam sets the URL parameter, which chi router sets in routing, to the request
+func withURLParam(r *http.Request, key, value string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add(key, value)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+// processRoutingOutbox applies the outbox events written by the handler, which the worker of the server applies in background
+func processRoutingOutbox(t *testing.T) {
+	t.Helper()
+	if _, err := usecase.ProcessRoutingOutbox(context.Background()); err != nil {
+		t.Errorf("process routing outbox error: %v", err)
+	}
+}
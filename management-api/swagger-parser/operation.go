@@ -0,0 +1,208 @@
+package swaggerparser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxRefDepth limits how deep references are resolved, which stops recursive schemas
+const maxRefDepth = 16
+
+var operationMethods = []string{"get", "put", "post", "delete", "options", "head", "patch"}
+
+// Operation is an operation of an api which has parameters or a json request body to be validated,
+// or requires security schemes. schemas are json schemas whose local references are resolved
+type Operation struct {
+	// Method is an upper case http method, ex.) GET
+	Method     string
+	Parameters []Parameter
+	// RequestBody is the schema of the application/json request body, nil if not defined
+	RequestBody         map[string]interface{}
+	RequestBodyRequired bool
+	// Security is names of the security schemes the operation requires, which is nil if it requires none
+	Security []string
+}
+
+type Parameter struct {
+	Name string
+	// In is path, query or header
+	In       string
+	Required bool
+	Schema   map[string]interface{}
+}
+
+// parameterParser converts a parameter object of the version into a parameter,
+// and returns the request body schema instead if the parameter is a swagger v2 body parameter
+type parameterParser func(param map[string]interface{}) (*Parameter, map[string]interface{}, bool)
+
+// parseOperations returns operations of the path item, whose parameters are merged with the ones of the path item.
+// operations which have nothing to be validated nor security are omitted, and nil is returned if no operation remains
+func (sp *Parser) parseOperations(path string, item map[string]interface{}, parseParam parameterParser,
+	parseBody func(op map[string]interface{}) (map[string]interface{}, bool)) ([]Operation, error) {
+	common, err := sp.parameterList(path, item["parameters"])
+	if err != nil {
+		return nil, err
+	}
+
+	defaultSecurity, _ := securityRequirement(sp.data["security"])
+	var ret []Operation
+	for _, method := range operationMethods {
+		opField, ok := item[method]
+		if !ok {
+			continue
+		}
+		op, ok := opField.(map[string]interface{})
+		if !ok {
+			return nil, newError(FileParseError, fmt.Errorf("operation %s of path %s must be map field", method, path))
+		}
+		params, err := sp.parameterList(path, op["parameters"])
+		if err != nil {
+			return nil, err
+		}
+
+		operation := Operation{
+			Method:     strings.ToUpper(method),
+			Parameters: make([]Parameter, 0),
+		}
+		// parameters of the operation override the ones of the path item with the same name and location
+		merged := make(map[string]map[string]interface{})
+		order := make([]string, 0)
+		for _, v := range append(common, params...) {
+			name, _ := v["name"].(string)
+			in, _ := v["in"].(string)
+			key := in + "#" + name
+			if _, ok := merged[key]; !ok {
+				order = append(order, key)
+			}
+			merged[key] = v
+		}
+		for _, key := range order {
+			param, body, required := parseParam(merged[key])
+			if body != nil {
+				operation.RequestBody, operation.RequestBodyRequired = body, required
+				continue
+			}
+			if param != nil {
+				operation.Parameters = append(operation.Parameters, *param)
+			}
+		}
+		if parseBody != nil {
+			if body, required := parseBody(op); body != nil {
+				operation.RequestBody, operation.RequestBodyRequired = body, required
+			}
+		}
+		if security, ok := securityRequirement(op["security"]); ok {
+			operation.Security = sp.knownSchemes(security)
+		} else {
+			operation.Security = sp.knownSchemes(defaultSecurity)
+		}
+		sort.Slice(operation.Parameters, func(i, j int) bool {
+			if operation.Parameters[i].In != operation.Parameters[j].In {
+				return operation.Parameters[i].In < operation.Parameters[j].In
+			}
+			return operation.Parameters[i].Name < operation.Parameters[j].Name
+		})
+
+		if len(operation.Parameters) > 0 || operation.RequestBody != nil || len(operation.Security) > 0 {
+			ret = append(ret, operation)
+		}
+	}
+	return ret, nil
+}
+
+func (sp *Parser) parameterList(path string, field interface{}) ([]map[string]interface{}, error) {
+	if field == nil {
+		return nil, nil
+	}
+	items, ok := field.([]interface{})
+	if !ok {
+		return nil, newError(FileParseError, fmt.Errorf("parameters of path %s must be array field", path))
+	}
+	ret := make([]map[string]interface{}, 0, len(items))
+	for _, v := range items {
+		param, ok := sp.resolve(v, 0).(map[string]interface{})
+		if !ok {
+			return nil, newError(FileParseError, fmt.Errorf("parameter of path %s must be map field", path))
+		}
+		ret = append(ret, param)
+	}
+	return ret, nil
+}
+
+// resolve returns a copy of the node whose local references, ex.) #/definitions/User, are replaced with the referred nodes
+func (sp *Parser) resolve(node interface{}, depth int) interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$ref"].(string); ok {
+			if depth >= maxRefDepth {
+				// a recursive schema accepts anything beyond the depth
+				return map[string]interface{}{}
+			}
+			target, ok := sp.lookupRef(ref)
+			if !ok {
+				return map[string]interface{}{}
+			}
+			return sp.resolve(target, depth+1)
+		}
+		ret := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			ret[key] = sp.resolve(value, depth)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, value := range v {
+			ret[i] = sp.resolve(value, depth)
+		}
+		return ret
+	default:
+		return v
+	}
+}
+
+// lookupRef follows a json pointer in the document, references to other documents are not supported
+func (sp *Parser) lookupRef(ref string) (interface{}, bool) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, false
+	}
+	var node interface{} = sp.data
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[token]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// schemaOf returns the field as a schema, or nil if it is not a map
+func schemaOf(field interface{}) map[string]interface{} {
+	schema, _ := field.(map[string]interface{})
+	return schema
+}
+
+func newParameter(param map[string]interface{}, schema map[string]interface{}) *Parameter {
+	name, _ := param["name"].(string)
+	in, _ := param["in"].(string)
+	switch in {
+	case "path", "query", "header":
+	default:
+		// cookie and form parameters are not validated
+		return nil
+	}
+	required, _ := param["required"].(bool)
+	if schema == nil {
+		schema = map[string]interface{}{}
+	}
+	return &Parameter{
+		Name:     name,
+		In:       in,
+		Required: required || in == "path",
+		Schema:   schema,
+	}
+}
@@ -29,11 +29,17 @@ type Swagger struct {
 	ForwardURLBase string
 	PathBase       string
 	APIs           []API
+	// SecuritySchemes are the schemes of the upstream authentication, which are sorted by their names
+	SecuritySchemes []SecurityScheme
 }
 
 type API struct {
 	ForwardURL string
 	Path       string
+	// Operations are the operations of the api which have parameters, a json request body or security
+	Operations []Operation
+	// Security is names of the security schemes required by any operation of the api
+	Security []string
 }
 
 type Parser struct {
@@ -41,6 +47,8 @@ type Parser struct {
 
 	data map[string]interface{}
 	url  *url.URL
+	// securitySchemes are the schemes of the document being parsed
+	securitySchemes []SecurityScheme
 }
 
 func NewParser(fetcher FileFetcher) Parser {
@@ -143,3 +143,191 @@ func TestParser(t *testing.T) {
 	}
 
 }
+
+func TestParser_Operations(t *testing.T) {
+	parser := NewParser(TestFetcher{})
+
+	// the schema of friends is recursive, so it is resolved until maxRefDepth
+	userSchema := map[string]interface{}{}
+	for i := 0; i < maxRefDepth; i++ {
+		userSchema = map[string]interface{}{
+			"type":     "object",
+			"required": []interface{}{"name"},
+			"properties": map[string]interface{}{
+				"name": map[string]interface{}{"type": "string"},
+				"friends": map[string]interface{}{
+					"type":  "array",
+					"items": userSchema,
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		urlStr   string
+		wantAPIs []API
+	}{
+		{
+			name:   "parse operations of swagger v2",
+			urlStr: "http://api.example.com/v2/operations/swagger.json",
+			wantAPIs: []API{
+				{
+					ForwardURL: "/users",
+					Path:       "/users",
+					Operations: []Operation{
+						{
+							Method: "POST",
+							Parameters: []Parameter{
+								{
+									Name:   "dry_run",
+									In:     "query",
+									Schema: map[string]interface{}{"type": "boolean"},
+								},
+							},
+							RequestBody: map[string]interface{}{
+								"type":     "object",
+								"required": []interface{}{"name"},
+								"properties": map[string]interface{}{
+									"name": map[string]interface{}{"type": "string", "maxLength": float64(10)},
+								},
+							},
+							RequestBodyRequired: true,
+						},
+					},
+				},
+			},
+		},
+		{
+			name:   "parse operations of openapi v3",
+			urlStr: "http://api.example.com/v3/operations/swagger.yaml",
+			wantAPIs: []API{
+				{
+					ForwardURL: "/users",
+					Path:       "/users",
+					Operations: []Operation{
+						{
+							Method: "GET",
+							Parameters: []Parameter{
+								{
+									Name:   "X-Request-Id",
+									In:     "header",
+									Schema: map[string]interface{}{"type": "string"},
+								},
+								{
+									Name:   "limit",
+									In:     "query",
+									Schema: map[string]interface{}{"type": "integer", "maximum": float64(100)},
+								},
+							},
+						},
+						{
+							Method:              "POST",
+							Parameters:          []Parameter{},
+							RequestBody:         userSchema,
+							RequestBodyRequired: true,
+						},
+					},
+				},
+				{
+					ForwardURL: "/users/{user_id}",
+					Path:       "/users/{user_id}",
+					Operations: []Operation{
+						{
+							Method: "DELETE",
+							Parameters: []Parameter{
+								{
+									Name:     "user_id",
+									In:       "path",
+									Required: true,
+									Schema:   map[string]interface{}{"type": "integer"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			swagger, err := parser.Parse(context.Background(), tt.urlStr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantAPIs, swagger.APIs); diff != "" {
+				t.Errorf("returned apis differ:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParser_Security(t *testing.T) {
+	parser := NewParser(TestFetcher{})
+
+	tests := []struct {
+		name        string
+		urlStr      string
+		wantSchemes []SecurityScheme
+		wantAPIs    []API
+	}{
+		{
+			name:   "parse security of swagger v2",
+			urlStr: "http://api.example.com/v2/security/swagger.json",
+			wantSchemes: []SecurityScheme{
+				{Name: "api_key", Type: "apiKey", In: "header", ParamName: "X-API-Key"},
+				{Name: "basic", Type: "http", Scheme: "basic"},
+			},
+			wantAPIs: []API{
+				{
+					ForwardURL: "/health",
+					Path:       "/health",
+				},
+				{
+					ForwardURL: "/users",
+					Path:       "/users",
+					Operations: []Operation{
+						{Method: "GET", Parameters: []Parameter{}, Security: []string{"api_key"}},
+						{Method: "DELETE", Parameters: []Parameter{}, Security: []string{"basic"}},
+					},
+					Security: []string{"api_key", "basic"},
+				},
+			},
+		},
+		{
+			name:   "parse security of openapi v3",
+			urlStr: "http://api.example.com/v3/security/swagger.yaml",
+			wantSchemes: []SecurityScheme{
+				{Name: "api_key", Type: "apiKey", In: "query", ParamName: "key"},
+				{Name: "bearer", Type: "http", Scheme: "bearer"},
+				{Name: "oauth", Type: "oauth2", TokenURL: "https://auth.example.com/token"},
+			},
+			wantAPIs: []API{
+				{
+					ForwardURL: "/users",
+					Path:       "/users",
+					Operations: []Operation{
+						{Method: "GET", Parameters: []Parameter{}, Security: []string{"api_key", "bearer"}},
+					},
+					Security: []string{"api_key", "bearer"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			swagger, err := parser.Parse(context.Background(), tt.urlStr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantSchemes, swagger.SecuritySchemes); diff != "" {
+				t.Errorf("returned security schemes differ:\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantAPIs, swagger.APIs); diff != "" {
+				t.Errorf("returned apis differ:\n%s", diff)
+			}
+		})
+	}
+}
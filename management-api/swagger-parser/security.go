@@ -0,0 +1,134 @@
+package swaggerparser
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SecurityScheme is a scheme of the upstream authentication defined in the swagger file.
+// swagger v2 basic schemes are converted into http schemes of basic
+type SecurityScheme struct {
+	// Name is the key of the scheme in securityDefinitions or components.securitySchemes
+	Name string
+	// Type is apiKey, http, oauth2 or openIdConnect
+	Type string
+	// In is header, query or cookie for an apiKey scheme
+	In string
+	// ParamName is the name of the header, query parameter or cookie for an apiKey scheme
+	ParamName string
+	// Scheme is the http authentication scheme, ex.) bearer
+	Scheme string
+	// TokenURL is the token endpoint of the client credentials flow of an oauth2 scheme,
+	// which is the application flow in swagger v2
+	TokenURL string
+}
+
+// parseSecuritySchemes parses a map of scheme names to scheme objects, which are sorted by the names
+func (sp *Parser) parseSecuritySchemes(field interface{}) ([]SecurityScheme, error) {
+	if field == nil {
+		return nil, nil
+	}
+	schemes, ok := field.(map[string]interface{})
+	if !ok {
+		return nil, newErrorString(FileParseError, "security schemes must be map field")
+	}
+
+	ret := make([]SecurityScheme, 0, len(schemes))
+	for name, value := range schemes {
+		scheme, ok := sp.resolve(value, 0).(map[string]interface{})
+		if !ok {
+			return nil, newError(FileParseError, fmt.Errorf("security scheme %s must be map field", name))
+		}
+		typ, _ := scheme["type"].(string)
+		in, _ := scheme["in"].(string)
+		paramName, _ := scheme["name"].(string)
+		httpScheme, _ := scheme["scheme"].(string)
+		if typ == "basic" {
+			typ, httpScheme = "http", "basic"
+		}
+		ret = append(ret, SecurityScheme{
+			Name:      name,
+			Type:      typ,
+			In:        in,
+			ParamName: paramName,
+			Scheme:    httpScheme,
+			TokenURL:  clientCredentialsTokenURL(scheme),
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
+// securityRequirement returns names of the schemes of the first alternative of the security requirement.
+// ok is false if the field is not given, and an empty list overrides the security of the document with no security
+func securityRequirement(field interface{}) (names []string, ok bool) {
+	alternatives, ok := field.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	for _, v := range alternatives {
+		requirement, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		names = make([]string, 0, len(requirement))
+		for name := range requirement {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, true
+	}
+	return nil, true
+}
+
+// securityOfOperations returns the sorted union of schemes required by the operations
+func securityOfOperations(operations []Operation) []string {
+	set := make(map[string]struct{})
+	for _, op := range operations {
+		for _, name := range op.Security {
+			set[name] = struct{}{}
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	ret := make([]string, 0, len(set))
+	for name := range set {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// knownSchemes drops names which are not defined in the security schemes, nil is returned if no name remains
+func (sp *Parser) knownSchemes(names []string) []string {
+	var ret []string
+	for _, name := range names {
+		for _, scheme := range sp.securitySchemes {
+			if scheme.Name == name {
+				ret = append(ret, name)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// clientCredentialsTokenURL returns the token url of the client credentials flow of the oauth2 scheme, or empty if not defined
+func clientCredentialsTokenURL(scheme map[string]interface{}) string {
+	if typ, _ := scheme["type"].(string); typ != "oauth2" {
+		return ""
+	}
+	// swagger v2
+	if flow, _ := scheme["flow"].(string); flow == "application" {
+		tokenURL, _ := scheme["tokenUrl"].(string)
+		return tokenURL
+	}
+	// openapi v3
+	flows, _ := scheme["flows"].(map[string]interface{})
+	flow, _ := flows["clientCredentials"].(map[string]interface{})
+	tokenURL, _ := flow["tokenUrl"].(string)
+	return tokenURL
+}
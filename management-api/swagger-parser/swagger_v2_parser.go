@@ -2,6 +2,7 @@ package swaggerparser
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -31,17 +32,22 @@ func (p *parserV2) parse() (*Swagger, error) {
 		return nil, err
 	}
 
+	if p.securitySchemes, err = p.parseSecuritySchemes(p.data["securityDefinitions"]); err != nil {
+		return nil, err
+	}
+
 	apis, err := p.parsePaths()
 	if err != nil {
 		return nil, err
 	}
 
 	return &Swagger{
-		Version:        "v2",
-		Schemes:        schemes,
-		ForwardURLBase: forwardURLBase,
-		PathBase:       pathBase,
-		APIs:           apis,
+		Version:         "v2",
+		Schemes:         schemes,
+		ForwardURLBase:  forwardURLBase,
+		PathBase:        pathBase,
+		APIs:            apis,
+		SecuritySchemes: p.securitySchemes,
 	}, nil
 }
 
@@ -144,6 +150,7 @@ func (p parserV2) parsePaths() ([]API, error) {
 	}
 
 	ret := make([]API, 0, len(paths))
+	var err error
 	for path, value := range paths {
 		if !strings.HasPrefix(path, "/") {
 			return nil, newErrorString(FileParseError, "path's key must start with '/'")
@@ -172,11 +179,40 @@ func (p parserV2) parsePaths() ([]API, error) {
 				Path:       path,
 			}
 		}
+		if api.Operations, err = p.parseOperations(path, description, p.parseParameter, nil); err != nil {
+			return nil, err
+		}
+		api.Security = securityOfOperations(api.Operations)
 		ret = append(ret, api)
 	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ForwardURL < ret[j].ForwardURL
+	})
 	return ret, nil
 }
 
+// v2SchemaKeys are schema keywords which a non body parameter of swagger v2 has in itself
+var v2SchemaKeys = []string{"type", "format", "items", "enum", "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum",
+	"minLength", "maxLength", "pattern", "minItems", "maxItems", "collectionFormat"}
+
+func (p parserV2) parseParameter(param map[string]interface{}) (*Parameter, map[string]interface{}, bool) {
+	if in, _ := param["in"].(string); in == "body" {
+		required, _ := param["required"].(bool)
+		schema := schemaOf(param["schema"])
+		if schema == nil {
+			schema = map[string]interface{}{}
+		}
+		return nil, schema, required
+	}
+	schema := make(map[string]interface{})
+	for _, key := range v2SchemaKeys {
+		if v, ok := param[key]; ok {
+			schema[key] = v
+		}
+	}
+	return newParameter(param, schema), nil, false
+}
+
 // host field that contains scheme or path is invalid
 func isOnlyHostContained(host string) bool {
 	return !strings.ContainsRune(host, '/')
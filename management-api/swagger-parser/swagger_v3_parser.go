@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/url"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -33,17 +34,22 @@ func (p parserV3) parse() (*Swagger, error) {
 		return nil, err
 	}
 
+	if p.securitySchemes, err = p.parseSecuritySchemes(p.componentsField("securitySchemes")); err != nil {
+		return nil, err
+	}
+
 	apis, err := p.parsePaths()
 	if err != nil {
 		return nil, err
 	}
 
 	return &Swagger{
-		Version:        "v3",
-		ForwardURLBase: forwardURLBase,
-		Schemes:        schemes,
-		PathBase:       pathBase,
-		APIs:           apis,
+		Version:         "v3",
+		ForwardURLBase:  forwardURLBase,
+		Schemes:         schemes,
+		PathBase:        pathBase,
+		APIs:            apis,
+		SecuritySchemes: p.securitySchemes,
 	}, nil
 }
 
@@ -140,6 +146,7 @@ func (p parserV3) parsePaths() ([]API, error) {
 	}
 
 	ret := make([]API, 0, len(paths))
+	var err error
 	for path, value := range paths {
 		if !strings.HasPrefix(path, "/") {
 			return nil, newErrorString(FileParseError, "path's key must start with '/'")
@@ -168,7 +175,55 @@ func (p parserV3) parsePaths() ([]API, error) {
 				Path:       path,
 			}
 		}
+		if api.Operations, err = p.parseOperations(path, description, p.parseParameter, p.parseRequestBody); err != nil {
+			return nil, err
+		}
+		api.Security = securityOfOperations(api.Operations)
 		ret = append(ret, api)
 	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ForwardURL < ret[j].ForwardURL
+	})
 	return ret, nil
 }
+
+// componentsField returns the field of components, nil if not defined
+func (p parserV3) componentsField(name string) interface{} {
+	components, ok := p.data["components"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return components[name]
+}
+
+func (p parserV3) parseParameter(param map[string]interface{}) (*Parameter, map[string]interface{}, bool) {
+	return newParameter(param, schemaOf(param["schema"])), nil, false
+}
+
+// parseRequestBody returns the schema of the json request body of the operation
+func (p parserV3) parseRequestBody(op map[string]interface{}) (map[string]interface{}, bool) {
+	body, ok := p.resolve(op["requestBody"], 0).(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	content, ok := body["content"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	required, _ := body["required"].(bool)
+	for mediaType, value := range content {
+		if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+			continue
+		}
+		media, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		schema := schemaOf(media["schema"])
+		if schema == nil {
+			schema = map[string]interface{}{}
+		}
+		return schema, required
+	}
+	return nil, false
+}
@@ -22,6 +22,10 @@ func (tf TestFetcher) Fetch(ctx context.Context, url *url.URL) (*swaggerFile, er
 		filePath = "./testdata/testv2_no_host_provided.json"
 	case "http://api.example.com/v2/wrong_format/swagger.json":
 		filePath = "./testdata/testv2_wrong_format.json"
+	case "http://api.example.com/v2/operations/swagger.json":
+		filePath = "./testdata/testv2_operations.json"
+	case "http://api.example.com/v2/security/swagger.json":
+		filePath = "./testdata/testv2_security.json"
 	// openapi 3.0
 	case "http://api.example.com/v3/swagger.yaml":
 		filePath = "./testdata/testv3.yaml"
@@ -29,6 +33,10 @@ func (tf TestFetcher) Fetch(ctx context.Context, url *url.URL) (*swaggerFile, er
 		filePath = "./testdata/testv3_no_servers_provided.yaml"
 	case "http://api.example.com/v3/wrong_format/swagger.yaml":
 		filePath = "./testdata/testv3_wrong_format.yaml"
+	case "http://api.example.com/v3/operations/swagger.yaml":
+		filePath = "./testdata/testv3_operations.yaml"
+	case "http://api.example.com/v3/security/swagger.yaml":
+		filePath = "./testdata/testv3_security.yaml"
 	case "http://api.example.com/v4/swagger.yaml":
 		filePath = "./testdata/testv4.yaml"
 	default:
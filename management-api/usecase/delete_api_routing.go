@@ -0,0 +1,58 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/apirouting"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+)
+
+// DeleteRouting removes the routing from the routing store through the outbox.
+// routings generated from authorizations are restored by reconcile while the authorizations remain,
+// so use DeleteContractRoutings or revoke the authorizations to remove them permanently
+func DeleteRouting(ctx context.Context, req model.DeleteRoutingReq) error {
+	routings, err := apirouting.ApiDBDriver.GetRoutings(ctx, req.APIKey)
+	if err != nil {
+		log.Printf("get api routings db error: %v", err)
+		return ServerError{err}
+	}
+	found := false
+	for _, v := range routings {
+		if v.Path == req.Path {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ClientError{fmt.Errorf("routing not found, api_key %s, path %s", req.APIKey, req.Path)}
+	}
+
+	payload := deleteRoutingPayload{AccessKey: req.APIKey, Path: req.Path}
+	if err = db.postOutboxEvent(ctx, outboxDeleteRouting, payload); err != nil {
+		log.Printf("insert outbox event of routing %s db error: %v", req.Path, err)
+		return ServerError{err}
+	}
+	notifyRoutingOutbox()
+	return nil
+}
+
+// DeleteContractRoutings revokes authorizations linked to the contract, and removes routings linked to it
+// from the routing store through the outbox. the contract itself is kept, so api keys can be authorized again
+func DeleteContractRoutings(ctx context.Context, contractID int) error {
+	if _, err := db.fetchContract(ctx, contractID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ClientError{fmt.Errorf("contract not found, id %d", contractID)}
+		}
+		log.Printf("fetch contract db error: %v", err)
+		return ServerError{err}
+	}
+
+	if err := db.deleteContractRoutings(ctx, contractID); err != nil {
+		log.Printf("delete routings of contract %d db error: %v", contractID, err)
+		return ServerError{err}
+	}
+	notifyRoutingOutbox()
+	return nil
+}
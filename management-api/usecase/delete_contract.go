@@ -0,0 +1,41 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// TerminateContract ends the contract at the current time, and removes authorizations and routings linked to it
+func TerminateContract(ctx context.Context, contractID int) error {
+	if _, err := db.fetchContract(ctx, contractID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ClientError{fmt.Errorf("contract not found, id %d", contractID)}
+		}
+		log.Printf("fetch contract db error: %v", err)
+		return ServerError{err}
+	}
+
+	if err := db.terminateContract(ctx, contractID); err != nil {
+		log.Printf("terminate contract db error: %v", err)
+		return ServerError{err}
+	}
+	notifyRoutingOutbox()
+	return nil
+}
+
+// ExpireContracts removes authorizations and routings linked to contracts whose end date has passed,
+// and returns the number of removed authorizations
+func ExpireContracts(ctx context.Context) (int, error) {
+	auths, err := db.fetchExpiredAuthorizations(ctx)
+	if err != nil {
+		log.Printf("fetch expired authorizations db error: %v", err)
+		return 0, ServerError{err}
+	}
+	if err = revokeAuthorizations(ctx, auths); err != nil {
+		log.Printf("revoke authorizations error: %v", err)
+		return 0, ServerError{err}
+	}
+	return len(auths), nil
+}
@@ -0,0 +1,22 @@
+package usecase
+
+import (
+	"context"
+	"log"
+)
+
+// DeleteUser soft-deletes the user. api keys of the user are revoked with their authorizations and routings,
+// and contracts of the user are ended
+func DeleteUser(ctx context.Context, accountID string) error {
+	user, err := fetchUserByAccountID(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	if err = db.deleteUser(ctx, user.ID); err != nil {
+		log.Printf("db delete user error: %v", err)
+		return ServerError{err}
+	}
+	notifyRoutingOutbox()
+	return nil
+}
@@ -0,0 +1,86 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/mail"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	verificationTokenBytes = 32
+	verificationTokenTTL   = 24 * time.Hour
+)
+
+var MailSender mail.Sender = mail.NewSenderFromEnv()
+
+// SendVerificationMail sends the mail to verify the current email address of the user again
+func SendVerificationMail(ctx context.Context, accountID string) error {
+	user, err := fetchUserByAccountID(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return ClientError{fmt.Errorf("email address of account_id %s has already been verified", accountID)}
+	}
+	if err = sendVerificationMail(ctx, user.ID, user.EmailAddress); err != nil {
+		log.Printf("send verification mail error: %v", err)
+		return ServerError{err}
+	}
+	return nil
+}
+
+// VerifyEmail marks the email address linked to the token as verified
+func VerifyEmail(ctx context.Context, req model.VerifyEmailReq) (*model.UserResp, error) {
+	userID, err := db.verifyEmail(ctx, hashVerificationToken(req.Token))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ClientError{errors.New("token is invalid or expired")}
+		}
+		log.Printf("verify email db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	user, err := db.fetchUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("fetch user db error: %v", err)
+		return nil, ServerError{err}
+	}
+	resp := model.NewUserResp(*user)
+	return &resp, nil
+}
+
+func sendVerificationMail(ctx context.Context, userID int, address string) error {
+	token := generateKey(verificationTokenBytes)
+	err := db.postEmailVerification(ctx, &model.EmailVerificationDB{
+		UserID:       userID,
+		EmailAddress: address,
+		TokenHash:    hashVerificationToken(token),
+		ExpiresAt:    time.Now().UTC().Add(verificationTokenTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("db insert email verification error: %w", err)
+	}
+
+	body := fmt.Sprintf("Please verify your email address with the following token within %v.\n\n%s\n", verificationTokenTTL, token)
+	if base := os.Getenv("EMAIL_VERIFICATION_URL"); base != "" {
+		body += fmt.Sprintf("\nor open the link: %s?token=%s\n", base, token)
+	}
+	return MailSender.Send(ctx, mail.Message{
+		To:      address,
+		Subject: "Verify your email address",
+		Body:    body,
+	})
+}
+
+// hashVerificationToken returns the value stored in DB, tokens themselves are never stored
+func hashVerificationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
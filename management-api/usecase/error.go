@@ -19,3 +19,12 @@ type ServerError struct {
 func NewServerError(err error) ServerError {
 	return ServerError{err}
 }
+
+// ConflictError is returned when the request conflicts with the current state of a resource, e.g. duplicate keys
+type ConflictError struct {
+	error
+}
+
+func NewConflictError(err error) ConflictError {
+	return ConflictError{err}
+}
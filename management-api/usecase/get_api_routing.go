@@ -0,0 +1,42 @@
+package usecase
+
+import (
+	"context"
+	"github.com/future-architect/apidoor/managementapi/apirouting"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+)
+
+func GetRoutings(ctx context.Context, req model.GetRoutingsReq) (*model.RoutingListResp, error) {
+	routings, err := apirouting.ApiDBDriver.GetRoutings(ctx, req.APIKey)
+	if err != nil {
+		log.Printf("get api routings db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = model.ResultLimitDefault
+	}
+
+	// routings of a key are bounded by the products it is authorized to, so they are paginated in memory
+	start := req.Offset
+	if start > len(routings) {
+		start = len(routings)
+	}
+	end := start + limit
+	if end > len(routings) {
+		end = len(routings)
+	}
+
+	return &model.RoutingListResp{
+		RoutingList: routings[start:end],
+		MetaData: model.RoutingListMetaData{
+			ResultSet: model.ResultSet{
+				Count:  len(routings),
+				Limit:  limit,
+				Offset: req.Offset,
+			},
+		},
+	}, nil
+}
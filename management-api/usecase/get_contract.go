@@ -0,0 +1,64 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+)
+
+func GetContracts(ctx context.Context, req model.GetContractsReq) (*model.ContractListResp, error) {
+	userID, err := fetchUserID(ctx, req.UserAccountID)
+	if err != nil {
+		log.Printf("fetch user id error: %v", err)
+		if errors.Is(err, ErrNotFound) {
+			return nil, ClientError{fmt.Errorf("account_id %s does not exist", req.UserAccountID)}
+		}
+		return nil, ServerError{err}
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = model.ResultLimitDefault
+	}
+
+	list, count, err := db.fetchContractsByUser(ctx, userID, limit, req.Offset)
+	if err != nil {
+		log.Printf("fetch contracts db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	return &model.ContractListResp{
+		ContractList: list,
+		MetaData: model.ContractListMetaData{
+			ResultSet: model.ResultSet{
+				Count:  count,
+				Limit:  limit,
+				Offset: req.Offset,
+			},
+		},
+	}, nil
+}
+
+func GetContract(ctx context.Context, contractID int) (*model.ContractDetail, error) {
+	contract, err := db.fetchContract(ctx, contractID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ClientError{fmt.Errorf("contract not found, id %d", contractID)}
+		}
+		log.Printf("fetch contract db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	products, err := db.fetchContractProducts(ctx, contractID)
+	if err != nil {
+		log.Printf("fetch contract products db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	return &model.ContractDetail{
+		Contract: *contract,
+		Products: products,
+	}, nil
+}
@@ -0,0 +1,25 @@
+package usecase
+
+import (
+	"context"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+)
+
+func GetUsage(ctx context.Context, params *model.UsageParams) (*model.UsageResp, error) {
+	list, err := db.fetchUsage(ctx, params)
+	if err != nil {
+		log.Printf("fetch usage db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	return &model.UsageResp{
+		UsageList: list,
+		MetaData: model.UsageMetaData{
+			From:        params.From.Format(model.UsageDateLayout),
+			To:          params.To.AddDate(0, 0, -1).Format(model.UsageDateLayout),
+			Granularity: params.Granularity,
+			GroupBy:     params.GroupBy,
+		},
+	}, nil
+}
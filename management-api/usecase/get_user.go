@@ -0,0 +1,31 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+)
+
+func GetUser(ctx context.Context, accountID string) (*model.UserResp, error) {
+	user, err := fetchUserByAccountID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	resp := model.NewUserResp(*user)
+	return &resp, nil
+}
+
+// fetchUserByAccountID returns ClientError if the user does not exist or is deleted
+func fetchUserByAccountID(ctx context.Context, accountID string) (*model.User, error) {
+	user, err := db.fetchUser(ctx, accountID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ClientError{fmt.Errorf("account_id %s does not exist", accountID)}
+		}
+		log.Printf("fetch user db error: %v", err)
+		return nil, ServerError{err}
+	}
+	return user, nil
+}
@@ -0,0 +1,189 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+	"time"
+)
+
+// PutPricingPlan replaces the default pricing plan of the product, or the plan of the product in the contract of the request
+func PutPricingPlan(ctx context.Context, productID int, req model.PutPricingPlanReq) (*model.PricingPlan, error) {
+	plan, err := db.upsertPricingPlan(ctx, productID, req)
+	if err != nil {
+		if constraintErr, ok := err.(*dbConstraintErr); ok {
+			return nil, ClientError{fmt.Errorf("%s %v does not exist", constraintErr.field, constraintErr.value)}
+		}
+		log.Printf("upsert pricing plan db error: %v", err)
+		return nil, ServerError{err}
+	}
+	return plan, nil
+}
+
+// GenerateInvoices replaces the invoices of the month with the ones generated at generatedAt from the billable calls
+// in log_list. an invoice is generated for each contract and currency, with an item for each product.
+// the result is the same as long as log_list and the pricing plans are not changed.
+// the invoices of a finalized month are not replaced unless force is true
+func GenerateInvoices(ctx context.Context, month, generatedAt time.Time, force bool) (*model.GenerateInvoicesResult, error) {
+	month = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	plans, err := db.fetchPricingPlans(ctx)
+	if err != nil {
+		log.Printf("fetch pricing plans db error: %v", err)
+		return nil, ServerError{err}
+	}
+	calls, err := db.fetchBillableCalls(ctx, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		log.Printf("fetch billable calls db error: %v", err)
+		return nil, ServerError{err}
+	}
+	unattributed, err := db.countUnattributedCalls(ctx, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		log.Printf("count unattributed calls db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	invoices, unpriced := buildInvoices(plans, calls, generatedAt.UTC())
+	if err = db.replaceInvoices(ctx, month, invoices, force); err != nil {
+		if errors.Is(err, ErrInvoicesFinalized) {
+			return nil, ClientError{fmt.Errorf("invoices of %s are finalized", month.Format(model.InvoiceMonthLayout))}
+		}
+		log.Printf("replace invoices db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	return &model.GenerateInvoicesResult{
+		Month:             month.Format(model.InvoiceMonthLayout),
+		Invoices:          len(invoices),
+		UnpricedProducts:  unpriced,
+		UnattributedCalls: unattributed,
+	}, nil
+}
+
+// FinalizeInvoices freezes the invoices of the month, which are not generated again after that unless forced.
+// it returns the time when the month is finalized, which is the time of the first finalization
+func FinalizeInvoices(ctx context.Context, month, finalizedAt time.Time) (time.Time, error) {
+	month = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	finalizedAt, err := db.finalizeInvoices(ctx, month, finalizedAt.UTC())
+	if err != nil {
+		log.Printf("finalize invoices db error: %v", err)
+		return time.Time{}, ServerError{err}
+	}
+	return finalizedAt, nil
+}
+
+// buildInvoices charges the billable calls sorted by the contract and the product by the plan of the contract,
+// or the default plan of the product
+func buildInvoices(plans []model.PricingPlan, calls []model.BillableCallsDB, generatedAt time.Time) ([]model.InvoiceDetail, []model.UnpricedProduct) {
+	type planKey struct {
+		productID, contractID int
+	}
+	planMap := make(map[planKey]model.PricingPlan, len(plans))
+	for _, plan := range plans {
+		key := planKey{productID: plan.ProductID}
+		if plan.ContractID != nil {
+			key.contractID = *plan.ContractID
+		}
+		planMap[key] = plan
+	}
+
+	type invoiceKey struct {
+		contractID int
+		currency   string
+	}
+	var invoices []model.InvoiceDetail
+	index := make(map[invoiceKey]int)
+	unpriced := make([]model.UnpricedProduct, 0)
+	for _, c := range calls {
+		plan, ok := planMap[planKey{productID: c.ProductID, contractID: c.ContractID}]
+		if !ok {
+			plan, ok = planMap[planKey{productID: c.ProductID}]
+		}
+		if !ok {
+			unpriced = append(unpriced, model.UnpricedProduct{
+				ContractID:    c.ContractID,
+				ProductID:     c.ProductID,
+				ProductName:   c.ProductName,
+				BillableCalls: c.BillableCalls,
+			})
+			continue
+		}
+
+		key := invoiceKey{contractID: c.ContractID, currency: plan.Currency}
+		i, ok := index[key]
+		if !ok {
+			i = len(invoices)
+			index[key] = i
+			invoices = append(invoices, model.InvoiceDetail{
+				Invoice: model.Invoice{
+					ContractID:  c.ContractID,
+					Currency:    plan.Currency,
+					GeneratedAt: generatedAt,
+				},
+			})
+		}
+		freeCalls, amount := plan.Charge(c.BillableCalls)
+		invoices[i].TotalMicros += amount
+		invoices[i].Items = append(invoices[i].Items, model.InvoiceItem{
+			ProductID:     c.ProductID,
+			ProductName:   c.ProductName,
+			BillableCalls: c.BillableCalls,
+			FreeCalls:     freeCalls,
+			AmountMicros:  amount,
+			Pricing: model.InvoicePricing{
+				PlanID:     plan.ID,
+				ContractID: plan.ContractID,
+				FreeCalls:  plan.FreeCalls,
+				Tiers:      plan.Tiers,
+			},
+		})
+	}
+	return invoices, unpriced
+}
+
+func GetInvoices(ctx context.Context, req model.GetInvoicesReq) (*model.InvoiceListResp, error) {
+	var month *time.Time
+	if req.Month != "" {
+		m, err := time.Parse(model.InvoiceMonthLayout, req.Month)
+		if err != nil {
+			return nil, ClientError{fmt.Errorf("invalid month %s", req.Month)}
+		}
+		month = &m
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = model.ResultLimitDefault
+	}
+
+	list, count, err := db.fetchInvoices(ctx, req.ContractID, month, limit, req.Offset)
+	if err != nil {
+		log.Printf("fetch invoices db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	return &model.InvoiceListResp{
+		InvoiceList: list,
+		MetaData: model.InvoiceListMetaData{
+			ResultSet: model.ResultSet{
+				Count:  count,
+				Limit:  limit,
+				Offset: req.Offset,
+			},
+		},
+	}, nil
+}
+
+func GetInvoice(ctx context.Context, invoiceID int) (*model.InvoiceDetail, error) {
+	invoice, err := db.fetchInvoice(ctx, invoiceID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ClientError{fmt.Errorf("invoice not found, id %d", invoiceID)}
+		}
+		log.Printf("fetch invoice db error: %v", err)
+		return nil, ServerError{err}
+	}
+	return invoice, nil
+}
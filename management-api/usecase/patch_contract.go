@@ -0,0 +1,98 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+	"time"
+)
+
+// PatchContract amends products and the end date of the contract.
+// authorizations linked to removed products are deleted in the same transaction, and their routings through the outbox
+func PatchContract(ctx context.Context, contractID int, req model.PatchContractReq) (*model.ContractDetail, error) {
+	current, err := GetContract(ctx, contractID)
+	if err != nil {
+		return nil, err
+	}
+	if isContractEnded(current.Contract, time.Now()) {
+		return nil, ClientError{fmt.Errorf("contract %d has already ended", contractID)}
+	}
+
+	endAt, err := parseContractTime(req.EndAt)
+	if err != nil {
+		return nil, ClientError{err}
+	}
+	if endAt != nil && current.StartAt != nil {
+		if startAt, err := time.Parse(time.RFC3339Nano, *current.StartAt); err == nil && !endAt.After(startAt) {
+			return nil, ClientError{errors.New("end_at must be after start_at")}
+		}
+	}
+
+	contained := make(map[string]struct{}, len(current.Products))
+	for _, v := range current.Products {
+		contained[v.ProductName] = struct{}{}
+	}
+
+	var addProducts []*model.ContractProductContentDB
+	if len(req.AddProducts) > 0 {
+		for _, v := range req.AddProducts {
+			if _, ok := contained[v.ProductName]; ok {
+				return nil, ClientError{fmt.Errorf("product_name %s is already contained in contract %d", v.ProductName, contractID)}
+			}
+		}
+		if addProducts, err = fetchProductIDs(ctx, req.AddProducts); err != nil {
+			log.Printf("fetch ids of products error: %v", err)
+			return nil, err
+		}
+	}
+
+	var removeProductIDs []int
+	if len(req.RemoveProducts) > 0 {
+		for _, name := range req.RemoveProducts {
+			if _, ok := contained[name]; !ok {
+				return nil, ClientError{fmt.Errorf("product_name %s is not contained in contract %d", name, contractID)}
+			}
+		}
+		productMap, err := db.fetchProducts(ctx, req.RemoveProducts)
+		if err != nil {
+			log.Printf("fetch products db error: %v", err)
+			return nil, ServerError{err}
+		}
+		for _, name := range req.RemoveProducts {
+			removeProductIDs = append(removeProductIDs, productMap[name].ID)
+		}
+	}
+
+	err = db.patchContract(ctx, &model.PatchContractDB{
+		ContractID:       contractID,
+		AddProducts:      addProducts,
+		RemoveProductIDs: removeProductIDs,
+		EndAt:            endAt,
+	})
+	if err != nil {
+		log.Printf("db update contract error: %v", err)
+		if constraintErr, ok := err.(*dbConstraintErr); ok {
+			return nil, ClientError{fmt.Errorf("product_id %v does not exist", constraintErr.value)}
+		}
+		return nil, ServerError{err}
+	}
+	if len(removeProductIDs) > 0 || endAt != nil && !endAt.After(time.Now()) {
+		notifyRoutingOutbox()
+	}
+
+	return GetContract(ctx, contractID)
+}
+
+func isContractEnded(contract model.Contract, now time.Time) bool {
+	if contract.EndAt == nil {
+		return false
+	}
+	endAt, err := time.Parse(time.RFC3339Nano, *contract.EndAt)
+	if err != nil {
+		log.Printf("parse end_at of contract %d failed: %v", contract.ID, err)
+		return false
+	}
+	return !endAt.After(now)
+}
@@ -0,0 +1,34 @@
+package usecase
+
+import (
+	"context"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+)
+
+// PatchUser updates the profile of the user.
+// if the email address is changed, a verification mail is sent to the new address
+func PatchUser(ctx context.Context, accountID string, req model.PatchUserReq) (*model.UserResp, error) {
+	user, err := fetchUserByAccountID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.patchUser(ctx, &model.PatchUserDB{
+		ID:           user.ID,
+		EmailAddress: req.EmailAddress,
+		Name:         req.Name,
+	})
+	if err != nil {
+		log.Printf("db update user error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	if req.EmailAddress != nil && *req.EmailAddress != user.EmailAddress {
+		if err = sendVerificationMail(ctx, user.ID, *req.EmailAddress); err != nil {
+			log.Printf("send verification mail error: %v", err)
+		}
+	}
+
+	return GetUser(ctx, accountID)
+}
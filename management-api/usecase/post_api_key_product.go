@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/future-architect/apidoor/managementapi/apirouting"
 	"github.com/future-architect/apidoor/managementapi/model"
 	"log"
 	"sort"
@@ -37,36 +36,16 @@ func PostAPIKeyProducts(ctx context.Context, req *model.PostAPIKeyProductsReq) e
 		return ClientError{err}
 	}
 
-	// TODO: 中途失敗時のrollback処理
-	err = db.postAPIKeyContractProductAuthorized(ctx, apiKeyID, contractProducts)
+	// routings are posted through the outbox, written in the same transaction as authorizations
+	err = db.postAPIKeyContractProductAuthorized(ctx, apiKeyID, keyAndUserID.apiKey, contractProducts)
 	if err != nil {
 		log.Printf("insert apikey_contract_product_authorized db error: %v", err)
-		if err, ok := err.(dbConstraintErr); ok {
+		if err, ok := err.(*dbConstraintErr); ok {
 			return ClientError{err}
 		}
 		return ServerError{err}
 	}
-
-	productIDs := productIDs(contractProducts)
-	log.Printf("products %v", productIDs)
-	swaggers, err := apirouting.ApiDBDriver.BatchGetSwagger(ctx, productIDs)
-	if err != nil {
-		log.Printf("get swagger info list db error: %v", err)
-		return ServerError{err}
-	}
-	routings, err := generateRoutings(keyAndUserID.apiKey, contractProducts, swaggers)
-	if err != nil {
-		log.Printf("in generating routings, data consistency error: %v", err)
-		return ServerError{err}
-	}
-
-	log.Println(routings)
-
-	_, err = apirouting.ApiDBDriver.BatchPostRouting(ctx, routings)
-	if err != nil {
-		log.Printf("post api routing db error: %v", err)
-		return ServerError{err}
-	}
+	notifyRoutingOutbox()
 
 	return nil
 }
@@ -136,7 +115,7 @@ func productIDs(items []model.ContractProductDB) []int {
 	return ret
 }
 
-func generateRoutings(apikey string, products []model.ContractProductDB, swaggers []model.Swagger) ([]model.Routing, error) {
+func generateRoutings(apikey string, apiKeyID int, products []model.ContractProductDB, swaggers []model.Swagger) ([]model.Routing, error) {
 	swaggerMap := make(map[int]model.Swagger)
 	for _, v := range swaggers {
 		swaggerMap[v.ProductID] = v
@@ -154,6 +133,10 @@ func generateRoutings(apikey string, products []model.ContractProductDB, swagger
 					Path:       swagger.PathBase + api.Path,
 					ForwardURL: fmt.Sprintf("%s://%s%s", scheme, swagger.ForwardURLBase, api.ForwardURL),
 					ContractID: v.ContractID,
+					APIKeyID:   apiKeyID,
+					ProductID:  v.ProductID,
+					Transform:  swagger.Transform,
+					Operations: api.Operations,
 				})
 			}
 		}
@@ -2,15 +2,59 @@ package usecase
 
 import (
 	"context"
+	"fmt"
 	"github.com/future-architect/apidoor/managementapi/apirouting"
 	"github.com/future-architect/apidoor/managementapi/model"
+	"github.com/future-architect/apidoor/managementapi/validator"
 	"log"
 )
 
 func PostRouting(ctx context.Context, req model.PostAPIRoutingReq) error {
-	if err := apirouting.ApiDBDriver.PostRouting(ctx, req.ApiKey, req.Path, req.ForwardURL); err != nil {
+	if err := checkRoutingConflict(ctx, req.ApiKey, req.Path); err != nil {
+		return err
+	}
+
+	if err := apirouting.ApiDBDriver.PostRouting(ctx, req.Routing()); err != nil {
 		log.Printf("post api routing db error: %v", err)
 		return ServerError{err}
 	}
 	return nil
 }
+
+// checkRoutingConflict returns validator.ValidationErrors if the path matches the same requests as another routing of the key.
+// posting the same path again overwrites the routing, so it is not a conflict
+func checkRoutingConflict(ctx context.Context, apiKey, path string) error {
+	template, err := validator.ParsePathTemplate(path)
+	if err != nil {
+		// unreachable, because the request is validated in advance
+		return ClientError{err}
+	}
+
+	routings, err := apirouting.ApiDBDriver.GetRoutings(ctx, apiKey)
+	if err != nil {
+		log.Printf("get api routings db error: %v", err)
+		return ServerError{err}
+	}
+
+	for _, v := range routings {
+		if v.Path == path {
+			continue
+		}
+		existing, err := validator.ParsePathTemplate(v.Path)
+		if err != nil {
+			log.Printf("stored routing %s of key %s cannot be parsed: %v", v.Path, apiKey, err)
+			continue
+		}
+		if template.Conflicts(existing) {
+			return validator.ValidationErrors{
+				{
+					Field:          "path",
+					ConstraintType: "conflict",
+					Message:        fmt.Sprintf("path conflicts with the existing routing %s", v.Path),
+					Got:            path,
+				},
+			}
+		}
+	}
+	return nil
+}
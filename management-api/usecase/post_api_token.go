@@ -3,12 +3,20 @@ package usecase
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/future-architect/apidoor/managementapi/apirouting"
 	"github.com/future-architect/apidoor/managementapi/model"
 	"log"
+	"strings"
 )
 
 func PostAPIToken(ctx context.Context, req model.PostAPITokenReq) error {
+	for i, v := range req.AccessTokens {
+		if v.ParamType == model.BodyJSON && !strings.HasPrefix(v.Key, "/") {
+			return ClientError{fmt.Errorf("key of tokens[%d] must be a json pointer, ex.) /auth/token", i)}
+		}
+	}
+
 	// check whether api routing exists
 	cnt, err := apirouting.ApiDBDriver.CountRouting(ctx, req.APIKey, req.Path)
 	if err != nil {
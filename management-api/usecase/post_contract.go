@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/future-architect/apidoor/managementapi/model"
 	"log"
+	"time"
 )
 
 func PostContract(ctx context.Context, req model.PostContractReq) error {
@@ -24,8 +25,22 @@ func PostContract(ctx context.Context, req model.PostContractReq) error {
 		return err
 	}
 
+	startAt, err := parseContractTime(req.StartAt)
+	if err != nil {
+		return ClientError{err}
+	}
+	endAt, err := parseContractTime(req.EndAt)
+	if err != nil {
+		return ClientError{err}
+	}
+	if startAt != nil && endAt != nil && !endAt.After(*startAt) {
+		return ClientError{errors.New("end_at must be after start_at")}
+	}
+
 	contract := model.PostContractDB{
 		UserID:   userID,
+		StartAt:  startAt,
+		EndAt:    endAt,
 		Products: products,
 	}
 
@@ -79,3 +94,16 @@ func fetchProductIDs(ctx context.Context, products []*model.ContractProducts) ([
 
 	return contractProducts, nil
 }
+
+// parseContractTime parses start_at or end_at field. if the field is empty, it returns nil
+func parseContractTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(model.ContractTimeLayout, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not %s format", value, model.ContractTimeLayout)
+	}
+	t = t.UTC()
+	return &t, nil
+}
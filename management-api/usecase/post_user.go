@@ -2,14 +2,24 @@ package usecase
 
 import (
 	"context"
+	"fmt"
 	"github.com/future-architect/apidoor/managementapi/model"
 	"log"
 )
 
 func PostUser(ctx context.Context, req model.PostUserReq) error {
-	if err := db.postUser(ctx, &req); err != nil {
+	userID, err := db.postUser(ctx, &req)
+	if err != nil {
 		log.Printf("db insert user error: %v", err)
+		if constraintErr, ok := err.(*dbConstraintErr); ok && constraintErr.constraintType == uniqueErr {
+			return ConflictError{fmt.Errorf("account_id %s already exists", req.AccountID)}
+		}
 		return ServerError{err}
 	}
+
+	// the user can request the verification mail again, so a failure of sending it does not fail the registration
+	if err = sendVerificationMail(ctx, userID, req.EmailAddress); err != nil {
+		log.Printf("send verification mail error: %v", err)
+	}
 	return nil
 }
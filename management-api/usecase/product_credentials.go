@@ -0,0 +1,203 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/apirouting"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+)
+
+// GetProductCredentials returns the security schemes of the product, and whether their credentials are stored
+func GetProductCredentials(ctx context.Context, productID int) (*model.ProductCredentialsResp, error) {
+	swagger, err := fetchProductSwagger(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := db.fetchProductCredentials(ctx, []int{productID})
+	if err != nil {
+		log.Printf("fetch product credentials db error: %v", err)
+		return nil, ServerError{err}
+	}
+	return newProductCredentialsResp(productID, swagger.SecuritySchemes, credentials[productID]), nil
+}
+
+// PutProductCredentials replaces credentials of the product.
+// access tokens of api keys authorized to the product are updated through the routing outbox
+func PutProductCredentials(ctx context.Context, productID int, req model.PutProductCredentialsReq) (*model.ProductCredentialsResp, error) {
+	swagger, err := fetchProductSwagger(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	schemes := securitySchemeMap(swagger.SecuritySchemes)
+	for name := range req.Credentials {
+		scheme, ok := schemes[name]
+		if !ok {
+			return nil, ClientError{fmt.Errorf("security scheme %s is not defined in the swagger file of the product", name)}
+		}
+		if _, ok = scheme.AccessToken(""); !ok {
+			return nil, ClientError{fmt.Errorf("security scheme %s of type %s is not supported", name, scheme.Type)}
+		}
+	}
+
+	if err = db.replaceProductCredentials(ctx, productID, req.Credentials); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ClientError{fmt.Errorf("product not found, id %d", productID)}
+		}
+		log.Printf("replace product credentials db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	notifyRoutingOutbox()
+	return newProductCredentialsResp(productID, swagger.SecuritySchemes, req.Credentials), nil
+}
+
+// fetchProductSwagger returns the swagger info of the product stored in the routing store
+func fetchProductSwagger(ctx context.Context, productID int) (*model.Swagger, error) {
+	if _, err := db.fetchProductByID(ctx, productID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ClientError{fmt.Errorf("product not found, id %d", productID)}
+		}
+		log.Printf("fetch product db error: %v", err)
+		return nil, ServerError{err}
+	}
+	result, err := apirouting.ApiDBDriver.BatchGetSwagger(ctx, []int{productID})
+	if err != nil {
+		log.Printf("get swagger info db error: %v", err)
+		return nil, ServerError{err}
+	}
+	if len(result.Swaggers) == 0 {
+		log.Printf("swagger info related to product, id %d, not found", productID)
+		return nil, ServerError{fmt.Errorf("swagger info of product %d is not stored", productID)}
+	}
+	return &result.Swaggers[0], nil
+}
+
+func newProductCredentialsResp(productID int, schemes []model.SecurityScheme, credentials map[string]string) *model.ProductCredentialsResp {
+	ret := &model.ProductCredentialsResp{
+		ProductID:       productID,
+		SecuritySchemes: make([]model.SecuritySchemeStatus, len(schemes)),
+	}
+	for i, v := range schemes {
+		_, supported := v.AccessToken("")
+		_, configured := credentials[v.Name]
+		ret.SecuritySchemes[i] = model.SecuritySchemeStatus{
+			SecurityScheme: v,
+			Supported:      supported,
+			Configured:     configured,
+		}
+	}
+	return ret
+}
+
+func securitySchemeMap(schemes []model.SecurityScheme) map[string]model.SecurityScheme {
+	ret := make(map[string]model.SecurityScheme, len(schemes))
+	for _, v := range schemes {
+		ret[v.Name] = v
+	}
+	return ret
+}
+
+// generateAccessTokens returns access tokens of the routings of the api key generated from the swagger,
+// for apis which require security schemes. apis none of whose schemes has a supported credential
+// are returned to delete their tokens
+func generateAccessTokens(apikey string, swagger model.Swagger, credentials map[string]string) (post []model.PostAPITokenReq, del []model.DeleteAPITokenReq) {
+	schemes := securitySchemeMap(swagger.SecuritySchemes)
+	for _, api := range swagger.APIList {
+		if len(api.Security) == 0 {
+			continue
+		}
+		path := swagger.PathBase + api.Path
+		tokens := make([]model.AccessToken, 0, len(api.Security))
+		for _, name := range api.Security {
+			scheme, ok := schemes[name]
+			if !ok {
+				continue
+			}
+			credential, ok := credentials[name]
+			if !ok {
+				continue
+			}
+			if token, ok := scheme.AccessToken(credential); ok {
+				tokens = append(tokens, token)
+			}
+		}
+		if len(tokens) == 0 {
+			del = append(del, model.DeleteAPITokenReq{APIKey: apikey, Path: path})
+		} else {
+			post = append(post, model.PostAPITokenReq{APIKey: apikey, Path: path, AccessTokens: tokens})
+		}
+	}
+	return post, del
+}
+
+// writeAccessTokens posts and deletes access tokens in the routing store, which is idempotent
+func writeAccessTokens(ctx context.Context, post []model.PostAPITokenReq, del []model.DeleteAPITokenReq) error {
+	for _, v := range post {
+		if err := apirouting.ApiDBDriver.PostAPIToken(ctx, v); err != nil {
+			return fmt.Errorf("post access tokens of %s %s: %w", v.APIKey, v.Path, err)
+		}
+	}
+	for _, v := range del {
+		if err := apirouting.ApiDBDriver.DeleteAPIToken(ctx, v); err != nil {
+			return fmt.Errorf("delete access tokens of %s %s: %w", v.APIKey, v.Path, err)
+		}
+	}
+	return nil
+}
+
+// applyProductCredentials updates access tokens of api keys authorized to the product with its current credentials.
+// it is skipped if the swagger info is missing, because the reconcile command is needed to recover it
+func applyProductCredentials(ctx context.Context, payload putCredentialsPayload) error {
+	result, err := apirouting.ApiDBDriver.BatchGetSwagger(ctx, []int{payload.ProductID})
+	if err != nil {
+		return fmt.Errorf("get swagger info db error: %w", err)
+	}
+	if len(result.Swaggers) == 0 {
+		log.Printf("swagger info related to product, id %d, not found", payload.ProductID)
+		return nil
+	}
+	credentials, err := db.fetchProductCredentials(ctx, []int{payload.ProductID})
+	if err != nil {
+		return err
+	}
+	keys, err := db.fetchAPIKeysAuthorizedToProduct(ctx, payload.ProductID)
+	if err != nil {
+		return fmt.Errorf("fetch authorized api keys db error: %w", err)
+	}
+
+	for _, key := range keys {
+		post, del := generateAccessTokens(key.AccessKey, result.Swaggers[0], credentials[payload.ProductID])
+		if err = writeAccessTokens(ctx, post, del); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyAuthorizationTokens posts access tokens of the authorized products, or deletes them of the revoked products.
+// products without credentials are skipped, so that tokens posted by hand are kept
+func applyAuthorizationTokens(ctx context.Context, payload authorizationPayload, swaggers []model.Swagger, revoke bool) error {
+	credentials, err := db.fetchProductCredentials(ctx, productIDs(payload.ContractProducts))
+	if err != nil {
+		return err
+	}
+	for _, swagger := range swaggers {
+		productCredentials, ok := credentials[swagger.ProductID]
+		if !ok {
+			continue
+		}
+		post, del := generateAccessTokens(payload.AccessKey, swagger, productCredentials)
+		if revoke {
+			for _, v := range post {
+				del = append(del, model.DeleteAPITokenReq{APIKey: v.APIKey, Path: v.Path})
+			}
+			post = nil
+		}
+		if err = writeAccessTokens(ctx, post, del); err != nil {
+			return err
+		}
+	}
+	return nil
+}
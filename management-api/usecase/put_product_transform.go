@@ -0,0 +1,25 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+)
+
+// PutProductTransform replaces the transformation of the product.
+// routings of api keys authorized to the product are updated through the routing outbox
+func PutProductTransform(ctx context.Context, productID int, req model.PutProductTransformReq) (*model.Product, error) {
+	product, err := db.updateProductTransform(ctx, productID, req.Transform)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ClientError{fmt.Errorf("product not found, id %d", productID)}
+		}
+		log.Printf("update product transform db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	notifyRoutingOutbox()
+	return product, nil
+}
@@ -0,0 +1,26 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+)
+
+// PutUserPassword changes the password of the user after verifying the current one
+func PutUserPassword(ctx context.Context, accountID string, req model.PutUserPasswordReq) error {
+	user, err := fetchUserByAccountID(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	ok, err := db.updateUserPassword(ctx, user.ID, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		log.Printf("db update password error: %v", err)
+		return ServerError{err}
+	}
+	if !ok {
+		return ClientError{errors.New("current_password is incorrect")}
+	}
+	return nil
+}
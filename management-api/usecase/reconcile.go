@@ -0,0 +1,199 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/apirouting"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+	"reflect"
+)
+
+// Reconcile compares PostgreSQL, which is the source of truth, with the routing store, and fixes drift unless dryRun.
+// swagger info missing in the routing store is imported again from the swagger url of the product,
+// and routings of each api key are made equal to the ones generated from its authorizations
+func Reconcile(ctx context.Context, dryRun bool) (*model.ReconcileReport, error) {
+	report := new(model.ReconcileReport)
+
+	if !dryRun {
+		// apply pending changes first so that they are not reported as drift
+		if _, err := ProcessRoutingOutbox(ctx); err != nil {
+			log.Printf("process routing outbox failed: %v", err)
+		}
+	}
+
+	swaggerMap, err := reconcileSwaggers(ctx, report, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	auths, err := db.fetchAllAuthorizations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch authorizations db error: %w", err)
+	}
+	contractProducts := make(map[string][]model.ContractProductDB)
+	// routings of keys authorized to products without swagger info cannot be generated,
+	// so such keys are skipped not to delete their routings wrongly
+	skipped := make(map[string]struct{})
+	for _, auth := range auths {
+		if _, ok := swaggerMap[auth.ProductID]; !ok {
+			skipped[auth.AccessKey] = struct{}{}
+			continue
+		}
+		contractProducts[auth.AccessKey] = append(contractProducts[auth.AccessKey], model.ContractProductDB{
+			ContractID: auth.ContractID,
+			ProductID:  auth.ProductID,
+		})
+	}
+	swaggers := make([]model.Swagger, 0, len(swaggerMap))
+	for _, v := range swaggerMap {
+		swaggers = append(swaggers, v)
+	}
+
+	keys, err := db.fetchAllAPIKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch api keys db error: %w", err)
+	}
+	for _, key := range keys {
+		if _, ok := skipped[key.AccessKey]; ok {
+			report.SkippedAPIKeys++
+			continue
+		}
+		report.CheckedAPIKeys++
+		if err = reconcileRoutings(ctx, report, key, contractProducts[key.AccessKey], swaggers, dryRun); err != nil {
+			log.Printf("reconcile routings of an api key failed: %v", err)
+			report.UnresolvedErrors++
+		}
+	}
+
+	return report, nil
+}
+
+// reconcileSwaggers returns swagger info stored in the routing store, after recovering missing ones
+func reconcileSwaggers(ctx context.Context, report *model.ReconcileReport, dryRun bool) (map[int]model.Swagger, error) {
+	products, err := db.fetchAllProducts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch products db error: %w", err)
+	}
+	if len(products) == 0 {
+		return map[int]model.Swagger{}, nil
+	}
+	ids := make([]int, len(products))
+	for i, v := range products {
+		ids[i] = v.ID
+	}
+
+	swaggerMap, err := fetchSwaggerMap(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	recovered := false
+	for _, product := range products {
+		if swagger, ok := swaggerMap[product.ID]; ok {
+			if reflect.DeepEqual(swagger.Transform, product.Transform) {
+				continue
+			}
+			// routings are expected to have the transformation of PostgreSQL even in a dry run
+			report.StaleSwaggers++
+			swagger.Transform = product.Transform
+			swaggerMap[product.ID] = swagger
+			if dryRun {
+				continue
+			}
+			if err = apirouting.ApiDBDriver.PostSwagger(ctx, swagger); err != nil {
+				log.Printf("post swagger of product, id %d, failed: %v", product.ID, err)
+				report.UnresolvedErrors++
+				continue
+			}
+			report.PostedSwaggers++
+			continue
+		}
+		report.MissingSwaggers++
+		if dryRun {
+			continue
+		}
+
+		swaggerInfo, err := parseSwagger(ctx, product.SwaggerURL)
+		if err != nil {
+			log.Printf("import swagger of product, id %d, failed: %v", product.ID, err)
+			report.UnresolvedErrors++
+			continue
+		}
+		swagger, err := newSwaggerModel(product.ID, swaggerInfo, product.Transform)
+		if err != nil {
+			log.Printf("convert swagger of product, id %d, failed: %v", product.ID, err)
+			report.UnresolvedErrors++
+			continue
+		}
+		if err = apirouting.ApiDBDriver.PostSwagger(ctx, swagger); err != nil {
+			log.Printf("post swagger of product, id %d, failed: %v", product.ID, err)
+			report.UnresolvedErrors++
+			continue
+		}
+		report.PostedSwaggers++
+		recovered = true
+	}
+
+	if !recovered {
+		return swaggerMap, nil
+	}
+	return fetchSwaggerMap(ctx, ids)
+}
+
+func reconcileRoutings(ctx context.Context, report *model.ReconcileReport, apiKey model.APIKey,
+	contractProducts []model.ContractProductDB, swaggers []model.Swagger, dryRun bool) error {
+	expected, err := generateRoutings(apiKey.AccessKey, apiKey.ID, contractProducts, swaggers)
+	if err != nil {
+		return err
+	}
+	actual, err := apirouting.ApiDBDriver.GetRoutings(ctx, apiKey.AccessKey)
+	if err != nil {
+		return fmt.Errorf("get routings db error: %w", err)
+	}
+
+	post, del := diffRoutings(actual, expected)
+	actualPaths := make(map[string]struct{}, len(actual))
+	for _, v := range actual {
+		actualPaths[v.Path] = struct{}{}
+	}
+	for _, v := range post {
+		if _, ok := actualPaths[v.Path]; ok {
+			report.StaleRoutings++
+		} else {
+			report.MissingRoutings++
+		}
+	}
+	report.ExtraRoutings += len(del)
+	if dryRun {
+		return nil
+	}
+
+	if len(post) > 0 {
+		result, err := apirouting.ApiDBDriver.BatchPostRouting(ctx, post)
+		report.PostedRoutings += result.Processed
+		if err != nil {
+			return fmt.Errorf("post api routing db error, failed routings %v: %w", result.Failed, err)
+		}
+	}
+	if len(del) > 0 {
+		result, err := apirouting.ApiDBDriver.BatchDeleteRouting(ctx, del)
+		report.DeletedRoutings += result.Processed
+		if err != nil {
+			return fmt.Errorf("delete api routing db error, failed routings %v: %w", result.Failed, err)
+		}
+	}
+	return nil
+}
+
+func fetchSwaggerMap(ctx context.Context, productIDs []int) (map[int]model.Swagger, error) {
+	result, err := apirouting.ApiDBDriver.BatchGetSwagger(ctx, productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("get swagger info list db error: %w", err)
+	}
+	ret := make(map[int]model.Swagger, len(result.Swaggers))
+	for _, v := range result.Swaggers {
+		ret[v.ProductID] = v
+	}
+	return ret, nil
+}
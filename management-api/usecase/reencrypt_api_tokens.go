@@ -0,0 +1,29 @@
+package usecase
+
+import (
+	"context"
+	"github.com/future-architect/apidoor/managementapi/apirouting"
+	"github.com/future-architect/apidoor/managementapi/model"
+	"log"
+)
+
+// ReencryptAPITokens encrypts the stored access tokens again with the current key of the key file,
+// which is run after a new key is added to the head of the key file
+func ReencryptAPITokens(ctx context.Context, dryRun bool) (model.ReencryptResult, error) {
+	result, err := apirouting.ApiDBDriver.ReencryptAPITokens(ctx, dryRun)
+	if err != nil {
+		log.Printf("re-encrypt api tokens error: %v", err)
+		return result, ServerError{err}
+	}
+	return result, nil
+}
+
+// ReencryptProductCredentials encrypts the stored credentials of products again with the current key of the key file
+func ReencryptProductCredentials(ctx context.Context, dryRun bool) (model.ReencryptResult, error) {
+	result, err := db.reencryptProductCredentials(ctx, dryRun)
+	if err != nil {
+		log.Printf("re-encrypt product credentials error: %v", err)
+		return result, ServerError{err}
+	}
+	return result, nil
+}
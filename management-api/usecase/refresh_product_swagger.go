@@ -0,0 +1,278 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/apirouting"
+	"github.com/future-architect/apidoor/managementapi/model"
+	swaggerparser "github.com/future-architect/apidoor/managementapi/swagger-parser"
+	"log"
+	"reflect"
+)
+
+// RefreshProductSwagger re-imports the swagger file of the product, and synchronizes routings of all api keys
+// which have the product authorized with the new api list. the base path is updated in the same transaction as
+// the outbox event which applies the new swagger info, so the response reports the changes to be applied
+func RefreshProductSwagger(ctx context.Context, productID int) (*model.SwaggerRefreshResp, error) {
+	product, err := db.fetchProductByID(ctx, productID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ClientError{fmt.Errorf("product not found, id %d", productID)}
+		}
+		log.Printf("fetch product db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	swaggerInfo, err := parseSwagger(ctx, product.SwaggerURL)
+	if err != nil {
+		return nil, err
+	}
+	newSwagger, err := newSwaggerModel(productID, swaggerInfo, product.Transform)
+	if err != nil {
+		log.Printf("convert swagger info error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	oldSwagger, err := storedSwagger(ctx, productID)
+	if err != nil {
+		log.Printf("get swagger info db error: %v", err)
+		return nil, ServerError{err}
+	}
+
+	resp := &model.SwaggerRefreshResp{ProductID: productID}
+	resp.AddedAPIs, resp.RemovedAPIs, resp.UpdatedAPIs = diffAPIList(oldSwagger.APIList, newSwagger.APIList)
+
+	keys, err := db.fetchAPIKeysAuthorizedToProduct(ctx, productID)
+	if err != nil {
+		log.Printf("fetch authorized api keys db error: %v", err)
+		return nil, ServerError{err}
+	}
+	resp.AffectedAPIKeys = len(keys)
+	postRoutings, deleteRoutings, err := diffProductRoutings(productID, keys, oldSwagger, newSwagger)
+	if err != nil {
+		return nil, ServerError{err}
+	}
+	resp.PostedRoutings = len(postRoutings)
+	resp.DeletedRoutings = len(deleteRoutings)
+
+	if err = db.refreshProductSwagger(ctx, productID, swaggerInfo, product.Transform); err != nil {
+		log.Printf("db refresh product swagger error: %v", err)
+		return nil, ServerError{err}
+	}
+	notifyRoutingOutbox()
+
+	return resp, nil
+}
+
+// storedSwagger returns the swagger info of the product in the routing store, which has no api if it is missing
+func storedSwagger(ctx context.Context, productID int) (model.Swagger, error) {
+	result, err := apirouting.ApiDBDriver.BatchGetSwagger(ctx, []int{productID})
+	if err != nil {
+		return model.Swagger{}, err
+	}
+	if len(result.Swaggers) == 0 {
+		return model.Swagger{ProductID: productID}, nil
+	}
+	return result.Swaggers[0], nil
+}
+
+// diffProductRoutings returns routings of the api keys to be posted and deleted when the swagger info of the product is replaced
+func diffProductRoutings(productID int, keys []model.AuthorizedAPIKeyDB, oldSwagger, newSwagger model.Swagger) (post, del []model.Routing, err error) {
+	post, del = make([]model.Routing, 0), make([]model.Routing, 0)
+	for _, key := range keys {
+		contractProducts := []model.ContractProductDB{
+			{
+				ContractID: key.ContractID,
+				ProductID:  productID,
+			},
+		}
+		oldRoutings, err := generateRoutings(key.AccessKey, key.APIKeyID, contractProducts, []model.Swagger{oldSwagger})
+		if err != nil {
+			return nil, nil, err
+		}
+		newRoutings, err := generateRoutings(key.AccessKey, key.APIKeyID, contractProducts, []model.Swagger{newSwagger})
+		if err != nil {
+			return nil, nil, err
+		}
+		p, d := diffRoutings(oldRoutings, newRoutings)
+		post = append(post, p...)
+		del = append(del, d...)
+	}
+	return post, del, nil
+}
+
+// applyProductSwagger replaces the swagger info of the product in the routing store, with routings and access tokens
+// of api keys authorized to the product. the swagger info is posted last, so a retry computes the same changes again
+func applyProductSwagger(ctx context.Context, payload refreshSwaggerPayload) error {
+	newSwagger, err := newSwaggerModel(payload.ProductID, payload.Swagger, payload.Transform)
+	if err != nil {
+		return fmt.Errorf("convert swagger of product %d: %w", payload.ProductID, err)
+	}
+	oldSwagger, err := storedSwagger(ctx, payload.ProductID)
+	if err != nil {
+		return fmt.Errorf("get swagger info db error: %w", err)
+	}
+	keys, err := db.fetchAPIKeysAuthorizedToProduct(ctx, payload.ProductID)
+	if err != nil {
+		return fmt.Errorf("fetch authorized api keys db error: %w", err)
+	}
+	postRoutings, deleteRoutings, err := diffProductRoutings(payload.ProductID, keys, oldSwagger, newSwagger)
+	if err != nil {
+		return err
+	}
+
+	if len(postRoutings) > 0 {
+		result, err := apirouting.ApiDBDriver.BatchPostRouting(ctx, postRoutings)
+		if err != nil {
+			return fmt.Errorf("post routings of product %d, %d routings failed: %w", payload.ProductID, len(result.Failed), err)
+		}
+	}
+	if len(deleteRoutings) > 0 {
+		result, err := apirouting.ApiDBDriver.BatchDeleteRouting(ctx, deleteRoutings)
+		if err != nil {
+			return fmt.Errorf("delete routings of product %d, %d routings failed: %w", payload.ProductID, len(result.Failed), err)
+		}
+	}
+
+	// access tokens follow the security of the new swagger file if credentials of the product are stored
+	credentials, err := db.fetchProductCredentials(ctx, []int{payload.ProductID})
+	if err != nil {
+		return err
+	}
+	if productCredentials, ok := credentials[payload.ProductID]; ok {
+		for _, key := range keys {
+			post, del := generateAccessTokens(key.AccessKey, newSwagger, productCredentials)
+			if err = writeAccessTokens(ctx, post, del); err != nil {
+				return err
+			}
+		}
+	}
+
+	return apirouting.ApiDBDriver.PostSwagger(ctx, newSwagger)
+}
+
+func newSwaggerModel(productID int, info *swaggerparser.Swagger, transform *model.Transform) (model.Swagger, error) {
+	apiList := make([]model.API, len(info.APIs))
+	for i, v := range info.APIs {
+		operations, err := newOperationModels(v.Operations)
+		if err != nil {
+			return model.Swagger{}, fmt.Errorf("operations of path %s: %w", v.Path, err)
+		}
+		apiList[i] = model.API{
+			ForwardURL: v.ForwardURL,
+			Path:       v.Path,
+			Operations: operations,
+			Security:   v.Security,
+		}
+	}
+	var schemes []model.SecurityScheme
+	for _, v := range info.SecuritySchemes {
+		schemes = append(schemes, model.SecurityScheme{
+			Name:      v.Name,
+			Type:      v.Type,
+			In:        v.In,
+			ParamName: v.ParamName,
+			Scheme:    v.Scheme,
+			TokenURL:  v.TokenURL,
+		})
+	}
+	return model.Swagger{
+		ProductID:       productID,
+		Schemes:         info.Schemes,
+		ForwardURLBase:  info.ForwardURLBase,
+		PathBase:        info.PathBase,
+		APIList:         apiList,
+		Transform:       transform,
+		SecuritySchemes: schemes,
+	}, nil
+}
+
+// newOperationModels encodes schemas of the operations into json.
+// operations which have nothing to be validated are omitted, and nil is returned if no operation remains
+func newOperationModels(operations []swaggerparser.Operation) ([]model.Operation, error) {
+	var ret []model.Operation
+	for _, op := range operations {
+		if len(op.Parameters) == 0 && op.RequestBody == nil {
+			continue
+		}
+		operation := model.Operation{
+			Method:              op.Method,
+			RequestBodyRequired: op.RequestBodyRequired,
+		}
+		if op.RequestBody != nil {
+			body, err := json.Marshal(op.RequestBody)
+			if err != nil {
+				return nil, fmt.Errorf("marshal request body schema: %w", err)
+			}
+			operation.RequestBody = string(body)
+		}
+		for _, param := range op.Parameters {
+			schema, err := json.Marshal(param.Schema)
+			if err != nil {
+				return nil, fmt.Errorf("marshal schema of parameter %s: %w", param.Name, err)
+			}
+			operation.Parameters = append(operation.Parameters, model.OperationParameter{
+				Name:     param.Name,
+				In:       param.In,
+				Required: param.Required,
+				Schema:   string(schema),
+			})
+		}
+		ret = append(ret, operation)
+	}
+	return ret, nil
+}
+
+// diffAPIList compares api lists by their gateway paths.
+// updated contains apis whose gateway path is unchanged, but forward url, operations or security are changed
+func diffAPIList(oldList, newList []model.API) (added, removed, updated []model.API) {
+	added, removed, updated = make([]model.API, 0), make([]model.API, 0), make([]model.API, 0)
+
+	oldMap := make(map[string]model.API, len(oldList))
+	for _, v := range oldList {
+		oldMap[v.Path] = v
+	}
+	newMap := make(map[string]model.API, len(newList))
+	for _, v := range newList {
+		newMap[v.Path] = v
+		old, ok := oldMap[v.Path]
+		if !ok {
+			added = append(added, v)
+		} else if old.ForwardURL != v.ForwardURL || !reflect.DeepEqual(old.Operations, v.Operations) ||
+			!reflect.DeepEqual(old.Security, v.Security) {
+			updated = append(updated, v)
+		}
+	}
+	for _, v := range oldList {
+		if _, ok := newMap[v.Path]; !ok {
+			removed = append(removed, v)
+		}
+	}
+	return added, removed, updated
+}
+
+// diffRoutings returns routings to be posted, i.e. new or changed ones, and routings to be deleted
+func diffRoutings(oldRoutings, newRoutings []model.Routing) (post, del []model.Routing) {
+	oldMap := make(map[string]model.Routing, len(oldRoutings))
+	for _, v := range oldRoutings {
+		oldMap[v.Path] = v
+	}
+	newMap := make(map[string]model.Routing, len(newRoutings))
+	for _, v := range newRoutings {
+		newMap[v.Path] = v
+	}
+
+	for path, v := range newMap {
+		if old, ok := oldMap[path]; !ok || !old.Equal(v) {
+			post = append(post, v)
+		}
+	}
+	for path, v := range oldMap {
+		if _, ok := newMap[path]; !ok {
+			del = append(del, v)
+		}
+	}
+	return post, del
+}
@@ -0,0 +1,20 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/model"
+)
+
+// revokeAuthorizations deletes the authorizations, and routings generated by them through the outbox
+func revokeAuthorizations(ctx context.Context, auths []model.AuthorizationDB) error {
+	if len(auths) == 0 {
+		return nil
+	}
+
+	if err := db.deleteAuthorizations(ctx, auths); err != nil {
+		return fmt.Errorf("delete authorizations db error: %w", err)
+	}
+	notifyRoutingOutbox()
+	return nil
+}
@@ -0,0 +1,314 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/future-architect/apidoor/managementapi/apirouting"
+	"github.com/future-architect/apidoor/managementapi/model"
+	swaggerparser "github.com/future-architect/apidoor/managementapi/swagger-parser"
+	"log"
+	"time"
+)
+
+// event types of routing_outbox. every event is idempotent, so it is safe to apply it more than once
+const (
+	// outboxPutSwagger stores the swagger info of a product
+	outboxPutSwagger = "put_swagger"
+	// outboxAuthorize posts routings generated from the current swagger info of authorized products
+	outboxAuthorize = "authorize"
+	// outboxRevoke deletes routings generated from the current swagger info of revoked products
+	outboxRevoke = "revoke"
+	// outboxPutTransform stores the transformation of a product, and updates routings of api keys authorized to it
+	outboxPutTransform = "put_transform"
+	// outboxPutCredentials updates access tokens of api keys authorized to a product with its credentials
+	outboxPutCredentials = "put_credentials"
+	// outboxRefreshSwagger replaces the swagger info of a product re-imported from its swagger file,
+	// with routings and access tokens of api keys authorized to it
+	outboxRefreshSwagger = "refresh_swagger"
+	// outboxDeleteContractRoutings deletes routings linked to a contract, including ones not generated from its authorizations
+	outboxDeleteContractRoutings = "delete_contract_routings"
+	// outboxDeleteRouting deletes a routing of an api key
+	outboxDeleteRouting = "delete_routing"
+)
+
+const (
+	outboxBatchSize    = 100
+	outboxMaxAttempts  = 10
+	outboxBaseDelay    = time.Second
+	outboxMaxRetryWait = 5 * time.Minute
+	// outboxLease is how long a claimed event is hidden from other workers, and the timeout to apply it
+	outboxLease = 5 * time.Minute
+)
+
+// outboxSignal wakes up the worker when events are written, and keeps at most one pending signal
+var outboxSignal = make(chan struct{}, 1)
+
+// outboxPayload is a payload of routing_outbox. events with the same ordering key are applied in id order,
+// while events with different keys are applied independently, so that a failing event does not block the others
+type outboxPayload interface {
+	orderingKey() string
+}
+
+type putSwaggerPayload struct {
+	ProductID int                    `json:"product_id"`
+	Swagger   *swaggerparser.Swagger `json:"swagger"`
+}
+
+type putTransformPayload struct {
+	ProductID int              `json:"product_id"`
+	Transform *model.Transform `json:"transform"`
+}
+
+type putCredentialsPayload struct {
+	ProductID int `json:"product_id"`
+}
+
+type refreshSwaggerPayload struct {
+	ProductID int                    `json:"product_id"`
+	Swagger   *swaggerparser.Swagger `json:"swagger"`
+	Transform *model.Transform       `json:"transform"`
+}
+
+type deleteContractRoutingsPayload struct {
+	ContractID int `json:"contract_id"`
+}
+
+type deleteRoutingPayload struct {
+	AccessKey string `json:"access_key"`
+	Path      string `json:"path"`
+}
+
+func (p putSwaggerPayload) orderingKey() string     { return productOrderingKey(p.ProductID) }
+func (p putTransformPayload) orderingKey() string   { return productOrderingKey(p.ProductID) }
+func (p putCredentialsPayload) orderingKey() string { return productOrderingKey(p.ProductID) }
+func (p refreshSwaggerPayload) orderingKey() string { return productOrderingKey(p.ProductID) }
+
+func (p deleteContractRoutingsPayload) orderingKey() string {
+	return fmt.Sprintf("contract:%d", p.ContractID)
+}
+
+// deleteRoutingPayload shares the ordering key with authorizations of the api key,
+// so that the deletion is applied after the routing is posted by an earlier authorization
+func (p deleteRoutingPayload) orderingKey() string {
+	return "apikey:" + p.AccessKey
+}
+
+func productOrderingKey(productID int) string {
+	return fmt.Sprintf("product:%d", productID)
+}
+
+type authorizationPayload struct {
+	AccessKey string `json:"access_key"`
+	// APIKeyID is not set in revoke events, because it is not needed to delete routings
+	APIKeyID         int                       `json:"apikey_id,omitempty"`
+	ContractProducts []model.ContractProductDB `json:"contract_products"`
+}
+
+func (p authorizationPayload) orderingKey() string {
+	return "apikey:" + p.AccessKey
+}
+
+// ProcessRoutingOutbox applies due changes to the routing store, and returns the number of applied events.
+// events are claimed with a lease and applied outside the transaction, so concurrent workers share the outbox.
+// a failed event is scheduled for the retry without stopping the others, and the last error is returned
+func ProcessRoutingOutbox(ctx context.Context) (int, error) {
+	total := 0
+	var lastErr error
+	for {
+		events, err := db.claimOutboxEvents(ctx, outboxBatchSize, outboxLease)
+		if err != nil {
+			return total, err
+		}
+		for _, event := range events {
+			applyCtx, cancel := context.WithTimeout(ctx, outboxLease)
+			applyErr := applyOutboxEvent(applyCtx, event)
+			cancel()
+			if err = db.finishOutboxEvent(ctx, event, applyErr, outboxRetry); err != nil {
+				return total, err
+			}
+			if applyErr != nil {
+				lastErr = fmt.Errorf("apply outbox event %d failed: %w", event.ID, applyErr)
+				continue
+			}
+			total++
+		}
+		if len(events) < outboxBatchSize {
+			return total, lastErr
+		}
+	}
+}
+
+// notifyRoutingOutbox wakes up the worker to apply changes just written to the outbox without waiting for the interval
+func notifyRoutingOutbox() {
+	select {
+	case outboxSignal <- struct{}{}:
+	default:
+	}
+}
+
+func applyOutboxEvent(ctx context.Context, event model.RoutingOutboxEvent) error {
+	switch event.EventType {
+	case outboxPutSwagger:
+		var payload putSwaggerPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return fmt.Errorf("parse payload of event %d: %w", event.ID, err)
+		}
+		// put_swagger is written when a product is created, so it has no transformation yet
+		swagger, err := newSwaggerModel(payload.ProductID, payload.Swagger, nil)
+		if err != nil {
+			return fmt.Errorf("convert swagger of event %d: %w", event.ID, err)
+		}
+		return apirouting.ApiDBDriver.PostSwagger(ctx, swagger)
+	case outboxPutTransform:
+		var payload putTransformPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return fmt.Errorf("parse payload of event %d: %w", event.ID, err)
+		}
+		return applyProductTransform(ctx, payload)
+	case outboxPutCredentials:
+		var payload putCredentialsPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return fmt.Errorf("parse payload of event %d: %w", event.ID, err)
+		}
+		return applyProductCredentials(ctx, payload)
+	case outboxRefreshSwagger:
+		var payload refreshSwaggerPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return fmt.Errorf("parse payload of event %d: %w", event.ID, err)
+		}
+		return applyProductSwagger(ctx, payload)
+	case outboxDeleteContractRoutings:
+		var payload deleteContractRoutingsPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return fmt.Errorf("parse payload of event %d: %w", event.ID, err)
+		}
+		result, err := apirouting.ApiDBDriver.DeleteRoutingsByContract(ctx, payload.ContractID)
+		if err != nil {
+			return fmt.Errorf("delete routings of contract %d, %d routings failed: %w", payload.ContractID, len(result.Failed), err)
+		}
+		return nil
+	case outboxDeleteRouting:
+		var payload deleteRoutingPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return fmt.Errorf("parse payload of event %d: %w", event.ID, err)
+		}
+		// the routing may have been deleted already, e.g. by the previous attempt, which is not an error
+		if _, err := apirouting.ApiDBDriver.DeleteRouting(ctx, payload.AccessKey, payload.Path); err != nil {
+			return fmt.Errorf("delete routing of event %d failed: %w", event.ID, err)
+		}
+		return nil
+	case outboxAuthorize, outboxRevoke:
+		var payload authorizationPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return fmt.Errorf("parse payload of event %d: %w", event.ID, err)
+		}
+		routings, swaggers, err := routingsOfAuthorization(ctx, payload)
+		if err != nil {
+			return err
+		}
+		if len(routings) == 0 {
+			return nil
+		}
+		// the whole event is applied again on retry, which is safe because routings are idempotent
+		var result model.BatchRoutingResult
+		if event.EventType == outboxAuthorize {
+			result, err = apirouting.ApiDBDriver.BatchPostRouting(ctx, routings)
+		} else {
+			result, err = apirouting.ApiDBDriver.BatchDeleteRouting(ctx, routings)
+		}
+		if err != nil {
+			return fmt.Errorf("%s routings of event %d, %d routings failed: %w", event.EventType, event.ID, len(result.Failed), err)
+		}
+		return applyAuthorizationTokens(ctx, payload, swaggers, event.EventType == outboxRevoke)
+	default:
+		return fmt.Errorf("unknown event type %s, id %d", event.EventType, event.ID)
+	}
+}
+
+// routingsOfAuthorization generates routings from the swagger info stored in the routing store, and returns them with the swagger info.
+// it fails if swagger info of a product is missing, because the put_swagger event of the product, which is ordered
+// independently of the api key, may not be applied yet. if it has been given up, the reconcile command recovers both
+func routingsOfAuthorization(ctx context.Context, payload authorizationPayload) ([]model.Routing, []model.Swagger, error) {
+	result, err := apirouting.ApiDBDriver.BatchGetSwagger(ctx, productIDs(payload.ContractProducts))
+	if err != nil {
+		return nil, nil, fmt.Errorf("get swagger info list db error: %w", err)
+	}
+	if len(result.MissingProductIDs) > 0 {
+		return nil, nil, fmt.Errorf("swagger info of products %v is not stored yet", result.MissingProductIDs)
+	}
+
+	routings, err := generateRoutings(payload.AccessKey, payload.APIKeyID, payload.ContractProducts, result.Swaggers)
+	if err != nil {
+		return nil, nil, err
+	}
+	return routings, result.Swaggers, nil
+}
+
+// applyProductTransform posts routings of authorized api keys with the new transformation, and then the swagger info.
+// it is skipped if the swagger info is missing, because the reconcile command posts it with the transformation of the product
+func applyProductTransform(ctx context.Context, payload putTransformPayload) error {
+	result, err := apirouting.ApiDBDriver.BatchGetSwagger(ctx, []int{payload.ProductID})
+	if err != nil {
+		return fmt.Errorf("get swagger info db error: %w", err)
+	}
+	if len(result.Swaggers) == 0 {
+		log.Printf("swagger info related to product, id %d, not found", payload.ProductID)
+		return nil
+	}
+	oldSwagger := result.Swaggers[0]
+	newSwagger := oldSwagger
+	newSwagger.Transform = payload.Transform
+
+	keys, err := db.fetchAPIKeysAuthorizedToProduct(ctx, payload.ProductID)
+	if err != nil {
+		return fmt.Errorf("fetch authorized api keys db error: %w", err)
+	}
+	// the transformation does not change paths, so no routing is deleted
+	routings, _, err := diffProductRoutings(payload.ProductID, keys, oldSwagger, newSwagger)
+	if err != nil {
+		return err
+	}
+
+	if len(routings) > 0 {
+		result, err := apirouting.ApiDBDriver.BatchPostRouting(ctx, routings)
+		if err != nil {
+			return fmt.Errorf("post routings of product %d, %d routings failed: %w", payload.ProductID, len(result.Failed), err)
+		}
+	}
+	return apirouting.ApiDBDriver.PostSwagger(ctx, newSwagger)
+}
+
+// outboxRetry backs off exponentially, and gives up after outboxMaxAttempts attempts
+func outboxRetry(attempts int) (time.Duration, bool) {
+	if attempts >= outboxMaxAttempts {
+		return 0, true
+	}
+	delay := outboxBaseDelay << uint(attempts-1)
+	if delay > outboxMaxRetryWait {
+		delay = outboxMaxRetryWait
+	}
+	return delay, false
+}
+
+// RunRoutingOutboxWorker applies pending changes to the routing store periodically, and whenever handlers write them,
+// until ctx is done
+func RunRoutingOutboxWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-outboxSignal:
+		}
+		n, err := ProcessRoutingOutbox(ctx)
+		if err != nil {
+			log.Printf("process routing outbox failed: %v", err)
+		}
+		if n > 0 {
+			log.Printf("%d routing outbox events are applied", n)
+		}
+	}
+}
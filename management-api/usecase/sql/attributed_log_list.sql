@@ -0,0 +1,15 @@
+-- log_list with the contract and the product of the routing the gateway wrote to custom_log,
+-- product_id and product_name are null if the product is not logged or does not exist,
+-- and billable is true only if the billing status is billing, which is shared by the usage and the invoices
+SELECT
+    l.id,
+    l.run_date,
+    l.api_key,
+    l.api_path,
+    l.custom_log,
+    CAST(l.custom_log->>'contract_id' AS int) AS contract_id,
+    p.id AS product_id,
+    p.name AS product_name,
+    COALESCE(l.custom_log->>'billing_status' = 'billing', false) AS billable
+FROM log_list l
+LEFT JOIN product p ON p.id = CAST(l.custom_log->>'product_id' AS int)
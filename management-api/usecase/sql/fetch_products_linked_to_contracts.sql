@@ -15,6 +15,8 @@ SELECT pd.id, pd.contract_id, pd.product_id FROM
 ) as pd INNER JOIN (
     SELECT id FROM contract
     WHERE user_id = :user_id
+        AND (start_at IS NULL OR start_at <= current_timestamp)
+        AND (end_at IS NULL OR end_at > current_timestamp)
 ) as ct on pd.contract_id = ct.id;
 
 
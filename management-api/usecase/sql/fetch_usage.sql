@@ -0,0 +1,34 @@
+SELECT
+    date_trunc('{{ .Granularity }}', l.run_date AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS period_start,
+    {{ if eq .GroupBy "key" -}}
+    l.api_key
+    {{- else if eq .GroupBy "path" -}}
+    l.api_path
+    {{- else if eq .GroupBy "contract" -}}
+    COALESCE(CAST(l.contract_id AS text), '')
+    {{- else -}}
+    COALESCE(l.product_name, '')
+    {{- end }} AS group_key,
+    COUNT(*) AS calls,
+    COUNT(*) FILTER (WHERE l.billable) AS billable_calls,
+    COUNT(*) FILTER (WHERE CAST(l.custom_log->>'status_code' AS int) >= 400) AS errors,
+    CAST(COUNT(*) FILTER (WHERE CAST(l.custom_log->>'status_code' AS int) >= 400) AS float8) / COUNT(*) AS error_rate,
+    percentile_cont(0.5) WITHIN GROUP (ORDER BY CAST(l.custom_log->>'latency_ms' AS float8)) AS latency_p50_ms,
+    percentile_cont(0.95) WITHIN GROUP (ORDER BY CAST(l.custom_log->>'latency_ms' AS float8)) AS latency_p95_ms,
+    percentile_cont(0.99) WITHIN GROUP (ORDER BY CAST(l.custom_log->>'latency_ms' AS float8)) AS latency_p99_ms
+FROM ({{ template "attributed_log_list" }}) l
+WHERE l.run_date >= :from AND l.run_date < :to
+{{- if ne .APIKey "" }}
+    AND l.api_key = :api_key
+{{- end }}
+{{- if ne .Path "" }}
+    AND l.api_path = :path
+{{- end }}
+{{- if ne .ContractID 0 }}
+    AND l.contract_id = :contract_id
+{{- end }}
+{{- if ne .ProductID 0 }}
+    AND l.product_id = :product_id
+{{- end }}
+GROUP BY 1, 2
+ORDER BY 1, 2
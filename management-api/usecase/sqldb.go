@@ -5,13 +5,18 @@ import (
 	"context"
 	"database/sql"
 	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/future-architect/apidoor/managementapi/model"
+	swaggerparser "github.com/future-architect/apidoor/managementapi/swagger-parser"
+	"github.com/future-architect/apidoor/secret"
 	"github.com/lib/pq"
 	"log"
 	"os"
+	"sort"
 	"text/template"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -31,10 +36,23 @@ var (
 	fetchProductsLinkedToContractsSQLTemplateStr string
 	fetchProductsLinkedToContractsSQLTemplate    *template.Template
 
+	// attributedLogListSQL is a subquery of log_list with the contract and the product a call is attributed to,
+	// which is shared by the usage and the invoice queries
+	//go:embed sql/attributed_log_list.sql
+	attributedLogListSQL string
+
+	//go:embed sql/fetch_usage.sql
+	fetchUsageSQLTemplateStr string
+	fetchUsageSQLTemplate    *template.Template
+
 	foreignKeyErrCode pq.ErrorCode   = "23503"
 	foreignKeyErr     constraintType = "foreign key constraint"
+	uniqueErrCode     pq.ErrorCode   = "23505"
+	uniqueErr         constraintType = "unique constraint"
 
 	ErrNotFound = errors.New("db: item not found")
+	// ErrInvoicesFinalized is returned when the invoices of a finalized month are replaced without force
+	ErrInvoicesFinalized = errors.New("db: invoices are finalized")
 )
 
 func init() {
@@ -59,10 +77,20 @@ func init() {
 		log.Fatalf("creating fetchProductsLinkedToContractsSQL template failed: %v", err)
 	}
 
+	fetchUsageSQLTemplate, err = template.New("fetch usage of access logs").Parse(fetchUsageSQLTemplateStr)
+	if err != nil {
+		log.Fatalf("creating fetchUsageSQL template failed: %v", err)
+	}
+	if _, err = fetchUsageSQLTemplate.New("attributed_log_list").Parse(attributedLogListSQL); err != nil {
+		log.Fatalf("creating attributedLogListSQL template failed: %v", err)
+	}
+
 }
 
 type sqlDB struct {
 	driver *sqlx.DB
+	// envelope encrypts credentials of products, which are stored in plaintext if it is nil
+	envelope *secret.Envelope
 }
 
 func NewSqlDB() (*sqlDB, error) {
@@ -82,8 +110,16 @@ func NewSqlDB() (*sqlDB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("db connection error: %v", err)
 	}
+	envelope, err := secret.NewRequiredEnvelopeFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("load token encryption key failed: %w", err)
+	}
+	if envelope == nil {
+		log.Printf("%s is true, product credentials are stored in plaintext", secret.AllowPlaintextEnv)
+	}
 	return &sqlDB{
-		driver: db,
+		driver:   db,
+		envelope: envelope,
 	}, nil
 }
 
@@ -107,15 +143,39 @@ func (sd sqlDB) getProducts(ctx context.Context) ([]model.Product, error) {
 	return list, nil
 }
 
-func (sd sqlDB) postProduct(ctx context.Context, product *model.PostProductDB) (*model.Product, error) {
+// postProduct inserts the product, and the outbox event to store its swagger info into the routing store
+func (sd sqlDB) postProduct(ctx context.Context, product *model.PostProductDB, swaggerInfo *swaggerparser.Swagger) (*model.Product, error) {
+	tx, err := sd.driver.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction failed: %w", err)
+	}
+
 	ret := new(model.Product)
-	stmt, err := sd.driver.PrepareNamedContext(ctx,
+	stmt, err := tx.PrepareNamedContext(ctx,
 		`INSERT INTO product(name, source, display_name, description, thumbnail, base_path, swagger_url, is_available, created_at, updated_at)
 			VALUES(:name, :source, :display_name, :description, :thumbnail, :base_path, :swagger_url, :is_available, current_timestamp, current_timestamp) RETURNING *`)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("preparing sql query failed: %w", err)
+	}
 	err = stmt.QueryRowxContext(ctx, product).StructScan(ret)
 	if err != nil {
+		tx.Rollback()
 		return nil, fmt.Errorf("sql execution error: %w", err)
 	}
+
+	err = insertOutboxEvent(ctx, tx, outboxPutSwagger, putSwaggerPayload{
+		ProductID: ret.ID,
+		Swagger:   swaggerInfo,
+	})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction faield: %w", err)
+	}
 	return ret, nil
 }
 
@@ -170,20 +230,34 @@ func (sd sqlDB) searchProduct(ctx context.Context, params *model.SearchProductPa
 	}, nil
 }
 
-func (sd sqlDB) postUser(ctx context.Context, user *model.PostUserReq) error {
-	_, err := sd.driver.NamedExecContext(ctx,
+func (sd sqlDB) postUser(ctx context.Context, user *model.PostUserReq) (int, error) {
+	stmt, err := sd.driver.PrepareNamedContext(ctx,
 		`INSERT INTO apiuser(account_id, email_address, login_password_hash, name, created_at, updated_at)
 				VALUES(:account_id, :email_address, crypt(:password, gen_salt('bf')),
-			    :name, current_timestamp, current_timestamp)`,
-		user)
+			    :name, current_timestamp, current_timestamp) RETURNING id`)
 	if err != nil {
-		return fmt.Errorf("sql execution error: %w", err)
+		return 0, fmt.Errorf("preparing sql query failed: %w", err)
 	}
-	return nil
+	defer stmt.Close()
+
+	var id int
+	if err = stmt.QueryRowxContext(ctx, user).Scan(&id); err != nil {
+		if postgresErr, ok := err.(*pq.Error); ok && postgresErr.Code == uniqueErrCode {
+			return 0, &dbConstraintErr{
+				constraintType: uniqueErr,
+				field:          "account_id",
+				value:          user.AccountID,
+				message:        fmt.Sprintf("insert user, account_id = %s, failed: unique constraint", user.AccountID),
+			}
+		}
+		return 0, fmt.Errorf("sql execution error: %w", err)
+	}
+	return id, nil
 }
 
+// fetchUser returns the user which is not deleted
 func (sd sqlDB) fetchUser(ctx context.Context, accountID string) (*model.User, error) {
-	rows, err := sd.driver.QueryxContext(ctx, "SELECT * FROM apiuser WHERE account_id = $1", accountID)
+	rows, err := sd.driver.QueryxContext(ctx, "SELECT * FROM apiuser WHERE account_id = $1 AND deleted_at IS NULL", accountID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch user: %w", err)
 	}
@@ -208,6 +282,140 @@ func (sd sqlDB) fetchUser(ctx context.Context, accountID string) (*model.User, e
 	return &user, nil
 }
 
+func (sd sqlDB) fetchUserByID(ctx context.Context, userID int) (*model.User, error) {
+	var user model.User
+	err := sd.driver.QueryRowxContext(ctx, "SELECT * FROM apiuser WHERE id = $1 AND deleted_at IS NULL", userID).StructScan(&user)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+	return &user, nil
+}
+
+// patchUser updates the profile of the user. if the email address is changed, email_verified is reset
+func (sd sqlDB) patchUser(ctx context.Context, user *model.PatchUserDB) error {
+	_, err := sd.driver.ExecContext(ctx,
+		`UPDATE apiuser SET
+				email_verified = CASE WHEN $2::text IS NULL OR $2::text = email_address THEN email_verified ELSE FALSE END,
+				email_address = COALESCE($2::text, email_address),
+				name = COALESCE($3::text, name),
+				updated_at = current_timestamp
+				WHERE id = $1`,
+		user.ID, user.EmailAddress, user.Name)
+	if err != nil {
+		return fmt.Errorf("sql execution error: %w", err)
+	}
+	return nil
+}
+
+// updateUserPassword changes the password of the user if currentPassword matches the stored hash.
+// it returns false if the current password is wrong
+func (sd sqlDB) updateUserPassword(ctx context.Context, userID int, currentPassword, newPassword string) (bool, error) {
+	res, err := sd.driver.ExecContext(ctx,
+		`UPDATE apiuser SET login_password_hash = crypt($3, gen_salt('bf')), updated_at = current_timestamp
+				WHERE id = $1 AND login_password_hash = crypt($2, login_password_hash)`,
+		userID, currentPassword, newPassword)
+	if err != nil {
+		return false, fmt.Errorf("sql execution error: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get affected rows error: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// deleteUser soft-deletes the user, removes its api keys and ends its contracts.
+// authorizations of the api keys are deleted with outbox events to delete their routings in the same transaction
+func (sd sqlDB) deleteUser(ctx context.Context, userID int) error {
+	tx, err := sd.driver.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction failed: %w", err)
+	}
+
+	// locking the api keys blocks authorizations of them being added until the keys are deleted
+	if _, err = tx.ExecContext(ctx, `SELECT id FROM apikey WHERE user_id = $1 FOR UPDATE`, userID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("sql execution error: %w", err)
+	}
+	auths, err := fetchUserAuthorizations(ctx, tx, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = deleteAuthorizationsTx(ctx, tx, auths); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	queries := []string{
+		`DELETE FROM apikey WHERE user_id = $1`,
+		`UPDATE contract SET end_at = current_timestamp, updated_at = current_timestamp
+				WHERE user_id = $1 AND (end_at IS NULL OR end_at > current_timestamp)`,
+		`UPDATE apiuser SET deleted_at = current_timestamp, updated_at = current_timestamp WHERE id = $1`,
+	}
+	for _, query := range queries {
+		if _, err = tx.ExecContext(ctx, query, userID); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sql execution error: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction faield: %w", err)
+	}
+	return nil
+}
+
+func (sd sqlDB) postEmailVerification(ctx context.Context, verification *model.EmailVerificationDB) error {
+	_, err := sd.driver.NamedExecContext(ctx,
+		`INSERT INTO email_verification(user_id, email_address, token_hash, expires_at, created_at)
+				VALUES(:user_id, :email_address, :token_hash, :expires_at, current_timestamp)`,
+		verification)
+	if err != nil {
+		return fmt.Errorf("sql execution error: %w", err)
+	}
+	return nil
+}
+
+// verifyEmail marks the email address linked to the token as verified, and returns the id of the user.
+// it returns ErrNotFound if the token is unknown, expired, already used, or the user has changed the address since
+func (sd sqlDB) verifyEmail(ctx context.Context, tokenHash string) (int, error) {
+	tx, err := sd.driver.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction failed: %w", err)
+	}
+
+	var userID int
+	err = tx.QueryRowxContext(ctx,
+		`UPDATE email_verification AS ev SET verified_at = current_timestamp
+				FROM apiuser AS u
+				WHERE ev.user_id = u.id AND ev.token_hash = $1 AND ev.verified_at IS NULL
+				AND ev.expires_at > current_timestamp AND ev.email_address = u.email_address AND u.deleted_at IS NULL
+				RETURNING ev.user_id`,
+		tokenHash).Scan(&userID)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, fmt.Errorf("sql execution error: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx,
+		`UPDATE apiuser SET email_verified = TRUE, updated_at = current_timestamp WHERE id = $1`, userID); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("sql execution error: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit transaction faield: %w", err)
+	}
+	return userID, nil
+}
+
 func (sd sqlDB) fetchProduct(ctx context.Context, productName string) (*model.Product, error) {
 	rows, err := sd.driver.QueryxContext(ctx, "SELECT * FROM product WHERE name = $1", productName)
 	if err != nil {
@@ -261,6 +469,253 @@ func (sd sqlDB) fetchProducts(ctx context.Context, productNames []string) (map[s
 	return products, nil
 }
 
+func (sd sqlDB) fetchProductByID(ctx context.Context, productID int) (*model.Product, error) {
+	var product model.Product
+	err := sd.driver.QueryRowxContext(ctx, "SELECT * FROM product WHERE id = $1", productID).StructScan(&product)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch product: %w", err)
+	}
+	return &product, nil
+}
+
+// refreshProductSwagger updates the base path of the product, and inserts the outbox event to replace its swagger info
+// in the routing store with the re-imported one
+func (sd sqlDB) refreshProductSwagger(ctx context.Context, productID int, swaggerInfo *swaggerparser.Swagger, transform *model.Transform) error {
+	tx, err := sd.driver.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE product SET base_path = $1, updated_at = current_timestamp WHERE id = $2`, swaggerInfo.PathBase, productID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("sql execution error: %w", err)
+	}
+	err = insertOutboxEvent(ctx, tx, outboxRefreshSwagger, refreshSwaggerPayload{
+		ProductID: productID,
+		Swagger:   swaggerInfo,
+		Transform: transform,
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction faield: %w", err)
+	}
+	return nil
+}
+
+// updateProductTransform replaces the transformation of the product, and inserts the outbox event to apply it to the routing store.
+// it returns ErrNotFound if the product does not exist
+func (sd sqlDB) updateProductTransform(ctx context.Context, productID int, transform *model.Transform) (*model.Product, error) {
+	tx, err := sd.driver.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction failed: %w", err)
+	}
+
+	ret := new(model.Product)
+	err = tx.QueryRowxContext(ctx,
+		`UPDATE product SET transform = $1, updated_at = current_timestamp WHERE id = $2 RETURNING *`,
+		transform, productID).StructScan(ret)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("sql execution error: %w", err)
+	}
+
+	err = insertOutboxEvent(ctx, tx, outboxPutTransform, putTransformPayload{
+		ProductID: productID,
+		Transform: transform,
+	})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction failed: %w", err)
+	}
+	return ret, nil
+}
+
+// fetchAPIKeysAuthorizedToProduct returns api keys which have the product authorized, and contracts the product belongs to
+func (sd sqlDB) fetchAPIKeysAuthorizedToProduct(ctx context.Context, productID int) ([]model.AuthorizedAPIKeyDB, error) {
+	rows, err := sd.driver.QueryxContext(ctx,
+		`SELECT ak.id AS apikey_id, ak.access_key, cpc.contract_id
+				FROM apikey_contract_product_authorized AS auth
+				INNER JOIN contract_product_content AS cpc ON auth.contract_product_id = cpc.id
+				INNER JOIN apikey AS ak ON auth.apikey_id = ak.id
+				WHERE cpc.product_id = $1`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch authorized api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]model.AuthorizedAPIKeyDB, 0)
+	for rows.Next() {
+		var key model.AuthorizedAPIKeyDB
+		if err := rows.StructScan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan result as authorized api key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// replaceProductCredentials replaces all credentials of the product, and writes the event to update access tokens
+func (sd sqlDB) replaceProductCredentials(ctx context.Context, productID int, credentials map[string]string) error {
+	values := make(map[string]string, len(credentials))
+	for name, value := range credentials {
+		encrypted, err := sd.encryptCredential(ctx, value)
+		if err != nil {
+			return err
+		}
+		values[name] = encrypted
+	}
+
+	tx, err := sd.driver.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+
+	var id int
+	if err = tx.QueryRowxContext(ctx, `SELECT id FROM product WHERE id = $1 FOR UPDATE`, productID).Scan(&id); err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("sql execution error: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM product_credential WHERE product_id = $1`, productID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete product credentials failed: %w", err)
+	}
+	for name, value := range values {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO product_credential(product_id, scheme_name, value, created_at, updated_at)
+				VALUES ($1, $2, $3, current_timestamp, current_timestamp)`,
+			productID, name, value)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("insert product credential failed: %w", err)
+		}
+	}
+
+	// the payload has no credential, which is read when the event is applied
+	if err = insertOutboxEvent(ctx, tx, outboxPutCredentials, putCredentialsPayload{ProductID: productID}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction failed: %w", err)
+	}
+	return nil
+}
+
+// fetchProductCredentials returns credentials of the products keyed by product ids and scheme names.
+// a product without credentials is not contained
+func (sd sqlDB) fetchProductCredentials(ctx context.Context, productIDs []int) (map[int]map[string]string, error) {
+	var rows []model.ProductCredentialDB
+	err := sd.driver.SelectContext(ctx, &rows,
+		`SELECT product_id, scheme_name, value FROM product_credential WHERE product_id = ANY($1)`, pq.Array(productIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch product credentials: %w", err)
+	}
+
+	ret := make(map[int]map[string]string)
+	for _, v := range rows {
+		if _, ok := ret[v.ProductID]; !ok {
+			ret[v.ProductID] = make(map[string]string)
+		}
+		value, err := sd.decryptCredential(ctx, v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt credential %s of product %d failed: %w", v.SchemeName, v.ProductID, err)
+		}
+		ret[v.ProductID][v.SchemeName] = value
+	}
+	return ret, nil
+}
+
+// encryptCredential encrypts the credential with the current key, or returns it as it is if no key file is set
+func (sd sqlDB) encryptCredential(ctx context.Context, value string) (string, error) {
+	if sd.envelope == nil {
+		return value, nil
+	}
+	encrypted, err := sd.envelope.Encrypt(ctx, value)
+	if err != nil {
+		return "", fmt.Errorf("encrypt product credential failed: %w", err)
+	}
+	return encrypted, nil
+}
+
+// decryptCredential returns the plaintext of the credential, which may be stored in plaintext before encryption
+func (sd sqlDB) decryptCredential(ctx context.Context, value string) (string, error) {
+	if sd.envelope == nil {
+		if secret.IsEncrypted(value) {
+			return "", errors.New(secret.KeyFileEnv + " is not set")
+		}
+		return value, nil
+	}
+	return sd.envelope.Decrypt(ctx, value)
+}
+
+// reencryptProductCredentials encrypts the credentials encrypted with an old key or stored in plaintext again with
+// the current key. a credential replaced during the re-encryption is left as it is, since it is encrypted when replaced
+func (sd sqlDB) reencryptProductCredentials(ctx context.Context, dryRun bool) (model.ReencryptResult, error) {
+	result := model.ReencryptResult{
+		Failed: make([]string, 0),
+	}
+	if sd.envelope == nil {
+		return result, errors.New(secret.KeyFileEnv + " is not set")
+	}
+
+	var rows []model.ProductCredentialDB
+	if err := sd.driver.SelectContext(ctx, &rows,
+		`SELECT product_id, scheme_name, value FROM product_credential ORDER BY product_id, scheme_name`); err != nil {
+		return result, fmt.Errorf("failed to fetch product credentials: %w", err)
+	}
+	for _, row := range rows {
+		result.Scanned++
+		if keyID, ok := secret.KeyID(row.Value); ok && keyID == sd.envelope.CurrentKeyID() {
+			continue
+		}
+		key := fmt.Sprintf("%d#%s", row.ProductID, row.SchemeName)
+		plaintext, err := sd.envelope.Decrypt(ctx, row.Value)
+		if err != nil {
+			log.Printf("decrypt product credential %s failed: %v", key, err)
+			result.Failed = append(result.Failed, key)
+			continue
+		}
+		encrypted, err := sd.envelope.Encrypt(ctx, plaintext)
+		if err != nil {
+			log.Printf("encrypt product credential %s failed: %v", key, err)
+			result.Failed = append(result.Failed, key)
+			continue
+		}
+		if !dryRun {
+			if _, err = sd.driver.ExecContext(ctx,
+				`UPDATE product_credential SET value = $4, updated_at = current_timestamp
+					WHERE product_id = $1 AND scheme_name = $2 AND value = $3`,
+				row.ProductID, row.SchemeName, row.Value, encrypted); err != nil {
+				log.Printf("update re-encrypted product credential %s failed: %v", key, err)
+				result.Failed = append(result.Failed, key)
+				continue
+			}
+		}
+		result.Reencrypted++
+	}
+	return result, nil
+}
+
 func (sd sqlDB) postContract(ctx context.Context, contract *model.PostContractDB) error {
 	tx, err := sd.driver.BeginTxx(ctx, &sql.TxOptions{})
 	if err != nil {
@@ -268,27 +723,38 @@ func (sd sqlDB) postContract(ctx context.Context, contract *model.PostContractDB
 	}
 
 	stmt, err := tx.PreparexContext(ctx,
-		`INSERT INTO contract(user_id, created_at, updated_at)
-				VALUES ($1, current_timestamp, current_timestamp) RETURNING id`)
+		`INSERT INTO contract(user_id, start_at, end_at, created_at, updated_at)
+				VALUES ($1, COALESCE($2::timestamptz, current_timestamp), $3, current_timestamp, current_timestamp) RETURNING id`)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("prepare sql to insert contract failed: %w", err)
 	}
 
 	var contractID int
-	err = stmt.QueryRowxContext(ctx, contract.UserID).Scan(&contractID)
+	err = stmt.QueryRowxContext(ctx, contract.UserID, contract.StartAt, contract.EndAt).Scan(&contractID)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("execute sql to insert contract failed: %w", err)
 	}
 
-	for _, product := range contract.Products {
-		_, err = tx.ExecContext(ctx,
+	if err = insertContractProducts(ctx, tx, contractID, contract.Products); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction faield: %w", err)
+	}
+	return nil
+}
+
+func insertContractProducts(ctx context.Context, tx *sqlx.Tx, contractID int, products []*model.ContractProductContentDB) error {
+	for _, product := range products {
+		_, err := tx.ExecContext(ctx,
 			`INSERT INTO contract_product_content(contract_id, product_id, description, created_at, updated_at)
 					VALUES ($1, $2, $3, current_timestamp, current_timestamp)`,
 			contractID, product.ProductID, product.Description)
 		if err != nil {
-			tx.Rollback()
 			if postgresErr, ok := err.(*pq.Error); ok {
 				if postgresErr.Code == foreignKeyErrCode {
 					return &dbConstraintErr{
@@ -302,6 +768,142 @@ func (sd sqlDB) postContract(ctx context.Context, contract *model.PostContractDB
 			return fmt.Errorf("insert content, api_id = %d, failed: %w", product.ProductID, err)
 		}
 	}
+	return nil
+}
+
+func (sd sqlDB) fetchContract(ctx context.Context, contractID int) (*model.Contract, error) {
+	var contract model.Contract
+	err := sd.driver.QueryRowxContext(ctx, "SELECT * FROM contract WHERE id = $1", contractID).StructScan(&contract)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch contract: %w", err)
+	}
+	return &contract, nil
+}
+
+func (sd sqlDB) fetchContractsByUser(ctx context.Context, userID, limit, offset int) ([]model.Contract, int, error) {
+	rows, err := sd.driver.QueryxContext(ctx,
+		`SELECT *, COUNT(*) OVER() AS count FROM contract WHERE user_id = $1 ORDER BY id LIMIT $2 OFFSET $3`,
+		userID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("sql execution error: %w", err)
+	}
+
+	list := make([]model.Contract, 0)
+	count := 0
+	for rows.Next() {
+		var row struct {
+			model.Contract
+			Count int `db:"count"`
+		}
+		if err := rows.StructScan(&row); err != nil {
+			return nil, 0, fmt.Errorf("scanning record error: %w", err)
+		}
+		list = append(list, row.Contract)
+		count = row.Count
+	}
+	return list, count, nil
+}
+
+func (sd sqlDB) fetchContractProducts(ctx context.Context, contractID int) ([]model.ContractProductDetail, error) {
+	rows, err := sd.driver.QueryxContext(ctx,
+		`SELECT cpc.id, cpc.product_id, p.name AS product_name, COALESCE(cpc.description, '') AS description
+				FROM contract_product_content AS cpc
+				INNER JOIN product AS p ON cpc.product_id = p.id
+				WHERE cpc.contract_id = $1 ORDER BY cpc.id`, contractID)
+	if err != nil {
+		return nil, fmt.Errorf("sql execution error: %w", err)
+	}
+
+	products := make([]model.ContractProductDetail, 0)
+	for rows.Next() {
+		var product model.ContractProductDetail
+		if err := rows.StructScan(&product); err != nil {
+			return nil, fmt.Errorf("failed to scan result as contract product: %w", err)
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+// patchContract adds and removes products of the contract and updates its end date.
+// authorizations linked to the removed products, or to the contract if the new end date has passed, are deleted
+// with outbox events to delete their routings in the same transaction
+func (sd sqlDB) patchContract(ctx context.Context, contract *model.PatchContractDB) error {
+	tx, err := sd.driver.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+
+	if err = insertContractProducts(ctx, tx, contract.ContractID, contract.AddProducts); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if len(contract.RemoveProductIDs) > 0 {
+		// authorizations of the removed products are revoked only if the whole patch succeeds
+		var auths []model.AuthorizationDB
+		query, args := contractAuthorizationsQuery(contract.ContractID, contract.RemoveProductIDs)
+		if err = tx.SelectContext(ctx, &auths, query, args...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("fetch authorizations failed: %w", err)
+		}
+		if err = deleteAuthorizationsTx(ctx, tx, auths); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM contract_product_content WHERE contract_id = $1 AND product_id = ANY($2)`,
+			contract.ContractID, pq.Array(contract.RemoveProductIDs))
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("delete contract products failed: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE contract SET end_at = COALESCE($1, end_at), updated_at = current_timestamp WHERE id = $2`,
+		contract.EndAt, contract.ContractID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update contract failed: %w", err)
+	}
+	// the new end date has already passed
+	if contract.EndAt != nil {
+		if err = deleteEndedContractAuthorizationsTx(ctx, tx, contract.ContractID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction faield: %w", err)
+	}
+	return nil
+}
+
+// terminateContract sets the end date of the contract to the current time unless the contract has already ended,
+// and deletes authorizations linked to it with outbox events to delete their routings, in one transaction
+func (sd sqlDB) terminateContract(ctx context.Context, contractID int) error {
+	tx, err := sd.driver.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE contract SET end_at = current_timestamp, updated_at = current_timestamp
+				WHERE id = $1 AND (end_at IS NULL OR end_at > current_timestamp)`, contractID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("sql execution error: %w", err)
+	}
+	if err = deleteEndedContractAuthorizationsTx(ctx, tx, contractID); err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("commit transaction faield: %w", err)
@@ -309,6 +911,164 @@ func (sd sqlDB) postContract(ctx context.Context, contract *model.PostContractDB
 	return nil
 }
 
+// deleteEndedContractAuthorizationsTx deletes authorizations linked to the contract in the transaction if its end date
+// has passed, with outbox events to delete their routings
+func deleteEndedContractAuthorizationsTx(ctx context.Context, tx *sqlx.Tx, contractID int) error {
+	auths, err := queryAuthorizations(ctx, tx,
+		`SELECT auth.id, ak.access_key, cpc.contract_id, cpc.product_id
+				FROM apikey_contract_product_authorized AS auth
+				INNER JOIN contract_product_content AS cpc ON auth.contract_product_id = cpc.id
+				INNER JOIN contract AS ct ON cpc.contract_id = ct.id
+				INNER JOIN apikey AS ak ON auth.apikey_id = ak.id
+				WHERE ct.id = $1 AND ct.end_at <= current_timestamp`, contractID)
+	if err != nil {
+		return err
+	}
+	return deleteAuthorizationsTx(ctx, tx, auths)
+}
+
+// deleteContractRoutings deletes authorizations linked to the contract with outbox events to delete their routings,
+// and inserts the event to delete the other routings linked to the contract, in one transaction
+func (sd sqlDB) deleteContractRoutings(ctx context.Context, contractID int) error {
+	tx, err := sd.driver.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+
+	// locking the products of the contract blocks authorizations of them being added until the deletion is committed
+	if _, err = tx.ExecContext(ctx,
+		`SELECT id FROM contract_product_content WHERE contract_id = $1 FOR UPDATE`, contractID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("sql execution error: %w", err)
+	}
+	var auths []model.AuthorizationDB
+	query, args := contractAuthorizationsQuery(contractID, nil)
+	if err = tx.SelectContext(ctx, &auths, query, args...); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("fetch authorizations failed: %w", err)
+	}
+	if err = deleteAuthorizationsTx(ctx, tx, auths); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = insertOutboxEvent(ctx, tx, outboxDeleteContractRoutings, deleteContractRoutingsPayload{ContractID: contractID}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction faield: %w", err)
+	}
+	return nil
+}
+
+func contractAuthorizationsQuery(contractID int, productIDs []int) (string, []interface{}) {
+	query := `SELECT auth.id, ak.access_key, cpc.contract_id, cpc.product_id
+				FROM apikey_contract_product_authorized AS auth
+				INNER JOIN contract_product_content AS cpc ON auth.contract_product_id = cpc.id
+				INNER JOIN apikey AS ak ON auth.apikey_id = ak.id
+				WHERE cpc.contract_id = $1`
+	args := []interface{}{contractID}
+	if len(productIDs) > 0 {
+		query += ` AND cpc.product_id = ANY($2)`
+		args = append(args, pq.Array(productIDs))
+	}
+	return query, args
+}
+
+// fetchExpiredAuthorizations returns authorizations linked to contracts whose end date has passed
+func (sd sqlDB) fetchExpiredAuthorizations(ctx context.Context) ([]model.AuthorizationDB, error) {
+	return sd.fetchAuthorizations(ctx,
+		`SELECT auth.id, ak.access_key, cpc.contract_id, cpc.product_id
+				FROM apikey_contract_product_authorized AS auth
+				INNER JOIN contract_product_content AS cpc ON auth.contract_product_id = cpc.id
+				INNER JOIN contract AS ct ON cpc.contract_id = ct.id
+				INNER JOIN apikey AS ak ON auth.apikey_id = ak.id
+				WHERE ct.end_at <= current_timestamp`)
+}
+
+// fetchUserAuthorizations returns authorizations of all api keys owned by the user
+func fetchUserAuthorizations(ctx context.Context, q sqlx.QueryerContext, userID int) ([]model.AuthorizationDB, error) {
+	return queryAuthorizations(ctx, q,
+		`SELECT auth.id, ak.access_key, cpc.contract_id, cpc.product_id
+				FROM apikey_contract_product_authorized AS auth
+				INNER JOIN contract_product_content AS cpc ON auth.contract_product_id = cpc.id
+				INNER JOIN apikey AS ak ON auth.apikey_id = ak.id
+				WHERE ak.user_id = $1`, userID)
+}
+
+func (sd sqlDB) fetchAuthorizations(ctx context.Context, query string, args ...interface{}) ([]model.AuthorizationDB, error) {
+	return queryAuthorizations(ctx, sd.driver, query, args...)
+}
+
+func queryAuthorizations(ctx context.Context, q sqlx.QueryerContext, query string, args ...interface{}) ([]model.AuthorizationDB, error) {
+	rows, err := q.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch authorizations: %w", err)
+	}
+	defer rows.Close()
+
+	auths := make([]model.AuthorizationDB, 0)
+	for rows.Next() {
+		var auth model.AuthorizationDB
+		if err := rows.StructScan(&auth); err != nil {
+			return nil, fmt.Errorf("failed to scan result as authorization: %w", err)
+		}
+		auths = append(auths, auth)
+	}
+	return auths, rows.Err()
+}
+
+// deleteAuthorizations deletes the authorizations, and inserts outbox events to delete routings of them
+func (sd sqlDB) deleteAuthorizations(ctx context.Context, auths []model.AuthorizationDB) error {
+	tx, err := sd.driver.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	if err = deleteAuthorizationsTx(ctx, tx, auths); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction faield: %w", err)
+	}
+	return nil
+}
+
+// deleteAuthorizationsTx deletes the authorizations in the transaction, with outbox events to delete routings of them
+func deleteAuthorizationsTx(ctx context.Context, tx *sqlx.Tx, auths []model.AuthorizationDB) error {
+	if len(auths) == 0 {
+		return nil
+	}
+	ids := make([]int, len(auths))
+	payloads := make(map[string]*authorizationPayload)
+	var accessKeys []string
+	for i, auth := range auths {
+		ids[i] = auth.ID
+		payload, ok := payloads[auth.AccessKey]
+		if !ok {
+			payload = &authorizationPayload{AccessKey: auth.AccessKey}
+			payloads[auth.AccessKey] = payload
+			accessKeys = append(accessKeys, auth.AccessKey)
+		}
+		payload.ContractProducts = append(payload.ContractProducts, model.ContractProductDB{
+			ContractID: auth.ContractID,
+			ProductID:  auth.ProductID,
+		})
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM apikey_contract_product_authorized WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return fmt.Errorf("sql execution error: %w", err)
+	}
+	for _, key := range accessKeys {
+		if err := insertOutboxEvent(ctx, tx, outboxRevoke, payloads[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (sd sqlDB) postAPIKey(ctx context.Context, apiKey model.APIKey) (*model.APIKey, error) {
 	ret := new(model.APIKey)
 	stmt, err := sd.driver.PrepareNamedContext(ctx,
@@ -389,7 +1149,8 @@ func (sd sqlDB) fetchContractProductToAuth(ctx context.Context, userID int, cont
 	return products, nil
 }
 
-func (sd sqlDB) postAPIKeyContractProductAuthorized(ctx context.Context, apiKeyID int, contractProducts []model.ContractProductDB) error {
+// postAPIKeyContractProductAuthorized inserts the authorizations, and the outbox event to post routings of them
+func (sd sqlDB) postAPIKeyContractProductAuthorized(ctx context.Context, apiKeyID int, accessKey string, contractProducts []model.ContractProductDB) error {
 
 	tx, err := sd.driver.BeginTxx(ctx, &sql.TxOptions{})
 	if err != nil {
@@ -425,6 +1186,16 @@ func (sd sqlDB) postAPIKeyContractProductAuthorized(ctx context.Context, apiKeyI
 		}
 	}
 
+	err = insertOutboxEvent(ctx, tx, outboxAuthorize, authorizationPayload{
+		AccessKey:        accessKey,
+		APIKeyID:         apiKeyID,
+		ContractProducts: contractProducts,
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("commit transaction faield: %w", err)
 	}
@@ -432,6 +1203,326 @@ func (sd sqlDB) postAPIKeyContractProductAuthorized(ctx context.Context, apiKeyI
 	return nil
 }
 
+func insertOutboxEvent(ctx context.Context, tx *sqlx.Tx, eventType string, payload outboxPayload) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal outbox payload failed: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO routing_outbox(event_type, ordering_key, payload, next_attempt_at, created_at)
+				VALUES ($1, $2, $3, current_timestamp, current_timestamp)`,
+		eventType, payload.orderingKey(), payloadBytes)
+	if err != nil {
+		return fmt.Errorf("insert outbox event failed: %w", err)
+	}
+	return nil
+}
+
+// postOutboxEvent inserts the outbox event alone, for changes of the routing store without changes of PostgreSQL
+func (sd sqlDB) postOutboxEvent(ctx context.Context, eventType string, payload outboxPayload) error {
+	tx, err := sd.driver.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	if err = insertOutboxEvent(ctx, tx, eventType, payload); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction faield: %w", err)
+	}
+	return nil
+}
+
+// claimOutboxEvents leases due outbox events in id order, and commits at once so that they are applied outside the transaction.
+// an event is claimed only after all earlier events with the same ordering key are processed or given up,
+// and SKIP LOCKED lets concurrent workers claim other events instead of waiting.
+// events whose lease expires, e.g. by a crash of the worker, are claimed again
+func (sd sqlDB) claimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]model.RoutingOutboxEvent, error) {
+	var events []model.RoutingOutboxEvent
+	err := sd.driver.SelectContext(ctx, &events,
+		`UPDATE routing_outbox SET locked_until = current_timestamp + $2::double precision * interval '1 millisecond'
+				WHERE id IN (
+					SELECT o.id FROM routing_outbox o
+						WHERE o.processed_at IS NULL AND o.failed_at IS NULL
+							AND o.next_attempt_at <= current_timestamp
+							AND (o.locked_until IS NULL OR o.locked_until <= current_timestamp)
+							AND NOT EXISTS (SELECT 1 FROM routing_outbox p
+								WHERE p.ordering_key = o.ordering_key AND p.id < o.id
+									AND p.processed_at IS NULL AND p.failed_at IS NULL)
+						ORDER BY o.id LIMIT $1
+						FOR UPDATE SKIP LOCKED)
+				RETURNING id, event_type, payload, attempts`,
+		limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("claim outbox events failed: %w", err)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+	return events, nil
+}
+
+// finishOutboxEvent records the result of the claimed event and releases its lease.
+// when applyErr is not nil, retry decides how long the event waits for the next attempt, or whether it is given up
+func (sd sqlDB) finishOutboxEvent(ctx context.Context, event model.RoutingOutboxEvent, applyErr error,
+	retry func(attempts int) (delay time.Duration, giveUp bool)) error {
+	if applyErr == nil {
+		if _, err := sd.driver.ExecContext(ctx,
+			`UPDATE routing_outbox SET attempts = attempts + 1, processed_at = current_timestamp, locked_until = NULL
+					WHERE id = $1`, event.ID); err != nil {
+			return fmt.Errorf("update outbox event failed: %w", err)
+		}
+		return nil
+	}
+
+	delay, giveUp := retry(event.Attempts + 1)
+	if _, err := sd.driver.ExecContext(ctx,
+		`UPDATE routing_outbox SET attempts = attempts + 1, last_error = $2, locked_until = NULL,
+				next_attempt_at = current_timestamp + $3::double precision * interval '1 millisecond',
+				failed_at = CASE WHEN $4::boolean THEN current_timestamp ELSE NULL END
+				WHERE id = $1`,
+		event.ID, applyErr.Error(), delay.Milliseconds(), giveUp); err != nil {
+		return fmt.Errorf("update outbox event failed: %w", err)
+	}
+	return nil
+}
+
+// fetchAllAPIKeys returns access keys of all api keys
+func (sd sqlDB) fetchAllAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	var keys []model.APIKey
+	if err := sd.driver.SelectContext(ctx, &keys, `SELECT id, access_key FROM apikey ORDER BY id`); err != nil {
+		return nil, fmt.Errorf("failed to fetch api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// fetchAllAuthorizations returns all authorizations, which are the source of truth of routings
+func (sd sqlDB) fetchAllAuthorizations(ctx context.Context) ([]model.AuthorizationDB, error) {
+	return sd.fetchAuthorizations(ctx,
+		`SELECT auth.id, ak.access_key, cpc.contract_id, cpc.product_id
+				FROM apikey_contract_product_authorized AS auth
+				INNER JOIN contract_product_content AS cpc ON auth.contract_product_id = cpc.id
+				INNER JOIN apikey AS ak ON auth.apikey_id = ak.id`)
+}
+
+func (sd sqlDB) fetchAllProducts(ctx context.Context) ([]model.Product, error) {
+	var products []model.Product
+	if err := sd.driver.SelectContext(ctx, &products, `SELECT * FROM product ORDER BY id`); err != nil {
+		return nil, fmt.Errorf("failed to fetch products: %w", err)
+	}
+	return products, nil
+}
+
+// fetchUsage aggregates log_list by the period and the group of the params
+func (sd sqlDB) fetchUsage(ctx context.Context, params *model.UsageParams) ([]model.Usage, error) {
+	var query bytes.Buffer
+	if err := fetchUsageSQLTemplate.Execute(&query, params); err != nil {
+		return nil, fmt.Errorf("generate SQL error: %w", err)
+	}
+	rows, err := sd.driver.NamedQueryContext(ctx, query.String(), map[string]interface{}{
+		"from":        params.From,
+		"to":          params.To,
+		"api_key":     params.APIKey,
+		"path":        params.Path,
+		"contract_id": params.ContractID,
+		"product_id":  params.ProductID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sql execution error: %w", err)
+	}
+	defer rows.Close()
+
+	list := make([]model.Usage, 0)
+	for rows.Next() {
+		var row model.Usage
+		if err := rows.StructScan(&row); err != nil {
+			return nil, fmt.Errorf("scanning record error: %w", err)
+		}
+		row.PeriodStart = row.PeriodStart.UTC()
+		list = append(list, row)
+	}
+	return list, rows.Err()
+}
+
+// upsertPricingPlan replaces the default plan of the product, or the plan of the product in the contract if contractID is not nil.
+// it returns dbConstraintErr if the product or the contract does not exist
+func (sd sqlDB) upsertPricingPlan(ctx context.Context, productID int, req model.PutPricingPlanReq) (*model.PricingPlan, error) {
+	conflict := `(product_id) WHERE contract_id IS NULL`
+	if req.ContractID != nil {
+		conflict = `(product_id, contract_id) WHERE contract_id IS NOT NULL`
+	}
+	ret := new(model.PricingPlan)
+	err := sd.driver.QueryRowxContext(ctx,
+		`INSERT INTO pricing_plan(product_id, contract_id, currency, free_calls, tiers, created_at, updated_at)
+			VALUES($1, $2, $3, $4, $5, current_timestamp, current_timestamp)
+			ON CONFLICT `+conflict+` DO UPDATE
+			SET currency = EXCLUDED.currency, free_calls = EXCLUDED.free_calls, tiers = EXCLUDED.tiers, updated_at = current_timestamp
+			RETURNING *`,
+		productID, req.ContractID, req.Currency, req.FreeCalls, req.Tiers).StructScan(ret)
+	if err != nil {
+		if postgresErr, ok := err.(*pq.Error); ok && postgresErr.Code == foreignKeyErrCode {
+			field, value := "product_id", interface{}(productID)
+			if postgresErr.Constraint == "pricing_plan_contract_id_fkey" {
+				field, value = "contract_id", *req.ContractID
+			}
+			return nil, &dbConstraintErr{
+				constraintType: foreignKeyErr,
+				field:          field,
+				value:          value,
+				message:        fmt.Sprintf("upsert pricing plan, %s = %v, failed: foreign key constraint", field, value),
+			}
+		}
+		return nil, fmt.Errorf("sql execution error: %w", err)
+	}
+	return ret, nil
+}
+
+func (sd sqlDB) fetchPricingPlans(ctx context.Context) ([]model.PricingPlan, error) {
+	var plans []model.PricingPlan
+	if err := sd.driver.SelectContext(ctx, &plans, `SELECT * FROM pricing_plan ORDER BY id`); err != nil {
+		return nil, fmt.Errorf("failed to fetch pricing plans: %w", err)
+	}
+	return plans, nil
+}
+
+// fetchBillableCalls counts the billed calls in log_list run in [from, to) by the contract and the product
+// of the routing the gateway logged
+func (sd sqlDB) fetchBillableCalls(ctx context.Context, from, to time.Time) ([]model.BillableCallsDB, error) {
+	var list []model.BillableCallsDB
+	err := sd.driver.SelectContext(ctx, &list,
+		`SELECT l.contract_id, l.product_id, l.product_name, COUNT(*) AS billable_calls
+			FROM (`+attributedLogListSQL+`) l
+			WHERE l.run_date >= $1 AND l.run_date < $2
+				AND l.billable
+				AND l.contract_id IS NOT NULL
+				AND l.product_id IS NOT NULL
+			GROUP BY 1, 2, 3
+			ORDER BY 1, 2`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("sql execution error: %w", err)
+	}
+	return list, nil
+}
+
+// countUnattributedCalls counts the billed calls in log_list run in [from, to) which are not counted by
+// fetchBillableCalls, since they have no contract or no existing product
+func (sd sqlDB) countUnattributedCalls(ctx context.Context, from, to time.Time) (int64, error) {
+	var count int64
+	err := sd.driver.GetContext(ctx, &count,
+		`SELECT COUNT(*) FROM (`+attributedLogListSQL+`) l
+			WHERE l.run_date >= $1 AND l.run_date < $2
+				AND l.billable
+				AND (l.contract_id IS NULL OR l.product_id IS NULL)`, from, to)
+	if err != nil {
+		return 0, fmt.Errorf("sql execution error: %w", err)
+	}
+	return count, nil
+}
+
+// replaceInvoices deletes the invoices of the month and inserts the given ones in a transaction.
+// It fails with ErrInvoicesFinalized if the month is finalized, unless force is true.
+func (sd sqlDB) replaceInvoices(ctx context.Context, month time.Time, invoices []model.InvoiceDetail, force bool) error {
+	tx, err := sd.driver.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the row of the month is locked so that it is not finalized while the invoices are replaced
+	var finalizedAt time.Time
+	err = tx.QueryRowxContext(ctx, `SELECT finalized_at FROM invoice_month WHERE billing_month = $1 FOR UPDATE`, month).Scan(&finalizedAt)
+	if err == nil && !force {
+		return ErrInvoicesFinalized
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("fetch invoice month failed: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM invoice WHERE billing_month = $1`, month); err != nil {
+		return fmt.Errorf("delete invoices failed: %w", err)
+	}
+	for _, invoice := range invoices {
+		var id int
+		if err = tx.QueryRowxContext(ctx,
+			`INSERT INTO invoice(contract_id, billing_month, currency, total_micros, generated_at)
+				VALUES($1, $2, $3, $4, $5) RETURNING id`,
+			invoice.ContractID, month, invoice.Currency, invoice.TotalMicros, invoice.GeneratedAt).Scan(&id); err != nil {
+			return fmt.Errorf("insert invoice, contract_id = %d, failed: %w", invoice.ContractID, err)
+		}
+		for _, item := range invoice.Items {
+			if _, err = tx.ExecContext(ctx,
+				`INSERT INTO invoice_item(invoice_id, product_id, product_name, billable_calls, free_calls, amount_micros, pricing)
+					VALUES($1, $2, $3, $4, $5, $6, $7)`,
+				id, item.ProductID, item.ProductName, item.BillableCalls, item.FreeCalls, item.AmountMicros, item.Pricing); err != nil {
+				return fmt.Errorf("insert invoice item, contract_id = %d, product_id = %d, failed: %w", invoice.ContractID, item.ProductID, err)
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction failed: %w", err)
+	}
+	return nil
+}
+
+// finalizeInvoices finalizes the month, whose invoices are not replaced after that without force.
+// It returns the time when the month is finalized, which is finalizedAt unless it is finalized before.
+func (sd sqlDB) finalizeInvoices(ctx context.Context, month, finalizedAt time.Time) (time.Time, error) {
+	err := sd.driver.QueryRowxContext(ctx,
+		`INSERT INTO invoice_month(billing_month, finalized_at) VALUES($1, $2)
+			ON CONFLICT (billing_month) DO UPDATE SET billing_month = EXCLUDED.billing_month
+			RETURNING finalized_at`, month, finalizedAt).Scan(&finalizedAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("finalize invoice month failed: %w", err)
+	}
+	return finalizedAt, nil
+}
+
+// fetchInvoices returns invoices filtered by the contract and the month if they are not zero values
+func (sd sqlDB) fetchInvoices(ctx context.Context, contractID int, month *time.Time, limit, offset int) ([]model.Invoice, int, error) {
+	rows, err := sd.driver.QueryxContext(ctx,
+		`SELECT *, COUNT(*) OVER() AS count FROM invoice
+			WHERE ($1 = 0 OR contract_id = $1) AND ($2::date IS NULL OR billing_month = $2)
+			ORDER BY billing_month, contract_id, currency LIMIT $3 OFFSET $4`,
+		contractID, month, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("sql execution error: %w", err)
+	}
+	defer rows.Close()
+
+	list := make([]model.Invoice, 0)
+	count := 0
+	for rows.Next() {
+		var row struct {
+			model.Invoice
+			Count int `db:"count"`
+		}
+		if err := rows.StructScan(&row); err != nil {
+			return nil, 0, fmt.Errorf("scanning record error: %w", err)
+		}
+		list = append(list, row.Invoice)
+		count = row.Count
+	}
+	return list, count, rows.Err()
+}
+
+func (sd sqlDB) fetchInvoice(ctx context.Context, invoiceID int) (*model.InvoiceDetail, error) {
+	var invoice model.InvoiceDetail
+	err := sd.driver.QueryRowxContext(ctx, `SELECT * FROM invoice WHERE id = $1`, invoiceID).StructScan(&invoice.Invoice)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch invoice: %w", err)
+	}
+	invoice.Items = make([]model.InvoiceItem, 0)
+	if err = sd.driver.SelectContext(ctx, &invoice.Items,
+		`SELECT product_id, product_name, billable_calls, free_calls, amount_micros, pricing
+			FROM invoice_item WHERE invoice_id = $1 ORDER BY product_id`, invoiceID); err != nil {
+		return nil, fmt.Errorf("failed to fetch invoice items: %w", err)
+	}
+	return &invoice, nil
+}
+
 type constraintType string
 
 type dbConstraintErr struct {
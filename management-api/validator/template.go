@@ -0,0 +1,146 @@
+package validator
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	templateParamNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	templateParamPattern     = regexp.MustCompile(`{([^{}]*)}`)
+	// headerNamePattern is the token of RFC 7230
+	headerNamePattern = regexp.MustCompile("^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$")
+)
+
+// PathTemplate is a path of the gateway whose segments enclosed in braces are parameters, ex.) /users/{user_id}
+// it follows how the gateway splits a path into segments
+type PathTemplate struct {
+	segments []templateSegment
+}
+
+type templateSegment struct {
+	value   string
+	isParam bool
+}
+
+// ParsePathTemplate parses the path, and returns an error if the gateway cannot handle it.
+// a parameter must be a whole segment, and its name must be unique in the path regardless of case
+func ParsePathTemplate(path string) (PathTemplate, error) {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return PathTemplate{}, fmt.Errorf("path has no segment")
+	}
+
+	items := strings.Split(trimmed, "/")
+	segments := make([]templateSegment, 0, len(items))
+	names := make(map[string]string, len(items))
+	for _, v := range items {
+		if v == "" {
+			return PathTemplate{}, fmt.Errorf("path contains an empty segment")
+		}
+		if !strings.ContainsAny(v, "{}") {
+			segments = append(segments, templateSegment{value: v})
+			continue
+		}
+
+		if !strings.HasPrefix(v, "{") || !strings.HasSuffix(v, "}") || strings.Count(v, "{") != 1 || strings.Count(v, "}") != 1 {
+			return PathTemplate{}, fmt.Errorf("segment %s must be a whole parameter such as {name}", v)
+		}
+		name := v[1 : len(v)-1]
+		if !templateParamNamePattern.MatchString(name) {
+			return PathTemplate{}, fmt.Errorf("parameter name %s must consist of alphanumerics and underscores", name)
+		}
+		if prev, ok := names[strings.ToLower(name)]; ok {
+			if prev == name {
+				return PathTemplate{}, fmt.Errorf("parameter %s is duplicated", name)
+			}
+			return PathTemplate{}, fmt.Errorf("parameter %s conflicts with %s", name, prev)
+		}
+		names[strings.ToLower(name)] = name
+		segments = append(segments, templateSegment{value: name, isParam: true})
+	}
+
+	return PathTemplate{
+		segments: segments,
+	}, nil
+}
+
+// Params returns parameter names in the order they appear
+func (pt PathTemplate) Params() []string {
+	ret := make([]string, 0)
+	for _, v := range pt.segments {
+		if v.isParam {
+			ret = append(ret, v.value)
+		}
+	}
+	return ret
+}
+
+// Conflicts reports whether some request path matches both templates, so the gateway cannot tell which one is used
+func (pt PathTemplate) Conflicts(other PathTemplate) bool {
+	if len(pt.segments) != len(other.segments) {
+		return false
+	}
+	for i, v := range pt.segments {
+		o := other.segments[i]
+		if !v.isParam && !o.isParam && v.value != o.value {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseForwardURLParams checks that the forward url is an http or https url with a host,
+// and returns parameter names used in its path and query string
+func ParseForwardURLParams(forwardURL string) ([]string, error) {
+	u, err := url.Parse(forwardURL)
+	if err != nil {
+		return nil, fmt.Errorf("forward url cannot be parsed: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("scheme must be http or https, but got %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("host is empty")
+	}
+
+	if strings.Contains(u.Fragment, "{") {
+		return nil, fmt.Errorf("fragment cannot contain parameters")
+	}
+	return ParseTemplateParams(forwardURL)
+}
+
+// ParseTemplateParams returns parameter names enclosed in braces in the string, ex.) "Bearer {token}" -> [token]
+func ParseTemplateParams(s string) ([]string, error) {
+	if strings.Count(s, "{") != strings.Count(s, "}") {
+		return nil, fmt.Errorf("braces are not balanced")
+	}
+	matches := templateParamPattern.FindAllStringSubmatch(s, -1)
+	if len(matches) != strings.Count(s, "{") {
+		return nil, fmt.Errorf("braces are nested or not closed")
+	}
+	ret := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if !templateParamNamePattern.MatchString(m[1]) {
+			return nil, fmt.Errorf("parameter name %s must consist of alphanumerics and underscores", m[1])
+		}
+		ret = append(ret, m[1])
+	}
+	return ret, nil
+}
+
+// ValidateHeaderName returns an error if the name is not a valid header field name
+// or is a header which the gateway manages by itself
+func ValidateHeaderName(name string) error {
+	if !headerNamePattern.MatchString(name) {
+		return fmt.Errorf("header name %s contains invalid characters", name)
+	}
+	switch http.CanonicalHeaderKey(name) {
+	case "Host", "Content-Length", "Connection", "Transfer-Encoding", "X-Apidoor-Authorization":
+		return fmt.Errorf("header %s cannot be set", name)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package validator
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestParsePathTemplate(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		wantParams []string
+		wantErr    bool
+	}{
+		{
+			name:       "path without parameters",
+			path:       "/users",
+			wantParams: []string{},
+		},
+		{
+			name:       "path with parameters",
+			path:       "/users/{user_id}/items/{item_id}",
+			wantParams: []string{"user_id", "item_id"},
+		},
+		{
+			name:       "leading slash is optional",
+			path:       "test",
+			wantParams: []string{},
+		},
+		{
+			name:    "empty path",
+			path:    "/",
+			wantErr: true,
+		},
+		{
+			name:    "empty segment",
+			path:    "/users//items",
+			wantErr: true,
+		},
+		{
+			name:    "parameter is a part of a segment",
+			path:    "/files/{name}.json",
+			wantErr: true,
+		},
+		{
+			name:    "unclosed brace",
+			path:    "/users/{user_id",
+			wantErr: true,
+		},
+		{
+			name:    "empty parameter name",
+			path:    "/users/{}",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate parameter names",
+			path:    "/users/{id}/items/{id}",
+			wantErr: true,
+		},
+		{
+			name:    "parameter names differ only in case",
+			path:    "/users/{id}/items/{ID}",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePathTemplate(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.wantParams, got.Params()); diff != "" {
+				t.Errorf("params differ:\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestPathTemplate_Conflicts(t *testing.T) {
+	tests := []struct {
+		name  string
+		path1 string
+		path2 string
+		want  bool
+	}{
+		{
+			name:  "different literal segments",
+			path1: "/users/list",
+			path2: "/users/me",
+			want:  false,
+		},
+		{
+			name:  "parameter matches a literal segment",
+			path1: "/users/{user_id}",
+			path2: "/users/me",
+			want:  true,
+		},
+		{
+			name:  "parameters with different names",
+			path1: "/users/{id}",
+			path2: "/users/{user_id}",
+			want:  true,
+		},
+		{
+			name:  "different number of segments",
+			path1: "/users/{user_id}",
+			path2: "/users/{user_id}/items",
+			want:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t1, err := ParsePathTemplate(tt.path1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t2, err := ParsePathTemplate(tt.path2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := t1.Conflicts(t2); got != tt.want {
+				t.Errorf("wrong result: got %v, want %v", got, tt.want)
+			}
+			if got := t2.Conflicts(t1); got != tt.want {
+				t.Errorf("result is not symmetric: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseForwardURLParams(t *testing.T) {
+	tests := []struct {
+		name       string
+		forwardURL string
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "url with parameters",
+			forwardURL: "https://example.com/users/{user_id}/items/{item_id}",
+			want:       []string{"user_id", "item_id"},
+		},
+		{
+			name:       "url without parameters",
+			forwardURL: "http://example.com/users",
+			want:       []string{},
+		},
+		{
+			name:       "url with parameters in the query string",
+			forwardURL: "https://example.com/users?id={user_id}&key={apikey_id}",
+			want:       []string{"user_id", "apikey_id"},
+		},
+		{
+			name:       "parameter in the fragment",
+			forwardURL: "https://example.com/users#{user_id}",
+			wantErr:    true,
+		},
+		{
+			name:       "unsupported scheme",
+			forwardURL: "tcp://example.com/users",
+			wantErr:    true,
+		},
+		{
+			name:       "host is empty",
+			forwardURL: "http:///users",
+			wantErr:    true,
+		},
+		{
+			name:       "unclosed brace",
+			forwardURL: "https://example.com/users/{user_id",
+			wantErr:    true,
+		},
+		{
+			name:       "nested braces",
+			forwardURL: "https://example.com/users/{{user_id}}",
+			wantErr:    true,
+		},
+		{
+			name:       "invalid parameter name",
+			forwardURL: "https://example.com/users/{user-id}",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseForwardURLParams(tt.forwardURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" && !tt.wantErr {
+				t.Errorf("params differ:\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestValidateHeaderName(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{
+			name:   "valid header name",
+			header: "X-User-Id",
+		},
+		{
+			name:    "header name with a space",
+			header:  "X User",
+			wantErr: true,
+		},
+		{
+			name:    "header managed by the gateway",
+			header:  "x-apidoor-authorization",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateHeaderName(tt.header); (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
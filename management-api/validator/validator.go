@@ -152,6 +152,11 @@ func generateConstraintType(fieldErr validator.FieldError) string {
 		return "enum"
 	}
 
+	// conditional requirements, ex.) "required_if=Type a"
+	if strings.HasPrefix(fieldErr.Tag(), "required_") {
+		return "required"
+	}
+
 	if fieldErr.Kind() == reflect.Slice || fieldErr.Kind() == reflect.Map {
 		if fieldErr.Tag() == "gte" {
 			return "length_gte"
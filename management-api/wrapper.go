@@ -7,8 +7,10 @@ import (
 	"github.com/future-architect/apidoor/managementapi/model"
 	"github.com/future-architect/apidoor/managementapi/usecase"
 	"github.com/future-architect/apidoor/managementapi/validator"
+	"github.com/go-chi/chi/v5"
 	"log"
 	"net/http"
+	"strconv"
 )
 
 // unmarshalJSONAndValidate unmarshals body bytes into the target struct and validates the struct
@@ -58,6 +60,15 @@ func writeErrResponse(w http.ResponseWriter, err error) {
 				log.Printf("write bad request response failed: %v", err)
 				http.Error(w, "server error", http.StatusInternalServerError)
 			}
+		case usecase.ConflictError:
+			if respBytes, err := createBadRequestRespBytes(err); err == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				w.Write(respBytes)
+			} else {
+				log.Printf("write conflict response failed: %v", err)
+				http.Error(w, "server error", http.StatusInternalServerError)
+			}
 		case usecase.ServerError:
 			http.Error(w, "server error", http.StatusInternalServerError)
 		case validator.ValidationErrors:
@@ -78,6 +89,31 @@ func writeErrResponse(w http.ResponseWriter, err error) {
 	}
 }
 
+// parseIDParam reads the URL parameter as an integer id.
+// if the parameter is not an integer, it returns ClientError whose message contains the label
+func parseIDParam(r *http.Request, key, label string) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, key))
+	if err != nil {
+		log.Printf("parse %s error: %v", label, err)
+		return 0, usecase.NewClientError(fmt.Errorf("%s must be an integer", label))
+	}
+	return id, nil
+}
+
+// writeJSONResponse writes the response marshaled into json with the status code
+func writeJSONResponse(w http.ResponseWriter, status int, resp interface{}) {
+	respBody, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("create json response error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(respBody)
+}
+
 func createBadRequestRespBytes(err error) ([]byte, error) {
 	br := validator.BadRequestResp{
 		Message: err.Error(),
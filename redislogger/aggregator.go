@@ -0,0 +1,168 @@
+package redislogger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/future-architect/apidoor/logtail"
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrCheckpointConflict is returned if the checkpoint is updated by another aggregator of the same log file
+var ErrCheckpointConflict = errors.New("checkpoint of the log file is updated by another aggregator")
+
+// Aggregator adds the billed calls appended to the log file to the usage buckets of every granularity.
+// The checkpoint of the aggregated lines is updated in the same transaction as the buckets,
+// so each line is counted exactly once even if the aggregator stops in the middle.
+type Aggregator struct {
+	Client *redis.Client
+	Path   string
+	Parser LineParser
+	// BatchSize is the number of lines aggregated by a transaction, 1000 if it is 0
+	BatchSize int
+}
+
+// checkpointKey returns the redis key of the checkpoint of the log file
+func (a Aggregator) checkpointKey() string {
+	return "usage:checkpoint:" + a.Path
+}
+
+// Run aggregates the log file every interval until the context is done
+func (a Aggregator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := a.AggregateOnce(ctx)
+		if err != nil {
+			log.Printf("aggregating %s failed: %v", a.Path, err)
+		} else if n > 0 {
+			log.Printf("aggregated %d lines of %s", n, a.Path)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// AggregateOnce aggregates the complete lines after the checkpoint, and returns the number of the aggregated lines.
+// If the log file is rotated, the rest of the previous file and the files rotated after it are aggregated in rotation
+// order before the new file.
+func (a Aggregator) AggregateOnce(ctx context.Context) (int, error) {
+	tailer := logtail.Tailer{
+		Path:      a.Path,
+		Store:     checkpointStore{a},
+		BatchSize: a.BatchSize,
+	}
+	return tailer.ReadOnce(ctx)
+}
+
+// checkpointStore stores the checkpoint of the log file in redis
+type checkpointStore struct {
+	Aggregator
+}
+
+func (c checkpointStore) Load(ctx context.Context) (logtail.Position, error) {
+	return c.loadCheckpoint(ctx)
+}
+
+func (c checkpointStore) Commit(ctx context.Context, prev, next logtail.Position, lines []logtail.Line) (int, error) {
+	calls := make([]Call, 0, len(lines))
+	for _, line := range lines {
+		call, err := c.Parser.Parse(line.Content)
+		if err != nil {
+			log.Printf("[WARN] skip a line at offset %d of %s: %v", line.Offset, line.Path, err)
+			continue
+		}
+		calls = append(calls, call)
+	}
+	if err := c.commit(ctx, prev, next, calls); err != nil {
+		return 0, err
+	}
+	return len(calls), nil
+}
+
+func (a Aggregator) loadCheckpoint(ctx context.Context) (logtail.Position, error) {
+	v, err := a.Client.Get(ctx, a.checkpointKey()).Result()
+	if errors.Is(err, redis.Nil) {
+		return logtail.Position{}, nil
+	} else if err != nil {
+		return logtail.Position{}, fmt.Errorf("get checkpoint: %w", err)
+	}
+	return parseCheckpoint(v)
+}
+
+// parseCheckpoint parses the checkpoint stored as <fingerprint>:<offset>
+func parseCheckpoint(v string) (logtail.Position, error) {
+	if v == "" {
+		return logtail.Position{}, nil
+	}
+	i := strings.LastIndex(v, ":")
+	if i < 0 {
+		return logtail.Position{}, fmt.Errorf("broken checkpoint: %s", v)
+	}
+	offset, err := strconv.ParseInt(v[i+1:], 10, 64)
+	if err != nil {
+		return logtail.Position{}, fmt.Errorf("broken checkpoint: %s", v)
+	}
+	return logtail.Position{Fingerprint: v[:i], Offset: offset}, nil
+}
+
+func formatCheckpoint(pos logtail.Position) string {
+	return pos.Fingerprint + ":" + strconv.FormatInt(pos.Offset, 10)
+}
+
+// commit adds the billed calls to the buckets and moves the checkpoint from prev to next in a transaction,
+// which fails with ErrCheckpointConflict if the checkpoint is not prev
+func (a Aggregator) commit(ctx context.Context, prev, next logtail.Position, calls []Call) error {
+	type field struct {
+		bucket Bucket
+		apikey string
+		path   string
+	}
+	counts := make(map[field]int64)
+	for _, call := range calls {
+		if !call.Billing {
+			continue
+		}
+		for _, g := range granularities {
+			counts[field{bucket: BucketOf(g, call.Time), apikey: call.APIKey, path: call.Path}]++
+		}
+	}
+
+	key := a.checkpointKey()
+	err := a.Client.Watch(ctx, func(tx *redis.Tx) error {
+		v, err := tx.Get(ctx, key).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return fmt.Errorf("get checkpoint: %w", err)
+		}
+		current, err := parseCheckpoint(v)
+		if err != nil {
+			return err
+		}
+		if current != prev {
+			return ErrCheckpointConflict
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for f, n := range counts {
+				bucketKey := f.bucket.Key(f.apikey)
+				pipe.HIncrBy(ctx, bucketKey, f.path, n)
+				pipe.ExpireAt(ctx, bucketKey, f.bucket.End().Add(Retention[f.bucket.Granularity]))
+			}
+			pipe.Set(ctx, key, formatCheckpoint(next), 0)
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return ErrCheckpointConflict
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package redislogger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketsBetween(t *testing.T) {
+	now := time.Date(2022, time.March, 15, 10, 30, 20, 0, time.UTC)
+	tests := []struct {
+		name string
+		from time.Time
+		// wantPrefix is the first buckets, which are followed by buckets continuous to the end of the current minute
+		wantPrefix []string
+		wantLen    int
+	}{
+		{
+			name:       "within the current hour",
+			from:       time.Date(2022, time.March, 15, 10, 28, 40, 0, time.UTC),
+			wantPrefix: []string{"minute:202203151028", "minute:202203151029", "minute:202203151030"},
+			wantLen:    3,
+		},
+		{
+			name:       "over the hour",
+			from:       time.Date(2022, time.March, 15, 8, 59, 0, 0, time.UTC),
+			wantPrefix: []string{"minute:202203150859", "hour:2022031509", "minute:202203151000"},
+			wantLen:    33,
+		},
+		{
+			name:       "expired minutes are counted from the start of the hour",
+			from:       time.Date(2022, time.March, 14, 23, 10, 0, 0, time.UTC),
+			wantPrefix: []string{"hour:2022031423", "hour:2022031500", "hour:2022031501"},
+			wantLen:    11 + 31,
+		},
+		{
+			name:       "expired hours are counted from the start of the day",
+			from:       time.Date(2022, time.February, 13, 5, 0, 0, 0, time.UTC),
+			wantPrefix: []string{"day:20220213", "day:20220214", "day:20220215"},
+			wantLen:    16 + 14 + 10 + 31,
+		},
+		{
+			name:       "whole months",
+			from:       time.Date(2021, time.December, 31, 0, 0, 0, 0, time.UTC),
+			wantPrefix: []string{"day:20211231", "month:202201", "month:202202", "day:20220301"},
+			wantLen:    1 + 2 + 14 + 10 + 31,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := bucketsBetween(tt.from, now, now)
+			var got []string
+			for _, b := range buckets {
+				got = append(got, string(b.Granularity)+":"+b.Start.Format(bucketLayouts[b.Granularity]))
+			}
+			if len(got) != tt.wantLen {
+				t.Errorf("unexpected number of buckets %d, expected %d: %v", len(got), tt.wantLen, got)
+			}
+			for i, want := range tt.wantPrefix {
+				if i >= len(got) || got[i] != want {
+					t.Fatalf("unexpected buckets %v, expected to start with %v", got, tt.wantPrefix)
+				}
+			}
+			for i := 1; i < len(buckets); i++ {
+				if !buckets[i-1].End().Equal(buckets[i].Start) {
+					t.Fatalf("buckets are not continuous at %d: %v", i, got)
+				}
+			}
+			if end := buckets[len(buckets)-1].End(); !end.Equal(time.Date(2022, time.March, 15, 10, 31, 0, 0, time.UTC)) {
+				t.Errorf("the buckets end at %s", end)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/future-architect/apidoor/redislogger"
+)
+
+var defaultAggregateInterval = 5 * time.Second
+
+// aggregator entry point, which aggregates the log file of the gateway until it is stopped
+func main() {
+	aggregator, err := redislogger.NewAggregator()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if aggregator.Path == "" {
+		log.Fatal("missing LOG_PATH env")
+	}
+
+	interval := defaultAggregateInterval
+	if v := os.Getenv("AGGREGATE_INTERVAL"); v != "" {
+		if interval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("invalid AGGREGATE_INTERVAL: %v", err)
+		}
+	}
+	if v := os.Getenv("AGGREGATE_BATCH_SIZE"); v != "" {
+		if aggregator.BatchSize, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("invalid AGGREGATE_BATCH_SIZE: %v", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	aggregator.Run(ctx, interval)
+}
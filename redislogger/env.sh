@@ -1,3 +1,3 @@
 #!/bin/bash
 export REDIS_HOST="localhost:6379"
-export LOGPATH="./log.csv"
\ No newline at end of file
+export LOG_PATH="./log.csv"
\ No newline at end of file
@@ -4,6 +4,9 @@ go 1.16
 
 require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/future-architect/apidoor/logtail v0.0.0
 	github.com/go-redis/redis/v8 v8.11.3
 	go.opentelemetry.io/otel v0.20.0 // indirect
 )
+
+replace github.com/future-architect/apidoor/logtail => ../logtail
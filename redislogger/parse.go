@@ -0,0 +1,113 @@
+package redislogger
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultLogPattern is the LOG_PATTERN of the gateway when it is not set
+const DefaultLogPattern = "time,key,path,response_status,billing_status"
+
+const notBilling = "not billing"
+
+// Call is an api call read from a line of the log file
+type Call struct {
+	Time   time.Time
+	APIKey string
+	Path   string
+	// Billing is false if the log has billing_status of "not billing"
+	Billing bool
+}
+
+// LineParser converts a line of the log file to a call
+type LineParser interface {
+	Parse(line []byte) (Call, error)
+}
+
+// CSVParser parses a line written by the csv appender of the gateway with the columns of LOG_PATTERN
+type CSVParser struct {
+	columns       int
+	time, key     int
+	path, billing int
+}
+
+// NewCSVParser returns the parser of the pattern, which must have the time, key and path columns
+func NewCSVParser(pattern string) (CSVParser, error) {
+	if pattern == "" {
+		pattern = DefaultLogPattern
+	}
+	columns := strings.Split(pattern, ",")
+	index := func(name string) int {
+		for i, column := range columns {
+			if column == name {
+				return i
+			}
+		}
+		return -1
+	}
+	p := CSVParser{
+		columns: len(columns),
+		time:    index("time"),
+		key:     index("key"),
+		path:    index("path"),
+		billing: index("billing_status"),
+	}
+	if p.time < 0 || p.key < 0 || p.path < 0 {
+		return CSVParser{}, fmt.Errorf("log pattern %s must have the time, key and path columns", pattern)
+	}
+	return p, nil
+}
+
+func (p CSVParser) Parse(line []byte) (Call, error) {
+	reader := csv.NewReader(strings.NewReader(string(line)))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	record, err := reader.Read()
+	if err != nil {
+		return Call{}, fmt.Errorf("parse csv line: %w", err)
+	}
+	if len(record) != p.columns {
+		return Call{}, fmt.Errorf("the line has %d columns, while the pattern has %d", len(record), p.columns)
+	}
+	billing := ""
+	if p.billing >= 0 {
+		billing = record[p.billing]
+	}
+	return newCall(record[p.time], record[p.key], record[p.path], billing)
+}
+
+// JSONParser parses a line written by the json appender of the gateway
+type JSONParser struct{}
+
+func (JSONParser) Parse(line []byte) (Call, error) {
+	var record struct {
+		Time          string `json:"time"`
+		APIKey        string `json:"api_key"`
+		Path          string `json:"path"`
+		BillingStatus string `json:"billing_status"`
+	}
+	if err := json.Unmarshal(line, &record); err != nil {
+		return Call{}, fmt.Errorf("parse json line: %w", err)
+	}
+	return newCall(record.Time, record.APIKey, record.Path, record.BillingStatus)
+}
+
+func newCall(timestamp, apikey, path, billingStatus string) (Call, error) {
+	if timestamp == "" || apikey == "" {
+		return Call{}, errors.New("the line has no time or api key")
+	}
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return Call{}, fmt.Errorf("parse time: %w", err)
+	}
+	return Call{
+		Time:    t,
+		APIKey:  apikey,
+		Path:    path,
+		Billing: billingStatus != notBilling,
+	}, nil
+}
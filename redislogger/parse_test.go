@@ -0,0 +1,68 @@
+package redislogger_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/future-architect/apidoor/redislogger"
+)
+
+func TestParser(t *testing.T) {
+	csvParser, err := redislogger.NewCSVParser("key,X-Client,time,path,billing_status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		parser  redislogger.LineParser
+		line    string
+		want    redislogger.Call
+		wantErr bool
+	}{
+		{
+			name:   "csv with the columns of the pattern",
+			parser: csvParser,
+			line:   `key,"a,b",2021-07-01T14:01:46+09:00,/users,not billing`,
+			want:   redislogger.Call{Time: time.Date(2021, time.July, 1, 5, 1, 46, 0, time.UTC), APIKey: "key", Path: "/users"},
+		},
+		{
+			name:    "csv of a different number of columns",
+			parser:  csvParser,
+			line:    "key,2021-07-01T14:01:46+09:00,/users,billing",
+			wantErr: true,
+		},
+		{
+			name:   "json",
+			parser: redislogger.JSONParser{},
+			line:   `{"time":"2021-07-01T14:01:46+09:00","api_key":"key","path":"/users","billing_status":"billing"}`,
+			want:   redislogger.Call{Time: time.Date(2021, time.July, 1, 5, 1, 46, 0, time.UTC), APIKey: "key", Path: "/users", Billing: true},
+		},
+		{
+			name:    "json without api key",
+			parser:  redislogger.JSONParser{},
+			line:    `{"time":"2021-07-01T14:01:46+09:00","path":"/users"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parser.Parse([]byte(tt.line))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !got.Time.Equal(tt.want.Time) || got.APIKey != tt.want.APIKey || got.Path != tt.want.Path || got.Billing != tt.want.Billing {
+				t.Errorf("unexpected call %+v, expected %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := redislogger.NewCSVParser("time,path"); err == nil {
+		t.Error("a pattern without the key column should be rejected")
+	}
+}
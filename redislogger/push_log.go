@@ -2,11 +2,8 @@ package redislogger
 
 import (
 	"context"
-	"encoding/csv"
-	"log"
+	"fmt"
 	"os"
-	"strconv"
-	"time"
 
 	"github.com/go-redis/redis/v8"
 )
@@ -17,53 +14,37 @@ var rdb = redis.NewClient(&redis.Options{
 	DB:       0,
 })
 
-func PushLog() {
-	file, err := os.OpenFile(os.Getenv("LOGPATH"), os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
-		log.Fatal(err)
+// NewParser returns the parser of the log file set by the LOG_FORMAT and LOG_PATTERN envs of the gateway
+func NewParser() (LineParser, error) {
+	switch os.Getenv("LOG_FORMAT") {
+	case "", "CSV":
+		return NewCSVParser(os.Getenv("LOG_PATTERN"))
+	case "JSON":
+		return JSONParser{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported LOG_FORMAT: %s", os.Getenv("LOG_FORMAT"))
 	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	count := make(map[string]map[string]int)
-	threshold := time.Now().Add(-1 * time.Minute)
-	for {
-		line, err := reader.Read()
-		if err != nil {
-			if err.Error() == "EOF" {
-				break
-			}
-			log.Fatal(err)
-		}
-
-		t, err := time.Parse(time.RFC3339, line[0])
-		if err != nil {
-			log.Fatal(err)
-		}
-		key, path := line[1], line[2]
+}
 
-		if t.After(threshold) {
-			if _, ok := count[key]; ok {
-				count[key][path]++
-			} else {
-				count[key] = make(map[string]int)
-				count[key][path] = 1
-			}
-		}
+// NewAggregator returns the aggregator of the log file at LOG_PATH to the redis at REDIS_HOST
+func NewAggregator() (Aggregator, error) {
+	parser, err := NewParser()
+	if err != nil {
+		return Aggregator{}, err
 	}
+	return Aggregator{
+		Client: rdb,
+		Path:   os.Getenv("LOG_PATH"),
+		Parser: parser,
+	}, nil
+}
 
-	ctx := context.Background()
-	for k, v := range count {
-		for p, n := range v {
-			if rdb.HExists(ctx, k, p).Val() {
-				now, err := strconv.Atoi(rdb.HGet(ctx, k, p).Val())
-				if err != nil {
-					log.Fatal(err)
-				}
-				rdb.HSet(ctx, k, p, now+n)
-			} else {
-				rdb.HSet(ctx, k, p, n)
-			}
-		}
+// PushLog aggregates the lines of the log file at LOG_PATH which are not aggregated yet
+func PushLog() error {
+	aggregator, err := NewAggregator()
+	if err != nil {
+		return err
 	}
+	_, err = aggregator.AggregateOnce(context.Background())
+	return err
 }
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/csv"
 	"os"
-	"strconv"
 	"testing"
 	"time"
 
@@ -15,26 +14,35 @@ import (
 var now = time.Now()
 
 var testdata = []struct {
-	date string
-	key  string
-	path string
+	date    string
+	key     string
+	path    string
+	billing string
 }{
-	// data not counted by logger
 	{
-		date: now.Add(-2 * time.Minute).Format(time.RFC3339),
-		key:  "key",
-		path: "path",
+		date:    now.Add(-2 * time.Minute).Format(time.RFC3339),
+		key:     "key",
+		path:    "path",
+		billing: "billing",
+	},
+	{
+		date:    now.Add(-2 * time.Second).Format(time.RFC3339),
+		key:     "key",
+		path:    "path",
+		billing: "billing",
 	},
-	// data counted by logger
 	{
-		date: now.Add(-2 * time.Second).Format(time.RFC3339),
-		key:  "key",
-		path: "path",
+		date:    now.Add(-2 * time.Second).Format(time.RFC3339),
+		key:     "key",
+		path:    "path",
+		billing: "billing",
 	},
+	// data not counted by logger
 	{
-		date: now.Add(-2 * time.Second).Format(time.RFC3339),
-		key:  "key",
-		path: "path",
+		date:    now.Add(-2 * time.Second).Format(time.RFC3339),
+		key:     "key",
+		path:    "path",
+		billing: "not billing",
 	},
 }
 
@@ -44,8 +52,21 @@ var rdb = redis.NewClient(&redis.Options{
 	DB:       0,
 })
 
+func cleanupUsage(ctx context.Context, t *testing.T) {
+	t.Helper()
+	for _, pattern := range []string{"usage:*:key", "usage:checkpoint:" + os.Getenv("LOG_PATH")} {
+		keys, err := rdb.Keys(ctx, pattern).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) > 0 {
+			rdb.Del(ctx, keys...)
+		}
+	}
+}
+
 func TestPushLog(t *testing.T) {
-	file, err := os.OpenFile(os.Getenv("LOGPATH"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	file, err := os.OpenFile(os.Getenv("LOG_PATH"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -61,28 +82,47 @@ func TestPushLog(t *testing.T) {
 			tt.date,
 			tt.key,
 			tt.path,
+			"200",
+			tt.billing,
 		})
 	}
 	writer.Flush()
 
 	ctx := context.Background()
-	rdb.HDel(ctx, "key", "path")
+	cleanupUsage(ctx, t)
+	defer cleanupUsage(ctx, t)
 
+	// the lines already aggregated are not counted again
 	for i := 1; i <= 2; i++ {
-		redislogger.PushLog()
+		if err := redislogger.PushLog(); err != nil {
+			t.Fatal(err)
+		}
 
-		n, err := strconv.Atoi(rdb.HGet(ctx, "key", "path").Val())
+		n, err := redislogger.CountSince(ctx, rdb, "key", "path", now.Add(-time.Hour), now)
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		if n != i*2 {
-			t.Fatalf("unexpected count %d, expected %d", n, i*2)
+		if n != 3 {
+			t.Fatalf("unexpected count %d, expected %d", n, 3)
 		}
 	}
 
+	n, err := redislogger.Usage(ctx, rdb, redislogger.Minute, now.Add(-2*time.Second), "key", "path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("unexpected count of the minute %d, expected %d", n, 2)
+	}
+	ttl, err := rdb.TTL(ctx, redislogger.BucketOf(redislogger.Minute, now).Key("key")).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= time.Hour || ttl > redislogger.Retention[redislogger.Minute]+time.Minute {
+		t.Fatalf("unexpected ttl of the minute bucket %s", ttl)
+	}
+
 	if err := file.Truncate(0); err != nil {
 		t.Fatal(err)
 	}
-	rdb.HDel(ctx, "key", "path")
 }
@@ -0,0 +1,143 @@
+package redislogger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Granularity is the span of a usage bucket, whose boundaries are in UTC
+type Granularity string
+
+const (
+	Minute Granularity = "minute"
+	Hour   Granularity = "hour"
+	Day    Granularity = "day"
+	Month  Granularity = "month"
+)
+
+// granularities are ordered from the coarsest
+var granularities = []Granularity{Month, Day, Hour, Minute}
+
+// Retention is how long the buckets of each granularity are kept after the end of the bucket
+var Retention = map[Granularity]time.Duration{
+	Minute: 2 * time.Hour,
+	Hour:   3 * 24 * time.Hour,
+	Day:    400 * 24 * time.Hour,
+	Month:  5 * 365 * 24 * time.Hour,
+}
+
+var bucketLayouts = map[Granularity]string{
+	Minute: "200601021504",
+	Hour:   "2006010215",
+	Day:    "20060102",
+	Month:  "200601",
+}
+
+// Bucket is the usage of an api key in a span, which is a redis hash from the path to the number of the billed calls
+type Bucket struct {
+	Granularity Granularity
+	Start       time.Time
+}
+
+// BucketOf returns the bucket of the granularity which includes t
+func BucketOf(g Granularity, t time.Time) Bucket {
+	t = t.UTC()
+	var start time.Time
+	switch g {
+	case Minute:
+		start = t.Truncate(time.Minute)
+	case Hour:
+		start = t.Truncate(time.Hour)
+	case Day:
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case Month:
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return Bucket{Granularity: g, Start: start}
+}
+
+// End returns the start of the next bucket
+func (b Bucket) End() time.Time {
+	switch b.Granularity {
+	case Minute:
+		return b.Start.Add(time.Minute)
+	case Hour:
+		return b.Start.Add(time.Hour)
+	case Day:
+		return b.Start.AddDate(0, 0, 1)
+	default:
+		return b.Start.AddDate(0, 1, 0)
+	}
+}
+
+// Key returns the redis key of the bucket of the api key
+func (b Bucket) Key(apikey string) string {
+	return fmt.Sprintf("usage:%s:%s:%s", b.Granularity, b.Start.Format(bucketLayouts[b.Granularity]), apikey)
+}
+
+// Usage returns the number of the billed calls of the api key and the path in the bucket of the granularity including t
+func Usage(ctx context.Context, client redis.Cmdable, g Granularity, t time.Time, apikey, path string) (int64, error) {
+	n, err := client.HGet(ctx, BucketOf(g, t).Key(apikey), path).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// CountSince returns the number of the billed calls of the api key and the path from since to now,
+// which the quota check of the gateway can use instead of counting the access logs.
+// If the buckets of the span including since have expired, the calls are counted from the start of the coarser bucket
+// including since, so the count may include calls before since, up to a day for a span older than the retention of hours.
+func CountSince(ctx context.Context, client redis.Cmdable, apikey, path string, since, now time.Time) (int64, error) {
+	buckets := bucketsBetween(since, now, now)
+	pipe := client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(buckets))
+	for i, b := range buckets {
+		cmds[i] = pipe.HGet(ctx, b.Key(apikey), path)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+
+	var count int64
+	for _, cmd := range cmds {
+		n, err := cmd.Int64()
+		if errors.Is(err, redis.Nil) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// bucketsBetween returns the fewest buckets which cover from from to the end of the minute including to.
+// from is moved to the start of the finest bucket which is retained at now.
+func bucketsBetween(from, to, now time.Time) []Bucket {
+	for _, g := range []Granularity{Minute, Hour, Day, Month} {
+		b := BucketOf(g, from)
+		if b.End().Add(Retention[g]).After(now) {
+			from = b.Start
+			break
+		}
+	}
+	end := BucketOf(Minute, to).End()
+
+	var buckets []Bucket
+	for t := from.UTC(); t.Before(end); {
+		for _, g := range granularities {
+			b := BucketOf(g, t)
+			if g == Minute || (b.Start.Equal(t) && !b.End().After(end)) {
+				buckets = append(buckets, b)
+				t = b.End()
+				break
+			}
+		}
+	}
+	return buckets
+}
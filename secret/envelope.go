@@ -0,0 +1,180 @@
+package secret
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// KeyFileEnv is the environment variable of the path of the key file
+const KeyFileEnv = "TOKEN_ENCRYPTION_KEY_FILE"
+
+// AllowPlaintextEnv is the environment variable which allows secrets to be stored in plaintext without the key file,
+// which is only for local development
+const AllowPlaintextEnv = "ALLOW_PLAINTEXT_TOKENS"
+
+// ErrKeyFileRequired is returned by NewRequiredEnvelopeFromEnv if neither the key file nor plaintext is allowed
+var ErrKeyFileRequired = fmt.Errorf("%s is not set, set %s=true to store secrets in plaintext", KeyFileEnv, AllowPlaintextEnv)
+
+// encryptedPrefix marks encrypted values, values without it are treated as plaintext stored before encryption
+const encryptedPrefix = "enc:v1:"
+
+const dataKeySize = 32
+
+// KeyProvider wraps data keys with master keys, which are identified by key ids
+type KeyProvider interface {
+	// KeyID returns the id of the master key which wraps new data keys
+	KeyID() string
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts the data key wrapped by the master key of the id
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Envelope encrypts each value with a new data key, and stores the data key wrapped by the key provider alongside.
+// an encrypted value is "enc:v1:<key id>:<wrapped data key>:<nonce and ciphertext>" encoded in base64
+type Envelope struct {
+	provider KeyProvider
+}
+
+func NewEnvelope(provider KeyProvider) *Envelope {
+	return &Envelope{provider: provider}
+}
+
+// NewEnvelopeFromEnv returns the envelope with the key file of TOKEN_ENCRYPTION_KEY_FILE, or nil if it is not set
+func NewEnvelopeFromEnv() (*Envelope, error) {
+	path := os.Getenv(KeyFileEnv)
+	if path == "" {
+		return nil, nil
+	}
+	keyFile, err := LoadKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewEnvelope(keyFile), nil
+}
+
+// NewRequiredEnvelopeFromEnv returns the envelope of NewEnvelopeFromEnv for the writers of secrets, which fails with
+// ErrKeyFileRequired if TOKEN_ENCRYPTION_KEY_FILE is not set, unless ALLOW_PLAINTEXT_TOKENS is true and it returns nil
+func NewRequiredEnvelopeFromEnv() (*Envelope, error) {
+	envelope, err := NewEnvelopeFromEnv()
+	if err != nil || envelope != nil {
+		return envelope, err
+	}
+	if os.Getenv(AllowPlaintextEnv) != "true" {
+		return nil, ErrKeyFileRequired
+	}
+	return nil, nil
+}
+
+// IsEncrypted reports whether the value is encrypted by an envelope
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// KeyID returns the id of the master key which wraps the data key of the encrypted value.
+// ok is false if the value is not encrypted
+func KeyID(value string) (keyID string, ok bool) {
+	if !IsEncrypted(value) {
+		return "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 2)
+	return parts[0], true
+}
+
+// CurrentKeyID returns the id of the master key which wraps new data keys
+func (e *Envelope) CurrentKeyID() string {
+	return e.provider.KeyID()
+}
+
+func (e *Envelope) Encrypt(ctx context.Context, plaintext string) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("generate data key failed: %w", err)
+	}
+	wrapped, err := e.provider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("wrap data key failed: %w", err)
+	}
+	keyID := e.provider.KeyID()
+	sealed, err := seal(dataKey, []byte(plaintext), []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + strings.Join([]string{
+		keyID,
+		base64.RawURLEncoding.EncodeToString(wrapped),
+		base64.RawURLEncoding.EncodeToString(sealed),
+	}, ":"), nil
+}
+
+// Decrypt returns the plaintext of the encrypted value, and returns the value as it is if it is not encrypted
+func (e *Envelope) Decrypt(ctx context.Context, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	keyID := parts[0]
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed data key: %w", err)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed ciphertext: %w", err)
+	}
+	dataKey, err := e.provider.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("unwrap data key failed: %w", err)
+	}
+	plaintext, err := open(dataKey, sealed, []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// seal encrypts the plaintext with AES-GCM, and returns the nonce followed by the ciphertext
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce failed: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt failed: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
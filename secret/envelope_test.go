@@ -0,0 +1,127 @@
+package secret
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string([]byte{b}), 32)))
+}
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	oldFile, err := ParseKeyFile([]byte("old:" + testKey('a') + "\n"))
+	if err != nil {
+		t.Fatalf("parse key file failed: %v", err)
+	}
+	encrypted, err := NewEnvelope(oldFile).Encrypt(ctx, "secret value")
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	if strings.Contains(encrypted, "secret value") {
+		t.Errorf("encrypted value contains the plaintext: %s", encrypted)
+	}
+	if keyID, ok := KeyID(encrypted); !ok || keyID != "old" {
+		t.Errorf("key id of encrypted value is %s, %v, want old", keyID, ok)
+	}
+
+	// the old key is kept after rotation
+	rotated, err := ParseKeyFile([]byte("# rotated\nnew:" + testKey('b') + "\n\nold:" + testKey('a') + "\n"))
+	if err != nil {
+		t.Fatalf("parse key file failed: %v", err)
+	}
+	envelope := NewEnvelope(rotated)
+	if envelope.CurrentKeyID() != "new" {
+		t.Errorf("current key id is %s, want new", envelope.CurrentKeyID())
+	}
+	got, err := envelope.Decrypt(ctx, encrypted)
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if got != "secret value" {
+		t.Errorf("decrypted value is %s, want secret value", got)
+	}
+
+	// plaintext stored before encryption is returned as it is
+	if got, err = envelope.Decrypt(ctx, "plain"); err != nil || got != "plain" {
+		t.Errorf("decrypt plaintext returned %s, %v", got, err)
+	}
+
+	// the removed key cannot decrypt
+	newOnly, err := ParseKeyFile([]byte("new:" + testKey('b')))
+	if err != nil {
+		t.Fatalf("parse key file failed: %v", err)
+	}
+	if _, err = NewEnvelope(newOnly).Decrypt(ctx, encrypted); err == nil {
+		t.Error("decrypt with a removed key succeeded")
+	}
+
+	// tampered ciphertext is rejected
+	tampered := encrypted[:len(encrypted)-2] + "AA"
+	if tampered == encrypted {
+		tampered = encrypted[:len(encrypted)-2] + "BB"
+	}
+	if _, err = envelope.Decrypt(ctx, tampered); err == nil {
+		t.Error("decrypt tampered value succeeded")
+	}
+}
+
+func TestNewRequiredEnvelopeFromEnv(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keyFile, []byte("k:"+testKey('a')+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name           string
+		keyFile        string
+		allowPlaintext string
+		wantEnvelope   bool
+		wantErr        error
+	}{
+		{name: "key file is set", keyFile: keyFile, wantEnvelope: true},
+		{name: "key file is required", wantErr: ErrKeyFileRequired},
+		{name: "plaintext is not allowed by other values", allowPlaintext: "1", wantErr: ErrKeyFileRequired},
+		{name: "plaintext is allowed", allowPlaintext: "true"},
+	}
+	defer os.Unsetenv(KeyFileEnv)
+	defer os.Unsetenv(AllowPlaintextEnv)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(KeyFileEnv, tt.keyFile)
+			os.Setenv(AllowPlaintextEnv, tt.allowPlaintext)
+			envelope, err := NewRequiredEnvelopeFromEnv()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("unexpected error %v, want %v", err, tt.wantErr)
+			}
+			if (envelope != nil) != tt.wantEnvelope {
+				t.Errorf("unexpected envelope %v", envelope)
+			}
+		})
+	}
+}
+
+func TestParseKeyFile_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "empty", data: "# no key\n"},
+		{name: "no separator", data: testKey('a')},
+		{name: "not base64", data: "k:***"},
+		{name: "short key", data: "k:" + base64.StdEncoding.EncodeToString([]byte("short"))},
+		{name: "duplicated id", data: "k:" + testKey('a') + "\nk:" + testKey('b')},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseKeyFile([]byte(tt.data)); err == nil {
+				t.Error("parse key file succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/future-architect/apidoor/secret
+
+go 1.16
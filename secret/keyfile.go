@@ -0,0 +1,83 @@
+package secret
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeyFile is a KeyProvider of master keys written in a local file.
+// each line of the file is "<key id>:<32 bytes key encoded in base64>", and the key of the first line wraps new data keys.
+// the following keys are kept to unwrap data keys wrapped before key rotation.
+// empty lines and lines starting with # are ignored
+type KeyFile struct {
+	current string
+	keys    map[string][]byte
+}
+
+func LoadKeyFile(path string) (*KeyFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file failed: %w", err)
+	}
+	return ParseKeyFile(data)
+}
+
+func ParseKeyFile(data []byte) (*KeyFile, error) {
+	kf := &KeyFile{
+		keys: make(map[string][]byte),
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		kv := strings.SplitN(text, ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("line %d of key file must be <key id>:<key>", line)
+		}
+		key, err := base64.StdEncoding.DecodeString(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("key of line %d is not base64: %w", line, err)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("key of line %d must be %d bytes, got %d", line, dataKeySize, len(key))
+		}
+		if _, ok := kf.keys[kv[0]]; ok {
+			return nil, fmt.Errorf("key id %s of line %d is duplicated", kv[0], line)
+		}
+		kf.keys[kv[0]] = key
+		if kf.current == "" {
+			kf.current = kv[0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read key file failed: %w", err)
+	}
+	if kf.current == "" {
+		return nil, errors.New("key file has no key")
+	}
+	return kf, nil
+}
+
+func (kf *KeyFile) KeyID() string {
+	return kf.current
+}
+
+func (kf *KeyFile) WrapKey(_ context.Context, dataKey []byte) ([]byte, error) {
+	return seal(kf.keys[kf.current], dataKey, []byte(kf.current))
+}
+
+func (kf *KeyFile) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := kf.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("key %s is not in the key file", keyID)
+	}
+	return open(key, wrapped, []byte(keyID))
+}
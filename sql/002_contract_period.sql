@@ -0,0 +1,11 @@
+BEGIN;
+
+ALTER TABLE public.contract ADD COLUMN IF NOT EXISTS start_at TIMESTAMPTZ;
+ALTER TABLE public.contract ADD COLUMN IF NOT EXISTS end_at TIMESTAMPTZ; /* NULLの場合、契約期間の終了日時なし */
+
+UPDATE public.contract SET start_at = created_at WHERE start_at IS NULL;
+
+COMMENT ON COLUMN public.contract.end_at
+    IS 'The contract is terminated or expired after end_at. Authorizations linked to the contract are removed at that time.';
+
+END;
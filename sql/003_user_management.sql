@@ -0,0 +1,20 @@
+BEGIN;
+
+ALTER TABLE public.apiuser ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE public.apiuser ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP; /* NULLでない場合、論理削除済み */
+
+CREATE TABLE IF NOT EXISTS public.email_verification
+(
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES apiuser(id) ON DELETE CASCADE,
+    email_address TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE, /* 検証トークンのSHA-256ハッシュ */
+    expires_at TIMESTAMPTZ NOT NULL,
+    verified_at TIMESTAMPTZ,
+    created_at TIMESTAMP
+);
+
+COMMENT ON TABLE public.email_verification
+    IS 'Store email verification tokens sent to management-api users.';
+
+END;
@@ -0,0 +1,27 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.routing_outbox
+(
+    id BIGSERIAL PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    ordering_key TEXT NOT NULL DEFAULT '', /* 同じキーのイベントはid順に反映される(product:<id>、apikey:<APIキー>) */
+    payload JSONB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    last_error TEXT,
+    locked_until TIMESTAMP, /* workerが反映中のイベントを他のworkerから隠す期限 */
+    processed_at TIMESTAMP, /* routing storeへの反映が完了した日時 */
+    failed_at TIMESTAMP, /* リトライ上限に達し、反映を諦めた日時 */
+    created_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS routing_outbox_pending_idx
+    ON public.routing_outbox (id) WHERE processed_at IS NULL AND failed_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS routing_outbox_ordering_key_idx
+    ON public.routing_outbox (ordering_key, id) WHERE processed_at IS NULL AND failed_at IS NULL;
+
+COMMENT ON TABLE public.routing_outbox
+    IS 'Store changes to be applied to the routing store (DynamoDB or Redis). Events are written in the same transaction as the change of PostgreSQL, and applied in id order per ordering_key.';
+
+END;
@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE public.product ADD COLUMN IF NOT EXISTS transform JSONB; /* NULLの場合、変換なし */
+
+COMMENT ON COLUMN public.product.transform
+    IS 'Transformation applied by the gateway to requests and responses of every api of the product. It is copied into the routings generated from the product.';
+
+END;
@@ -0,0 +1,16 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.product_credential
+(
+    product_id INT NOT NULL REFERENCES public.product (id) ON DELETE CASCADE,
+    scheme_name TEXT NOT NULL, /* swaggerファイルのsecuritySchemes(securityDefinitions)のキー */
+    value TEXT NOT NULL, /* TOKEN_ENCRYPTION_KEY_FILEが設定されている場合はエンベロープ暗号化した値 */
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    PRIMARY KEY (product_id, scheme_name)
+);
+
+COMMENT ON TABLE public.product_credential
+    IS 'Store credentials of the upstream security schemes of products. Access tokens of the routings generated from the product are created from them.';
+
+END;
@@ -0,0 +1,14 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.log_shipper_offset
+(
+    log_path TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL, /* ログファイルの先頭行のSHA-256、ローテーションの検出に使う */
+    file_offset BIGINT NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+COMMENT ON TABLE public.log_shipper_offset
+    IS 'Store the offset of the log file shipped to log_list by dblogger. The offset is updated in the same transaction as the insert to log_list.';
+
+END;
@@ -0,0 +1,56 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.pricing_plan
+(
+    id SERIAL PRIMARY KEY,
+    product_id INT NOT NULL REFERENCES public.product (id) ON DELETE CASCADE,
+    contract_id INT REFERENCES public.contract (id) ON DELETE CASCADE, /* NULLの場合、商材の全ての契約に適用するデフォルトの料金 */
+    currency VARCHAR(3) NOT NULL, /* ISO 4217 */
+    free_calls BIGINT NOT NULL DEFAULT 0,
+    tiers jsonb NOT NULL, /* [{"up_to": 1000, "unit_price_micros": 10000}, {"up_to": null, "unit_price_micros": 5000}] */
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS pricing_plan_product_idx ON public.pricing_plan (product_id) WHERE contract_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS pricing_plan_contract_idx ON public.pricing_plan (product_id, contract_id) WHERE contract_id IS NOT NULL;
+
+COMMENT ON TABLE public.pricing_plan
+    IS 'Store prices of billable calls of products. A plan of a contract overrides the default plan of the product.';
+
+CREATE TABLE IF NOT EXISTS public.invoice
+(
+    id SERIAL PRIMARY KEY,
+    contract_id INT NOT NULL, /* 契約が削除されても請求書は残す */
+    billing_month DATE NOT NULL, /* 月初日(UTC) */
+    currency VARCHAR(3) NOT NULL,
+    total_micros BIGINT NOT NULL,
+    generated_at TIMESTAMP NOT NULL,
+    UNIQUE (contract_id, billing_month, currency)
+);
+
+CREATE TABLE IF NOT EXISTS public.invoice_item
+(
+    invoice_id INT NOT NULL REFERENCES public.invoice (id) ON DELETE CASCADE,
+    product_id INT NOT NULL,
+    product_name TEXT NOT NULL,
+    billable_calls BIGINT NOT NULL,
+    free_calls BIGINT NOT NULL,
+    amount_micros BIGINT NOT NULL,
+    pricing jsonb NOT NULL, /* 請求書を作成した時点の料金 */
+    PRIMARY KEY (invoice_id, product_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.invoice_month
+(
+    billing_month DATE PRIMARY KEY, /* 月初日(UTC) */
+    finalized_at TIMESTAMPTZ NOT NULL
+);
+
+COMMENT ON TABLE public.invoice
+    IS 'Store monthly invoices of contracts generated from billable calls in log_list. Invoices of a month are replaced when they are generated again unless the month is finalized.';
+
+COMMENT ON TABLE public.invoice_month
+    IS 'Store finalized months, whose invoices are not generated again unless forced.';
+
+END;
@@ -0,0 +1,10 @@
+BEGIN;
+
+ALTER TABLE public.log_list ADD COLUMN IF NOT EXISTS log_id TEXT; /* ゲートウェイが生成するアクセスログのID、dbloggerが書き込む行はNULL */
+
+CREATE UNIQUE INDEX IF NOT EXISTS log_list_log_id_idx ON public.log_list (log_id);
+
+COMMENT ON COLUMN public.log_list.log_id
+    IS 'Identify the access log written by the gateway, so that the log put again after a failure of another sink is not inserted twice.';
+
+END;